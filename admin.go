@@ -0,0 +1,738 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+const (
+	// adminPath is the path of the admin dashboard.
+	adminPath = "/admin"
+
+	// adminAPIPathPrefix is the path under which the admin JSON API is
+	// served.
+	adminAPIPathPrefix = adminPath + "/api"
+
+	// defaultAdminUser is the default user name of the admin password.
+	defaultAdminUser = "admin"
+
+	// adminMacaroonID is the identifier of the macaroons minted for the
+	// admin area.
+	adminMacaroonID = "dcrlnfaucet-admin"
+
+	// adminMacaroonLocation is the location of the macaroons minted for
+	// the admin area.
+	adminMacaroonLocation = "dcrlnfaucet"
+
+	// adminMacaroonKeyFilename is the name of the file holding the root
+	// key of the admin macaroons within the network specific data
+	// directory. Removing it revokes every admin macaroon.
+	adminMacaroonKeyFilename = "admin_macaroon.key"
+
+	// adminMacaroonHeader is the header carrying a hex encoded admin
+	// macaroon.
+	adminMacaroonHeader = "Macaroon"
+
+	// defaultAdminListLimit is the default number of entries returned by
+	// the admin listings.
+	defaultAdminListLimit = 50
+
+	// maxAdminListLimit is the largest number of entries returned by the
+	// admin listings.
+	maxAdminListLimit = 1000
+)
+
+// adminAuth authenticates the requests made to the admin area. Any of the
+// configured credentials is accepted.
+type adminAuth struct {
+	// user and passwordHash are the HTTP basic auth credentials. The
+	// password is stored as a bcrypt hash.
+	user         string
+	passwordHash []byte
+
+	// token is accepted as an Authorization bearer token.
+	token string
+
+	// macRootKey is the root key the admin macaroons are verified with.
+	macRootKey []byte
+}
+
+// newAdminAuth returns the admin authenticator described by the passed
+// config, or nil if no admin credentials are configured. When a macaroon path
+// is configured, an admin macaroon is minted to it unless it already exists.
+func newAdminAuth(cfg *config) (*adminAuth, error) {
+	if cfg.AdminPasswordHash == "" && cfg.AdminToken == "" &&
+		cfg.AdminMacaroonPath == "" {
+
+		return nil, nil
+	}
+
+	auth := &adminAuth{
+		user:  cfg.AdminUser,
+		token: cfg.AdminToken,
+	}
+
+	if cfg.AdminPasswordHash != "" {
+		hash := []byte(cfg.AdminPasswordHash)
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("invalid admin_password_hash: %v",
+				err)
+		}
+		auth.passwordHash = hash
+	}
+
+	if cfg.AdminMacaroonPath != "" {
+		keyPath := filepath.Join(networkDataDir(cfg.DataDir),
+			adminMacaroonKeyFilename)
+		rootKey, created, err := loadAdminRootKey(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		auth.macRootKey = rootKey
+
+		// A new root key invalidates any macaroon minted before, so
+		// it is replaced along with the key.
+		macPath := cleanAndExpandPath(cfg.AdminMacaroonPath)
+		_, err = os.Stat(macPath)
+		if created || os.IsNotExist(err) {
+			if err := writeAdminMacaroon(macPath, rootKey); err != nil {
+				return nil, err
+			}
+			log.Infof("Wrote admin macaroon to %v", macPath)
+		}
+	}
+
+	return auth, nil
+}
+
+// loadAdminRootKey reads the root key of the admin macaroons from the passed
+// path, generating and storing a new one if the file doesn't exist yet.
+func loadAdminRootKey(path string) ([]byte, bool, error) {
+	rootKey, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(rootKey) != 32 {
+			return nil, false, fmt.Errorf("invalid admin macaroon "+
+				"root key in %v", path)
+		}
+		return rootKey, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	rootKey = make([]byte, 32)
+	if _, err := rand.Read(rootKey); err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, err
+	}
+	if err := ioutil.WriteFile(path, rootKey, 0600); err != nil {
+		return nil, false, err
+	}
+	return rootKey, true, nil
+}
+
+// writeAdminMacaroon mints an admin macaroon with the passed root key and
+// writes it to path.
+func writeAdminMacaroon(path string, rootKey []byte) error {
+	mac, err := macaroon.New(rootKey, []byte(adminMacaroonID),
+		adminMacaroonLocation, macaroon.LatestVersion)
+	if err != nil {
+		return err
+	}
+	macBytes, err := mac.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, macBytes, 0600)
+}
+
+// checkAdminCaveat verifies a first party caveat of an admin macaroon. Only
+// the "time-before" caveats used to attenuate a macaroon to a limited
+// lifetime are understood, so any other caveat fails the verification.
+func checkAdminCaveat(caveat string) error {
+	parts := strings.SplitN(caveat, " ", 2)
+	if len(parts) != 2 || parts[0] != "time-before" {
+		return fmt.Errorf("unsupported caveat %q", caveat)
+	}
+	expiry, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid expiry %q: %v", parts[1], err)
+	}
+	if time.Now().After(expiry) {
+		return fmt.Errorf("macaroon expired at %v", expiry)
+	}
+	return nil
+}
+
+// verifyMacaroon returns an error unless the hex encoded macaroon is a valid
+// admin macaroon.
+func (a *adminAuth) verifyMacaroon(hexMac string) error {
+	macBytes, err := hex.DecodeString(hexMac)
+	if err != nil {
+		return err
+	}
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(macBytes); err != nil {
+		return err
+	}
+	if string(mac.Id()) != adminMacaroonID {
+		return fmt.Errorf("not an admin macaroon")
+	}
+	return mac.Verify(a.macRootKey, checkAdminCaveat, nil)
+}
+
+// authenticate returns whether the request carries valid admin credentials.
+func (a *adminAuth) authenticate(r *http.Request) bool {
+	authHeader := r.Header.Get("Authorization")
+	if a.token != "" && strings.HasPrefix(authHeader, "Bearer ") {
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(token),
+			[]byte(a.token)) == 1
+	}
+
+	if a.passwordHash != nil {
+		if user, password, ok := r.BasicAuth(); ok {
+			userOK := subtle.ConstantTimeCompare([]byte(user),
+				[]byte(a.user)) == 1
+			err := bcrypt.CompareHashAndPassword(a.passwordHash,
+				[]byte(password))
+			return userOK && err == nil
+		}
+	}
+
+	if a.macRootKey != nil {
+		if hexMac := r.Header.Get(adminMacaroonHeader); hexMac != "" {
+			if err := a.verifyMacaroon(hexMac); err != nil {
+				log.Debugf("Invalid admin macaroon: %v", err)
+				return false
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminOnly wraps the passed handler so that it is only reached by requests
+// carrying valid admin credentials. Requests changing the state of the faucet
+// must also be JSON encoded, which browsers can't send across origins
+// without a preflight request, so that the admin session of an operator
+// can't be abused by other sites.
+func (l *lightningFaucet) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		isAPI := strings.HasPrefix(r.URL.Path, adminAPIPathPrefix)
+
+		if !l.admin.authenticate(r) {
+			clientIP, _ := getRealIP(r, l.trustedProxies)
+			log.Warnf("Unauthorized admin request for %v from %v",
+				r.URL.Path, clientIP)
+
+			if l.admin.passwordHash != nil {
+				w.Header().Set("WWW-Authenticate",
+					`Basic realm="dcrlnfaucet admin"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			if isAPI {
+				writeAPIError(w, http.StatusUnauthorized,
+					"unauthorized", "admin credentials required")
+			} else {
+				http.Error(w, "admin credentials required",
+					http.StatusUnauthorized)
+			}
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			contentType := r.Header.Get("Content-Type")
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || mediaType != "application/json" {
+				writeAPIError(w, http.StatusUnsupportedMediaType,
+					"unsupported_media_type",
+					"admin requests must be application/json")
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// registerAdminRoutes registers the admin dashboard and its JSON API with the
+// passed router. Nothing is registered unless admin credentials are
+// configured.
+func (l *lightningFaucet) registerAdminRoutes(r *mux.Router) {
+	if l.admin == nil {
+		log.Info("No admin credentials configured, admin area disabled")
+		return
+	}
+
+	r.HandleFunc(adminPath, l.adminOnly(l.adminPage)).Methods("GET")
+
+	api := r.PathPrefix(adminAPIPathPrefix).Subrouter()
+	api.HandleFunc("/grants", l.adminOnly(l.adminGrants)).Methods("GET")
+	api.HandleFunc("/ratelimit",
+		l.adminOnly(l.adminRateLimit)).Methods("GET")
+	api.HandleFunc("/ratelimit/clear",
+		l.adminOnly(l.adminClearRateLimit)).Methods("POST")
+	api.HandleFunc("/channels",
+		l.adminOnly(l.adminChannels)).Methods("GET")
+	api.HandleFunc("/channels/close",
+		l.adminOnly(l.adminCloseChannel)).Methods("POST")
+	api.HandleFunc("/closes", l.adminOnly(l.adminCloses)).Methods("GET")
+	api.HandleFunc("/sweeps", l.adminOnly(l.adminSweeps)).Methods("GET")
+	api.HandleFunc("/actions", l.adminOnly(l.adminActions)).Methods("GET")
+	api.HandleFunc("/actions",
+		l.adminOnly(l.adminToggleAction)).Methods("POST")
+}
+
+// adminGrantsResponse is returned by GET /admin/api/grants. Every list is
+// ordered from newest to oldest.
+type adminGrantsResponse struct {
+	Channels []*channelGrant `json:"channels"`
+	Invoices []*invoiceGrant `json:"invoices"`
+	Payments []*paymentGrant `json:"payments"`
+	Closes   []*channelClose `json:"closes"`
+}
+
+// adminRateLimitResponse is returned by GET /admin/api/ratelimit.
+type adminRateLimitResponse struct {
+	Stats   *rateLimiterStats `json:"stats"`
+	Limited []*rateLimitEntry `json:"limited"`
+}
+
+// adminClearRequest is the body accepted by POST /admin/api/ratelimit/clear.
+type adminClearRequest struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+// adminClearResponse is returned once the limits of a key were lifted.
+type adminClearResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// adminChannel describes the health of one of the channels of the node.
+// Uptime and lifetime are in seconds.
+type adminChannel struct {
+	ChannelPoint  string     `json:"channel_point"`
+	RemotePubKey  string     `json:"remote_pubkey"`
+	Capacity      int64      `json:"capacity"`
+	LocalBalance  int64      `json:"local_balance"`
+	RemoteBalance int64      `json:"remote_balance"`
+	Active        bool       `json:"active"`
+	PeerConnected bool       `json:"peer_connected"`
+	Uptime        int64      `json:"uptime"`
+	Lifetime      int64      `json:"lifetime"`
+	NumUpdates    uint64     `json:"num_updates"`
+	PendingHTLCs  int        `json:"pending_htlcs"`
+	FaucetOpened  bool       `json:"faucet_opened"`
+	OpenedAt      *time.Time `json:"opened_at,omitempty"`
+}
+
+// adminCloseRequest is the body accepted by POST /admin/api/channels/close.
+type adminCloseRequest struct {
+	ChannelPoint string `json:"channel_point"`
+	Force        bool   `json:"force"`
+}
+
+// adminToggleRequest is the body accepted by POST /admin/api/actions.
+type adminToggleRequest struct {
+	Action   string `json:"action"`
+	Disabled bool   `json:"disabled"`
+}
+
+// adminActionsResponse maps every action to whether it is disabled.
+type adminActionsResponse map[string]bool
+
+// adminPageContext is the context used to render the admin dashboard.
+type adminPageContext struct {
+	*homePageContext
+
+	Grants         *adminGrantsResponse
+	RateLimit      *adminRateLimitResponse
+	Channels       []*adminChannel
+	Actions        adminActionsResponse
+	LastSweep      *sweepReport
+	PendingCloses  []*closeRequest
+	ChannelsErr    string
+	AdminAPIPrefix string
+}
+
+// listLimit parses the limit query parameter of an admin listing.
+func listLimit(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultAdminListLimit, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 || limit > maxAdminListLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d",
+			maxAdminListLimit)
+	}
+	return limit, nil
+}
+
+// recentGrants returns up to limit of the most recent grants of each kind.
+func (l *lightningFaucet) recentGrants(limit int) (*adminGrantsResponse, error) {
+	grants := &adminGrantsResponse{
+		Channels: []*channelGrant{},
+		Invoices: []*invoiceGrant{},
+		Payments: []*paymentGrant{},
+	}
+
+	err := l.db.ForEachChannelGrant(func(g *channelGrant) bool {
+		grants.Channels = append(grants.Channels, g)
+		return len(grants.Channels) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.db.ForEachInvoiceGrant(func(g *invoiceGrant) bool {
+		grants.Invoices = append(grants.Invoices, g)
+		return len(grants.Invoices) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.db.ForEachPaymentGrant(func(g *paymentGrant) bool {
+		grants.Payments = append(grants.Payments, g)
+		return len(grants.Payments) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	grants.Closes, err = l.recentCloses(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// channelHealth returns the health of every open channel of the node.
+func (l *lightningFaucet) channelHealth(ctx context.Context) ([]*adminChannel, error) {
+	channels, err := l.lnd.ListChannels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list channels: %v", err)
+	}
+	peers, err := l.lnd.ListPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list peers: %v", err)
+	}
+	connected := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		connected[peer.PubKey] = true
+	}
+
+	l.openChannelsMtx.Lock()
+	defer l.openChannelsMtx.Unlock()
+
+	health := make([]*adminChannel, 0, len(channels))
+	for _, channel := range channels {
+		c := &adminChannel{
+			ChannelPoint:  channel.ChannelPoint,
+			RemotePubKey:  channel.RemotePubkey,
+			Capacity:      channel.Capacity,
+			LocalBalance:  channel.LocalBalance,
+			RemoteBalance: channel.RemoteBalance,
+			Active:        channel.Active,
+			PeerConnected: connected[channel.RemotePubkey],
+			Uptime:        channel.Uptime,
+			Lifetime:      channel.Lifetime,
+			NumUpdates:    channel.NumUpdates,
+			PendingHTLCs:  len(channel.PendingHtlcs),
+		}
+
+		op, err := strPointToOutPoint(channel.ChannelPoint)
+		if err == nil {
+			if openedAt, ok := l.openChannels[*op]; ok {
+				c.FaucetOpened = true
+				c.OpenedAt = &openedAt
+			}
+		}
+
+		health = append(health, c)
+	}
+
+	return health, nil
+}
+
+// disabledActionsSnapshot returns whether each action is currently disabled.
+func (l *lightningFaucet) disabledActionsSnapshot() adminActionsResponse {
+	actions := make(adminActionsResponse)
+	for _, action := range []string{OpenChannelAction,
+		GenerateInvoiceAction, PayInvoiceAction} {
+
+		actions[action] = l.actionDisabled(action)
+	}
+	return actions
+}
+
+// adminPage renders the admin dashboard.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminPage(w http.ResponseWriter, r *http.Request) {
+	adminTemplate := l.templates.Lookup("admin.html")
+
+	homeInfo, err := l.fetchHomeState(r.Context())
+	if err != nil {
+		log.Error("unable to fetch info state")
+		http.Error(w, "unable to render admin page",
+			http.StatusInternalServerError)
+		return
+	}
+
+	grants, err := l.recentGrants(defaultAdminListLimit)
+	if err != nil {
+		log.Errorf("unable to fetch grants: %v", err)
+		http.Error(w, "unable to render admin page",
+			http.StatusInternalServerError)
+		return
+	}
+
+	adminState := &adminPageContext{
+		homePageContext: homeInfo,
+		Grants:          grants,
+		RateLimit: &adminRateLimitResponse{
+			Stats:   l.limiter.stats(),
+			Limited: l.limiter.limited(defaultAdminListLimit),
+		},
+		Actions:        l.disabledActionsSnapshot(),
+		LastSweep:      l.lastSweep(),
+		PendingCloses:  l.closer.pending(),
+		AdminAPIPrefix: adminAPIPathPrefix,
+	}
+
+	// The rest of the dashboard is still useful when the channels can't
+	// be fetched.
+	adminState.Channels, err = l.channelHealth(r.Context())
+	if err != nil {
+		log.Errorf("unable to fetch channel health: %v", err)
+		adminState.ChannelsErr = err.Error()
+	}
+
+	if err := adminTemplate.Execute(w, adminState); err != nil {
+		log.Errorf("unable to render admin page: %v", err)
+	}
+}
+
+// adminGrants returns the most recent grants of the faucet.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminGrants(w http.ResponseWriter, r *http.Request) {
+	limit, err := listLimit(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request",
+			err.Error())
+		return
+	}
+
+	grants, err := l.recentGrants(limit)
+	if err != nil {
+		log.Errorf("unable to fetch grants: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, grants)
+}
+
+// adminRateLimit returns the state of the rate limiter along with the
+// entities that are currently refused an action.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminRateLimit(w http.ResponseWriter, r *http.Request) {
+	limit, err := listLimit(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request",
+			err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &adminRateLimitResponse{
+		Stats:   l.limiter.stats(),
+		Limited: l.limiter.limited(limit),
+	})
+}
+
+// adminClearRateLimit lifts the limits of a single rate limited entity.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminClearRateLimit(w http.ResponseWriter,
+	r *http.Request) {
+
+	var req adminClearRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	kind := rateLimitKeyKind(req.Kind)
+	switch kind {
+	case clientIPKey, subnetKey, nodeKey, destinationKey:
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("unknown key kind %q", req.Kind))
+		return
+	}
+
+	cleared := l.limiter.clear(rateLimitKey{kind, req.Key})
+	log.Infof("Admin cleared %d rate limits of %v(%v)", cleared, kind,
+		req.Key)
+
+	writeJSON(w, http.StatusOK, &adminClearResponse{Cleared: cleared})
+}
+
+// adminChannels returns the health of every open channel of the node.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := l.channelHealth(r.Context())
+	if err != nil {
+		log.Errorf("unable to fetch channel health: %v", err)
+		writeAPIError(w, http.StatusBadGateway, "rpc_failed", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, channels)
+}
+
+// adminCloseChannel hands a channel of the node over to the close manager,
+// which closes it cooperatively unless a force close is requested. The state
+// of the close is returned, with a 202 status if the closing transaction
+// wasn't broadcast yet.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminCloseChannel(w http.ResponseWriter,
+	r *http.Request) {
+
+	var req adminCloseRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	if _, err := strPointToChanPoint(req.ChannelPoint); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request",
+			err.Error())
+		return
+	}
+
+	channels, err := l.lnd.ListChannels(r.Context())
+	if err != nil {
+		log.Errorf("unable to fetch open channels: %v", err)
+		writeAPIError(w, http.StatusBadGateway, "rpc_failed", err.Error())
+		return
+	}
+	var channel *lnrpc.Channel
+	for _, c := range channels {
+		if c.ChannelPoint == req.ChannelPoint {
+			channel = c
+			break
+		}
+	}
+	if channel == nil {
+		writeAPIError(w, http.StatusNotFound, "unknown_channel",
+			fmt.Sprintf("channel %v is not open", req.ChannelPoint))
+		return
+	}
+
+	log.Infof("Admin closing ChannelPoint(%v), force=%v",
+		req.ChannelPoint, req.Force)
+
+	closeReq, err := l.requestClose(channel.ChannelPoint,
+		channel.RemotePubkey, closeReasonAdmin, req.Force)
+	if err != nil {
+		log.Errorf("unable to close ChannelPoint(%v): %v",
+			req.ChannelPoint, err)
+		writeAPIError(w, http.StatusInternalServerError, "close_failed",
+			err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if closeReq.State != closeStateClosed {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, closeReq)
+}
+
+// adminCloses returns the closes still in progress, oldest first.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminCloses(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, l.closer.pending())
+}
+
+// lastSweep returns the report of the most recent zombie channel sweep, or nil
+// if no sweep was performed yet.
+func (l *lightningFaucet) lastSweep() *sweepReport {
+	reports := l.sweeper.recentReports()
+	if len(reports) == 0 {
+		return nil
+	}
+	return reports[0]
+}
+
+// adminSweeps returns the reports of the most recent zombie channel sweeps,
+// newest first.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminSweeps(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, l.sweeper.recentReports())
+}
+
+// adminActions returns whether each action is currently disabled.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminActions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, l.disabledActionsSnapshot())
+}
+
+// adminToggleAction enables or disables an action.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) adminToggleAction(w http.ResponseWriter,
+	r *http.Request) {
+
+	var req adminToggleRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	switch req.Action {
+	case OpenChannelAction, GenerateInvoiceAction, PayInvoiceAction:
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("unknown action %q", req.Action))
+		return
+	}
+
+	l.setActionDisabled(req.Action, req.Disabled)
+	log.Infof("Admin set %v disabled=%v", req.Action, req.Disabled)
+
+	writeJSON(w, http.StatusOK, l.disabledActionsSnapshot())
+}
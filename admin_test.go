@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testAdminPassword = "hunter2"
+	testAdminToken    = "s3cr3t-token"
+)
+
+// newTestAdmin sets up admin credentials for the faucet and returns a router
+// serving its admin area.
+func newTestAdmin(t *testing.T, l *lightningFaucet) *mux.Router {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testAdminPassword),
+		bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("unable to hash password: %v", err)
+	}
+	l.admin = &adminAuth{
+		user:         defaultAdminUser,
+		passwordHash: hash,
+		token:        testAdminToken,
+	}
+
+	r := mux.NewRouter()
+	l.registerAdminRoutes(r)
+	return r
+}
+
+// adminRequest makes a request to the admin area authenticated with the
+// bearer token and returns the response.
+func adminRequest(r *mux.Router, method, target,
+	body string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.RemoteAddr = testClientIP + ":12345"
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestAdminAuth ensures the admin area is only reachable with valid
+// credentials and only accepts JSON encoded changes.
+func TestAdminAuth(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+	r := newTestAdmin(t, faucet)
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		auth        func(*http.Request)
+		wantStatus  int
+	}{{
+		name:       "no credentials",
+		method:     http.MethodGet,
+		target:     adminPath,
+		auth:       func(*http.Request) {},
+		wantStatus: http.StatusUnauthorized,
+	}, {
+		name:   "wrong token",
+		method: http.MethodGet,
+		target: adminAPIPathPrefix + "/actions",
+		auth: func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer nope")
+		},
+		wantStatus: http.StatusUnauthorized,
+	}, {
+		name:   "token",
+		method: http.MethodGet,
+		target: adminAPIPathPrefix + "/actions",
+		auth: func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		},
+		wantStatus: http.StatusOK,
+	}, {
+		name:   "wrong password",
+		method: http.MethodGet,
+		target: adminPath,
+		auth: func(req *http.Request) {
+			req.SetBasicAuth(defaultAdminUser, "hunter3")
+		},
+		wantStatus: http.StatusUnauthorized,
+	}, {
+		name:   "wrong user",
+		method: http.MethodGet,
+		target: adminPath,
+		auth: func(req *http.Request) {
+			req.SetBasicAuth("root", testAdminPassword)
+		},
+		wantStatus: http.StatusUnauthorized,
+	}, {
+		name:   "password",
+		method: http.MethodGet,
+		target: adminPath,
+		auth: func(req *http.Request) {
+			req.SetBasicAuth(defaultAdminUser, testAdminPassword)
+		},
+		wantStatus: http.StatusOK,
+	}, {
+		name:        "form post",
+		method:      http.MethodPost,
+		target:      adminAPIPathPrefix + "/actions",
+		contentType: "application/x-www-form-urlencoded",
+		auth: func(req *http.Request) {
+			req.SetBasicAuth(defaultAdminUser, testAdminPassword)
+		},
+		wantStatus: http.StatusUnsupportedMediaType,
+	}}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, nil)
+			req.RemoteAddr = testClientIP + ":12345"
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			test.auth(req)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("unexpected status: got %d, want %d",
+					rec.Code, test.wantStatus)
+			}
+		})
+	}
+}
+
+// TestAdminToggleAction ensures actions disabled through the admin API are
+// refused by the faucet.
+func TestAdminToggleAction(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+	r := newTestAdmin(t, faucet)
+
+	rec := adminRequest(r, http.MethodPost, adminAPIPathPrefix+"/actions",
+		`{"action": "openchannel", "disabled": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unable to disable action: %d %s", rec.Code,
+			rec.Body.String())
+	}
+	var actions adminActionsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&actions); err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+	if !actions[OpenChannelAction] || actions[PayInvoiceAction] {
+		t.Fatalf("unexpected actions: %v", actions)
+	}
+
+	req := httptest.NewRequest(http.MethodPost,
+		"/?action="+OpenChannelAction, nil)
+	req.RemoteAddr = testClientIP + ":12345"
+	home := httptest.NewRecorder()
+	faucet.faucetHome(home, req)
+	if home.Code != http.StatusForbidden {
+		t.Fatalf("disabled action allowed: %d", home.Code)
+	}
+
+	rec = adminRequest(r, http.MethodPost, adminAPIPathPrefix+"/actions",
+		`{"action": "sendallcoins", "disabled": true}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown action accepted: %d", rec.Code)
+	}
+}
+
+// TestAdminClearRateLimit ensures an operator can lift the limits of a rate
+// limited client.
+func TestAdminClearRateLimit(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+	r := newTestAdmin(t, faucet)
+
+	keys := faucet.clients.keys(testClientIP)
+	faucet.limiter.record(GenerateInvoiceAction, time.Now(), keys...)
+	if err := faucet.limiter.check(GenerateInvoiceAction, keys...); err == nil {
+		t.Fatal("client not rate limited")
+	}
+
+	rec := adminRequest(r, http.MethodGet, adminAPIPathPrefix+"/ratelimit",
+		"")
+	var state adminRateLimitResponse
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+	if len(state.Limited) != 1 || state.Limited[0].Key != testClientIP {
+		t.Fatalf("unexpected limited entries: %v", state.Limited)
+	}
+
+	rec = adminRequest(r, http.MethodPost,
+		adminAPIPathPrefix+"/ratelimit/clear",
+		`{"kind": "client", "key": "`+testClientIP+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unable to clear rate limit: %d %s", rec.Code,
+			rec.Body.String())
+	}
+	if err := faucet.limiter.check(GenerateInvoiceAction, keys...); err != nil {
+		t.Fatalf("client still rate limited: %v", err)
+	}
+}
+
+// TestAdminCloseChannel ensures an operator can close a channel and sees
+// which channels were opened by the faucet. Cooperative closes that can't be
+// made right away are left to the close manager until forced.
+func TestAdminCloseChannel(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+	r := newTestAdmin(t, faucet)
+
+	lnd.addChannel(fakePubKey(0x01), 1e6)
+	chanPoint := lnd.channels[0].ChannelPoint
+	op, err := strPointToOutPoint(chanPoint)
+	if err != nil {
+		t.Fatalf("invalid channel point: %v", err)
+	}
+	faucet.openChannels[*op] = time.Now()
+
+	rec := adminRequest(r, http.MethodGet, adminAPIPathPrefix+"/channels",
+		"")
+	var channels []*adminChannel
+	if err := json.NewDecoder(rec.Body).Decode(&channels); err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+	if len(channels) != 1 || !channels[0].FaucetOpened ||
+		channels[0].PeerConnected {
+
+		t.Fatalf("unexpected channels: %v", channels)
+	}
+
+	// The peer is offline so the cooperative close is only requested.
+	lnd.channels[0].Active = false
+	rec = adminRequest(r, http.MethodPost,
+		adminAPIPathPrefix+"/channels/close",
+		`{"channel_point": "`+chanPoint+`", "force": false}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d %s", rec.Code,
+			rec.Body.String())
+	}
+	rec = adminRequest(r, http.MethodGet, adminAPIPathPrefix+"/closes", "")
+	var closes []*closeRequest
+	if err := json.NewDecoder(rec.Body).Decode(&closes); err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+	if len(closes) != 1 || closes[0].State != closeStateCoop {
+		t.Fatalf("unexpected closes: %v", closes)
+	}
+
+	rec = adminRequest(r, http.MethodPost,
+		adminAPIPathPrefix+"/channels/close",
+		`{"channel_point": "`+chanPoint+`", "force": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unable to close channel: %d %s", rec.Code,
+			rec.Body.String())
+	}
+	if len(lnd.closed) != 1 {
+		t.Fatalf("channel not closed")
+	}
+	if faucet.closer.closing(chanPoint) {
+		t.Fatalf("close still in progress")
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAlertInterval is the default interval between two evaluations
+	// of the alert conditions.
+	defaultAlertInterval = 5 * time.Minute
+
+	// defaultAlertMinBalance is the default confirmed balance, in atoms,
+	// below which the wallet is reported as low on funds.
+	defaultAlertMinBalance int64 = 1e8
+
+	// defaultAlertFailureRate is the default fraction of the recent channel
+	// opens that must have failed for an alert to be raised.
+	defaultAlertFailureRate = 0.5
+
+	// defaultAlertPendingTimeout is the default time after which channels
+	// still pending open are reported as stuck.
+	defaultAlertPendingTimeout = 6 * time.Hour
+
+	// alertFailureWindow is the window over which the failure rate of the
+	// channel opens is computed.
+	alertFailureWindow = time.Hour
+
+	// alertMinOpens is the number of channel opens the failure window must
+	// hold for the failure rate to be meaningful.
+	alertMinOpens = 4
+
+	// alertWebhookTimeout is the time a webhook has to accept a
+	// notification.
+	alertWebhookTimeout = 10 * time.Second
+)
+
+// alertKind identifies the condition an alert reports.
+type alertKind string
+
+const (
+	// nodeUnreachableAlert is raised when dcrlnd doesn't answer.
+	nodeUnreachableAlert alertKind = "node_unreachable"
+
+	// lowBalanceAlert is raised when the confirmed balance of the wallet
+	// is below the threshold.
+	lowBalanceAlert alertKind = "low_balance"
+
+	// chainUnsyncedAlert is raised when dcrlnd isn't synced to the chain.
+	chainUnsyncedAlert alertKind = "chain_unsynced"
+
+	// graphUnsyncedAlert is raised when dcrlnd isn't synced to the graph.
+	graphUnsyncedAlert alertKind = "graph_unsynced"
+
+	// noPeersAlert is raised when dcrlnd isn't connected to any peer.
+	noPeersAlert alertKind = "no_peers"
+
+	// openFailuresAlert is raised when too many of the recent channel
+	// opens failed.
+	openFailuresAlert alertKind = "open_failures"
+
+	// stuckChannelsAlert is raised when channels have been pending open
+	// for longer than the pending timeout.
+	stuckChannelsAlert alertKind = "stuck_channels"
+)
+
+const (
+	// alertFiring is the status of the notifications of a condition that
+	// was just raised.
+	alertFiring = "firing"
+
+	// alertResolved is the status of the notifications of a condition that
+	// no longer holds.
+	alertResolved = "resolved"
+)
+
+// alertCondition is the outcome of the evaluation of an alert condition.
+type alertCondition struct {
+	kind    alertKind
+	firing  bool
+	message string
+}
+
+// alertNotification is the JSON body posted to the webhooks whenever an alert
+// is raised or resolved. Text repeats the notification in a sentence so that
+// the incoming webhooks of chat services are able to display it.
+type alertNotification struct {
+	Alert      alertKind  `json:"alert"`
+	Status     string     `json:"status"`
+	Message    string     `json:"message"`
+	Network    string     `json:"network"`
+	Since      time.Time  `json:"since"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	Text       string     `json:"text"`
+}
+
+// openOutcome records when a channel open ended and whether it failed.
+type openOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// alertManager evaluates the health of the faucet and notifies the webhooks
+// whenever a condition is raised, and once more when it is resolved. A
+// condition that remains raised isn't notified again, while notifications that
+// no webhook accepted are sent again along with the next evaluation.
+type alertManager struct {
+	webhooks       []string
+	interval       time.Duration
+	minBalance     int64
+	failureRate    float64
+	pendingTimeout time.Duration
+	network        string
+	client         *http.Client
+
+	mtx sync.Mutex
+
+	// firing holds the notification of every condition currently raised.
+	firing map[alertKind]*alertNotification
+
+	// undelivered holds the notifications no webhook accepted yet, in
+	// the order they were raised.
+	undelivered []*alertNotification
+
+	// opens holds the outcome of the channel opens within the failure
+	// window.
+	opens []openOutcome
+
+	// pendingSince holds when each channel pending open was first seen.
+	pendingSince map[string]time.Time
+}
+
+// newAlertManager returns an alert manager notifying the webhooks of the
+// passed config, or nil when there are none.
+func newAlertManager(cfg *config, network string) (*alertManager, error) {
+	if len(cfg.AlertWebhooks) == 0 {
+		return nil, nil
+	}
+
+	for _, webhook := range cfg.AlertWebhooks {
+		u, err := url.Parse(webhook)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert_webhook: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid alert_webhook: must be " +
+				"an http or https URL")
+		}
+	}
+
+	return &alertManager{
+		webhooks:       cfg.AlertWebhooks,
+		interval:       cfg.AlertInterval,
+		minBalance:     cfg.AlertMinBalance,
+		failureRate:    cfg.AlertFailureRate,
+		pendingTimeout: cfg.AlertPendingTimeout,
+		network:        network,
+		client:         &http.Client{Timeout: alertWebhookTimeout},
+		firing:         make(map[alertKind]*alertNotification),
+		pendingSince:   make(map[string]time.Time),
+	}, nil
+}
+
+// recordOpen records the outcome of a channel open that ended at the time
+// now.
+func (m *alertManager) recordOpen(now time.Time, failed bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.opens = append(m.opens, openOutcome{at: now, failed: failed})
+	m.pruneOpens(now)
+}
+
+// pruneOpens forgets the outcomes of the opens that ended before the failure
+// window.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (m *alertManager) pruneOpens(now time.Time) {
+	cutoff := now.Add(-alertFailureWindow)
+	i := 0
+	for i < len(m.opens) && m.opens[i].at.Before(cutoff) {
+		i++
+	}
+	m.opens = m.opens[i:]
+}
+
+// openFailures returns the number of channel opens within the failure window
+// along with how many of them failed.
+func (m *alertManager) openFailures(now time.Time) (int, int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.pruneOpens(now)
+	var numFailed int
+	for _, o := range m.opens {
+		if o.failed {
+			numFailed++
+		}
+	}
+	return len(m.opens), numFailed
+}
+
+// stuckChannels returns the channel points of the pending channels that have
+// been pending for longer than the pending timeout. openedAt returns when the
+// faucet opened a channel, if it did.
+func (m *alertManager) stuckChannels(now time.Time, pending []string,
+	openedAt func(string) (time.Time, bool)) []string {
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	seen := make(map[string]time.Time, len(pending))
+	var stuck []string
+	for _, chanPoint := range pending {
+		since, ok := openedAt(chanPoint)
+		if !ok {
+			since, ok = m.pendingSince[chanPoint]
+			if !ok {
+				since = now
+			}
+		}
+		seen[chanPoint] = since
+		if now.Sub(since) > m.pendingTimeout {
+			stuck = append(stuck, chanPoint)
+		}
+	}
+	m.pendingSince = seen
+
+	sort.Strings(stuck)
+	return stuck
+}
+
+// update applies the outcome of the evaluation of the conditions at the time
+// now, and returns the notifications to send for the conditions that were
+// raised or resolved since the previous evaluation, after the ones that were
+// not delivered before.
+func (m *alertManager) update(now time.Time,
+	conds []alertCondition) []*alertNotification {
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	notifications := m.undelivered
+	m.undelivered = nil
+	for _, cond := range conds {
+		active, isFiring := m.firing[cond.kind]
+		switch {
+		case cond.firing && !isFiring:
+			n := &alertNotification{
+				Alert:   cond.kind,
+				Status:  alertFiring,
+				Message: cond.message,
+				Network: m.network,
+				Since:   now,
+			}
+			n.Text = fmt.Sprintf("[%s] Faucet alert %s: %s",
+				m.network, cond.kind, cond.message)
+			m.firing[cond.kind] = n
+			notifications = append(notifications, n)
+
+		case !cond.firing && isFiring:
+			delete(m.firing, cond.kind)
+
+			// A condition resolved before it could be notified
+			// isn't notified at all.
+			if i := indexNotification(notifications, active); i >= 0 {
+				notifications = append(notifications[:i],
+					notifications[i+1:]...)
+				continue
+			}
+
+			resolvedAt := now
+			n := *active
+			n.Status = alertResolved
+			n.ResolvedAt = &resolvedAt
+			n.Text = fmt.Sprintf("[%s] Faucet alert %s resolved after "+
+				"%v: %s", m.network, cond.kind,
+				now.Sub(active.Since).Round(time.Second),
+				active.Message)
+			notifications = append(notifications, &n)
+		}
+	}
+	return notifications
+}
+
+// indexNotification returns the index of the notification n within
+// notifications, or -1 if it isn't there.
+func indexNotification(notifications []*alertNotification,
+	n *alertNotification) int {
+
+	for i, other := range notifications {
+		if other == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// retry queues the notification to be sent again along with the next
+// evaluation.
+func (m *alertManager) retry(n *alertNotification) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.undelivered = append(m.undelivered, n)
+}
+
+// notify posts the notification to every webhook, and returns whether at least
+// one of them accepted it.
+func (m *alertManager) notify(ctx context.Context, n *alertNotification) bool {
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Errorf("unable to encode %s alert: %v", n.Alert, err)
+		return false
+	}
+
+	var delivered bool
+	for _, webhook := range m.webhooks {
+		// Only the host of the webhooks is logged, as their path
+		// often holds a secret.
+		if err := m.post(ctx, webhook, body); err != nil {
+			u, _ := url.Parse(webhook)
+			log.Errorf("unable to notify %s alert to %s: %v",
+				n.Alert, u.Host, err)
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+// post sends the encoded notification to the webhook.
+func (m *alertManager) post(ctx context.Context, webhook string,
+	body []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, webhook,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %v", resp.Status)
+	}
+	return nil
+}
+
+// evaluateAlerts evaluates the health of the faucet and its node. Conditions
+// that can't be evaluated because dcrlnd failed to answer are left out, so
+// that their state doesn't change.
+func (l *lightningFaucet) evaluateAlerts(ctx context.Context) []alertCondition {
+	m := l.alerts
+	now := time.Now()
+
+	info, err := l.lnd.GetInfo(ctx)
+	if err != nil {
+		log.Errorf("rpc GetInfo failed: %v", err)
+		return []alertCondition{{
+			kind:    nodeUnreachableAlert,
+			firing:  true,
+			message: fmt.Sprintf("dcrlnd is unreachable: %v", err),
+		}}
+	}
+	conds := []alertCondition{{
+		kind: nodeUnreachableAlert,
+	}, {
+		kind:   chainUnsyncedAlert,
+		firing: !info.SyncedToChain,
+		message: fmt.Sprintf("dcrlnd is not synced to the chain, at "+
+			"height %d", info.BlockHeight),
+	}, {
+		kind:    graphUnsyncedAlert,
+		firing:  !info.SyncedToGraph,
+		message: "dcrlnd is not synced to the channel graph",
+	}}
+
+	if m.minBalance > 0 {
+		balance, err := l.lnd.WalletBalance(ctx)
+		if err != nil {
+			log.Errorf("rpc WalletBalance failed: %v", err)
+		} else {
+			conds = append(conds, alertCondition{
+				kind:   lowBalanceAlert,
+				firing: balance.ConfirmedBalance < m.minBalance,
+				message: fmt.Sprintf("the confirmed balance of %s "+
+					"DCR is below %s DCR",
+					formatCoins(balance.ConfirmedBalance),
+					formatCoins(m.minBalance)),
+			})
+		}
+	}
+
+	peers, err := l.lnd.ListPeers(ctx)
+	if err != nil {
+		log.Errorf("rpc ListPeers failed: %v", err)
+	} else {
+		conds = append(conds, alertCondition{
+			kind:    noPeersAlert,
+			firing:  len(peers) == 0,
+			message: "dcrlnd is not connected to any peer",
+		})
+	}
+
+	if m.failureRate > 0 {
+		numOpens, numFailed := m.openFailures(now)
+		conds = append(conds, alertCondition{
+			kind: openFailuresAlert,
+			firing: numOpens >= alertMinOpens &&
+				float64(numFailed) >= m.failureRate*float64(numOpens),
+			message: fmt.Sprintf("%d of the %d channel opens of the "+
+				"last %v failed", numFailed, numOpens,
+				alertFailureWindow),
+		})
+	}
+
+	if m.pendingTimeout > 0 {
+		pending, err := l.lnd.PendingChannels(ctx)
+		if err != nil {
+			log.Errorf("rpc PendingChannels failed: %v", err)
+		} else {
+			chanPoints := make([]string, 0,
+				len(pending.PendingOpenChannels))
+			for _, c := range pending.PendingOpenChannels {
+				chanPoints = append(chanPoints,
+					c.Channel.ChannelPoint)
+			}
+			stuck := m.stuckChannels(now, chanPoints, l.channelOpenedAt)
+			conds = append(conds, alertCondition{
+				kind:   stuckChannelsAlert,
+				firing: len(stuck) > 0,
+				message: fmt.Sprintf("%d channels have been pending "+
+					"open for more than %v: %s", len(stuck),
+					m.pendingTimeout,
+					strings.Join(stuck, ", ")),
+			})
+		}
+	}
+
+	return conds
+}
+
+// channelOpenedAt returns when the faucet opened the channel with the given
+// channel point, and false if it didn't open it.
+func (l *lightningFaucet) channelOpenedAt(chanPoint string) (time.Time, bool) {
+	op, err := strPointToOutPoint(chanPoint)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	l.openChannelsMtx.Lock()
+	defer l.openChannelsMtx.Unlock()
+
+	openedAt, ok := l.openChannels[*op]
+	return openedAt, ok
+}
+
+// checkAlerts evaluates the alert conditions and notifies the webhooks of the
+// ones that were raised or resolved, retrying the notifications none of them
+// accepted before.
+func (l *lightningFaucet) checkAlerts() {
+	ctx, cancel := context.WithTimeout(ctxb, l.alerts.interval)
+	defer cancel()
+
+	conds := l.evaluateAlerts(ctx)
+	for _, n := range l.alerts.update(time.Now(), conds) {
+		log.Infof("Alert %s %s: %s", n.Alert, n.Status, n.Message)
+		if !l.alerts.notify(ctx, n) {
+			l.alerts.retry(n)
+		}
+	}
+}
+
+// alertWatcher periodically evaluates the alert conditions.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) alertWatcher() {
+	defer l.wg.Done()
+
+	log.Infof("Alerts active, notifying %d webhooks every %v",
+		len(l.alerts.webhooks), l.alerts.interval)
+
+	l.checkAlerts()
+
+	ticker := time.NewTicker(l.alerts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.checkAlerts()
+
+		case <-l.quit:
+			return
+		}
+	}
+}
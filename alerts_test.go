@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// alertReceiver is a webhook recording the notifications posted to it.
+type alertReceiver struct {
+	mtx           sync.Mutex
+	notifications []*alertNotification
+
+	// down, when set, makes the webhook refuse every notification.
+	down bool
+}
+
+// setDown sets whether the webhook refuses the notifications.
+func (a *alertReceiver) setDown(down bool) {
+	a.mtx.Lock()
+	a.down = down
+	a.mtx.Unlock()
+}
+
+// ServeHTTP records the posted notification.
+func (a *alertReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mtx.Lock()
+	down := a.down
+	a.mtx.Unlock()
+	if down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+
+	var n alertNotification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mtx.Lock()
+	a.notifications = append(a.notifications, &n)
+	a.mtx.Unlock()
+}
+
+// received returns the alert and status of the notifications received since
+// the previous call.
+func (a *alertReceiver) received() []string {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var received []string
+	for _, n := range a.notifications {
+		received = append(received, string(n.Alert)+" "+n.Status)
+	}
+	a.notifications = nil
+	return received
+}
+
+// TestAlerts ensures the webhooks are notified once when a condition is raised
+// and once more when it is resolved.
+func TestAlerts(t *testing.T) {
+	receiver := new(alertReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	alerts, err := newAlertManager(&config{
+		AlertWebhooks:       []string{server.URL},
+		AlertInterval:       time.Minute,
+		AlertMinBalance:     1e8,
+		AlertFailureRate:    0.5,
+		AlertPendingTimeout: time.Hour,
+	}, "testnet")
+	if err != nil {
+		t.Fatalf("unable to create alert manager: %v", err)
+	}
+	faucet.alerts = alerts
+
+	checkAlerts := func(want ...string) {
+		t.Helper()
+
+		faucet.checkAlerts()
+		if got := receiver.received(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected notifications: got %v, want %v",
+				got, want)
+		}
+	}
+
+	// The fake node starts out without peers, which is only notified
+	// once.
+	checkAlerts("no_peers firing")
+	checkAlerts()
+
+	lnd.addPeer(fakePubKey(0x01))
+	lnd.info.SyncedToChain = false
+	lnd.balance.ConfirmedBalance = 5e7
+	checkAlerts("chain_unsynced firing", "low_balance firing",
+		"no_peers resolved")
+
+	// The other conditions are left as is while the node is unreachable.
+	lnd.errGetInfo = errors.New("connection refused")
+	checkAlerts("node_unreachable firing")
+	checkAlerts()
+
+	lnd.errGetInfo = nil
+	lnd.info.SyncedToChain = true
+	lnd.balance.ConfirmedBalance = 100e8
+	checkAlerts("node_unreachable resolved", "chain_unsynced resolved",
+		"low_balance resolved")
+
+	// Half of the recent opens failing raises an alert once enough opens
+	// were attempted.
+	now := time.Now()
+	faucet.alerts.recordOpen(now.Add(-2*alertFailureWindow), true)
+	faucet.alerts.recordOpen(now, true)
+	faucet.alerts.recordOpen(now, false)
+	faucet.alerts.recordOpen(now, true)
+	checkAlerts()
+	faucet.alerts.recordOpen(now, false)
+	checkAlerts("open_failures firing")
+
+	// Channels opened by the faucet are stuck once pending for longer
+	// than the timeout, while other channels are followed from the
+	// moment they are first seen.
+	stuckPoint := fakePubKey(0x02)[2:] + ":0"
+	otherPoint := fakePubKey(0x03)[2:] + ":1"
+	op, err := strPointToOutPoint(stuckPoint)
+	if err != nil {
+		t.Fatalf("unable to parse channel point: %v", err)
+	}
+	faucet.openChannelsMtx.Lock()
+	faucet.openChannels[*op] = now.Add(-2 * time.Hour)
+	faucet.openChannelsMtx.Unlock()
+
+	lnd.mtx.Lock()
+	for _, chanPoint := range []string{stuckPoint, otherPoint} {
+		lnd.pending.PendingOpenChannels = append(
+			lnd.pending.PendingOpenChannels,
+			&lnrpc.PendingChannelsResponse_PendingOpenChannel{
+				Channel: &lnrpc.PendingChannelsResponse_PendingChannel{
+					ChannelPoint: chanPoint,
+					Capacity:     1e6,
+				},
+			})
+	}
+	lnd.mtx.Unlock()
+	checkAlerts("stuck_channels firing")
+
+	stuck := faucet.alerts.stuckChannels(now.Add(2*time.Hour),
+		[]string{stuckPoint, otherPoint}, faucet.channelOpenedAt)
+	if !reflect.DeepEqual(stuck, []string{stuckPoint, otherPoint}) {
+		t.Fatalf("unexpected stuck channels: %v", stuck)
+	}
+
+	lnd.mtx.Lock()
+	lnd.pending.PendingOpenChannels = nil
+	lnd.mtx.Unlock()
+	checkAlerts("stuck_channels resolved")
+}
+
+// TestAlertRetry ensures the notifications no webhook accepted are sent again
+// along with the next evaluation, unless their condition was resolved in the
+// meantime.
+func TestAlertRetry(t *testing.T) {
+	receiver := new(alertReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	alerts, err := newAlertManager(&config{
+		AlertWebhooks: []string{failing.URL, server.URL},
+		AlertInterval: time.Minute,
+	}, "testnet")
+	if err != nil {
+		t.Fatalf("unable to create alert manager: %v", err)
+	}
+	faucet.alerts = alerts
+
+	checkAlerts := func(want ...string) {
+		t.Helper()
+
+		faucet.checkAlerts()
+		if got := receiver.received(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected notifications: got %v, want %v",
+				got, want)
+		}
+	}
+	removePeers := func() {
+		lnd.mtx.Lock()
+		lnd.peers = nil
+		lnd.mtx.Unlock()
+	}
+
+	// A notification accepted by one of the webhooks is delivered.
+	checkAlerts("no_peers firing")
+
+	// Notifications refused by every webhook are sent again once they
+	// are accepted, in the order they were raised.
+	receiver.setDown(true)
+	lnd.addPeer(fakePubKey(0x01))
+	checkAlerts()
+	lnd.info.SyncedToGraph = false
+	checkAlerts()
+	receiver.setDown(false)
+	checkAlerts("no_peers resolved", "graph_unsynced firing")
+	checkAlerts()
+
+	// A condition raised and resolved while the webhooks were down isn't
+	// notified at all.
+	receiver.setDown(true)
+	removePeers()
+	checkAlerts()
+	lnd.addPeer(fakePubKey(0x01))
+	receiver.setDown(false)
+	checkAlerts()
+
+	// The resolution of a condition notified before is sent even when it
+	// is raised again in the meantime.
+	removePeers()
+	checkAlerts("no_peers firing")
+	receiver.setDown(true)
+	lnd.addPeer(fakePubKey(0x01))
+	checkAlerts()
+	removePeers()
+	receiver.setDown(false)
+	checkAlerts("no_peers resolved", "no_peers firing")
+}
+
+// TestNewAlertManager ensures alerts are disabled without webhooks and that
+// invalid webhooks are refused.
+func TestNewAlertManager(t *testing.T) {
+	alerts, err := newAlertManager(&config{}, "testnet")
+	if err != nil || alerts != nil {
+		t.Fatalf("unexpected alert manager: %v, %v", alerts, err)
+	}
+
+	for _, webhook := range []string{"ftp://example.com", "/alerts",
+		"http://"} {
+
+		_, err := newAlertManager(&config{
+			AlertWebhooks: []string{webhook},
+		}, "testnet")
+		if err == nil {
+			t.Fatalf("invalid webhook %q accepted", webhook)
+		}
+	}
+}
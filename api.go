@@ -0,0 +1,686 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// apiPathPrefix is the path under which the versioned JSON API is
+	// served.
+	apiPathPrefix = "/api/v1"
+
+	// maxAPIRequestSize is the largest request body, in bytes, that the
+	// API will attempt to decode.
+	maxAPIRequestSize = 1 << 16
+
+	// sseKeepAliveInterval is the interval at which a comment is sent over
+	// idle event streams so that proxies don't close them.
+	sseKeepAliveInterval = 15 * time.Second
+)
+
+// apiError is the body returned by the API whenever a request fails. Code is
+// a stable machine readable identifier while Message is meant for humans.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// MaxChannelSize is the largest channel the faucet is currently able
+	// to fund, suggested along with wallet_low_funds errors when a
+	// smaller channel is possible.
+	MaxChannelSize int64 `json:"max_channel_size,omitempty"`
+}
+
+// apiErrorResponse wraps an apiError so that error responses are always
+// distinguishable from successful ones.
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiLimits describes the constraints the faucet enforces on each action for
+// the class of the requesting client.
+type apiLimits struct {
+	Class           clientClass `json:"class"`
+	MinChannelSize  int64       `json:"min_channel_size"`
+	MaxChannelSize  int64       `json:"max_channel_size"`
+	MinInvoiceAtoms int64       `json:"min_invoice_atoms"`
+	MaxInvoiceAtoms int64       `json:"max_invoice_atoms"`
+	MinPaymentAtoms int64       `json:"min_payment_atoms"`
+	MaxPaymentAtoms int64       `json:"max_payment_atoms"`
+}
+
+// apiNodeInfo describes the dcrlnd node backing the faucet.
+type apiNodeInfo struct {
+	PubKey        string   `json:"pubkey"`
+	Alias         string   `json:"alias"`
+	Version       string   `json:"version"`
+	URIs          []string `json:"uris"`
+	SyncedToChain bool     `json:"synced_to_chain"`
+	SyncedToGraph bool     `json:"synced_to_graph"`
+	BlockHeight   uint32   `json:"block_height"`
+	BlockHash     string   `json:"block_hash"`
+}
+
+// apiInfoResponse is returned by GET /api/v1/info.
+type apiInfoResponse struct {
+	FaucetVersion           string            `json:"faucet_version"`
+	FaucetCommit            string            `json:"faucet_commit"`
+	Network                 string            `json:"network"`
+	Node                    apiNodeInfo       `json:"node"`
+	ConfirmedBalance        int64             `json:"confirmed_balance"`
+	NumActiveChannels       int               `json:"num_active_channels"`
+	NumPendingChannels      int               `json:"num_pending_channels"`
+	NumInactiveChannels     uint32            `json:"num_inactive_channels"`
+	NumClosingChannels      int               `json:"num_closing_channels"`
+	LimboBalance            int64             `json:"limbo_balance"`
+	Limits                  apiLimits         `json:"limits"`
+	Budgets                 []budgetStatus    `json:"budgets"`
+	RateLimiter             *rateLimiterStats `json:"rate_limiter"`
+	DisableOpenChannels     bool              `json:"disable_open_channels"`
+	DisableGenerateInvoices bool              `json:"disable_generate_invoices"`
+	DisablePayInvoices      bool              `json:"disable_pay_invoices"`
+	CaptchaActions          []string          `json:"captcha_actions"`
+	PoWActions              []string          `json:"pow_actions"`
+	Explorer                *blockExplorer    `json:"explorer,omitempty"`
+}
+
+// apiCaptchaSolution holds the solution to the captcha of an action. The
+// token is only used by the self-hosted captcha, while the response is either
+// the answer to its sum or the response of the remote captcha widget.
+type apiCaptchaSolution struct {
+	CaptchaToken    string `json:"captcha_token,omitempty"`
+	CaptchaResponse string `json:"captcha_response,omitempty"`
+}
+
+// solution returns the captcha solution held by the request.
+func (s *apiCaptchaSolution) solution() *captchaSolution {
+	return &captchaSolution{
+		Token:    s.CaptchaToken,
+		Response: s.CaptchaResponse,
+	}
+}
+
+// apiPoWSolution holds the solution to the proof of work challenge of an
+// action.
+type apiPoWSolution struct {
+	PoWChallenge string `json:"pow_challenge,omitempty"`
+	PoWSolution  string `json:"pow_solution,omitempty"`
+}
+
+// apiOpenChannelRequest is the body accepted by POST /api/v1/channels. The
+// node is given either as its public key or as pubkey@host:port, and all
+// amounts are in atoms.
+type apiOpenChannelRequest struct {
+	NodePubKey string `json:"node_pubkey"`
+	Amount     int64  `json:"amount"`
+	PushAmount int64  `json:"push_amount"`
+	apiCaptchaSolution
+	apiPoWSolution
+}
+
+// apiInvoiceRequest is the body accepted by POST /api/v1/invoices.
+type apiInvoiceRequest struct {
+	Amount      int64  `json:"amount"`
+	Description string `json:"description"`
+	apiCaptchaSolution
+}
+
+// apiInvoiceResponse is returned once the faucet has generated an invoice.
+type apiInvoiceResponse struct {
+	PaymentRequest string `json:"payment_request"`
+	PaymentHash    string `json:"payment_hash"`
+	AddIndex       uint64 `json:"add_index"`
+}
+
+// apiPaymentRequest is the body accepted by POST /api/v1/payments.
+type apiPaymentRequest struct {
+	PaymentRequest string `json:"payment_request"`
+	apiCaptchaSolution
+	apiPoWSolution
+}
+
+// apiHop is a single hop of the route taken by a payment.
+type apiHop struct {
+	PubKey    string `json:"pubkey"`
+	ChanID    uint64 `json:"chan_id"`
+	FeeMAtoms int64  `json:"fee_matoms"`
+}
+
+// apiPaymentResponse is returned once the faucet has paid an invoice.
+type apiPaymentResponse struct {
+	Destination     string   `json:"destination"`
+	Description     string   `json:"description"`
+	Amount          int64    `json:"amount"`
+	PaymentHash     string   `json:"payment_hash"`
+	PaymentPreimage string   `json:"payment_preimage"`
+	Hops            []apiHop `json:"hops"`
+}
+
+// apiStatusCode returns the HTTP status code used to report the passed
+// ChanCreationError through the API.
+func apiStatusCode(c ChanCreationError) int {
+	switch c {
+	case NoError:
+		return http.StatusOK
+
+	case InvalidAddress, ChanAmountNotNumber, ChannelTooLarge,
+		ChannelTooSmall, PushIncorrect, InvoiceAmountTooHigh,
+		ErrorDecodingPayReq, ErrorPaymentAmount, AmountTooSmall:
+		return http.StatusBadRequest
+
+	case NotConnected, PeerWrongNetwork:
+		return http.StatusPreconditionFailed
+
+	case HaveChannel, HavePendingChannel, OpenInProgress:
+		return http.StatusConflict
+
+	case TimeLimitError:
+		return http.StatusTooManyRequests
+
+	case CaptchaFailed, PoWFailed:
+		return http.StatusForbidden
+
+	case ChannelOpenFail, ErrorGeneratingInvoice, PaymentStreamError,
+		PeerUnreachable, PeerHandshakeFailed:
+		return http.StatusBadGateway
+
+	case ChannelCapReached, OpenQueueFull, BudgetExhausted,
+		WalletLowFunds:
+		return http.StatusServiceUnavailable
+
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON encodes v as the JSON body of the response with the given status
+// code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("unable to encode API response: %v", err)
+	}
+}
+
+// writeAPIError reports a failed request with the given status code, machine
+// readable code and human readable message.
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, &apiErrorResponse{
+		Error: apiError{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// writeChanCreationError reports a failed request caused by the passed
+// ChanCreationError.
+func writeChanCreationError(w http.ResponseWriter, c ChanCreationError) {
+	writeAPIError(w, apiStatusCode(c), c.Code(), c.String())
+}
+
+// decodeAPIRequest decodes the JSON body of the request into v. If the body
+// can't be decoded an error response is written and false is returned.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request,
+	v interface{}) bool {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request",
+			"unable to decode request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// registerAPIRoutes registers the handlers of the versioned JSON API with the
+// passed router.
+func (l *lightningFaucet) registerAPIRoutes(r *mux.Router) {
+	api := r.PathPrefix(apiPathPrefix).Subrouter()
+	api.HandleFunc("/info", l.apiInfo).Methods("GET")
+	api.HandleFunc("/limbo", l.apiLimbo).Methods("GET")
+	api.HandleFunc("/captcha/{action}", l.apiCaptcha).Methods("GET")
+	api.HandleFunc("/pow/{action}", l.apiPoWChallenge).Methods("GET")
+	api.HandleFunc("/channels", l.apiOpenChannel).Methods("POST")
+	api.HandleFunc("/channels/{id}", l.apiOpenJob).Methods("GET")
+	api.HandleFunc("/channels/{id}/events", l.apiOpenJobEvents).Methods("GET")
+	api.HandleFunc("/invoices", l.apiGenerateInvoice).Methods("POST")
+	api.HandleFunc("/payments", l.apiPayInvoice).Methods("POST")
+}
+
+// apiInfo returns general information about the faucet, its node and the
+// limits it enforces.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiInfo(w http.ResponseWriter, r *http.Request) {
+	homeInfo, err := l.fetchHomeState(r.Context())
+	if err != nil {
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	nodeInfo := homeInfo.NodeInfo
+	limits := l.requestLimits(r)
+	captchaActions := make([]string, 0, len(l.captchaActions))
+	for _, action := range []string{OpenChannelAction,
+		GenerateInvoiceAction, PayInvoiceAction} {
+
+		if l.captchaRequired(action) {
+			captchaActions = append(captchaActions, action)
+		}
+	}
+	powRequired := make([]string, 0, len(powActions))
+	for _, action := range []string{OpenChannelAction, PayInvoiceAction} {
+		if l.powRequired(limits.Class, action) {
+			powRequired = append(powRequired, action)
+		}
+	}
+	writeJSON(w, http.StatusOK, &apiInfoResponse{
+		FaucetVersion: homeInfo.FaucetVersion,
+		FaucetCommit:  homeInfo.FaucetCommit,
+		Network:       homeInfo.Network,
+		Node: apiNodeInfo{
+			PubKey:        nodeInfo.IdentityPubkey,
+			Alias:         nodeInfo.Alias,
+			Version:       nodeInfo.Version,
+			URIs:          nodeInfo.Uris,
+			SyncedToChain: nodeInfo.SyncedToChain,
+			SyncedToGraph: nodeInfo.SyncedToGraph,
+			BlockHeight:   nodeInfo.BlockHeight,
+			BlockHash:     nodeInfo.BlockHash,
+		},
+		ConfirmedBalance:    homeInfo.ConfirmedBalance,
+		NumActiveChannels:   len(homeInfo.ActiveChannels),
+		NumPendingChannels:  len(homeInfo.PendingChannels),
+		NumInactiveChannels: nodeInfo.NumInactiveChannels,
+		NumClosingChannels:  len(homeInfo.Limbo.Channels),
+		LimboBalance:        homeInfo.Limbo.TotalLimbo,
+		Limits: apiLimits{
+			Class:           limits.Class,
+			MinChannelSize:  limits.OpenChannel.MinAmount,
+			MaxChannelSize:  limits.OpenChannel.MaxAmount,
+			MinInvoiceAtoms: limits.GenerateInvoice.MinAmount,
+			MaxInvoiceAtoms: limits.GenerateInvoice.MaxAmount,
+			MinPaymentAtoms: limits.PayInvoice.MinAmount,
+			MaxPaymentAtoms: limits.PayInvoice.MaxAmount,
+		},
+		Budgets:                 l.budget.status(time.Now()),
+		RateLimiter:             l.limiter.stats(),
+		DisableOpenChannels:     homeInfo.DisableOpenChannels,
+		DisableGenerateInvoices: homeInfo.DisableGenerateInvoices,
+		DisablePayInvoices:      homeInfo.DisablePayInvoices,
+		CaptchaActions:          captchaActions,
+		PoWActions:              powRequired,
+		Explorer:                homeInfo.Explorer,
+	})
+}
+
+// apiLimbo returns the channels pending close along with the funds they hold,
+// and the channels whose funds recently returned to the wallet.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiLimbo(w http.ResponseWriter, r *http.Request) {
+	if err := l.updateLimbo(r.Context()); err != nil {
+		log.Errorf("unable to fetch pending channels: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, l.limbo.summary())
+}
+
+// apiCaptcha returns a new captcha challenge for the action given in the path.
+// Its solution must be submitted along with the request of the action.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiCaptcha(w http.ResponseWriter, r *http.Request) {
+	action := mux.Vars(r)["action"]
+	if !l.captchaRequired(action) {
+		writeAPIError(w, http.StatusNotFound, "captcha_not_required",
+			"action does not require human verification")
+		return
+	}
+
+	challenge, err := l.captcha.newChallenge()
+	if err != nil {
+		log.Errorf("unable to create captcha challenge: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challenge)
+}
+
+// apiPoWChallenge issues a proof of work challenge for the action given in the
+// path. The amount query parameter must hold the size of the channel to open
+// or the amount of the invoice to pay, as larger amounts get harder
+// challenges and a challenge is only valid for up to its amount.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiPoWChallenge(w http.ResponseWriter, r *http.Request) {
+	action := mux.Vars(r)["action"]
+	if l.pow == nil || !powActions[action] {
+		writeAPIError(w, http.StatusNotFound, "pow_not_required",
+			"action does not require a proof of work")
+		return
+	}
+
+	var amount int64
+	if rawAmount := r.URL.Query().Get("amount"); rawAmount != "" {
+		var err error
+		amount, err = strconv.ParseInt(rawAmount, 10, 64)
+		if err != nil || amount < 0 {
+			writeChanCreationError(w, ChanAmountNotNumber)
+			return
+		}
+	}
+
+	challenge, err := l.pow.newChallenge(action, amount)
+	if err != nil {
+		log.Errorf("unable to create proof of work challenge: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challenge)
+}
+
+// powRequired returns whether clients of the given class must solve a proof of
+// work challenge to perform the action. Only anonymous clients must, as the
+// others are identified by their API key or their address.
+func (l *lightningFaucet) powRequired(class clientClass, action string) bool {
+	return l.pow != nil && powActions[action] && class == anonymousClient
+}
+
+// checkPoW verifies the solution to the proof of work challenge of the action
+// of amount atoms, if proofs of work are required from clients of the given
+// class.
+func (l *lightningFaucet) checkPoW(class clientClass, action string,
+	sol *apiPoWSolution, amount int64) ChanCreationError {
+
+	if !l.powRequired(class, action) {
+		return NoError
+	}
+
+	err := l.pow.verify(sol.PoWChallenge, sol.PoWSolution, action, amount)
+	if err != nil {
+		log.Debugf("Proof of work for %v rejected: %v", action, err)
+		return PoWFailed
+	}
+
+	return NoError
+}
+
+// apiOpenChannel queues the open of a channel with the node given in the
+// request. The returned job reports the progress of the open, which can be
+// followed at the URL given in the Location header.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiOpenChannel(w http.ResponseWriter, r *http.Request) {
+	if l.actionDisabled(OpenChannelAction) {
+		writeAPIError(w, http.StatusForbidden, "action_disabled",
+			"open channels was disabled")
+		return
+	}
+
+	var req apiOpenChannelRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	clientIP, err := getRealIP(r, l.trustedProxies)
+	if err != nil {
+		log.Errorf("Can't get client ip: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	chanErr := l.checkCaptcha(r.Context(), OpenChannelAction,
+		req.solution(), clientIP)
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	class := l.policy.classify(r, clientIP)
+	chanErr = l.checkPoW(class, OpenChannelAction, &req.apiPoWSolution,
+		req.Amount)
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	job, chanErr := l.queueChannel(r.Context(), clientIP, class,
+		req.NodePubKey, req.Amount, req.PushAmount)
+	if chanErr == WalletLowFunds {
+		msg, maxSize := l.lowFundsMessage(r.Context(), class)
+		writeJSON(w, apiStatusCode(chanErr), &apiErrorResponse{
+			Error: apiError{
+				Code:           chanErr.Code(),
+				Message:        msg,
+				MaxChannelSize: maxSize,
+			},
+		})
+		return
+	}
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	w.Header().Set("Location", apiPathPrefix+"/channels/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// apiOpenJob returns the progress of the channel open whose job ID is given in
+// the path.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiOpenJob(w http.ResponseWriter, r *http.Request) {
+	job := l.lookupOpenJob(r.Context(), mux.Vars(r)["id"])
+	if job == nil {
+		writeAPIError(w, http.StatusNotFound, "unknown_job",
+			"no such channel open")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// writeSSE writes v as the JSON data of a server-sent event of the given type
+// and flushes it to the client.
+func writeSSE(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// apiOpenJobEvents streams the progress of the channel open whose job ID is
+// given in the path as server-sent events. A progress event holding the job is
+// sent right away and after every change, until the channel is open or the
+// open failed. Streams cut short by the write timeout of the server are
+// resumed by the EventSource of browsers, which reconnect on their own.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiOpenJobEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeAPIError(w, http.StatusInternalServerError,
+			"streaming_unsupported", "event streams are not supported")
+		return
+	}
+
+	job, updates, cancel := l.opener.subscribe(mux.Vars(r)["id"])
+	if job == nil {
+		writeAPIError(w, http.StatusNotFound, "unknown_job",
+			"no such channel open")
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		if err := writeSSE(w, "progress", job); err != nil {
+			log.Debugf("unable to send job %v progress: %v", job.ID,
+				err)
+			return
+		}
+		if job.finished() {
+			return
+		}
+
+		job = nil
+		for job == nil {
+			select {
+			case job = <-updates:
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			case <-l.quit:
+				return
+			}
+		}
+	}
+}
+
+// apiGenerateInvoice generates an invoice for the amount given in the
+// request.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiGenerateInvoice(w http.ResponseWriter, r *http.Request) {
+	if l.actionDisabled(GenerateInvoiceAction) {
+		writeAPIError(w, http.StatusForbidden, "action_disabled",
+			"generate invoices was disabled")
+		return
+	}
+
+	var req apiInvoiceRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	clientIP, err := getRealIP(r, l.trustedProxies)
+	if err != nil {
+		log.Errorf("Can't get client ip: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	chanErr := l.checkCaptcha(r.Context(), GenerateInvoiceAction,
+		req.solution(), clientIP)
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	invoice, chanErr := l.createInvoice(r.Context(), clientIP,
+		l.policy.classify(r, clientIP), req.Amount, req.Description)
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &apiInvoiceResponse{
+		PaymentRequest: invoice.PaymentRequest,
+		PaymentHash:    hex.EncodeToString(invoice.RHash),
+		AddIndex:       invoice.AddIndex,
+	})
+}
+
+// apiPayInvoice pays the payment request given in the request.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) apiPayInvoice(w http.ResponseWriter, r *http.Request) {
+	if l.actionDisabled(PayInvoiceAction) {
+		writeAPIError(w, http.StatusForbidden, "action_disabled",
+			"invoices payment was disabled")
+		return
+	}
+
+	var req apiPaymentRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	clientIP, err := getRealIP(r, l.trustedProxies)
+	if err != nil {
+		log.Errorf("Can't get client ip: %v", err)
+		writeChanCreationError(w, InternalServerError)
+		return
+	}
+
+	chanErr := l.checkCaptcha(r.Context(), PayInvoiceAction,
+		req.solution(), clientIP)
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	// The proof of work must have been issued for at least the amount of
+	// the invoice.
+	class := l.policy.classify(r, clientIP)
+	if l.powRequired(class, PayInvoiceAction) {
+		payReq := strings.TrimSpace(req.PaymentRequest)
+		decodedPayReq, err := l.lnd.DecodePayReq(r.Context(), payReq)
+		if err != nil {
+			log.Errorf("Error on decode pay_req: %v", err)
+			writeChanCreationError(w, ErrorDecodingPayReq)
+			return
+		}
+		chanErr = l.checkPoW(class, PayInvoiceAction,
+			&req.apiPoWSolution, decodedPayReq.GetNumAtoms())
+	}
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	payment, chanErr := l.sendPayment(r.Context(), clientIP, class,
+		req.PaymentRequest)
+	if chanErr != NoError {
+		writeChanCreationError(w, chanErr)
+		return
+	}
+
+	hops := make([]apiHop, 0, len(payment.Hops))
+	for _, hop := range payment.Hops {
+		hops = append(hops, apiHop{
+			PubKey:    hop.PubKey,
+			ChanID:    hop.ChanId,
+			FeeMAtoms: hop.FeeMAtoms,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, &apiPaymentResponse{
+		Destination:     payment.Destination,
+		Description:     payment.Description,
+		Amount:          int64(payment.Amount),
+		PaymentHash:     payment.PaymentHash,
+		PaymentPreimage: payment.Preimage,
+		Hops:            hops,
+	})
+}
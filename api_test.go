@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAPIStatusCode ensures every ChanCreationError is reported through the
+// API with the status code matching its cause.
+func TestAPIStatusCode(t *testing.T) {
+	tests := []struct {
+		chanErr    ChanCreationError
+		wantStatus int
+	}{
+		{NoError, http.StatusOK},
+		{InvalidAddress, http.StatusBadRequest},
+		{NotConnected, http.StatusPreconditionFailed},
+		{ChanAmountNotNumber, http.StatusBadRequest},
+		{ChannelTooLarge, http.StatusBadRequest},
+		{ChannelTooSmall, http.StatusBadRequest},
+		{PushIncorrect, http.StatusBadRequest},
+		{ChannelOpenFail, http.StatusBadGateway},
+		{HaveChannel, http.StatusConflict},
+		{HavePendingChannel, http.StatusConflict},
+		{ErrorGeneratingInvoice, http.StatusBadGateway},
+		{InvoiceAmountTooHigh, http.StatusBadRequest},
+		{ErrorDecodingPayReq, http.StatusBadRequest},
+		{PaymentStreamError, http.StatusBadGateway},
+		{ErrorPaymentAmount, http.StatusBadRequest},
+		{TimeLimitError, http.StatusTooManyRequests},
+		{InternalServerError, http.StatusInternalServerError},
+		{CaptchaFailed, http.StatusForbidden},
+		{PoWFailed, http.StatusForbidden},
+		{ChannelCapReached, http.StatusServiceUnavailable},
+		{OpenQueueFull, http.StatusServiceUnavailable},
+		{PeerUnreachable, http.StatusBadGateway},
+		{PeerHandshakeFailed, http.StatusBadGateway},
+		{PeerWrongNetwork, http.StatusPreconditionFailed},
+		{OpenInProgress, http.StatusConflict},
+		{AmountTooSmall, http.StatusBadRequest},
+		{BudgetExhausted, http.StatusServiceUnavailable},
+		{WalletLowFunds, http.StatusServiceUnavailable},
+		{WalletLowFunds + 1, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		status := apiStatusCode(test.chanErr)
+		if status != test.wantStatus {
+			t.Fatalf("%v: unexpected status: got %d, want %d",
+				test.chanErr.Code(), status, test.wantStatus)
+		}
+	}
+}
+
+// apiErrorCheck returns a handlerTest check ensuring the response is a JSON
+// error envelope holding nothing but an error of the given code along with a
+// message.
+func apiErrorCheck(wantCode string) func(*testing.T, *fakeBackend,
+	*lightningFaucet, *httptest.ResponseRecorder) {
+
+	return func(t *testing.T, _ *fakeBackend, _ *lightningFaucet,
+		rec *httptest.ResponseRecorder) {
+
+		t.Helper()
+
+		contentType := rec.Header().Get("Content-Type")
+		if contentType != "application/json" {
+			t.Fatalf("unexpected content type: %q", contentType)
+		}
+
+		var envelope map[string]json.RawMessage
+		err := json.Unmarshal(rec.Body.Bytes(), &envelope)
+		if err != nil {
+			t.Fatalf("unable to decode response: %v", err)
+		}
+		if len(envelope) != 1 || envelope["error"] == nil {
+			t.Fatalf("unexpected error envelope: %s", rec.Body)
+		}
+
+		var apiErr apiError
+		if err := json.Unmarshal(envelope["error"], &apiErr); err != nil {
+			t.Fatalf("unable to decode error: %v", err)
+		}
+		if apiErr.Code != wantCode || apiErr.Message == "" {
+			t.Fatalf("unexpected error: %+v", apiErr)
+		}
+	}
+}
+
+// TestAPIOpenChannel exercises the channel open endpoint of the API.
+func TestAPIOpenChannel(t *testing.T) {
+	peer := fakePubKey(0x01)
+	target := apiPathPrefix + "/channels"
+	openBody := func(node string, amt, push int64) string {
+		return `{"node_pubkey": "` + node + `", "amount": ` +
+			strconv.FormatInt(amt, 10) + `, "push_amount": ` +
+			strconv.FormatInt(push, 10) + `}`
+	}
+
+	tests := []handlerTest{{
+		name:       "malformed body",
+		method:     http.MethodPost,
+		target:     target,
+		body:       `{"node_pubkey": `,
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck("invalid_request"),
+	}, {
+		name:       "wrong field type",
+		method:     http.MethodPost,
+		target:     target,
+		body:       `{"node_pubkey": "` + peer + `", "amount": "lots"}`,
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck("invalid_request"),
+	}, {
+		name:   "oversized body",
+		method: http.MethodPost,
+		target: target,
+		body: `{"node_pubkey": "` +
+			strings.Repeat("0", maxAPIRequestSize) + `"}`,
+		wantStatus: http.StatusBadRequest,
+		wantBody:   []string{"too large"},
+		check:      apiErrorCheck("invalid_request"),
+	}, {
+		name:       "invalid node",
+		method:     http.MethodPost,
+		target:     target,
+		body:       openBody("garbage", 1e6, 0),
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck(InvalidAddress.Code()),
+	}, {
+		name:       "not connected",
+		method:     http.MethodPost,
+		target:     target,
+		body:       openBody(peer, 1e6, 0),
+		wantStatus: http.StatusPreconditionFailed,
+		check:      apiErrorCheck(NotConnected.Code()),
+	}, {
+		name: "push too large",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+		},
+		method:     http.MethodPost,
+		target:     target,
+		body:       openBody(peer, 1e6, 1e6),
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck(PushIncorrect.Code()),
+	}, {
+		name: "rate limited",
+		setup: func(lnd *fakeBackend, l *lightningFaucet) {
+			lnd.addPeer(peer)
+			l.limiter.record(OpenChannelAction, time.Now(),
+				rateLimitKey{clientIPKey, testClientIP})
+		},
+		method:     http.MethodPost,
+		target:     target,
+		body:       openBody(peer, 1e6, 0),
+		wantStatus: http.StatusTooManyRequests,
+		check:      apiErrorCheck(TimeLimitError.Code()),
+	}, {
+		name: "open disabled",
+		setup: func(_ *fakeBackend, l *lightningFaucet) {
+			l.setActionDisabled(OpenChannelAction, true)
+		},
+		method:     http.MethodPost,
+		target:     target,
+		body:       openBody(peer, 1e6, 0),
+		wantStatus: http.StatusForbidden,
+		check:      apiErrorCheck("action_disabled"),
+	}, {
+		name: "channel queued",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+		},
+		method:     http.MethodPost,
+		target:     target,
+		body:       openBody(peer, 1e6, 1e5),
+		wantStatus: http.StatusAccepted,
+		check: func(t *testing.T, _ *fakeBackend, l *lightningFaucet,
+			rec *httptest.ResponseRecorder) {
+
+			var job openJob
+			err := json.Unmarshal(rec.Body.Bytes(), &job)
+			if err != nil {
+				t.Fatalf("unable to decode job: %v", err)
+			}
+			if job.ID == "" || job.NodePubKey != peer ||
+				job.Amount != 1e6 || job.PushAmount != 1e5 {
+
+				t.Fatalf("unexpected job: %+v", job)
+			}
+
+			// The Location header points at the progress of the
+			// job that was returned.
+			location := rec.Header().Get("Location")
+			if location != apiPathPrefix+"/channels/"+job.ID {
+				t.Fatalf("unexpected location: %q", location)
+			}
+			if waitOpenJob(t, l, job.ID).State != openJobPending {
+				t.Fatalf("channel of job %v not pending", job.ID)
+			}
+		},
+	}}
+
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return l.apiOpenChannel
+	})
+}
+
+// TestAPIInvoices exercises the invoice and payment endpoints of the API.
+func TestAPIInvoices(t *testing.T) {
+	dest := fakePubKey(0x02)
+	genTarget := apiPathPrefix + "/invoices"
+	payTarget := apiPathPrefix + "/payments"
+	handlers := map[string]func(*lightningFaucet) http.HandlerFunc{
+		genTarget: func(l *lightningFaucet) http.HandlerFunc {
+			return l.apiGenerateInvoice
+		},
+		payTarget: func(l *lightningFaucet) http.HandlerFunc {
+			return l.apiPayInvoice
+		},
+	}
+
+	tests := []handlerTest{{
+		name:       "generate invoice",
+		method:     http.MethodPost,
+		target:     genTarget,
+		body:       `{"amount": 1000000, "description": "test"}`,
+		wantStatus: http.StatusCreated,
+		wantBody:   []string{`"payment_request":"lntdcr1fake`},
+	}, {
+		name:       "invoice malformed body",
+		method:     http.MethodPost,
+		target:     genTarget,
+		body:       `[1000000]`,
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck("invalid_request"),
+	}, {
+		name:       "invoice too large",
+		method:     http.MethodPost,
+		target:     genTarget,
+		body:       `{"amount": 100000000}`,
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck(InvoiceAmountTooHigh.Code()),
+	}, {
+		name: "invoice generation fails",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.errAddInvoice = errors.New("db full")
+		},
+		method:     http.MethodPost,
+		target:     genTarget,
+		body:       `{"amount": 1000000}`,
+		wantStatus: http.StatusBadGateway,
+		check:      apiErrorCheck(ErrorGeneratingInvoice.Code()),
+	}, {
+		name: "pay invoice",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+		},
+		method:     http.MethodPost,
+		target:     payTarget,
+		body:       `{"payment_request": "lntdcr1pay"}`,
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			`"destination":"` + dest + `"`,
+			`"amount":500`,
+		},
+	}, {
+		name:       "undecodable payreq",
+		method:     http.MethodPost,
+		target:     payTarget,
+		body:       `{"payment_request": "garbage"}`,
+		wantStatus: http.StatusBadRequest,
+		check:      apiErrorCheck(ErrorDecodingPayReq.Code()),
+	}, {
+		name: "payment fails",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+			lnd.errPayment = errors.New("no route")
+		},
+		method:     http.MethodPost,
+		target:     payTarget,
+		body:       `{"payment_request": "lntdcr1pay"}`,
+		wantStatus: http.StatusBadGateway,
+		check:      apiErrorCheck(PaymentStreamError.Code()),
+	}}
+
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handlers[r.URL.Path](l)(w, r)
+		}
+	})
+}
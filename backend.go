@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrlnd/lnrpc"
+	"github.com/decred/dcrlnd/macaroons"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// lightningBackend is the narrow set of operations the faucet requires from
+// the Lightning Network node it is backed by. Streaming RPCs are collapsed
+// into blocking calls so that implementations other than dcrlnd (such as the
+// in-memory fake used by the tests) remain simple.
+type lightningBackend interface {
+	// GetInfo returns general information about the node.
+	GetInfo(ctx context.Context) (*lnrpc.GetInfoResponse, error)
+
+	// NodeInfo returns the announcement information of the node with the
+	// given public key.
+	NodeInfo(ctx context.Context, pubKey string) (*lnrpc.NodeInfo, error)
+
+	// ListChannels returns all of the node's open channels.
+	ListChannels(ctx context.Context) ([]*lnrpc.Channel, error)
+
+	// PendingChannels returns all of the node's pending channels.
+	PendingChannels(ctx context.Context) (*lnrpc.PendingChannelsResponse, error)
+
+	// OpenChannel starts the funding workflow described by the request and
+	// returns the funding outpoint once the funding transaction has been
+	// broadcast.
+	OpenChannel(ctx context.Context,
+		req *lnrpc.OpenChannelRequest) (*wire.OutPoint, error)
+
+	// CloseChannel closes the channel, optionally executing a force
+	// close, and returns the closing txid once it has been broadcast.
+	CloseChannel(ctx context.Context, chanPoint *lnrpc.ChannelPoint,
+		force bool) (*chainhash.Hash, error)
+
+	// AddInvoice adds a new invoice to the node.
+	AddInvoice(ctx context.Context,
+		invoice *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error)
+
+	// DecodePayReq decodes the passed payment request.
+	DecodePayReq(ctx context.Context, payReq string) (*lnrpc.PayReq, error)
+
+	// SendPayment attempts to pay the payment request described by req and
+	// blocks until the payment either succeeds or fails.
+	SendPayment(ctx context.Context,
+		req *lnrpc.SendRequest) (*lnrpc.SendResponse, error)
+
+	// ListPayments returns the payments sent by the node, including the
+	// ones that are still in flight.
+	ListPayments(ctx context.Context) ([]*lnrpc.Payment, error)
+
+	// ListPeers returns the peers the node is currently connected to.
+	ListPeers(ctx context.Context) ([]*lnrpc.Peer, error)
+
+	// ConnectPeer connects to the node with the given public key at the
+	// given host:port address.
+	ConnectPeer(ctx context.Context, pubKey, host string) error
+
+	// WalletBalance returns the balance of the node's on-chain wallet.
+	WalletBalance(ctx context.Context) (*lnrpc.WalletBalanceResponse, error)
+
+	// ListUnspent returns the unspent outputs of the node's on-chain
+	// wallet, including the unconfirmed ones.
+	ListUnspent(ctx context.Context) ([]*lnrpc.Utxo, error)
+
+	// GetTransactions returns the transactions of the node's on-chain
+	// wallet.
+	GetTransactions(ctx context.Context) ([]*lnrpc.Transaction, error)
+
+	// SubscribeChannelEvents calls fn for every change of the state of
+	// the node's channels, and blocks until the context is canceled or
+	// the subscription fails.
+	SubscribeChannelEvents(ctx context.Context,
+		fn func(*lnrpc.ChannelEventUpdate)) error
+}
+
+// lndBackend is a lightningBackend that is backed by the gRPC interface of a
+// dcrlnd node.
+type lndBackend struct {
+	client lnrpc.LightningClient
+}
+
+// A compile-time assertion to ensure lndBackend meets the lightningBackend
+// interface.
+var _ lightningBackend = (*lndBackend)(nil)
+
+// newLndBackend establishes a connection to the dcrlnd gRPC server described
+// by the passed config.
+func newLndBackend(cfg *config) (*lndBackend, error) {
+	// First attempt to establish a connection to lnd's RPC sever.
+	tlsCertPath := cleanAndExpandPath(cfg.TLSCertPath)
+	creds, err := credentials.NewClientTLSFromFile(tlsCertPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cert file: %v", err)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	// Load the specified macaroon file.
+	macPath := cleanAndExpandPath(cfg.MacaroonPath)
+	macBytes, err := ioutil.ReadFile(macPath)
+	if err != nil {
+		return nil, err
+	}
+	mac := &macaroon.Macaroon{}
+	if err = mac.UnmarshalBinary(macBytes); err != nil {
+		return nil, err
+	}
+
+	// Now we append the macaroon credentials to the dial options.
+	opts = append(
+		opts,
+		grpc.WithPerRPCCredentials(macaroons.NewMacaroonCredential(mac)),
+	)
+
+	conn, err := grpc.Dial(cfg.LndNode, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial to lnd's gRPC server: %v", err)
+	}
+
+	return &lndBackend{
+		client: lnrpc.NewLightningClient(conn),
+	}, nil
+}
+
+// GetInfo returns general information about the node.
+func (b *lndBackend) GetInfo(ctx context.Context) (*lnrpc.GetInfoResponse, error) {
+	return b.client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+}
+
+// NodeInfo returns the announcement information of the node with the given
+// public key.
+func (b *lndBackend) NodeInfo(ctx context.Context,
+	pubKey string) (*lnrpc.NodeInfo, error) {
+
+	return b.client.GetNodeInfo(ctx, &lnrpc.NodeInfoRequest{
+		PubKey: pubKey,
+	})
+}
+
+// ListChannels returns all of the node's open channels.
+func (b *lndBackend) ListChannels(ctx context.Context) ([]*lnrpc.Channel, error) {
+	resp, err := b.client.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Channels, nil
+}
+
+// PendingChannels returns all of the node's pending channels.
+func (b *lndBackend) PendingChannels(
+	ctx context.Context) (*lnrpc.PendingChannelsResponse, error) {
+
+	return b.client.PendingChannels(ctx, &lnrpc.PendingChannelsRequest{})
+}
+
+// OpenChannel starts the funding workflow described by the request and
+// returns the funding outpoint once the funding transaction has been
+// broadcast.
+func (b *lndBackend) OpenChannel(ctx context.Context,
+	req *lnrpc.OpenChannelRequest) (*wire.OutPoint, error) {
+
+	stream, err := b.client.OpenChannel(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Consume the first update from the open channel stream which
+	// indicates that the channel has been broadcast to the network.
+	chanUpdate, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	update, ok := chanUpdate.Update.(*lnrpc.OpenStatusUpdate_ChanPending)
+	if !ok {
+		return nil, fmt.Errorf("didn't get a pending update")
+	}
+	fundingTXID, err := chainhash.NewHash(update.ChanPending.Txid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wire.OutPoint{
+		Hash:  *fundingTXID,
+		Index: update.ChanPending.OutputIndex,
+	}, nil
+}
+
+// CloseChannel closes the channel, optionally executing a force close, and
+// returns the closing txid once it has been broadcast.
+func (b *lndBackend) CloseChannel(ctx context.Context,
+	chanPoint *lnrpc.ChannelPoint, force bool) (*chainhash.Hash, error) {
+
+	closeReq := &lnrpc.CloseChannelRequest{
+		ChannelPoint: chanPoint,
+		Force:        force,
+	}
+	stream, err := b.client.CloseChannel(ctx, closeReq)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start channel close: %v", err)
+	}
+
+	// Consume the first response which'll be sent once the closing
+	// transaction has been broadcast.
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("unable to close chan: %v", err)
+	}
+
+	update, ok := resp.Update.(*lnrpc.CloseStatusUpdate_ClosePending)
+	if !ok {
+		return nil, fmt.Errorf("didn't get a pending update")
+	}
+
+	// Convert the raw bytes into a new chainhash so we gain access to its
+	// utility methods.
+	closingHash := update.ClosePending.Txid
+	return chainhash.NewHash(closingHash)
+}
+
+// AddInvoice adds a new invoice to the node.
+func (b *lndBackend) AddInvoice(ctx context.Context,
+	invoice *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error) {
+
+	return b.client.AddInvoice(ctx, invoice)
+}
+
+// DecodePayReq decodes the passed payment request.
+func (b *lndBackend) DecodePayReq(ctx context.Context,
+	payReq string) (*lnrpc.PayReq, error) {
+
+	return b.client.DecodePayReq(ctx, &lnrpc.PayReqString{PayReq: payReq})
+}
+
+// SendPayment attempts to pay the payment request described by req and
+// blocks until the payment either succeeds or fails.
+func (b *lndBackend) SendPayment(ctx context.Context,
+	req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+
+	// Create a payment stream to send the payment request.
+	paymentStream, err := b.client.SendPayment(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create payment stream: %v", err)
+	}
+	defer paymentStream.CloseSend()
+
+	// Stream the payment request.
+	if err := paymentStream.Send(req); err != nil {
+		return nil, fmt.Errorf("unable to send pay_req: %v", err)
+	}
+
+	// Receive response from streamed payment request.
+	resp, err := paymentStream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("unable to receive pay_req response: %v",
+			err)
+	}
+
+	return resp, nil
+}
+
+// ListPayments returns the payments sent by the node, including the ones that
+// are still in flight.
+func (b *lndBackend) ListPayments(ctx context.Context) ([]*lnrpc.Payment, error) {
+	resp, err := b.client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{
+		IncludeIncomplete: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payments, nil
+}
+
+// ListPeers returns the peers the node is currently connected to.
+func (b *lndBackend) ListPeers(ctx context.Context) ([]*lnrpc.Peer, error) {
+	resp, err := b.client.ListPeers(ctx, &lnrpc.ListPeersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
+
+// ConnectPeer connects to the node with the given public key at the given
+// host:port address.
+func (b *lndBackend) ConnectPeer(ctx context.Context, pubKey,
+	host string) error {
+
+	_, err := b.client.ConnectPeer(ctx, &lnrpc.ConnectPeerRequest{
+		Addr: &lnrpc.LightningAddress{
+			Pubkey: pubKey,
+			Host:   host,
+		},
+	})
+	return err
+}
+
+// WalletBalance returns the balance of the node's on-chain wallet.
+func (b *lndBackend) WalletBalance(
+	ctx context.Context) (*lnrpc.WalletBalanceResponse, error) {
+
+	return b.client.WalletBalance(ctx, &lnrpc.WalletBalanceRequest{})
+}
+
+// ListUnspent returns the unspent outputs of the node's on-chain wallet,
+// including the unconfirmed ones.
+func (b *lndBackend) ListUnspent(ctx context.Context) ([]*lnrpc.Utxo, error) {
+	resp, err := b.client.ListUnspent(ctx, &lnrpc.ListUnspentRequest{
+		MinConfs: 0,
+		MaxConfs: math.MaxInt32,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Utxos, nil
+}
+
+// GetTransactions returns the transactions of the node's on-chain wallet.
+func (b *lndBackend) GetTransactions(
+	ctx context.Context) ([]*lnrpc.Transaction, error) {
+
+	resp, err := b.client.GetTransactions(ctx,
+		&lnrpc.GetTransactionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Transactions, nil
+}
+
+// SubscribeChannelEvents calls fn for every change of the state of the node's
+// channels, and blocks until the context is canceled or the subscription
+// fails.
+func (b *lndBackend) SubscribeChannelEvents(ctx context.Context,
+	fn func(*lnrpc.ChannelEventUpdate)) error {
+
+	stream, err := b.client.SubscribeChannelEvents(ctx,
+		&lnrpc.ChannelEventSubscription{})
+	if err != nil {
+		return fmt.Errorf("unable to subscribe to channel events: %v",
+			err)
+	}
+
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		fn(update)
+	}
+}
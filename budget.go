@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// budgetKind identifies what a budget limits the spending of.
+type budgetKind string
+
+const (
+	// channelBudget limits the funds committed to new channels.
+	channelBudget budgetKind = "channels"
+
+	// pushBudget limits the funds pushed to the peers of new channels.
+	pushBudget budgetKind = "pushed"
+
+	// paymentBudget limits the funds paid out to invoices.
+	paymentBudget budgetKind = "payments"
+)
+
+// budgetPeriod is the length of the windows a budget applies to. Windows are
+// aligned to UTC, so hourly budgets reset on the hour and daily budgets at
+// midnight UTC.
+type budgetPeriod struct {
+	name   string
+	length time.Duration
+
+	// desc describes the current window in a sentence.
+	desc string
+}
+
+var (
+	// hourlyPeriod is the period of the budgets that reset every hour.
+	hourlyPeriod = budgetPeriod{"hourly", time.Hour, "this hour"}
+
+	// dailyPeriod is the period of the budgets that reset every day.
+	dailyPeriod = budgetPeriod{"daily", 24 * time.Hour, "today"}
+)
+
+// budgetSpend are the funds, in atoms, an action spends from each kind of
+// budget.
+type budgetSpend struct {
+	Channels int64
+	Pushed   int64
+	Payments int64
+}
+
+// amount returns the funds spent from the given kind of budget.
+func (s budgetSpend) amount(kind budgetKind) int64 {
+	switch kind {
+	case channelBudget:
+		return s.Channels
+	case pushBudget:
+		return s.Pushed
+	case paymentBudget:
+		return s.Payments
+	}
+	return 0
+}
+
+// budget limits the funds of one kind spent within each window of a period.
+type budget struct {
+	kind   budgetKind
+	period budgetPeriod
+	limit  int64
+
+	// start is the start of the current window and spent the funds spent
+	// within it.
+	start time.Time
+	spent int64
+}
+
+// roll moves the budget to the window holding now, which resets the spent
+// funds when a new window started. Budgets never move back to an earlier
+// window.
+func (b *budget) roll(now time.Time) {
+	start := now.UTC().Truncate(b.period.length)
+	if start.After(b.start) {
+		b.start = start
+		b.spent = 0
+	}
+}
+
+// fits returns whether amt atoms may still be spent within the current
+// window.
+func (b *budget) fits(amt int64) bool {
+	return amt == 0 || b.spent+amt <= b.limit
+}
+
+// budgetStatus describes the state of a budget in its current window.
+type budgetStatus struct {
+	Kind      budgetKind `json:"kind"`
+	Period    string     `json:"period"`
+	Limit     int64      `json:"limit"`
+	Spent     int64      `json:"spent"`
+	Remaining int64      `json:"remaining"`
+	ResetsAt  time.Time  `json:"resets_at"`
+
+	desc string
+}
+
+// Exhausted returns whether nothing is left of the budget.
+func (s budgetStatus) Exhausted() bool {
+	return s.Remaining <= 0
+}
+
+// String returns a human readable description of the budget.
+func (s budgetStatus) String() string {
+	return fmt.Sprintf("%s: %s of %s DCR left %s, resets at %s",
+		s.Kind, formatCoins(s.Remaining), formatCoins(s.Limit), s.desc,
+		s.ResetsAt.Format("2006-01-02 15:04 MST"))
+}
+
+// budgetTracker enforces the budgets of the faucet wallet. The funds spent are
+// only tracked in memory and are restored from the grants on startup.
+type budgetTracker struct {
+	mtx     sync.Mutex
+	budgets []*budget
+}
+
+// newBudgetTracker returns a budget tracker enforcing the budgets of the
+// passed config. Budgets of zero are disabled.
+func newBudgetTracker(cfg *config) *budgetTracker {
+	t := new(budgetTracker)
+	limits := []struct {
+		kind   budgetKind
+		period budgetPeriod
+		limit  int64
+	}{
+		{channelBudget, hourlyPeriod, cfg.ChannelBudgetHourly},
+		{channelBudget, dailyPeriod, cfg.ChannelBudgetDaily},
+		{pushBudget, hourlyPeriod, cfg.PushBudgetHourly},
+		{pushBudget, dailyPeriod, cfg.PushBudgetDaily},
+		{paymentBudget, hourlyPeriod, cfg.PaymentBudgetHourly},
+		{paymentBudget, dailyPeriod, cfg.PaymentBudgetDaily},
+	}
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+		t.budgets = append(t.budgets, &budget{
+			kind:   l.kind,
+			period: l.period,
+			limit:  l.limit,
+		})
+	}
+	return t
+}
+
+// check returns BudgetExhausted if the spend doesn't fit in every budget at
+// the time now, without spending it.
+func (t *budgetTracker) check(now time.Time, spend budgetSpend) ChanCreationError {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, b := range t.budgets {
+		b.roll(now)
+		if !b.fits(spend.amount(b.kind)) {
+			return BudgetExhausted
+		}
+	}
+	return NoError
+}
+
+// reserve spends the funds from every budget at the time now if they fit in
+// all of them, and returns BudgetExhausted otherwise. Funds that end up not
+// being spent must be given back with release.
+func (t *budgetTracker) reserve(now time.Time, spend budgetSpend) ChanCreationError {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, b := range t.budgets {
+		b.roll(now)
+		if !b.fits(spend.amount(b.kind)) {
+			log.Infof("%s %s budget exhausted until %v", b.period.name,
+				b.kind, b.start.Add(b.period.length))
+			return BudgetExhausted
+		}
+	}
+	for _, b := range t.budgets {
+		b.spent += spend.amount(b.kind)
+	}
+	return NoError
+}
+
+// release gives back funds reserved at the time at. Funds reserved within a
+// window that is over are already available again.
+func (t *budgetTracker) release(now, at time.Time, spend budgetSpend) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, b := range t.budgets {
+		b.roll(now)
+		if !at.UTC().Truncate(b.period.length).Equal(b.start) {
+			continue
+		}
+		b.spent -= spend.amount(b.kind)
+		if b.spent < 0 {
+			b.spent = 0
+		}
+	}
+}
+
+// record accounts for funds spent at the time at, which is used to restore
+// the funds spent before a restart.
+func (t *budgetTracker) record(now, at time.Time, spend budgetSpend) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, b := range t.budgets {
+		b.roll(now)
+		if at.UTC().Truncate(b.period.length).Equal(b.start) {
+			b.spent += spend.amount(b.kind)
+		}
+	}
+}
+
+// pausedUntil returns when the spend fits in every budget again, assuming
+// nothing else is spent, or the zero time if it fits right away.
+func (t *budgetTracker) pausedUntil(now time.Time, spend budgetSpend) time.Time {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var until time.Time
+	for _, b := range t.budgets {
+		b.roll(now)
+		resetsAt := b.start.Add(b.period.length)
+		if !b.fits(spend.amount(b.kind)) && resetsAt.After(until) {
+			until = resetsAt
+		}
+	}
+	return until
+}
+
+// status returns the state of the budgets of the given kinds, or of every
+// budget if no kind is given.
+func (t *budgetTracker) status(now time.Time, kinds ...budgetKind) []budgetStatus {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	statuses := make([]budgetStatus, 0, len(t.budgets))
+	for _, b := range t.budgets {
+		if !budgetKindIn(b.kind, kinds) {
+			continue
+		}
+		b.roll(now)
+		remaining := b.limit - b.spent
+		if remaining < 0 {
+			remaining = 0
+		}
+		statuses = append(statuses, budgetStatus{
+			Kind:      b.kind,
+			Period:    b.period.name,
+			Limit:     b.limit,
+			Spent:     b.spent,
+			Remaining: remaining,
+			ResetsAt:  b.start.Add(b.period.length),
+			desc:      b.period.desc,
+		})
+	}
+	return statuses
+}
+
+// budgetKindIn returns whether kind is one of kinds, or kinds is empty.
+func budgetKindIn(kind budgetKind, kinds []budgetKind) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreBudgets accounts for the grants recorded within the current window
+// of every budget, so that restarting the faucet doesn't reset the budgets.
+func (l *lightningFaucet) restoreBudgets() error {
+	now := time.Now()
+	since := now.UTC().Truncate(dailyPeriod.length)
+
+	err := l.db.ForEachChannelGrant(func(g *channelGrant) bool {
+		if g.Timestamp.Before(since) {
+			return false
+		}
+		l.budget.record(now, g.Timestamp, budgetSpend{
+			Channels: g.ChannelSize,
+			Pushed:   g.PushAmount,
+		})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.db.ForEachPaymentGrant(func(g *paymentGrant) bool {
+		if g.Timestamp.Before(since) {
+			return false
+		}
+		l.budget.record(now, g.Timestamp, budgetSpend{
+			Payments: g.Amount,
+		})
+		return true
+	})
+}
+
+// addLimits adds the limits of the client making the request to the page
+// state, along with the budgets of the faucet and the time at which the
+// actions paused by an exhausted budget resume.
+func (l *lightningFaucet) addLimits(homeState *homePageContext,
+	r *http.Request) {
+
+	now := time.Now()
+	homeState.Limits = l.requestLimits(r)
+	homeState.ChannelBudgets = l.budget.status(now, channelBudget,
+		pushBudget)
+	homeState.PaymentBudgets = l.budget.status(now, paymentBudget)
+
+	// The actions are paused once not even their smallest amount fits.
+	homeState.OpenChannelsPausedUntil = l.budget.pausedUntil(now,
+		budgetSpend{Channels: homeState.Limits.OpenChannel.MinAmount})
+	minPayment := homeState.Limits.PayInvoice.MinAmount
+	if minPayment < 1 {
+		minPayment = 1
+	}
+	homeState.PayInvoicesPausedUntil = l.budget.pausedUntil(now,
+		budgetSpend{Payments: minPayment})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestBudgetTracker ensures spending is refused once a budget is exhausted
+// and allowed again once its window is over.
+func TestBudgetTracker(t *testing.T) {
+	tracker := newBudgetTracker(&config{
+		ChannelBudgetHourly: 1e6,
+		ChannelBudgetDaily:  25e5,
+		PushBudgetDaily:     1e5,
+	})
+
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	nextHour := start.Add(time.Hour)
+	nextDay := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	spend := budgetSpend{Channels: 6e5, Pushed: 1e4}
+	if chanErr := tracker.reserve(start, spend); chanErr != NoError {
+		t.Fatalf("unable to reserve: %v", chanErr)
+	}
+
+	// The hourly budget has 4e5 atoms left.
+	if chanErr := tracker.check(start, spend); chanErr != BudgetExhausted {
+		t.Fatalf("unexpected check result: %v", chanErr)
+	}
+	if chanErr := tracker.reserve(start, spend); chanErr != BudgetExhausted {
+		t.Fatalf("unexpected reserve result: %v", chanErr)
+	}
+	if until := tracker.pausedUntil(start, spend); !until.Equal(nextHour) {
+		t.Fatalf("unexpected pause: %v", until)
+	}
+	small := budgetSpend{Channels: 4e5}
+	if !tracker.pausedUntil(start, small).IsZero() {
+		t.Fatalf("spend that fits is paused")
+	}
+
+	// Released funds can be spent again within the same window.
+	tracker.release(start, start, spend)
+	if chanErr := tracker.check(start, spend); chanErr != NoError {
+		t.Fatalf("released funds not available: %v", chanErr)
+	}
+	if chanErr := tracker.reserve(start, spend); chanErr != NoError {
+		t.Fatalf("unable to reserve: %v", chanErr)
+	}
+
+	// The hourly budget resets on the hour, while the daily budgets keep
+	// what was spent.
+	for i := 0; i < 3; i++ {
+		at := nextHour.Add(time.Duration(i) * time.Hour)
+		if chanErr := tracker.reserve(at, spend); chanErr != NoError {
+			t.Fatalf("unable to reserve at %v: %v", at, chanErr)
+		}
+	}
+	late := nextHour.Add(3 * time.Hour)
+	if chanErr := tracker.reserve(late, spend); chanErr != BudgetExhausted {
+		t.Fatalf("daily budget not exhausted: %v", chanErr)
+	}
+	if until := tracker.pausedUntil(late, spend); !until.Equal(nextDay) {
+		t.Fatalf("unexpected pause: %v", until)
+	}
+
+	// Releasing funds reserved in a previous window only gives them back
+	// to the budgets whose window is still the same.
+	tiny := budgetSpend{Channels: 1e5}
+	if chanErr := tracker.reserve(late, tiny); chanErr != NoError {
+		t.Fatalf("unable to reserve: %v", chanErr)
+	}
+	tracker.release(late, start, budgetSpend{Channels: 6e5})
+	wantSpent := map[string]int64{
+		hourlyPeriod.name: 1e5,
+		dailyPeriod.name:  19e5,
+	}
+	for _, status := range tracker.status(late, channelBudget) {
+		if status.Spent != wantSpent[status.Period] {
+			t.Fatalf("unexpected %s spending: %d", status.Period,
+				status.Spent)
+		}
+	}
+
+	// Everything is available again the next day.
+	if chanErr := tracker.reserve(nextDay, spend); chanErr != NoError {
+		t.Fatalf("unable to reserve the next day: %v", chanErr)
+	}
+	statuses := tracker.status(nextDay)
+	if len(statuses) != 3 {
+		t.Fatalf("unexpected number of budgets: %d", len(statuses))
+	}
+	want := "pushed: 0.0009 of 0.001 DCR left today, resets at " +
+		"2026-10-18 00:00 UTC"
+	if got := statuses[2].String(); got != want {
+		t.Fatalf("unexpected status: got %q, want %q", got, want)
+	}
+}
+
+// TestBudgetTrackerDisabled ensures a tracker without budgets allows any
+// spending.
+func TestBudgetTrackerDisabled(t *testing.T) {
+	tracker := newBudgetTracker(&config{})
+	spend := budgetSpend{Channels: maxFundingAmount, Payments: 1e8}
+	if chanErr := tracker.reserve(time.Now(), spend); chanErr != NoError {
+		t.Fatalf("unexpected reserve result: %v", chanErr)
+	}
+	if len(tracker.status(time.Now())) != 0 {
+		t.Fatalf("unexpected budgets")
+	}
+}
+
+// TestRestoreBudgets ensures the grants recorded within the current window of
+// the budgets are accounted for on startup.
+func TestRestoreBudgets(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	now := time.Now()
+	grants := []*channelGrant{{
+		NodePubKey:   fakePubKey(0x01),
+		ChannelSize:  1e6,
+		PushAmount:   1e4,
+		ChannelPoint: fakePubKey(0x01)[2:] + ":0",
+		Timestamp:    now.Add(-48 * time.Hour),
+	}, {
+		NodePubKey:   fakePubKey(0x02),
+		ChannelSize:  2e5,
+		PushAmount:   1e3,
+		ChannelPoint: fakePubKey(0x02)[2:] + ":0",
+		Timestamp:    now,
+	}}
+	for _, g := range grants {
+		if err := faucet.db.AddChannelGrant(g); err != nil {
+			t.Fatalf("unable to add grant: %v", err)
+		}
+	}
+	err := faucet.db.AddPaymentGrant(&paymentGrant{
+		Amount:    700,
+		Timestamp: now,
+	})
+	if err != nil {
+		t.Fatalf("unable to add grant: %v", err)
+	}
+
+	faucet.budget = newBudgetTracker(&config{
+		ChannelBudgetDaily: 1e6,
+		PushBudgetDaily:    1e6,
+		PaymentBudgetDaily: 1000,
+	})
+	if err := faucet.restoreBudgets(); err != nil {
+		t.Fatalf("unable to restore budgets: %v", err)
+	}
+
+	want := map[budgetKind]int64{
+		channelBudget: 2e5,
+		pushBudget:    1e3,
+		paymentBudget: 700,
+	}
+	for _, status := range faucet.budget.status(time.Now()) {
+		if status.Spent != want[status.Kind] {
+			t.Fatalf("unexpected %s spending: got %d, want %d",
+				status.Kind, status.Spent, want[status.Kind])
+		}
+	}
+}
+
+// TestPaymentBudgetFees ensures the routing fees of payments are spent from the
+// budgets, both as the payments are made and when the budgets are restored.
+func TestPaymentBudgetFees(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	lnd.addPayReq("lntdcr1pay", fakePubKey(0x01), 500)
+	lnd.mtx.Lock()
+	lnd.paymentFee = 10
+	lnd.mtx.Unlock()
+
+	budgetCfg := &config{PaymentBudgetDaily: 1000}
+	faucet.budget = newBudgetTracker(budgetCfg)
+	spent := func() int64 {
+		return faucet.budget.status(time.Now())[0].Spent
+	}
+
+	payment, chanErr := faucet.sendPayment(context.Background(),
+		testClientIP, anonymousClient, "lntdcr1pay")
+	if chanErr != NoError {
+		t.Fatalf("unable to pay: %v", chanErr)
+	}
+	if payment.Amount != 510 {
+		t.Fatalf("unexpected amount: %v", payment.Amount)
+	}
+	if spent() != 510 {
+		t.Fatalf("unexpected spending: %d", spent())
+	}
+
+	faucet.budget = newBudgetTracker(budgetCfg)
+	if err := faucet.restoreBudgets(); err != nil {
+		t.Fatalf("unable to restore budgets: %v", err)
+	}
+	if spent() != 510 {
+		t.Fatalf("unexpected restored spending: %d", spent())
+	}
+}
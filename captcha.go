@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// arithCaptcha is a self-hosted captcha asking to solve a simple sum
+	// rendered as an image.
+	arithCaptcha = "arith"
+
+	// hCaptcha verifies the clients through hCaptcha.
+	hCaptcha = "hcaptcha"
+
+	// turnstileCaptcha verifies the clients through Cloudflare Turnstile.
+	turnstileCaptcha = "turnstile"
+
+	// captchaTTL is how long a self-hosted captcha challenge may be solved
+	// for after being issued.
+	captchaTTL = 10 * time.Minute
+
+	// captchaVerifyTimeout is the maximum time to wait for the remote
+	// verification of a captcha response.
+	captchaVerifyTimeout = 10 * time.Second
+
+	// Form fields of the self-hosted captcha.
+	captchaTokenField  = "captcha_token"
+	captchaAnswerField = "captcha_answer"
+)
+
+// captchaChallenge holds everything needed to render the human verification
+// step of a form.
+type captchaChallenge struct {
+	Provider string `json:"provider"`
+
+	// Token and Image are set by the self-hosted captcha. The token must
+	// be submitted along with the answer to the sum shown by the image.
+	Token string       `json:"token,omitempty"`
+	Image template.URL `json:"image,omitempty"`
+
+	// SiteKey and ScriptURL are set by the remote captchas and are used
+	// to render their widget.
+	SiteKey     string `json:"site_key,omitempty"`
+	ScriptURL   string `json:"script_url,omitempty"`
+	WidgetClass string `json:"-"`
+
+	// Failed is set when the previous submission of the form failed the
+	// verification.
+	Failed bool `json:"-"`
+}
+
+// captchaSolution is the answer of a client to a captcha challenge.
+type captchaSolution struct {
+	Token    string
+	Response string
+}
+
+// captchaProvider is a human verification mechanism used to keep scripts from
+// draining the faucet.
+type captchaProvider interface {
+	// newChallenge returns a new challenge to be rendered in a form.
+	newChallenge() (*captchaChallenge, error)
+
+	// formSolution extracts the solution to a challenge from a submitted
+	// form.
+	formSolution(r *http.Request) *captchaSolution
+
+	// verify returns an error unless the passed solution is valid for the
+	// client at clientIP.
+	verify(ctx context.Context, sol *captchaSolution, clientIP string) error
+}
+
+// newCaptchaProvider returns the captcha provider selected in the config, or
+// nil if none is.
+func newCaptchaProvider(cfg *config) (captchaProvider, error) {
+	switch cfg.CaptchaProvider {
+	case "", "none":
+		return nil, nil
+
+	case arithCaptcha:
+		return newArithCaptcha()
+
+	case hCaptcha:
+		return newRemoteCaptcha(cfg, hCaptcha,
+			"https://hcaptcha.com/siteverify",
+			"https://js.hcaptcha.com/1/api.js", "h-captcha",
+			"h-captcha-response")
+
+	case turnstileCaptcha:
+		return newRemoteCaptcha(cfg, turnstileCaptcha,
+			"https://challenges.cloudflare.com/turnstile/v0/siteverify",
+			"https://challenges.cloudflare.com/turnstile/v0/api.js",
+			"cf-turnstile", "cf-turnstile-response")
+
+	default:
+		return nil, fmt.Errorf("unknown captcha provider %q",
+			cfg.CaptchaProvider)
+	}
+}
+
+// arithCaptchaProvider is a self-hosted captcha. Every challenge is a sum of
+// two small numbers rendered as a noisy image, and the expected answer is
+// bound to a signed token so that no state needs to be kept until the
+// challenge is solved.
+type arithCaptchaProvider struct {
+	key []byte
+
+	// spent holds the tokens that were already solved until they expire,
+	// so that every challenge can only be solved once.
+	spent *spentTokens
+}
+
+// newArithCaptcha returns a self-hosted captcha signing its challenges with a
+// random key. Challenges issued before a restart are no longer valid.
+func newArithCaptcha() (*arithCaptchaProvider, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &arithCaptchaProvider{
+		key:   key,
+		spent: newSpentTokens(),
+	}, nil
+}
+
+// randInt returns a uniform random number in [0, max).
+func randInt(max int64) int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(max))
+	if err != nil {
+		return 0
+	}
+	return n.Int64()
+}
+
+// sign returns the signature binding the expiry and nonce of a challenge to
+// its answer.
+func (a *arithCaptchaProvider) sign(expiry int64, nonce []byte,
+	answer string) []byte {
+
+	mac := hmac.New(sha256.New, a.key)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(expiry))
+	mac.Write(b[:])
+	mac.Write(nonce)
+	mac.Write([]byte(answer))
+	return mac.Sum(nil)
+}
+
+// newChallenge returns a new sum to be solved.
+//
+// NOTE: This is part of the captchaProvider interface.
+func (a *arithCaptchaProvider) newChallenge() (*captchaChallenge, error) {
+	x, y := randInt(50)+1, randInt(50)+1
+	answer := strconv.FormatInt(x+y, 10)
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	expiry := time.Now().Add(captchaTTL).Unix()
+	token := fmt.Sprintf("%x.%x.%x", expiry, nonce,
+		a.sign(expiry, nonce, answer))
+
+	img, err := renderCaptchaText(fmt.Sprintf("%d+%d=?", x, y))
+	if err != nil {
+		return nil, err
+	}
+
+	return &captchaChallenge{
+		Provider: arithCaptcha,
+		Token:    token,
+		Image:    img,
+	}, nil
+}
+
+// formSolution extracts the token and answer from the submitted form.
+//
+// NOTE: This is part of the captchaProvider interface.
+func (a *arithCaptchaProvider) formSolution(r *http.Request) *captchaSolution {
+	return &captchaSolution{
+		Token:    r.FormValue(captchaTokenField),
+		Response: r.FormValue(captchaAnswerField),
+	}
+}
+
+// verify checks that the answer matches the signed token, that the token
+// hasn't expired and that it wasn't used before.
+//
+// NOTE: This is part of the captchaProvider interface.
+func (a *arithCaptchaProvider) verify(ctx context.Context,
+	sol *captchaSolution, clientIP string) error {
+
+	parts := strings.Split(sol.Token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed captcha token")
+	}
+	expiry, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil {
+		return fmt.Errorf("malformed captcha expiry: %v", err)
+	}
+	nonce, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed captcha nonce: %v", err)
+	}
+	sig, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed captcha signature: %v", err)
+	}
+
+	now := time.Now()
+	expiresAt := time.Unix(expiry, 0)
+	if now.After(expiresAt) {
+		return fmt.Errorf("captcha expired")
+	}
+
+	answer := strings.TrimSpace(sol.Response)
+	if !hmac.Equal(sig, a.sign(expiry, nonce, answer)) {
+		return fmt.Errorf("wrong captcha answer")
+	}
+
+	if !a.spent.spend(sol.Token, expiresAt) {
+		return fmt.Errorf("captcha already used")
+	}
+
+	return nil
+}
+
+// captchaGlyphs is a 5x7 bitmap font covering the characters used by the
+// self-hosted captcha.
+var captchaGlyphs = map[rune][7]string{
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'+': {"     ", "  #  ", "  #  ", "#####", "  #  ", "  #  ", "     "},
+	'=': {"     ", "     ", "#####", "     ", "#####", "     ", "     "},
+	'?': {" ### ", "#   #", "    #", "   # ", "  #  ", "     ", "  #  "},
+}
+
+// renderCaptchaText renders the passed text as a noisy PNG image encoded in a
+// data URI.
+func renderCaptchaText(text string) (template.URL, error) {
+	const (
+		scale   = 4
+		glyphW  = 5 * scale
+		glyphH  = 7 * scale
+		advance = glyphW + 2*scale
+		margin  = 3 * scale
+	)
+
+	width := 2*margin + len(text)*advance
+	height := 2*margin + glyphH
+	palette := color.Palette{
+		color.RGBA{0xff, 0xff, 0xff, 0xff},
+		color.RGBA{0x2e, 0xd6, 0xa1, 0xff},
+		color.RGBA{0x09, 0x1c, 0x4a, 0xff},
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+
+	// Scatter some noise over the background.
+	for i := 0; i < width*height/12; i++ {
+		x, y := int(randInt(int64(width))), int(randInt(int64(height)))
+		img.SetColorIndex(x, y, 1)
+	}
+
+	// Draw every glyph with a small random vertical offset.
+	for i, c := range text {
+		glyph, ok := captchaGlyphs[c]
+		if !ok {
+			return "", fmt.Errorf("no glyph for %q", c)
+		}
+		originX := margin + i*advance
+		originY := margin + int(randInt(2*scale+1)) - scale
+		for row, line := range glyph {
+			for col, pixel := range line {
+				if pixel != '#' {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						img.SetColorIndex(
+							originX+col*scale+dx,
+							originY+row*scale+dy, 2)
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," +
+		base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// remoteCaptchaProvider verifies the clients through a remote service that
+// follows the hCaptcha and Turnstile siteverify protocol.
+type remoteCaptchaProvider struct {
+	name          string
+	siteKey       string
+	secret        string
+	verifyURL     string
+	scriptURL     string
+	widgetClass   string
+	responseField string
+	client        *http.Client
+}
+
+// newRemoteCaptcha returns a remote captcha provider. The verify URL of the
+// config, when set, overrides the one of the service.
+func newRemoteCaptcha(cfg *config, name, verifyURL, scriptURL, widgetClass,
+	responseField string) (*remoteCaptchaProvider, error) {
+
+	if cfg.CaptchaSiteKey == "" || cfg.CaptchaSecret == "" {
+		return nil, fmt.Errorf("%s captcha requires captcha_site_key "+
+			"and captcha_secret", name)
+	}
+	if cfg.CaptchaVerifyURL != "" {
+		verifyURL = cfg.CaptchaVerifyURL
+	}
+
+	return &remoteCaptchaProvider{
+		name:          name,
+		siteKey:       cfg.CaptchaSiteKey,
+		secret:        cfg.CaptchaSecret,
+		verifyURL:     verifyURL,
+		scriptURL:     scriptURL,
+		widgetClass:   widgetClass,
+		responseField: responseField,
+		client:        &http.Client{Timeout: captchaVerifyTimeout},
+	}, nil
+}
+
+// newChallenge returns the parameters of the widget of the service.
+//
+// NOTE: This is part of the captchaProvider interface.
+func (c *remoteCaptchaProvider) newChallenge() (*captchaChallenge, error) {
+	return &captchaChallenge{
+		Provider:    c.name,
+		SiteKey:     c.siteKey,
+		ScriptURL:   c.scriptURL,
+		WidgetClass: c.widgetClass,
+	}, nil
+}
+
+// formSolution extracts the response of the widget from the submitted form.
+//
+// NOTE: This is part of the captchaProvider interface.
+func (c *remoteCaptchaProvider) formSolution(r *http.Request) *captchaSolution {
+	return &captchaSolution{
+		Response: r.FormValue(c.responseField),
+	}
+}
+
+// verify asks the service whether the response is valid.
+//
+// NOTE: This is part of the captchaProvider interface.
+func (c *remoteCaptchaProvider) verify(ctx context.Context,
+	sol *captchaSolution, clientIP string) error {
+
+	if sol.Response == "" {
+		return fmt.Errorf("missing %s response", c.name)
+	}
+
+	form := url.Values{
+		"secret":   {c.secret},
+		"response": {sol.Response},
+		"remoteip": {clientIP},
+		"sitekey":  {c.siteKey},
+	}
+	req, err := http.NewRequest(http.MethodPost, c.verifyURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to verify %s response: %v", c.name,
+			err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to verify %s response: %v", c.name,
+			resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("unable to decode %s verification: %v",
+			c.name, err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%s verification failed: %v", c.name,
+			strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// solveArithCaptcha returns the answer to the challenge by trying every
+// possible sum against a copy of the provider that doesn't remember used
+// tokens.
+func solveArithCaptcha(t *testing.T, a *arithCaptchaProvider,
+	challenge *captchaChallenge) string {
+
+	t.Helper()
+
+	probe := &arithCaptchaProvider{key: a.key, spent: newSpentTokens()}
+	for answer := 2; answer <= 100; answer++ {
+		sol := &captchaSolution{
+			Token:    challenge.Token,
+			Response: strconv.Itoa(answer),
+		}
+		if probe.verify(context.Background(), sol, testClientIP) == nil {
+			return sol.Response
+		}
+	}
+	t.Fatal("unable to solve captcha")
+	return ""
+}
+
+// TestArithCaptcha ensures self-hosted challenges can only be solved once and
+// only with the right answer.
+func TestArithCaptcha(t *testing.T) {
+	a, err := newArithCaptcha()
+	if err != nil {
+		t.Fatalf("unable to create captcha: %v", err)
+	}
+	challenge, err := a.newChallenge()
+	if err != nil {
+		t.Fatalf("unable to create challenge: %v", err)
+	}
+	if challenge.Image == "" || challenge.Token == "" {
+		t.Fatal("challenge has no image or token")
+	}
+
+	answer := solveArithCaptcha(t, a, challenge)
+	ctx := context.Background()
+
+	wrong := &captchaSolution{Token: challenge.Token, Response: "0"}
+	if err := a.verify(ctx, wrong, testClientIP); err == nil {
+		t.Fatal("wrong answer accepted")
+	}
+
+	right := &captchaSolution{Token: challenge.Token, Response: answer}
+	if err := a.verify(ctx, right, testClientIP); err != nil {
+		t.Fatalf("right answer rejected: %v", err)
+	}
+	if err := a.verify(ctx, right, testClientIP); err == nil {
+		t.Fatal("captcha solved twice")
+	}
+
+	tampered := &captchaSolution{Token: "0." + challenge.Token[2:],
+		Response: answer}
+	if err := a.verify(ctx, tampered, testClientIP); err == nil {
+		t.Fatal("tampered token accepted")
+	}
+}
+
+// newCaptchaServer returns a stand-in for a remote verification service that
+// accepts the response "pass" when sent along with the expected secret.
+func newCaptchaServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("unable to parse verification form: %v", err)
+		}
+		success := r.PostForm.Get("secret") == "secret" &&
+			r.PostForm.Get("response") == "pass" &&
+			r.PostForm.Get("remoteip") == testClientIP
+
+		resp := map[string]interface{}{"success": success}
+		if !success {
+			resp["error-codes"] = []string{"invalid-input-response"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+// TestRemoteCaptcha ensures the responses of the remote captchas are verified
+// against the configured verification URL.
+func TestRemoteCaptcha(t *testing.T) {
+	server := newCaptchaServer(t)
+	defer server.Close()
+
+	cfg := &config{
+		CaptchaProvider:  turnstileCaptcha,
+		CaptchaSiteKey:   "sitekey",
+		CaptchaSecret:    "secret",
+		CaptchaVerifyURL: server.URL,
+	}
+	provider, err := newCaptchaProvider(cfg)
+	if err != nil {
+		t.Fatalf("unable to create captcha: %v", err)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		response string
+		valid    bool
+	}{
+		{"pass", true},
+		{"fail", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		sol := &captchaSolution{Response: test.response}
+		err := provider.verify(ctx, sol, testClientIP)
+		if (err == nil) != test.valid {
+			t.Errorf("response %q: got err %v, want valid %v",
+				test.response, err, test.valid)
+		}
+	}
+}
+
+// TestOpenChannelCaptcha ensures the open channel form requires solving the
+// captcha when enabled.
+func TestOpenChannelCaptcha(t *testing.T) {
+	server := newCaptchaServer(t)
+	defer server.Close()
+
+	peer := fakePubKey(0x01)
+	withCaptcha := func(lnd *fakeBackend, l *lightningFaucet) {
+		lnd.addPeer(peer)
+		provider, err := newCaptchaProvider(&config{
+			CaptchaProvider:  hCaptcha,
+			CaptchaSiteKey:   "sitekey",
+			CaptchaSecret:    "secret",
+			CaptchaVerifyURL: server.URL,
+		})
+		if err != nil {
+			t.Fatalf("unable to create captcha: %v", err)
+		}
+		l.captcha = provider
+		l.captchaActions = map[string]bool{OpenChannelAction: true}
+	}
+	target := "/?action=" + OpenChannelAction
+
+	tests := []handlerTest{{
+		name: "render arith",
+		setup: func(_ *fakeBackend, l *lightningFaucet) {
+			provider, err := newArithCaptcha()
+			if err != nil {
+				t.Fatalf("unable to create captcha: %v", err)
+			}
+			l.captcha = provider
+			l.captchaActions = map[string]bool{OpenChannelAction: true}
+		},
+		method:     http.MethodGet,
+		target:     "/",
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			`src="data:image/png;base64,`,
+			`name="captcha_token"`,
+		},
+	}, {
+		name:       "render widget",
+		setup:      withCaptcha,
+		method:     http.MethodGet,
+		target:     "/",
+		wantStatus: http.StatusOK,
+		wantBody:   []string{`class="h-captcha" data-sitekey="sitekey"`},
+	}, {
+		name:   "missing response",
+		setup:  withCaptcha,
+		method: http.MethodPost,
+		target: target,
+		form: url.Values{
+			"node": {peer},
+			"amt":  {"0.01"},
+			"bal":  {"0"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{CaptchaFailed.String()},
+	}, {
+		name:   "solved",
+		setup:  withCaptcha,
+		method: http.MethodPost,
+		target: target,
+		form: url.Values{
+			"node":               {peer},
+			"amt":                {"0.01"},
+			"bal":                {"0"},
+			"h-captcha-response": {"pass"},
+		},
+		wantStatus: http.StatusSeeOther,
+	}}
+
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return l.faucetHome
+	})
+}
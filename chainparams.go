@@ -9,6 +9,9 @@ import (
 type params struct {
 	*chaincfg.Params
 	rpcPort string
+
+	// explorer is the public block explorer linked to by default.
+	explorer blockExplorer
 }
 
 var (
@@ -17,6 +20,11 @@ var (
 	decredMainNetParams = params{
 		Params:  &chaincfg.MainNetParams,
 		rpcPort: "10009",
+		explorer: blockExplorer{
+			TxURL:      "https://dcrdata.decred.org/tx/%s",
+			AddressURL: "https://dcrdata.decred.org/address/%s",
+			BlockURL:   "https://dcrdata.decred.org/block/%s",
+		},
 	}
 
 	// decredTestNet3Params contains parameters specific to the test network
@@ -24,10 +32,15 @@ var (
 	decredTestNet3Params = params{
 		Params:  &chaincfg.TestNet3Params,
 		rpcPort: "10009",
+		explorer: blockExplorer{
+			TxURL:      "https://testnet.dcrdata.org/tx/%s",
+			AddressURL: "https://testnet.dcrdata.org/address/%s",
+			BlockURL:   "https://testnet.dcrdata.org/block/%s",
+		},
 	}
 
 	// decredSimNetParams contains parameters specific to the simulation test network
-	// (wire.SimNet).
+	// (wire.SimNet). There is no public explorer for simnet.
 	decredSimNetParams = params{
 		Params:  &chaincfg.SimNetParams,
 		rpcPort: "10009",
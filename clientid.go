@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// defaultIPv4Prefix is the default prefix length IPv4 clients are
+	// aggregated to. Every IPv4 address is a distinct client.
+	defaultIPv4Prefix = 32
+
+	// defaultIPv6Prefix is the default prefix length IPv6 clients are
+	// aggregated to. A /64 is the smallest subnet usually assigned to a
+	// single site, so every address within it belongs to the same client.
+	defaultIPv6Prefix = 64
+
+	// defaultSubnetBurst is the default number of actions the clients of
+	// a subnet may perform back to back when subnet limits are enabled.
+	defaultSubnetBurst = 10
+)
+
+// clientIdentifier maps client addresses to the rate limit keys that
+// identify them. Addresses are aggregated to a configurable prefix so that a
+// client can't get new identities by rotating through the addresses it was
+// assigned, and are optionally also limited per larger subnet.
+type clientIdentifier struct {
+	ipv4Prefix int
+	ipv6Prefix int
+
+	// ipv4SubnetPrefix and ipv6SubnetPrefix are the prefix lengths of
+	// the subnets that get secondary limits. Zero disables them.
+	ipv4SubnetPrefix int
+	ipv6SubnetPrefix int
+}
+
+// newClientIdentifier returns a client identifier using the prefix lengths
+// of the passed config.
+func newClientIdentifier(cfg *config) *clientIdentifier {
+	return &clientIdentifier{
+		ipv4Prefix:       cfg.IPv4Prefix,
+		ipv6Prefix:       cfg.IPv6Prefix,
+		ipv4SubnetPrefix: cfg.IPv4SubnetPrefix,
+		ipv6SubnetPrefix: cfg.IPv6SubnetPrefix,
+	}
+}
+
+// maskedAddr returns the subnet of the given prefix length that ip belongs
+// to. Full length prefixes are returned as a plain address.
+func maskedAddr(ip net.IP, prefix, bits int) string {
+	if prefix >= bits {
+		return ip.String()
+	}
+	mask := net.CIDRMask(prefix, bits)
+	subnet := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	return subnet.String()
+}
+
+// keys returns the rate limit keys of the client with the given address.
+// Addresses that can't be parsed are used verbatim as the client key.
+func (c *clientIdentifier) keys(clientIP string) []rateLimitKey {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return []rateLimitKey{{clientIPKey, clientIP}}
+	}
+
+	prefix, subnetPrefix, bits := c.ipv6Prefix, c.ipv6SubnetPrefix, 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		prefix, subnetPrefix, bits = c.ipv4Prefix, c.ipv4SubnetPrefix, 32
+	}
+
+	keys := []rateLimitKey{{clientIPKey, maskedAddr(ip, prefix, bits)}}
+	if subnetPrefix > 0 {
+		keys = append(keys, rateLimitKey{
+			subnetKey, maskedAddr(ip, subnetPrefix, bits),
+		})
+	}
+	return keys
+}
+
+// validatePrefixes returns an error if any of the prefix lengths of the
+// passed config is out of range.
+func validatePrefixes(cfg *config) error {
+	prefixes := []struct {
+		name       string
+		prefix     int
+		min, max   int
+		allowEmpty bool
+	}{
+		{"ipv4_prefix", cfg.IPv4Prefix, 1, 32, false},
+		{"ipv6_prefix", cfg.IPv6Prefix, 1, 128, false},
+		{"ipv4_subnet_prefix", cfg.IPv4SubnetPrefix, 1, cfg.IPv4Prefix, true},
+		{"ipv6_subnet_prefix", cfg.IPv6SubnetPrefix, 1, cfg.IPv6Prefix, true},
+	}
+	for _, p := range prefixes {
+		if p.allowEmpty && p.prefix == 0 {
+			continue
+		}
+		if p.prefix < p.min || p.prefix > p.max {
+			return fmt.Errorf("%s must be between %d and %d",
+				p.name, p.min, p.max)
+		}
+	}
+
+	if cfg.SubnetBurst <= 0 {
+		return fmt.Errorf("subnet_burst must be > 0")
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestClientIdentifierKeys ensures client addresses are aggregated to the
+// configured prefixes.
+func TestClientIdentifierKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		clients  clientIdentifier
+		clientIP string
+		want     []rateLimitKey
+	}{{
+		name:     "ipv4 host",
+		clients:  clientIdentifier{ipv4Prefix: 32, ipv6Prefix: 64},
+		clientIP: "192.0.2.1",
+		want:     []rateLimitKey{{clientIPKey, "192.0.2.1"}},
+	}, {
+		name:     "ipv4 prefix",
+		clients:  clientIdentifier{ipv4Prefix: 24, ipv6Prefix: 64},
+		clientIP: "192.0.2.77",
+		want:     []rateLimitKey{{clientIPKey, "192.0.2.0/24"}},
+	}, {
+		name:     "ipv4 mapped ipv6",
+		clients:  clientIdentifier{ipv4Prefix: 32, ipv6Prefix: 64},
+		clientIP: "::ffff:192.0.2.1",
+		want:     []rateLimitKey{{clientIPKey, "192.0.2.1"}},
+	}, {
+		name:     "ipv6 /64",
+		clients:  clientIdentifier{ipv4Prefix: 32, ipv6Prefix: 64},
+		clientIP: "2001:db8:1:2:aaaa:bbbb:cccc:dddd",
+		want:     []rateLimitKey{{clientIPKey, "2001:db8:1:2::/64"}},
+	}, {
+		name: "ipv6 with subnet",
+		clients: clientIdentifier{
+			ipv4Prefix:       32,
+			ipv6Prefix:       56,
+			ipv6SubnetPrefix: 48,
+		},
+		clientIP: "2001:db8:1:2ff::1",
+		want: []rateLimitKey{
+			{clientIPKey, "2001:db8:1:200::/56"},
+			{subnetKey, "2001:db8:1::/48"},
+		},
+	}, {
+		name: "ipv4 with subnet",
+		clients: clientIdentifier{
+			ipv4Prefix:       32,
+			ipv6Prefix:       64,
+			ipv4SubnetPrefix: 16,
+		},
+		clientIP: "198.51.100.7",
+		want: []rateLimitKey{
+			{clientIPKey, "198.51.100.7"},
+			{subnetKey, "198.51.0.0/16"},
+		},
+	}, {
+		name:     "not an address",
+		clients:  clientIdentifier{ipv4Prefix: 32, ipv6Prefix: 64},
+		clientIP: "unknown",
+		want:     []rateLimitKey{{clientIPKey, "unknown"}},
+	}}
+
+	for _, test := range tests {
+		got := test.clients.keys(test.clientIP)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
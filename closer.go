@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCloseGracePeriod is the default time the close manager keeps
+	// attempting a cooperative close before escalating to a force close.
+	defaultCloseGracePeriod = 24 * time.Hour
+
+	// defaultCloseRetryInterval is the default interval between two
+	// attempts to close a channel.
+	defaultCloseRetryInterval = 10 * time.Minute
+
+	// closeReconnectTimeout is how long the close manager waits to
+	// reconnect to the peer of a channel before attempting a cooperative
+	// close.
+	closeReconnectTimeout = 30 * time.Second
+)
+
+// closeState is the state of a channel being closed by the close manager.
+type closeState string
+
+const (
+	// closeStateCoop is the state of a channel the close manager is
+	// attempting to close cooperatively until its grace period is over.
+	closeStateCoop closeState = "coop"
+
+	// closeStateForce is the state of a channel the close manager is
+	// attempting to force close.
+	closeStateForce closeState = "force"
+
+	// closeStateClosed is the final state of a channel whose closing
+	// transaction was broadcast.
+	closeStateClosed closeState = "closed"
+)
+
+// closeRequest tracks a channel being closed by the close manager. It is
+// persisted after every change so that closes resume where they left off
+// after a restart.
+type closeRequest struct {
+	ChannelPoint string      `json:"channel_point"`
+	RemotePubKey string      `json:"remote_pubkey"`
+	Reason       closeReason `json:"reason"`
+	State        closeState  `json:"state"`
+	Requested    time.Time   `json:"requested"`
+	Deadline     time.Time   `json:"deadline"`
+	Attempts     int         `json:"attempts"`
+	LastError    string      `json:"last_error,omitempty"`
+	ClosingTxid  string      `json:"closing_txid,omitempty"`
+}
+
+// closeManager closes channels cooperatively first, escalating to a force
+// close once the grace period of a channel is over.
+type closeManager struct {
+	gracePeriod   time.Duration
+	retryInterval time.Duration
+	reconnect     bool
+
+	// mtx protects requests, which holds the channels being closed keyed
+	// by channel point, and stepLocks.
+	mtx      sync.Mutex
+	requests map[string]*closeRequest
+
+	// stepLocks serializes the close attempts of each channel while
+	// letting different channels be closed concurrently.
+	stepLocks map[string]*sync.Mutex
+}
+
+// newCloseManager returns a close manager using the parameters of the passed
+// config, resuming the closes recorded in the database.
+func newCloseManager(cfg *config, db *faucetDB) (*closeManager, error) {
+	m := &closeManager{
+		gracePeriod:   cfg.CloseGracePeriod,
+		retryInterval: cfg.CloseRetryInterval,
+		reconnect:     cfg.CloseReconnect,
+		requests:      make(map[string]*closeRequest),
+		stepLocks:     make(map[string]*sync.Mutex),
+	}
+
+	err := db.ForEachCloseRequest(func(req *closeRequest) {
+		m.requests[req.ChannelPoint] = req
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load close requests: %v", err)
+	}
+	if len(m.requests) > 0 {
+		log.Infof("Resuming the close of %d channels", len(m.requests))
+	}
+
+	return m, nil
+}
+
+// stepLock returns the lock serializing the close attempts of the channel.
+func (m *closeManager) stepLock(chanPoint string) *sync.Mutex {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	lock, ok := m.stepLocks[chanPoint]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.stepLocks[chanPoint] = lock
+	}
+	return lock
+}
+
+// closing returns whether the channel is being closed.
+func (m *closeManager) closing(chanPoint string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, ok := m.requests[chanPoint]
+	return ok
+}
+
+// pending returns a copy of every ongoing close, oldest first.
+func (m *closeManager) pending() []*closeRequest {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	reqs := make([]*closeRequest, 0, len(m.requests))
+	for _, req := range m.requests {
+		r := *req
+		reqs = append(reqs, &r)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Requested.Before(reqs[j].Requested)
+	})
+	return reqs
+}
+
+// requestClose starts closing the channel, cooperatively unless force is set,
+// and makes a first attempt right away. Requesting a force close of a channel
+// that is already being closed cooperatively escalates it immediately. A copy
+// of the resulting state of the close is returned.
+func (l *lightningFaucet) requestClose(chanPoint, remotePubKey string,
+	reason closeReason, force bool) (*closeRequest, error) {
+
+	m := l.closer
+
+	// Changes to the state of a close are only made by a single attempt
+	// at a time, so the close may not be escalated during an attempt.
+	stepLock := m.stepLock(chanPoint)
+	stepLock.Lock()
+	m.mtx.Lock()
+	req, ok := m.requests[chanPoint]
+	if !ok {
+		now := time.Now()
+		req = &closeRequest{
+			ChannelPoint: chanPoint,
+			RemotePubKey: remotePubKey,
+			Reason:       reason,
+			State:        closeStateCoop,
+			Requested:    now,
+			Deadline:     now.Add(m.gracePeriod),
+		}
+		m.requests[chanPoint] = req
+	}
+	if force {
+		req.State = closeStateForce
+	}
+	stored := *req
+	m.mtx.Unlock()
+
+	err := l.db.PutCloseRequest(&stored)
+	stepLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.stepClose(chanPoint), nil
+}
+
+// stepClose makes a single attempt to move the close of the channel forward
+// and returns a copy of its resulting state, or nil if the channel isn't being
+// closed. Cooperative closes are escalated to force closes once their
+// deadline has passed.
+func (l *lightningFaucet) stepClose(strPoint string) *closeRequest {
+	m := l.closer
+	stepLock := m.stepLock(strPoint)
+	stepLock.Lock()
+	defer stepLock.Unlock()
+
+	m.mtx.Lock()
+	cur, ok := m.requests[strPoint]
+	if !ok {
+		m.mtx.Unlock()
+		return nil
+	}
+	req := *cur
+	m.mtx.Unlock()
+
+	chanPoint, err := strPointToChanPoint(req.ChannelPoint)
+	if err != nil {
+		log.Errorf("unable to get chan point: %v", err)
+		l.forgetClose(req.ChannelPoint)
+		return &req
+	}
+
+	if req.State == closeStateCoop {
+		if m.reconnect {
+			l.reconnectPeer(req.RemotePubKey)
+		}
+
+		req.Attempts++
+		txid, err := l.closeChannel(chanPoint, false, req.Reason)
+		switch {
+		case err == nil:
+			req.State = closeStateClosed
+			req.ClosingTxid = txid.String()
+
+		case time.Now().After(req.Deadline):
+			log.Infof("Unable to cooperatively close ChannelPoint(%v) "+
+				"within the grace period, escalating to a force "+
+				"close: %v", req.ChannelPoint, err)
+			req.LastError = err.Error()
+			req.State = closeStateForce
+
+		default:
+			log.Debugf("Unable to cooperatively close "+
+				"ChannelPoint(%v), will retry: %v",
+				req.ChannelPoint, err)
+			req.LastError = err.Error()
+		}
+	}
+
+	if req.State == closeStateForce {
+		req.Attempts++
+		txid, err := l.closeChannel(chanPoint, true, req.Reason)
+		if err != nil {
+			log.Errorf("Unable to force close ChannelPoint(%v), will "+
+				"retry: %v", req.ChannelPoint, err)
+			req.LastError = err.Error()
+		} else {
+			req.State = closeStateClosed
+			req.ClosingTxid = txid.String()
+		}
+	}
+
+	if req.State == closeStateClosed {
+		log.Infof("Closed ChannelPoint(%v) after %d attempts, closing "+
+			"txid: %v", req.ChannelPoint, req.Attempts,
+			req.ClosingTxid)
+		l.forgetClose(req.ChannelPoint)
+		return &req
+	}
+
+	// The close may have been dropped meanwhile if the channel is no
+	// longer open.
+	stored := req
+	m.mtx.Lock()
+	_, ok = m.requests[req.ChannelPoint]
+	if ok {
+		m.requests[req.ChannelPoint] = &stored
+	}
+	m.mtx.Unlock()
+	if !ok {
+		return &req
+	}
+	if err := l.db.PutCloseRequest(&stored); err != nil {
+		log.Errorf("unable to store close request: %v", err)
+	}
+
+	return &req
+}
+
+// forgetClose stops tracking the close of the channel.
+func (l *lightningFaucet) forgetClose(chanPoint string) {
+	l.closer.mtx.Lock()
+	delete(l.closer.requests, chanPoint)
+	l.closer.mtx.Unlock()
+
+	if err := l.db.DeleteCloseRequest(chanPoint); err != nil {
+		log.Errorf("unable to delete close request: %v", err)
+	}
+}
+
+// reconnectPeer attempts to connect to the peer at any of its announced
+// addresses unless already connected.
+func (l *lightningFaucet) reconnectPeer(pubKey string) {
+	ctx, cancel := context.WithTimeout(ctxb, closeReconnectTimeout)
+	defer cancel()
+
+	if l.connectedToNode(ctx, pubKey) {
+		return
+	}
+
+	nodeInfo, err := l.lnd.NodeInfo(ctx, pubKey)
+	if err != nil {
+		log.Debugf("unable to get node info of %v: %v", pubKey, err)
+		return
+	}
+	for _, addr := range nodeInfo.Node.Addresses {
+		err := l.lnd.ConnectPeer(ctx, pubKey, addr.Addr)
+		if err == nil {
+			log.Infof("Reconnected to %v at %v", pubKey, addr.Addr)
+			return
+		}
+		log.Debugf("unable to connect to %v at %v: %v", pubKey,
+			addr.Addr, err)
+	}
+}
+
+// processCloses makes an attempt to move every ongoing close forward. Closes
+// of channels that are no longer open are dropped. It returns the number of
+// closes still ongoing.
+func (l *lightningFaucet) processCloses() int {
+	reqs := l.closer.pending()
+	if len(reqs) == 0 {
+		return 0
+	}
+
+	channels, err := l.lnd.ListChannels(ctxb)
+	if err != nil {
+		log.Errorf("unable to fetch open channels: %v", err)
+		return len(reqs)
+	}
+	open := make(map[string]struct{}, len(channels))
+	for _, channel := range channels {
+		open[channel.ChannelPoint] = struct{}{}
+	}
+
+	var numPending int
+	for _, req := range reqs {
+		if _, ok := open[req.ChannelPoint]; !ok {
+			log.Infof("ChannelPoint(%v) is no longer open",
+				req.ChannelPoint)
+			l.forgetClose(req.ChannelPoint)
+			continue
+		}
+
+		res := l.stepClose(req.ChannelPoint)
+		if res != nil && res.State != closeStateClosed {
+			numPending++
+		}
+	}
+	return numPending
+}
+
+// closeManagerLoop periodically moves every ongoing close forward.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) closeManagerLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.closer.retryInterval)
+	defer ticker.Stop()
+
+	l.processCloses()
+	for {
+		select {
+		case <-ticker.C:
+			l.processCloses()
+
+		case <-l.quit:
+			return
+		}
+	}
+}
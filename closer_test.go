@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// TestCloseEscalation ensures a channel that can't be closed cooperatively is
+// retried until its grace period is over and then force closed.
+func TestCloseEscalation(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	lnd.addChannel(fakePubKey(0x01), 1e6)
+	channel := lnd.channels[0]
+	channel.Active = false
+
+	req, err := faucet.requestClose(channel.ChannelPoint,
+		channel.RemotePubkey, closeReasonAdmin, false)
+	if err != nil {
+		t.Fatalf("unable to request close: %v", err)
+	}
+	if req.State != closeStateCoop || req.Attempts != 1 ||
+		req.LastError == "" {
+
+		t.Fatalf("unexpected close: %+v", req)
+	}
+
+	if n := faucet.processCloses(); n != 1 || len(lnd.closed) != 0 {
+		t.Fatalf("channel closed within the grace period")
+	}
+
+	// Once the deadline has passed the close is escalated.
+	faucet.closer.mtx.Lock()
+	faucet.closer.requests[channel.ChannelPoint].Deadline = time.Now()
+	faucet.closer.mtx.Unlock()
+
+	if n := faucet.processCloses(); n != 0 || len(lnd.closed) != 1 {
+		t.Fatalf("channel not force closed")
+	}
+
+	closes, err := faucet.recentCloses(10)
+	if err != nil {
+		t.Fatalf("unable to fetch closes: %v", err)
+	}
+	if len(closes) != 1 || !closes[0].Force ||
+		closes[0].Reason != closeReasonAdmin {
+
+		t.Fatalf("unexpected closes: %v", closes)
+	}
+	if len(faucet.closer.pending()) != 0 {
+		t.Fatalf("close still pending")
+	}
+}
+
+// TestCloseReconnect ensures the close manager reconnects to the peer of a
+// channel so that it can be closed cooperatively.
+func TestCloseReconnect(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	pubKey := fakePubKey(0x01)
+	lnd.addChannel(pubKey, 1e6)
+	channel := lnd.channels[0]
+	channel.Active = false
+	lnd.nodes[pubKey] = &lnrpc.NodeInfo{
+		Node: &lnrpc.LightningNode{
+			PubKey: pubKey,
+			Addresses: []*lnrpc.NodeAddress{{
+				Network: "tcp",
+				Addr:    "127.0.0.1:9735",
+			}},
+		},
+	}
+	lnd.reachable[pubKey] = true
+	faucet.closer.reconnect = true
+
+	req, err := faucet.requestClose(channel.ChannelPoint, pubKey,
+		closeReasonZombie, false)
+	if err != nil {
+		t.Fatalf("unable to request close: %v", err)
+	}
+	if req.State != closeStateClosed || req.ClosingTxid == "" {
+		t.Fatalf("unexpected close: %+v", req)
+	}
+
+	closes, err := faucet.recentCloses(10)
+	if err != nil {
+		t.Fatalf("unable to fetch closes: %v", err)
+	}
+	if len(closes) != 1 || closes[0].Force {
+		t.Fatalf("unexpected closes: %v", closes)
+	}
+}
+
+// TestCloseResume ensures the closes in progress are resumed after a restart.
+func TestCloseResume(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	lnd.addChannel(fakePubKey(0x01), 1e6)
+	channel := lnd.channels[0]
+	channel.Active = false
+
+	_, err := faucet.requestClose(channel.ChannelPoint,
+		channel.RemotePubkey, closeReasonMaxAge, false)
+	if err != nil {
+		t.Fatalf("unable to request close: %v", err)
+	}
+
+	closer, err := newCloseManager(faucet.cfg, faucet.db)
+	if err != nil {
+		t.Fatalf("unable to create close manager: %v", err)
+	}
+	pending := closer.pending()
+	if len(pending) != 1 {
+		t.Fatalf("expected 1 close to resume, got %d", len(pending))
+	}
+	if pending[0].ChannelPoint != channel.ChannelPoint ||
+		pending[0].Reason != closeReasonMaxAge ||
+		pending[0].State != closeStateCoop || pending[0].Attempts != 1 {
+
+		t.Fatalf("unexpected close: %+v", pending[0])
+	}
+
+	// Closes of channels that are gone are dropped.
+	lnd.mtx.Lock()
+	lnd.channels = nil
+	lnd.mtx.Unlock()
+	if n := faucet.processCloses(); n != 0 {
+		t.Fatalf("expected no pending closes, got %d", n)
+	}
+	closer, err = newCloseManager(faucet.cfg, faucet.db)
+	if err != nil {
+		t.Fatalf("unable to create close manager: %v", err)
+	}
+	if len(closer.pending()) != 0 {
+		t.Fatalf("dropped close was resumed")
+	}
+}
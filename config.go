@@ -54,16 +54,123 @@ type config struct {
 	Domain           string        `long:"domain" description:"the domain of the faucet, required for TLS"`
 	MacaroonPath     string        `long:"macpath" description:"path to macaroons files"`
 	TLSCertPath      string        `long:"tlscertpath" description:"Path to write the TLS certificate for lnd's RPC and REST services"`
+	DataDir          string        `long:"datadir" description:"Directory to store the faucet's database"`
+	DumpGrants       bool          `long:"dump_grants" description:"print every grant recorded in the database as JSON and exit"`
 	ActionsTimeLimit time.Duration `long:"actions_timelimit" description:"Time to wait before a second request can be made by a single faucet client."`
-	UseRealIP        bool          `long:"userealip" description:"Use the RealIP middleware to get the client's real IP from the X-Real-IP or X-Forwarded-For headers, in that order."`
+	UseRealIP        bool          `long:"userealip" description:"Get the client's real IP from the Forwarded, X-Forwarded-For or X-Real-IP headers, in that order, of requests coming from a trusted proxy."`
+	TrustedProxies   []string      `long:"trusted_proxies" description:"CIDR network of a reverse proxy whose forwarded headers are trusted when userealip is set. May be specified multiple times (default: 127.0.0.0/8 and ::1/128)"`
 
-	DisableZombieSweeper bool `long:"disable_zombie_sweeper" description:"disable zombie channels sweeper"`
+	// Per action time limits. They default to ActionsTimeLimit.
+	OpenChannelTimeLimit     time.Duration `long:"openchannel_timelimit" description:"Time a client or target node must wait between channel opens (default: actions_timelimit)"`
+	GenerateInvoiceTimeLimit time.Duration `long:"generateinvoice_timelimit" description:"Time a client must wait between generated invoices (default: actions_timelimit)"`
+	PayInvoiceTimeLimit      time.Duration `long:"payinvoice_timelimit" description:"Time a client or payment destination must wait between paid invoices (default: actions_timelimit)"`
+
+	RateLimitBurst   int `long:"ratelimit_burst" description:"Number of actions a client may perform back to back before having to wait for the time limit of the action"`
+	RateLimitMaxKeys int `long:"ratelimit_max_keys" description:"Maximum number of clients, nodes and destinations tracked by the rate limiter. New ones are refused once it is reached"`
+
+	// Client identity. Client addresses are aggregated to these prefixes
+	// before being rate limited.
+	IPv4Prefix       int `long:"ipv4_prefix" description:"Prefix length IPv4 client addresses are aggregated to before being rate limited"`
+	IPv6Prefix       int `long:"ipv6_prefix" description:"Prefix length IPv6 client addresses are aggregated to before being rate limited, usually 64, 56 or 48"`
+	IPv4SubnetPrefix int `long:"ipv4_subnet_prefix" description:"Prefix length of the IPv4 subnets that are also rate limited as a whole (default: disabled)"`
+	IPv6SubnetPrefix int `long:"ipv6_subnet_prefix" description:"Prefix length of the IPv6 subnets that are also rate limited as a whole (default: disabled)"`
+	SubnetBurst      int `long:"subnet_burst" description:"Number of actions the clients of a subnet may perform back to back before having to wait for the time limit of the action"`
+
+	// Human verification
+	CaptchaProvider  string   `long:"captcha_provider" description:"Human verification required by the actions in captcha_actions {none, arith, hcaptcha, turnstile}"`
+	CaptchaActions   []string `long:"captcha_actions" description:"Action requiring human verification {openchannel, generateinvoice, payinvoice}. May be specified multiple times (default: all of them)"`
+	CaptchaSiteKey   string   `long:"captcha_site_key" description:"Site key of the hcaptcha or turnstile captcha"`
+	CaptchaSecret    string   `long:"captcha_secret" description:"Secret key of the hcaptcha or turnstile captcha"`
+	CaptchaVerifyURL string   `long:"captcha_verify_url" description:"URL used to verify the hcaptcha or turnstile responses (default: the one of the provider)"`
+
+	// Proof of work required from API clients
+	PoWDifficulty    uint          `long:"pow_difficulty" description:"Base difficulty, in leading zero bits, of the proof of work required by the API to open channels and pay invoices (default: disabled)"`
+	PoWMaxDifficulty uint          `long:"pow_max_difficulty" description:"Maximum difficulty of the proof of work challenges"`
+	PoWLoadWindow    time.Duration `long:"pow_load_window" description:"Window over which the load of the faucet is measured to scale the proof of work difficulty"`
+	PoWLoadStep      int           `long:"pow_load_step" description:"Number of actions performed within pow_load_window that add a bit of difficulty (0 disables load scaling)"`
+	PoWChanSizeStep  int64         `long:"pow_chansize_step" description:"Channel size in atoms that adds a bit of difficulty (0 disables size scaling)"`
+
+	DisableZombieSweeper   bool          `long:"disable_zombie_sweeper" description:"disable zombie channels sweeper"`
+	ZombieSweepInterval    time.Duration `long:"zombie_sweep_interval" description:"Interval between two zombie channel sweeps"`
+	ZombieOfflineThreshold time.Duration `long:"zombie_offline_threshold" description:"Time the peer of an inactive channel must have been offline for the channel to be a zombie"`
+	ZombieIdleThreshold    time.Duration `long:"zombie_idle_threshold" description:"Time an inactive channel must have gone without updates to be a zombie"`
+	ZombieMinUptimeRatio   float64       `long:"zombie_min_uptime" description:"Only consider channels whose peer was online for less than this fraction of the channel lifetime as zombies (0 disables the check)"`
+	ZombieDryRun           bool          `long:"zombie_dry_run" description:"Only report the zombie channels found by the sweeper without closing them"`
+
+	// Channel recycling
+	MaxChannels     int           `long:"max_channels" description:"Maximum number of open or pending channels opened by the faucet, the oldest are recycled to make room for new ones (0 for unlimited)"`
+	ChannelMaxAge   time.Duration `long:"channel_max_age" description:"Age after which the channels opened by the faucet are cooperatively closed (default: disabled)"`
+	RecyclePolicy   string        `long:"recycle_policy" description:"Order in which channels are recycled once max_channels is reached {oldest, leastused}"`
+	RecycleInterval time.Duration `long:"recycle_interval" description:"Interval between two runs of the channel recycler"`
+
+	// Channel opens
+	OpenWorkers      int           `long:"open_workers" description:"Number of channel opens performed at the same time"`
+	OpenQueueSize    int           `long:"open_queue_size" description:"Number of channel opens that may wait for a worker, new requests are refused once it is reached"`
+	OpenTimeout      time.Duration `long:"open_timeout" description:"Time allowed to negotiate the funding of a channel with its peer"`
+	MaxInflightOpens int           `long:"max_inflight_opens" description:"Maximum number of channel opens in progress, from the negotiation of their funding until their funding transaction confirms, further opens wait in the queue (0 for unlimited)"`
+	ConnectTimeout   time.Duration `long:"connect_timeout" description:"Time allowed to connect to the node of a client given as pubkey@host:port"`
+	PeerAllowlist    []string      `long:"peer_allowlist" description:"CIDR network of non-public addresses the nodes of clients may be connected at, which are refused otherwise outside of simnet. May be specified multiple times"`
+	ChanConfs        uint32        `long:"chan_confs" description:"Number of confirmations the peers require before opening channels, as shown to users (default: the scale used by dcrlnd, from 3 to 6 depending on the channel size)"`
+
+	// Channel closes
+	CloseGracePeriod   time.Duration `long:"close_grace_period" description:"Time during which the faucet attempts to cooperatively close a channel before force closing it"`
+	CloseRetryInterval time.Duration `long:"close_retry_interval" description:"Interval between two attempts to close a channel"`
+	CloseReconnect     bool          `long:"close_reconnect" description:"Attempt to reconnect to the peer of a channel before each cooperative close attempt"`
+
+	// Channel selection and behavior of wipe_chans
+	WipeFaucetOnly  bool          `long:"wipe_faucet_only" description:"Only wipe the channels opened by the faucet"`
+	WipeMinAge      time.Duration `long:"wipe_min_age" description:"Only wipe the channels opened by the faucet at least this long ago"`
+	WipePeers       []string      `long:"wipe_peer" description:"Only wipe the channels with this peer (may be repeated)"`
+	WipeMinCapacity int64         `long:"wipe_min_capacity" description:"Only wipe the channels of at least this capacity in atoms"`
+	WipeMaxCapacity int64         `long:"wipe_max_capacity" description:"Only wipe the channels of at most this capacity in atoms"`
+	WipeDryRun      bool          `long:"wipe_dry_run" description:"Only list the channels wipe_chans would close"`
+	WipeConcurrency int           `long:"wipe_concurrency" description:"Number of channels closed at the same time by wipe_chans"`
+	WipeWaitConfs   bool          `long:"wipe_wait_confs" description:"Wait until the closing transactions are confirmed before exiting"`
+
+	// Admin area credentials. The admin area is disabled unless at least
+	// one of them is set.
+	AdminUser         string `long:"admin_user" description:"User name of the admin area password"`
+	AdminPasswordHash string `long:"admin_password_hash" description:"bcrypt hash of the admin area password"`
+	AdminToken        string `long:"admin_token" description:"Bearer token granting access to the admin area"`
+	AdminMacaroonPath string `long:"admin_macaroonpath" description:"Path to write a macaroon granting access to the admin area"`
 
 	// Network
 	MainNet bool `long:"mainnet" description:"Use the main network"`
 	TestNet bool `long:"testnet" description:"Use the test network"`
 	SimNet  bool `long:"simnet" description:"Use the simulation test network"`
 
+	// Block explorer links
+	ExplorerTxURL      string `long:"explorer_tx_url" description:"URL of the block explorer page of a transaction, with %s standing for the txid (default: dcrdata for mainnet and testnet, none for simnet)"`
+	ExplorerAddressURL string `long:"explorer_address_url" description:"URL of the block explorer page of an address, with %s standing for the address"`
+	ExplorerBlockURL   string `long:"explorer_block_url" description:"URL of the block explorer page of a block, with %s standing for the block hash"`
+	DisableExplorer    bool   `long:"disable_explorer" description:"Disable all links to a block explorer"`
+
+	// Limits of the actions
+	PolicyFile string `long:"policy_file" description:"Path to a JSON file defining the limits of the actions per network and client class, reloaded on SIGHUP (default: built-in limits)"`
+
+	// Spending budgets, in atoms per window. Hourly budgets reset on the
+	// hour and daily budgets at midnight UTC.
+	ChannelBudgetHourly int64 `long:"channel_budget_hourly" description:"Atoms committed to new channels each hour (0 for unlimited)"`
+	ChannelBudgetDaily  int64 `long:"channel_budget_daily" description:"Atoms committed to new channels each day (0 for unlimited)"`
+	PushBudgetHourly    int64 `long:"push_budget_hourly" description:"Atoms pushed to the peers of new channels each hour (0 for unlimited)"`
+	PushBudgetDaily     int64 `long:"push_budget_daily" description:"Atoms pushed to the peers of new channels each day (0 for unlimited)"`
+	PaymentBudgetHourly int64 `long:"payment_budget_hourly" description:"Atoms paid to invoices each hour (0 for unlimited)"`
+	PaymentBudgetDaily  int64 `long:"payment_budget_daily" description:"Atoms paid to invoices each day (0 for unlimited)"`
+
+	// WalletReserve is kept in the wallet when funding channels.
+	WalletReserve int64 `long:"wallet_reserve" description:"Confirmed atoms kept in the wallet when funding channels, to pay for closing and sweeping them"`
+
+	// Alerting
+	AlertWebhooks       []string      `long:"alert_webhook" description:"URL to which alerts are posted as JSON. May be specified multiple times (default: alerts disabled)"`
+	AlertInterval       time.Duration `long:"alert_interval" description:"Interval between two evaluations of the alert conditions"`
+	AlertMinBalance     int64         `long:"alert_min_balance" description:"Confirmed atoms below which the wallet is reported as low on funds (0 disables the alert)"`
+	AlertFailureRate    float64       `long:"alert_failure_rate" description:"Fraction of the channel opens of the last hour that must have failed to raise an alert (0 disables the alert)"`
+	AlertPendingTimeout time.Duration `long:"alert_pending_timeout" description:"Time after which channels still pending open are reported as stuck (0 disables the alert)"`
+
+	// Prometheus metrics
+	Metrics       bool   `long:"metrics" description:"Serve Prometheus metrics at /metrics"`
+	MetricsListen string `long:"metrics_listen" description:"Address on which /metrics is served instead of bind_addr, which implies metrics (default: bind_addr)"`
+
 	// Invoice features
 	DisableGenerateInvoices bool `long:"disablegen" description:"disable generate invoice"`
 	DisablePayInvoices      bool `long:"disablepay" description:"disable invoice payment"`
@@ -82,13 +189,43 @@ func normalizeNetwork(network string) string {
 func loadConfig() (*config, []string, error) {
 	// Default config.
 	cfg := config{
-		BindAddr:         defaultBindAddr,
-		UseLeHTTPS:       defaultUseLeHTTPS,
-		WipeChannels:     defaultWipeChannels,
-		MacaroonPath:     defaultMacaroonPath,
-		TLSCertPath:      defaultTLSCertPath,
-		ActionsTimeLimit: defaultActionsTimeLimit,
-		UseRealIP:        defaultUseRealIP,
+		BindAddr:               defaultBindAddr,
+		UseLeHTTPS:             defaultUseLeHTTPS,
+		WipeChannels:           defaultWipeChannels,
+		MacaroonPath:           defaultMacaroonPath,
+		TLSCertPath:            defaultTLSCertPath,
+		DataDir:                defaultDataDir,
+		ActionsTimeLimit:       defaultActionsTimeLimit,
+		UseRealIP:              defaultUseRealIP,
+		RateLimitBurst:         defaultRateLimitBurst,
+		RateLimitMaxKeys:       defaultRateLimitMaxKeys,
+		IPv4Prefix:             defaultIPv4Prefix,
+		IPv6Prefix:             defaultIPv6Prefix,
+		SubnetBurst:            defaultSubnetBurst,
+		PoWMaxDifficulty:       defaultPoWMaxDifficulty,
+		PoWLoadWindow:          defaultPoWLoadWindow,
+		PoWLoadStep:            defaultPoWLoadStep,
+		PoWChanSizeStep:        defaultPoWChanSizeStep,
+		AdminUser:              defaultAdminUser,
+		MaxChannels:            defaultMaxChannels,
+		ZombieSweepInterval:    defaultZombieSweepInterval,
+		ZombieOfflineThreshold: defaultZombieOfflineThreshold,
+		ZombieIdleThreshold:    defaultZombieIdleThreshold,
+		RecyclePolicy:          recycleOldest,
+		RecycleInterval:        defaultRecycleInterval,
+		CloseGracePeriod:       defaultCloseGracePeriod,
+		CloseRetryInterval:     defaultCloseRetryInterval,
+		WipeConcurrency:        defaultWipeConcurrency,
+		OpenWorkers:            defaultOpenWorkers,
+		OpenQueueSize:          defaultOpenQueueSize,
+		OpenTimeout:            defaultOpenTimeout,
+		ConnectTimeout:         defaultConnectTimeout,
+		MaxInflightOpens:       defaultMaxInflightOpens,
+		WalletReserve:          defaultWalletReserve,
+		AlertInterval:          defaultAlertInterval,
+		AlertMinBalance:        defaultAlertMinBalance,
+		AlertFailureRate:       defaultAlertFailureRate,
+		AlertPendingTimeout:    defaultAlertPendingTimeout,
 	}
 
 	// Pre-parse the command line options to see if an alternative config
@@ -206,6 +343,14 @@ func loadConfig() (*config, []string, error) {
 	initLogRotator(defaultLogPath)
 	setLogLevels(defaultLogLevel)
 
+	// The explorer depends on the active network, so it can only be
+	// checked once the network is known.
+	if _, err := newBlockExplorer(&cfg); err != nil {
+		err := fmt.Errorf("%s: %v", funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+
 	if cfg.UseLeHTTPS && cfg.Domain == "" {
 		err := fmt.Errorf("%s: domain must be specified to use Let's Encrypt HTTPS", funcName)
 		fmt.Fprintln(os.Stderr, err)
@@ -220,6 +365,202 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Any per action time limit that wasn't set defaults to the actions
+	// time limit.
+	actionTimeLimits := []*time.Duration{
+		&cfg.OpenChannelTimeLimit,
+		&cfg.GenerateInvoiceTimeLimit,
+		&cfg.PayInvoiceTimeLimit,
+	}
+	for _, timeLimit := range actionTimeLimits {
+		if *timeLimit < 0 {
+			str := "%s: action time limits cannot be < 0"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, err
+		}
+		if *timeLimit == 0 {
+			*timeLimit = cfg.ActionsTimeLimit
+		}
+	}
+
+	if cfg.RateLimitBurst <= 0 || cfg.RateLimitMaxKeys <= 0 {
+		str := "%s: ratelimit_burst and ratelimit_max_keys must be > 0"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+
+	if err := validatePrefixes(&cfg); err != nil {
+		err := fmt.Errorf("%s: %v", funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+
+	// Forwarded headers are only honored when coming from a trusted
+	// proxy, which defaults to a proxy running on the same host.
+	if cfg.UseRealIP {
+		if len(cfg.TrustedProxies) == 0 {
+			log.Warnf("userealip is set without trusted_proxies, "+
+				"only trusting %v",
+				strings.Join(defaultTrustedProxies, ", "))
+			cfg.TrustedProxies = defaultTrustedProxies
+		}
+		if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+			err := fmt.Errorf("%s: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, err
+		}
+	}
+
+	// Human verification defaults to every action once a provider is
+	// selected.
+	if cfg.CaptchaProvider != "" && cfg.CaptchaProvider != "none" {
+		if len(cfg.CaptchaActions) == 0 {
+			cfg.CaptchaActions = []string{
+				OpenChannelAction,
+				GenerateInvoiceAction,
+				PayInvoiceAction,
+			}
+		}
+		for _, action := range cfg.CaptchaActions {
+			switch action {
+			case OpenChannelAction, GenerateInvoiceAction,
+				PayInvoiceAction:
+			default:
+				err := fmt.Errorf("%s: unknown captcha action %q",
+					funcName, action)
+				fmt.Fprintln(os.Stderr, err)
+				return nil, nil, err
+			}
+		}
+	}
+
+	// The html forms don't ask for a proof of work, so they must be
+	// guarded by a captcha instead for the proof of work to mean anything.
+	if cfg.PoWDifficulty > 0 {
+		captchaActions := make(map[string]bool)
+		if cfg.CaptchaProvider != "" && cfg.CaptchaProvider != "none" {
+			for _, action := range cfg.CaptchaActions {
+				captchaActions[action] = true
+			}
+		}
+		var unguarded []string
+		for _, action := range []string{OpenChannelAction,
+			PayInvoiceAction} {
+
+			if !captchaActions[action] {
+				unguarded = append(unguarded, action)
+			}
+		}
+
+		switch {
+		case len(unguarded) > 0:
+			err = fmt.Errorf("%s: pow_difficulty requires a "+
+				"captcha_provider with captcha_actions including "+
+				"%v", funcName, strings.Join(unguarded, " and "))
+		case cfg.PoWMaxDifficulty < cfg.PoWDifficulty ||
+			cfg.PoWMaxDifficulty > 256:
+			err = fmt.Errorf("%s: pow_max_difficulty must be "+
+				"between pow_difficulty and 256", funcName)
+		case cfg.PoWLoadWindow <= 0:
+			err = fmt.Errorf("%s: pow_load_window must be > 0",
+				funcName)
+		case cfg.PoWLoadStep < 0 || cfg.PoWChanSizeStep < 0:
+			err = fmt.Errorf("%s: pow_load_step and "+
+				"pow_chansize_step cannot be < 0", funcName)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case cfg.MaxChannels < 0 || cfg.ChannelMaxAge < 0:
+		err = fmt.Errorf("%s: max_channels and channel_max_age cannot "+
+			"be < 0", funcName)
+	case cfg.RecycleInterval <= 0:
+		err = fmt.Errorf("%s: recycle_interval must be > 0", funcName)
+	case cfg.ZombieSweepInterval <= 0:
+		err = fmt.Errorf("%s: zombie_sweep_interval must be > 0",
+			funcName)
+	case cfg.ZombieOfflineThreshold < 0 || cfg.ZombieIdleThreshold < 0:
+		err = fmt.Errorf("%s: zombie_offline_threshold and "+
+			"zombie_idle_threshold cannot be < 0", funcName)
+	case cfg.ZombieMinUptimeRatio < 0 || cfg.ZombieMinUptimeRatio > 1:
+		err = fmt.Errorf("%s: zombie_min_uptime must be between 0 "+
+			"and 1", funcName)
+	case cfg.CloseGracePeriod < 0:
+		err = fmt.Errorf("%s: close_grace_period cannot be < 0",
+			funcName)
+	case cfg.CloseRetryInterval <= 0:
+		err = fmt.Errorf("%s: close_retry_interval must be > 0",
+			funcName)
+	case cfg.OpenWorkers <= 0 || cfg.OpenQueueSize <= 0:
+		err = fmt.Errorf("%s: open_workers and open_queue_size must be "+
+			"> 0", funcName)
+	case cfg.MaxInflightOpens < 0:
+		err = fmt.Errorf("%s: max_inflight_opens cannot be < 0",
+			funcName)
+	case cfg.ChannelBudgetHourly < 0 || cfg.ChannelBudgetDaily < 0 ||
+		cfg.PushBudgetHourly < 0 || cfg.PushBudgetDaily < 0 ||
+		cfg.PaymentBudgetHourly < 0 || cfg.PaymentBudgetDaily < 0:
+		err = fmt.Errorf("%s: budgets cannot be < 0", funcName)
+	case cfg.WalletReserve < 0:
+		err = fmt.Errorf("%s: wallet_reserve cannot be < 0", funcName)
+	case cfg.AlertInterval <= 0:
+		err = fmt.Errorf("%s: alert_interval must be > 0", funcName)
+	case cfg.AlertMinBalance < 0 || cfg.AlertPendingTimeout < 0:
+		err = fmt.Errorf("%s: alert_min_balance and "+
+			"alert_pending_timeout cannot be < 0", funcName)
+	case cfg.AlertFailureRate < 0 || cfg.AlertFailureRate > 1:
+		err = fmt.Errorf("%s: alert_failure_rate must be between 0 "+
+			"and 1", funcName)
+	case cfg.OpenTimeout <= 0 || cfg.ConnectTimeout <= 0:
+		err = fmt.Errorf("%s: open_timeout and connect_timeout must be "+
+			"> 0", funcName)
+	case cfg.WipeConcurrency <= 0:
+		err = fmt.Errorf("%s: wipe_concurrency must be > 0", funcName)
+	case cfg.WipeMinAge < 0 || cfg.WipeMinCapacity < 0 ||
+		cfg.WipeMaxCapacity < 0:
+		err = fmt.Errorf("%s: wipe_min_age, wipe_min_capacity and "+
+			"wipe_max_capacity cannot be < 0", funcName)
+	case cfg.WipeMaxCapacity > 0 && cfg.WipeMinCapacity > cfg.WipeMaxCapacity:
+		err = fmt.Errorf("%s: wipe_min_capacity cannot be above "+
+			"wipe_max_capacity", funcName)
+	case cfg.RecyclePolicy != recycleOldest &&
+		cfg.RecyclePolicy != recycleLeastUsed:
+		err = fmt.Errorf("%s: unknown recycle_policy %q", funcName,
+			cfg.RecyclePolicy)
+	case cfg.Metrics && cfg.MetricsListen == "" &&
+		cfg.AdminPasswordHash == "" && cfg.AdminToken == "" &&
+		cfg.AdminMacaroonPath == "":
+		err = fmt.Errorf("%s: metrics served on bind_addr require "+
+			"admin credentials, set metrics_listen to serve them "+
+			"on a private address instead", funcName)
+	}
+	if err == nil {
+		for _, peer := range cfg.WipePeers {
+			if err = validateWipePeer(peer); err != nil {
+				err = fmt.Errorf("%s: wipe_peer: %v", funcName,
+					err)
+				break
+			}
+		}
+	}
+	if err == nil {
+		_, err = parseNetworks("peer_allowlist network",
+			cfg.PeerAllowlist)
+		if err != nil {
+			err = fmt.Errorf("%s: %v", funcName, err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultConnectTimeout is the default time allowed to connect to the
+	// node of a client.
+	defaultConnectTimeout = 15 * time.Second
+
+	// defaultPeerPort is the port of the node of a client when its address
+	// doesn't specify one.
+	defaultPeerPort = "9735"
+
+	// pubKeySize is the size of a serialized compressed public key.
+	pubKeySize = 33
+)
+
+var (
+	// errInvalidPubKey is returned when the public key of a node isn't a
+	// hex encoded compressed public key.
+	errInvalidPubKey = errors.New("invalid public key")
+
+	// errInvalidHost is returned when the address of a node isn't a valid
+	// host or host:port.
+	errInvalidHost = errors.New("invalid host")
+
+	// errRefusedPeerAddr is returned when none of the addresses of a node
+	// may be connected to.
+	errRefusedPeerAddr = errors.New("no public address")
+)
+
+// parseNodeAddr splits the passed node, given either as a public key or as
+// pubkey@host[:port], into its public key and address. The address is empty
+// when only the public key is given, and defaults to defaultPeerPort when no
+// port is given.
+func parseNodeAddr(node string) (string, string, error) {
+	node = strings.TrimSpace(node)
+
+	pubKey, host := node, ""
+	if i := strings.Index(node, "@"); i > -1 {
+		pubKey, host = node[:i], node[i+1:]
+		if host == "" {
+			return "", "", errInvalidHost
+		}
+	}
+
+	pubKeyBytes, err := hex.DecodeString(pubKey)
+	if err != nil || len(pubKeyBytes) != pubKeySize {
+		return "", "", errInvalidPubKey
+	}
+
+	if host == "" {
+		return pubKey, "", nil
+	}
+	// Addresses without a port use the default one, which
+	// net.JoinHostPort adds while bracketing IPv6 addresses.
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"),
+			defaultPeerPort)
+	}
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil || hostname == "" ||
+		strings.ContainsAny(hostname, "/@ ") {
+
+		return "", "", errInvalidHost
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return "", "", errInvalidHost
+	}
+
+	return pubKey, host, nil
+}
+
+// nonPublicNets are the networks of the addresses that aren't reachable over
+// the internet, which the faucet refuses to connect to outside of simnet so
+// that clients can't use it to probe the hosts around it.
+var nonPublicNets = mustParseNetworks(
+	"0.0.0.0/8",      // This network.
+	"10.0.0.0/8",     // Private.
+	"100.64.0.0/10",  // Carrier-grade NAT.
+	"127.0.0.0/8",    // Loopback.
+	"169.254.0.0/16", // Link-local.
+	"172.16.0.0/12",  // Private.
+	"192.168.0.0/16", // Private.
+	"224.0.0.0/4",    // Multicast.
+	"240.0.0.0/4",    // Reserved and broadcast.
+	"::/128",         // Unspecified.
+	"::1/128",        // Loopback.
+	"fc00::/7",       // Unique local.
+	"fe80::/10",      // Link-local.
+	"ff00::/8",       // Multicast.
+)
+
+// mustParseNetworks parses the passed CIDR networks, panicking if any of them
+// is invalid.
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	nets, err := parseNetworks("network", cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// peerAddrFilter decides which addresses the faucet may connect to the nodes
+// of clients at.
+type peerAddrFilter struct {
+	// allowAll disables the filter, on networks where the nodes are
+	// expected to run on private addresses.
+	allowAll bool
+
+	// allowed are the non-public networks allowed anyway.
+	allowed []*net.IPNet
+
+	// ownPorts are the ports the faucet and its dcrlnd node listen on.
+	ownPorts map[int]bool
+
+	// lookupIPAddr resolves host names.
+	lookupIPAddr func(context.Context, string) ([]net.IPAddr, error)
+}
+
+// newPeerAddrFilter returns the filter of the peer addresses configured by
+// the passed config.
+func newPeerAddrFilter(cfg *config) (*peerAddrFilter, error) {
+	allowed, err := parseNetworks("peer_allowlist network",
+		cfg.PeerAllowlist)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := []string{cfg.BindAddr, cfg.MetricsListen, cfg.LndNode}
+	if cfg.UseLeHTTPS {
+		listeners = append(listeners, ":https")
+	}
+	ownPorts := make(map[int]bool)
+	for _, addr := range listeners {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if p, err := net.LookupPort("tcp", port); err == nil {
+			ownPorts[p] = true
+		}
+	}
+
+	return &peerAddrFilter{
+		allowAll:     cfg.SimNet,
+		allowed:      allowed,
+		ownPorts:     ownPorts,
+		lookupIPAddr: net.DefaultResolver.LookupIPAddr,
+	}, nil
+}
+
+// permitted returns whether the faucet may connect to a node at the passed
+// address and port.
+func (f *peerAddrFilter) permitted(ip net.IP, port int) bool {
+	if f.allowAll {
+		return true
+	}
+	for _, ipNet := range f.allowed {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	if f.ownPorts[port] {
+		return false
+	}
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve resolves the host of the passed host:port and returns the first of
+// its addresses the faucet may connect to, as ip:port, so that the address
+// that was checked is the one connected to.
+func (f *peerAddrFilter) resolve(ctx context.Context,
+	hostPort string) (string, error) {
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := f.lookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if f.permitted(addr.IP, portNum) {
+			return net.JoinHostPort(addr.IP.String(), port), nil
+		}
+	}
+	return "", errRefusedPeerAddr
+}
+
+// connectErrorCode returns the error reported to a client whose node the
+// faucet failed to connect to with the passed error. dcrlnd only reports
+// these failures through the message of the error, so they are told apart by
+// the messages of the underlying dial, handshake and chain checks.
+func connectErrorCode(err error) ChanCreationError {
+	if err == context.DeadlineExceeded ||
+		status.Code(err) == codes.DeadlineExceeded {
+
+		return PeerUnreachable
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case wrongNetworkError(msg):
+		return PeerWrongNetwork
+
+	case strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "no such host"),
+		strings.Contains(msg, "i/o timeout"),
+		strings.Contains(msg, "no route to host"),
+		strings.Contains(msg, "network is unreachable"),
+		strings.Contains(msg, "host is down"),
+		strings.Contains(msg, "deadline exceeded"):
+		return PeerUnreachable
+	}
+
+	// Once connected, anything else is a failure of the encrypted
+	// handshake with the node or of the exchange of init messages.
+	return PeerHandshakeFailed
+}
+
+// wrongNetworkError returns whether the passed lower cased error message
+// reports a peer running on another chain.
+func wrongNetworkError(msg string) bool {
+	return strings.Contains(msg, "chain hash") ||
+		strings.Contains(msg, "unknown chain") ||
+		strings.Contains(msg, "different chain") ||
+		strings.Contains(msg, "genesis")
+}
+
+// connectToNode connects to the node with the passed public key at host unless
+// it is already connected, giving up after the connect timeout.
+func (l *lightningFaucet) connectToNode(ctx context.Context, pubKey,
+	host string) ChanCreationError {
+
+	if l.connectedToNode(ctx, pubKey) {
+		return NoError
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.cfg.ConnectTimeout)
+	defer cancel()
+
+	// Addresses the faucet refuses to connect to are reported as
+	// unreachable, like the ones that can't be resolved, so that clients
+	// can't tell them apart from the hosts that aren't listening.
+	addr, err := l.peerAddrs.resolve(ctx, host)
+	if err != nil {
+		log.Infof("Refusing to connect to %v at %v: %v", pubKey, host,
+			err)
+		return PeerUnreachable
+	}
+
+	err = l.lnd.ConnectPeer(ctx, pubKey, addr)
+	switch {
+	case err == nil:
+		log.Infof("Connected to %v at %v", pubKey, host)
+		return NoError
+
+	// The node may have connected to the faucet in the meantime.
+	case strings.Contains(err.Error(), "already connected"):
+		return NoError
+	}
+
+	chanErr := connectErrorCode(err)
+	log.Infof("Unable to connect to %v at %v (%v): %v", pubKey, host,
+		chanErr.Code(), err)
+	return chanErr
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestParseNodeAddr ensures nodes are accepted as public keys or as full URIs.
+func TestParseNodeAddr(t *testing.T) {
+	pubKey := fakePubKey(0x01)
+
+	tests := []struct {
+		node       string
+		wantPubKey string
+		wantHost   string
+		wantErr    error
+	}{
+		{node: pubKey, wantPubKey: pubKey},
+		{node: " " + pubKey + "\n", wantPubKey: pubKey},
+		{node: pubKey + "@192.0.2.1:9736", wantPubKey: pubKey,
+			wantHost: "192.0.2.1:9736"},
+		{node: pubKey + "@node.example.com", wantPubKey: pubKey,
+			wantHost: "node.example.com:9735"},
+		{node: pubKey + "@[2001:db8::1]:9000", wantPubKey: pubKey,
+			wantHost: "[2001:db8::1]:9000"},
+		{node: pubKey + "@2001:db8::1", wantPubKey: pubKey,
+			wantHost: "[2001:db8::1]:9735"},
+		{node: "zz", wantErr: errInvalidPubKey},
+		{node: pubKey[:64], wantErr: errInvalidPubKey},
+		{node: "zz@192.0.2.1:9735", wantErr: errInvalidPubKey},
+		{node: pubKey + "@", wantErr: errInvalidHost},
+		{node: pubKey + "@:9735", wantErr: errInvalidHost},
+		{node: pubKey + "@192.0.2.1:port", wantErr: errInvalidHost},
+		{node: pubKey + "@192.0.2.1:70000", wantErr: errInvalidHost},
+		{node: pubKey + "@host/path:9735", wantErr: errInvalidHost},
+	}
+
+	for _, test := range tests {
+		gotPubKey, gotHost, err := parseNodeAddr(test.node)
+		if err != test.wantErr {
+			t.Fatalf("%q: unexpected error: got %v, want %v",
+				test.node, err, test.wantErr)
+		}
+		if gotPubKey != test.wantPubKey || gotHost != test.wantHost {
+			t.Fatalf("%q: got (%q, %q), want (%q, %q)", test.node,
+				gotPubKey, gotHost, test.wantPubKey,
+				test.wantHost)
+		}
+	}
+}
+
+// TestPeerAddrFilter ensures the nodes of clients are only connected to at
+// public addresses, outside of the faucet's own ports, unless allowed.
+func TestPeerAddrFilter(t *testing.T) {
+	filter, err := newPeerAddrFilter(&config{
+		BindAddr:      ":8080",
+		LndNode:       "localhost:10009",
+		PeerAllowlist: []string{"10.1.0.0/16", "fd00::1"},
+	})
+	if err != nil {
+		t.Fatalf("unable to create filter: %v", err)
+	}
+	filter.lookupIPAddr = func(_ context.Context,
+		host string) ([]net.IPAddr, error) {
+
+		switch host {
+		case "mixed.example.com":
+			return []net.IPAddr{
+				{IP: net.ParseIP("127.0.0.1")},
+				{IP: net.ParseIP("203.0.113.5")},
+			}, nil
+		case "private.example.com":
+			return []net.IPAddr{
+				{IP: net.ParseIP("192.168.1.1")},
+				{IP: net.ParseIP("fe80::1")},
+			}, nil
+		}
+		return net.DefaultResolver.LookupIPAddr(context.Background(),
+			host)
+	}
+
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "192.0.2.1:9735", want: "192.0.2.1:9735"},
+		{host: "[2001:db8::1]:9735", want: "[2001:db8::1]:9735"},
+		{host: "mixed.example.com:9735", want: "203.0.113.5:9735"},
+		{host: "private.example.com:9735", wantErr: true},
+		{host: "127.0.0.1:9735", wantErr: true},
+		{host: "10.0.0.1:9735", wantErr: true},
+		{host: "172.16.5.4:9735", wantErr: true},
+		{host: "192.168.1.1:9735", wantErr: true},
+		{host: "169.254.169.254:80", wantErr: true},
+		{host: "0.0.0.0:9735", wantErr: true},
+		{host: "[::1]:9735", wantErr: true},
+		{host: "[::ffff:127.0.0.1]:9735", wantErr: true},
+		{host: "[fe80::1]:9735", wantErr: true},
+		{host: "[fc00::1]:9735", wantErr: true},
+		{host: "192.0.2.1:8080", wantErr: true},
+		{host: "192.0.2.1:10009", wantErr: true},
+		{host: "10.1.2.3:9735", want: "10.1.2.3:9735"},
+		{host: "[fd00::1]:9735", want: "[fd00::1]:9735"},
+	}
+	for _, test := range tests {
+		got, err := filter.resolve(context.Background(), test.host)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Fatalf("%v: got (%q, %v), want %q", test.host, got,
+				err, test.want)
+		}
+	}
+
+	// Nodes may run anywhere on simnet.
+	filter, err = newPeerAddrFilter(&config{SimNet: true})
+	if err != nil {
+		t.Fatalf("unable to create filter: %v", err)
+	}
+	got, err := filter.resolve(context.Background(), "127.0.0.1:9735")
+	if err != nil || got != "127.0.0.1:9735" {
+		t.Fatalf("simnet address refused: %q %v", got, err)
+	}
+}
+
+// TestConnectErrorCode ensures failures to connect to a node are told apart.
+func TestConnectErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ChanCreationError
+	}{
+		{context.DeadlineExceeded, PeerUnreachable},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"),
+			PeerUnreachable},
+		{errors.New("dial tcp 192.0.2.1:9735: connect: connection refused"),
+			PeerUnreachable},
+		{errors.New("dial tcp: lookup nowhere.example: no such host"),
+			PeerUnreachable},
+		{errors.New("dial tcp 192.0.2.1:9735: i/o timeout"),
+			PeerUnreachable},
+		{errors.New("EOF"), PeerHandshakeFailed},
+		{errors.New("invalid ephemeral key"), PeerHandshakeFailed},
+		{errors.New("remote peer uses an unknown chain hash"),
+			PeerWrongNetwork},
+	}
+
+	for _, test := range tests {
+		if got := connectErrorCode(test.err); got != test.want {
+			t.Fatalf("%v: got %v, want %v", test.err, got.Code(),
+				test.want.Code())
+		}
+	}
+}
+
+// TestQueueChannelConnect ensures the faucet connects to the nodes given with
+// their address once their channel leaves the queue, and that their job
+// reports why it couldn't.
+func TestQueueChannelConnect(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	ctx := context.Background()
+
+	// Without an address, the node must have connected to the faucet.
+	peer := fakePubKey(0x01)
+	_, chanErr := faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peer, 1e6, 0)
+	if chanErr != NotConnected {
+		t.Fatalf("unexpected error: %v", chanErr.Code())
+	}
+
+	// Failures to connect fail the job. As the node is to blame, the
+	// client and the node keep having their tokens taken while the
+	// budget is given back.
+	faucet.budget = newBudgetTracker(&config{ChannelBudgetDaily: 1e8})
+	connectFails := func(clientIP, node string, wantErr ChanCreationError) {
+		t.Helper()
+
+		job, chanErr := faucet.queueChannel(ctx, clientIP,
+			anonymousClient, node+"@192.0.2.1:9735", 1e6, 0)
+		if chanErr != NoError {
+			t.Fatalf("unable to queue channel: %v", chanErr.Code())
+		}
+		job = waitOpenJob(t, faucet, job.ID)
+		if job.State != openJobFailed || job.ErrorCode != wantErr.Code() {
+			t.Fatalf("unexpected job: %+v", job)
+		}
+
+		if spent := faucet.budget.status(time.Now())[0].Spent; spent != 0 {
+			t.Fatalf("budget not given back: %d", spent)
+		}
+		clientKey := rateLimitKey{clientIPKey, clientIP}
+		if faucet.limiter.check(OpenChannelAction, clientKey) == nil {
+			t.Fatalf("tokens of the client given back")
+		}
+		nodeLimitKey := rateLimitKey{nodeKey, node}
+		if faucet.limiter.check(OpenChannelAction, nodeLimitKey) == nil {
+			t.Fatalf("tokens of the node given back")
+		}
+	}
+	connectFails("198.51.100.1", fakePubKey(0x03), PeerUnreachable)
+
+	lnd.mtx.Lock()
+	lnd.errConnectPeer = errors.New("EOF")
+	lnd.mtx.Unlock()
+	connectFails("198.51.100.2", fakePubKey(0x04), PeerHandshakeFailed)
+
+	lnd.mtx.Lock()
+	lnd.errConnectPeer = nil
+	lnd.reachable[peer] = true
+	lnd.mtx.Unlock()
+
+	// Nodes at non-public addresses are reported as unreachable without
+	// being connected to.
+	job, chanErr := faucet.queueChannel(ctx, "198.51.100.3",
+		anonymousClient, peer+"@127.0.0.1:9735", 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobFailed ||
+		job.ErrorCode != PeerUnreachable.Code() {
+
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	if faucet.connectedToNode(ctx, peer) {
+		t.Fatalf("connected to node at a loopback address")
+	}
+	faucet.limiter.clear(rateLimitKey{nodeKey, peer})
+
+	// Invalid requests are refused before connecting.
+	_, chanErr = faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peer+"@192.0.2.1:9735", defaultMinChannelSize-1, 0)
+	if chanErr != ChannelTooSmall {
+		t.Fatalf("unexpected error: %v", chanErr.Code())
+	}
+	if faucet.connectedToNode(ctx, peer) {
+		t.Fatalf("connected to node of invalid request")
+	}
+
+	job, chanErr = faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peer+"@192.0.2.1:9735", 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	if job.NodePubKey != peer {
+		t.Fatalf("unexpected node: %v", job.NodePubKey)
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobPending {
+		t.Fatalf("channel not pending: %+v", job)
+	}
+	if !faucet.connectedToNode(ctx, peer) {
+		t.Fatalf("not connected to node")
+	}
+
+	// Nodes running on another network are only found out once they
+	// refuse the channel.
+	other := fakePubKey(0x02)
+	lnd.mtx.Lock()
+	lnd.reachable[other] = true
+	lnd.errOpenChannel = errors.New("received funding error from " +
+		other + ": chan_id=00, err=unknown chain hash")
+	lnd.mtx.Unlock()
+	job, chanErr = faucet.queueChannel(ctx, "192.0.2.99", anonymousClient,
+		other+"@192.0.2.2", 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobFailed ||
+		job.ErrorCode != PeerWrongNetwork.Code() {
+
+		t.Fatalf("unexpected job: %+v", job)
+	}
+}
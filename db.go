@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// dbFilename is the name of the faucet's database file within the
+	// network specific data directory.
+	dbFilename = "faucet.db"
+
+	// dbOpenTimeout is how long we'll wait to obtain the lock on the
+	// database file before giving up. This prevents a second instance of
+	// the faucet from blocking forever.
+	dbOpenTimeout = time.Second
+)
+
+var (
+	// channelGrantsBucket stores a channelGrant for every channel opened
+	// by the faucet, keyed by an increasing sequence number.
+	channelGrantsBucket = []byte("channel-grants")
+
+	// invoiceGrantsBucket stores an invoiceGrant for every invoice
+	// generated by the faucet, keyed by an increasing sequence number.
+	invoiceGrantsBucket = []byte("invoice-grants")
+
+	// paymentGrantsBucket stores a paymentGrant for every invoice paid by
+	// the faucet, keyed by an increasing sequence number.
+	paymentGrantsBucket = []byte("payment-grants")
+
+	// channelClosesBucket stores a channelClose for every channel closed
+	// by the faucet, keyed by an increasing sequence number.
+	channelClosesBucket = []byte("channel-closes")
+
+	// closeRequestsBucket stores the closeRequest of every channel being
+	// closed by the close manager, keyed by channel point.
+	closeRequestsBucket = []byte("close-requests")
+
+	// inflightOpensBucket stores an inflightOpen for every channel whose
+	// funding is being negotiated, keyed by node public key.
+	inflightOpensBucket = []byte("inflight-opens")
+
+	// topLevelBuckets is the list of buckets created when the database is
+	// opened.
+	topLevelBuckets = [][]byte{
+		channelGrantsBucket,
+		invoiceGrantsBucket,
+		paymentGrantsBucket,
+		channelClosesBucket,
+		closeRequestsBucket,
+		inflightOpensBucket,
+	}
+)
+
+// channelGrant records a channel opened by the faucet.
+type channelGrant struct {
+	NodePubKey   string    `json:"node_pubkey"`
+	ChannelSize  int64     `json:"channel_size"`
+	PushAmount   int64     `json:"push_amount"`
+	ChannelPoint string    `json:"channel_point"`
+	ClientIP     string    `json:"client_ip"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// invoiceGrant records an invoice generated by the faucet.
+type invoiceGrant struct {
+	PaymentHash    string    `json:"payment_hash"`
+	PaymentRequest string    `json:"payment_request"`
+	Amount         int64     `json:"amount"`
+	Description    string    `json:"description"`
+	ClientIP       string    `json:"client_ip"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// paymentGrant records an invoice paid by the faucet.
+type paymentGrant struct {
+	PaymentRequest string    `json:"payment_request"`
+	Destination    string    `json:"destination"`
+	PaymentHash    string    `json:"payment_hash"`
+	Amount         int64     `json:"amount"`
+	ClientIP       string    `json:"client_ip"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// channelClose records a channel closed by the faucet and why.
+type channelClose struct {
+	ChannelPoint string      `json:"channel_point"`
+	Reason       closeReason `json:"reason"`
+	Force        bool        `json:"force"`
+	ClosingTxid  string      `json:"closing_txid"`
+	Timestamp    time.Time   `json:"timestamp"`
+}
+
+// inflightOpen records a channel open in progress with a node, from the moment
+// it is queued until its funding transaction is broadcast or it fails.
+type inflightOpen struct {
+	NodePubKey string    `json:"node_pubkey"`
+	JobID      string    `json:"job_id"`
+	ClientIP   string    `json:"client_ip"`
+	Started    time.Time `json:"started"`
+}
+
+// faucetDB is the persistent storage of the faucet. It records every grant
+// made by the faucet so that limits survive restarts and operators can audit
+// what was given to whom.
+type faucetDB struct {
+	db *bolt.DB
+}
+
+// networkDataDir returns the directory holding the faucet's data for the
+// active network.
+func networkDataDir(dataDir string) string {
+	return filepath.Join(cleanAndExpandPath(dataDir), "data",
+		normalizeNetwork(activeNetParams.Name))
+}
+
+// dbPath returns the path of the faucet's database for the active network.
+func dbPath(dataDir string) string {
+	return filepath.Join(networkDataDir(dataDir), dbFilename)
+}
+
+// openFaucetDB opens the database at the passed path, creating it and all of
+// its buckets if needed.
+func openFaucetDB(path string) (*faucetDB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: dbOpenTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database %v: %v", path,
+			err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range topLevelBuckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &faucetDB{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (d *faucetDB) Close() error {
+	return d.db.Close()
+}
+
+// seqKey serializes a sequence number into a key that sorts in insertion
+// order.
+func seqKey(seq uint64) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], seq)
+	return k[:]
+}
+
+// appendRecord serializes v and stores it in the given bucket under the next
+// sequence number.
+func (d *faucetDB) appendRecord(bucket []byte, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(seq), value)
+	})
+}
+
+// forEachRecord calls fn with the raw value of every record in the given
+// bucket, from newest to oldest. Iteration stops as soon as fn returns false.
+func (d *faucetDB) forEachRecord(bucket []byte, fn func([]byte) (bool, error)) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			more, err := fn(v)
+			if err != nil {
+				return err
+			}
+			if !more {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// AddChannelGrant records a channel opened by the faucet.
+func (d *faucetDB) AddChannelGrant(g *channelGrant) error {
+	return d.appendRecord(channelGrantsBucket, g)
+}
+
+// AddInvoiceGrant records an invoice generated by the faucet.
+func (d *faucetDB) AddInvoiceGrant(g *invoiceGrant) error {
+	return d.appendRecord(invoiceGrantsBucket, g)
+}
+
+// AddPaymentGrant records an invoice paid by the faucet.
+func (d *faucetDB) AddPaymentGrant(g *paymentGrant) error {
+	return d.appendRecord(paymentGrantsBucket, g)
+}
+
+// AddChannelClose records a channel closed by the faucet.
+func (d *faucetDB) AddChannelClose(c *channelClose) error {
+	return d.appendRecord(channelClosesBucket, c)
+}
+
+// ForEachChannelGrant calls fn for every recorded channel grant, from newest
+// to oldest, until fn returns false.
+func (d *faucetDB) ForEachChannelGrant(fn func(*channelGrant) bool) error {
+	return d.forEachRecord(channelGrantsBucket, func(v []byte) (bool, error) {
+		var g channelGrant
+		if err := json.Unmarshal(v, &g); err != nil {
+			return false, err
+		}
+		return fn(&g), nil
+	})
+}
+
+// ForEachInvoiceGrant calls fn for every recorded invoice grant, from newest
+// to oldest, until fn returns false.
+func (d *faucetDB) ForEachInvoiceGrant(fn func(*invoiceGrant) bool) error {
+	return d.forEachRecord(invoiceGrantsBucket, func(v []byte) (bool, error) {
+		var g invoiceGrant
+		if err := json.Unmarshal(v, &g); err != nil {
+			return false, err
+		}
+		return fn(&g), nil
+	})
+}
+
+// ForEachPaymentGrant calls fn for every recorded payment grant, from newest
+// to oldest, until fn returns false.
+func (d *faucetDB) ForEachPaymentGrant(fn func(*paymentGrant) bool) error {
+	return d.forEachRecord(paymentGrantsBucket, func(v []byte) (bool, error) {
+		var g paymentGrant
+		if err := json.Unmarshal(v, &g); err != nil {
+			return false, err
+		}
+		return fn(&g), nil
+	})
+}
+
+// ForEachChannelClose calls fn for every recorded channel close, from newest
+// to oldest, until fn returns false.
+func (d *faucetDB) ForEachChannelClose(fn func(*channelClose) bool) error {
+	return d.forEachRecord(channelClosesBucket, func(v []byte) (bool, error) {
+		var c channelClose
+		if err := json.Unmarshal(v, &c); err != nil {
+			return false, err
+		}
+		return fn(&c), nil
+	})
+}
+
+// PutCloseRequest stores the state of a channel being closed, replacing any
+// previous state of the same channel.
+func (d *faucetDB) PutCloseRequest(req *closeRequest) error {
+	value, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(closeRequestsBucket)
+		return b.Put([]byte(req.ChannelPoint), value)
+	})
+}
+
+// DeleteCloseRequest forgets about the channel being closed.
+func (d *faucetDB) DeleteCloseRequest(chanPoint string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(closeRequestsBucket).Delete([]byte(chanPoint))
+	})
+}
+
+// ForEachCloseRequest calls fn for the state of every channel being closed.
+func (d *faucetDB) ForEachCloseRequest(fn func(*closeRequest)) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(closeRequestsBucket).ForEach(func(_, v []byte) error {
+			var req closeRequest
+			if err := json.Unmarshal(v, &req); err != nil {
+				return err
+			}
+			fn(&req)
+			return nil
+		})
+	})
+}
+
+// PutInflightOpen records the channel open in progress with a node, replacing
+// any previous open with the same node.
+func (d *faucetDB) PutInflightOpen(open *inflightOpen) error {
+	value, err := json.Marshal(open)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(inflightOpensBucket)
+		return b.Put([]byte(open.NodePubKey), value)
+	})
+}
+
+// DeleteInflightOpen forgets about the channel open in progress with the node.
+func (d *faucetDB) DeleteInflightOpen(nodePubStr string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(inflightOpensBucket).Delete([]byte(nodePubStr))
+	})
+}
+
+// ForEachInflightOpen calls fn for every recorded channel open in progress.
+func (d *faucetDB) ForEachInflightOpen(fn func(*inflightOpen)) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(inflightOpensBucket).ForEach(func(_, v []byte) error {
+			var open inflightOpen
+			if err := json.Unmarshal(v, &open); err != nil {
+				return err
+			}
+			fn(&open)
+			return nil
+		})
+	})
+}
+
+// dumpGrants writes every recorded grant as a line of JSON to the passed
+// encoder, newest first within each kind of grant.
+func (d *faucetDB) dumpGrants(enc *json.Encoder) error {
+	type dumpedGrant struct {
+		Kind  string      `json:"kind"`
+		Grant interface{} `json:"grant"`
+	}
+
+	var encErr error
+	dump := func(kind string, g interface{}) bool {
+		encErr = enc.Encode(&dumpedGrant{Kind: kind, Grant: g})
+		return encErr == nil
+	}
+
+	err := d.ForEachChannelGrant(func(g *channelGrant) bool {
+		return dump("channel", g)
+	})
+	if err != nil || encErr != nil {
+		return firstErr(err, encErr)
+	}
+
+	err = d.ForEachInvoiceGrant(func(g *invoiceGrant) bool {
+		return dump("invoice", g)
+	})
+	if err != nil || encErr != nil {
+		return firstErr(err, encErr)
+	}
+
+	err = d.ForEachPaymentGrant(func(g *paymentGrant) bool {
+		return dump("payment", g)
+	})
+	return firstErr(err, encErr)
+}
+
+// firstErr returns the first non-nil error of the passed ones.
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDB opens a database in a new temporary directory. The returned
+// function must be called to remove the directory once the database is
+// closed.
+func newTestDB(t *testing.T) (*faucetDB, string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "faucetdb")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	path := filepath.Join(dir, dbFilename)
+
+	db, err := openFaucetDB(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to open database: %v", err)
+	}
+
+	return db, path, func() { os.RemoveAll(dir) }
+}
+
+// TestFaucetDBReopen ensures the grants recorded in the database are still
+// there once it is closed and opened again.
+func TestFaucetDBReopen(t *testing.T) {
+	db, path, cleanUp := newTestDB(t)
+	defer cleanUp()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	chanGrant := &channelGrant{
+		NodePubKey:   fakePubKey(0x01),
+		ChannelSize:  1e6,
+		PushAmount:   1e4,
+		ChannelPoint: "abcd:0",
+		ClientIP:     testClientIP,
+		Timestamp:    now,
+	}
+	invGrant := &invoiceGrant{
+		PaymentHash:    "00ff",
+		PaymentRequest: "lntdcr1inv",
+		Amount:         5e5,
+		Description:    "test",
+		ClientIP:       testClientIP,
+		Timestamp:      now,
+	}
+	payGrant := &paymentGrant{
+		PaymentRequest: "lntdcr1pay",
+		Destination:    fakePubKey(0x02),
+		PaymentHash:    "ff00",
+		Amount:         500,
+		ClientIP:       testClientIP,
+		Timestamp:      now,
+	}
+	if err := db.AddChannelGrant(chanGrant); err != nil {
+		t.Fatalf("unable to add channel grant: %v", err)
+	}
+	if err := db.AddInvoiceGrant(invGrant); err != nil {
+		t.Fatalf("unable to add invoice grant: %v", err)
+	}
+	if err := db.AddPaymentGrant(payGrant); err != nil {
+		t.Fatalf("unable to add payment grant: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("unable to close database: %v", err)
+	}
+
+	db, err := openFaucetDB(path)
+	if err != nil {
+		t.Fatalf("unable to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	var chanGrants []channelGrant
+	err = db.ForEachChannelGrant(func(g *channelGrant) bool {
+		chanGrants = append(chanGrants, *g)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unable to read channel grants: %v", err)
+	}
+	if len(chanGrants) != 1 || chanGrants[0] != *chanGrant {
+		t.Fatalf("unexpected channel grants: %+v", chanGrants)
+	}
+
+	var invGrants []invoiceGrant
+	err = db.ForEachInvoiceGrant(func(g *invoiceGrant) bool {
+		invGrants = append(invGrants, *g)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unable to read invoice grants: %v", err)
+	}
+	if len(invGrants) != 1 || invGrants[0] != *invGrant {
+		t.Fatalf("unexpected invoice grants: %+v", invGrants)
+	}
+
+	var payGrants []paymentGrant
+	err = db.ForEachPaymentGrant(func(g *paymentGrant) bool {
+		payGrants = append(payGrants, *g)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unable to read payment grants: %v", err)
+	}
+	if len(payGrants) != 1 || payGrants[0] != *payGrant {
+		t.Fatalf("unexpected payment grants: %+v", payGrants)
+	}
+}
+
+// TestFaucetDBNewestFirst ensures the grants are iterated from the newest to
+// the oldest, and that iteration stops as soon as the callback asks to.
+func TestFaucetDBNewestFirst(t *testing.T) {
+	db, _, cleanUp := newTestDB(t)
+	defer cleanUp()
+	defer db.Close()
+
+	const numGrants = 5
+	start := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < numGrants; i++ {
+		stamp := start.Add(time.Duration(i) * time.Minute)
+		err := db.AddChannelGrant(&channelGrant{
+			ChannelSize: int64(i),
+			Timestamp:   stamp,
+		})
+		if err != nil {
+			t.Fatalf("unable to add channel grant: %v", err)
+		}
+		err = db.AddInvoiceGrant(&invoiceGrant{
+			Amount:    int64(i),
+			Timestamp: stamp,
+		})
+		if err != nil {
+			t.Fatalf("unable to add invoice grant: %v", err)
+		}
+		err = db.AddPaymentGrant(&paymentGrant{
+			Amount:    int64(i),
+			Timestamp: stamp,
+		})
+		if err != nil {
+			t.Fatalf("unable to add payment grant: %v", err)
+		}
+	}
+
+	// Each walk collects the amounts of the grants it is handed and stops
+	// after the given number of them.
+	tests := []struct {
+		name string
+		walk func(stopAfter int) ([]int64, error)
+	}{{
+		name: "channel grants",
+		walk: func(stopAfter int) ([]int64, error) {
+			var amts []int64
+			err := db.ForEachChannelGrant(func(g *channelGrant) bool {
+				amts = append(amts, g.ChannelSize)
+				return len(amts) < stopAfter
+			})
+			return amts, err
+		},
+	}, {
+		name: "invoice grants",
+		walk: func(stopAfter int) ([]int64, error) {
+			var amts []int64
+			err := db.ForEachInvoiceGrant(func(g *invoiceGrant) bool {
+				amts = append(amts, g.Amount)
+				return len(amts) < stopAfter
+			})
+			return amts, err
+		},
+	}, {
+		name: "payment grants",
+		walk: func(stopAfter int) ([]int64, error) {
+			var amts []int64
+			err := db.ForEachPaymentGrant(func(g *paymentGrant) bool {
+				amts = append(amts, g.Amount)
+				return len(amts) < stopAfter
+			})
+			return amts, err
+		},
+	}}
+
+	for _, test := range tests {
+		amts, err := test.walk(numGrants + 1)
+		if err != nil {
+			t.Fatalf("%v: unable to iterate: %v", test.name, err)
+		}
+		if len(amts) != numGrants {
+			t.Fatalf("%v: unexpected grants: %v", test.name, amts)
+		}
+		for i, amt := range amts {
+			if amt != int64(numGrants-1-i) {
+				t.Fatalf("%v: grants not newest first: %v",
+					test.name, amts)
+			}
+		}
+
+		amts, err = test.walk(2)
+		if err != nil {
+			t.Fatalf("%v: unable to iterate: %v", test.name, err)
+		}
+		if len(amts) != 2 || amts[0] != numGrants-1 ||
+			amts[1] != numGrants-2 {
+
+			t.Fatalf("%v: iteration not stopped: %v", test.name,
+				amts)
+		}
+	}
+}
+
+// TestRestoreLimits ensures a restarted faucet rebuilds the time limits and
+// the budgets spent from the grants recorded before, leaving out the grants
+// that are too old to count.
+func TestRestoreLimits(t *testing.T) {
+	const oldClientIP = "198.51.100.1"
+
+	cfg := newTestConfig(t)
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.ChannelBudgetDaily = 1e8
+	cfg.PaymentBudgetDaily = 1e6
+
+	db, err := openFaucetDB(dbPath(cfg.DataDir))
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+
+	// The grants are recorded oldest first, as the faucet would have.
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	node := fakePubKey(0x01)
+	oldNode := fakePubKey(0x03)
+	dest := fakePubKey(0x02)
+	oldDest := fakePubKey(0x04)
+	grants := []func() error{
+		func() error {
+			return db.AddChannelGrant(&channelGrant{
+				NodePubKey:  oldNode,
+				ChannelSize: 5e6,
+				ClientIP:    oldClientIP,
+				Timestamp:   old,
+			})
+		},
+		func() error {
+			return db.AddInvoiceGrant(&invoiceGrant{
+				Amount:    1e5,
+				ClientIP:  oldClientIP,
+				Timestamp: old,
+			})
+		},
+		func() error {
+			return db.AddPaymentGrant(&paymentGrant{
+				Destination: oldDest,
+				Amount:      3e5,
+				ClientIP:    oldClientIP,
+				Timestamp:   old,
+			})
+		},
+		func() error {
+			return db.AddChannelGrant(&channelGrant{
+				NodePubKey:  node,
+				ChannelSize: 1e6,
+				PushAmount:  1e4,
+				ClientIP:    testClientIP,
+				Timestamp:   now,
+			})
+		},
+		func() error {
+			return db.AddInvoiceGrant(&invoiceGrant{
+				Amount:    1e5,
+				ClientIP:  testClientIP,
+				Timestamp: now,
+			})
+		},
+		func() error {
+			return db.AddPaymentGrant(&paymentGrant{
+				Destination: dest,
+				Amount:      500,
+				ClientIP:    testClientIP,
+				Timestamp:   now,
+			})
+		},
+	}
+	for _, addGrant := range grants {
+		if err := addGrant(); err != nil {
+			t.Fatalf("unable to add grant: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("unable to close database: %v", err)
+	}
+
+	faucet := startTestFaucet(t, cfg, newFakeBackend())
+	defer faucet.Stop()
+
+	limited := []struct {
+		action string
+		key    rateLimitKey
+	}{
+		{OpenChannelAction, rateLimitKey{clientIPKey, testClientIP}},
+		{OpenChannelAction, rateLimitKey{nodeKey, node}},
+		{GenerateInvoiceAction, rateLimitKey{clientIPKey, testClientIP}},
+		{PayInvoiceAction, rateLimitKey{clientIPKey, testClientIP}},
+		{PayInvoiceAction, rateLimitKey{destinationKey, dest}},
+	}
+	for _, l := range limited {
+		if err := faucet.limiter.check(l.action, l.key); err == nil {
+			t.Fatalf("%v of %v not limited after restart",
+				l.action, l.key)
+		}
+	}
+
+	allowed := []struct {
+		action string
+		key    rateLimitKey
+	}{
+		{OpenChannelAction, rateLimitKey{clientIPKey, oldClientIP}},
+		{OpenChannelAction, rateLimitKey{nodeKey, oldNode}},
+		{GenerateInvoiceAction, rateLimitKey{clientIPKey, oldClientIP}},
+		{PayInvoiceAction, rateLimitKey{clientIPKey, oldClientIP}},
+		{PayInvoiceAction, rateLimitKey{destinationKey, oldDest}},
+	}
+	for _, a := range allowed {
+		if err := faucet.limiter.check(a.action, a.key); err != nil {
+			t.Fatalf("%v of %v limited by an old grant: %v",
+				a.action, a.key, err)
+		}
+	}
+
+	// Only the grants made today count against the daily budgets.
+	wantSpent := map[budgetKind]int64{
+		channelBudget: 1e6,
+		paymentBudget: 500,
+	}
+	statuses := faucet.budget.status(time.Now(), channelBudget,
+		paymentBudget)
+	if len(statuses) != len(wantSpent) {
+		t.Fatalf("unexpected budgets: %+v", statuses)
+	}
+	for _, status := range statuses {
+		if status.Spent != wantSpent[status.Kind] {
+			t.Fatalf("unexpected %v budget spent: got %v, want %v",
+				status.Kind, status.Spent, wantSpent[status.Kind])
+		}
+	}
+}
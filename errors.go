@@ -15,11 +15,12 @@ const (
 	// yet been submitted or no errors have arisen.
 	NoError ChanCreationError = iota
 
-	// InvalidAddress indicates that the passed node address is invalid.
+	// InvalidAddress indicates that the passed node public key or address
+	// is invalid.
 	InvalidAddress
 
 	// NotConnected indicates that the target peer isn't connected to the
-	// faucet.
+	// faucet and no address was given to connect to it.
 	NotConnected
 
 	// ChanAmountNotNumber indicates that the amount specified for the
@@ -27,11 +28,11 @@ const (
 	ChanAmountNotNumber
 
 	// ChannelTooLarge indicates that the amounts specified to fund the
-	// channel with is greater than maxChannelSize.
+	// channel with is greater than the largest channel the policy allows.
 	ChannelTooLarge
 
 	// ChannelTooSmall indicates that the channel size required is below
-	// minChannelSize.
+	// the smallest channel the policy allows.
 	ChannelTooSmall
 
 	// PushIncorrect indicates that the amount specified to push to the
@@ -74,6 +75,50 @@ const (
 
 	// InternalServerError indicates that something has gone wrong on the server
 	InternalServerError
+
+	// CaptchaFailed indicates that the human verification challenge of
+	// the action was missing, expired or wrongly solved.
+	CaptchaFailed
+
+	// PoWFailed indicates that the proof of work required from API clients
+	// was missing, expired or didn't meet the difficulty of its challenge.
+	PoWFailed
+
+	// ChannelCapReached indicates that the faucet already has as many
+	// channels as it may have at any given time.
+	ChannelCapReached
+
+	// OpenQueueFull indicates that too many channel opens are already
+	// waiting to be performed.
+	OpenQueueFull
+
+	// PeerUnreachable indicates that the faucet couldn't reach the target
+	// peer at the given address.
+	PeerUnreachable
+
+	// PeerHandshakeFailed indicates that the faucet reached the target
+	// peer but failed to complete the handshake with it.
+	PeerHandshakeFailed
+
+	// PeerWrongNetwork indicates that the target peer runs on another
+	// network than the faucet.
+	PeerWrongNetwork
+
+	// OpenInProgress indicates that a channel with the target node is
+	// already being opened on behalf of another request.
+	OpenInProgress
+
+	// AmountTooSmall indicates that the amount of an invoice to generate
+	// or pay is below the smallest amount the policy allows.
+	AmountTooSmall
+
+	// BudgetExhausted indicates that the action would spend more than
+	// what is left of the budgets of the faucet until they reset.
+	BudgetExhausted
+
+	// WalletLowFunds indicates that funding the channel would dip the
+	// confirmed balance of the faucet's wallet below its reserve.
+	WalletLowFunds
 )
 
 // String returns a human readable string describing the chanCreationError.
@@ -84,15 +129,15 @@ func (c ChanCreationError) String() string {
 	case NoError:
 		return ""
 	case InvalidAddress:
-		return "Not a valid public key"
+		return "Not a valid public key or node address (pubkey@host:port)"
 	case NotConnected:
-		return "Faucet cannot connect to this node"
+		return "Faucet is not connected to this node, connect to the faucet or give the address of the node as pubkey@host:port"
 	case ChanAmountNotNumber:
 		return "Amount must be a number"
 	case ChannelTooLarge:
-		return "Amount is too large"
+		return "Amount is above the maximum channel size"
 	case ChannelTooSmall:
-		return fmt.Sprintf("Minimum channel size is is %d DCR", minChannelSize)
+		return "Amount is below the minimum channel size"
 	case PushIncorrect:
 		return "Initial Balance is incorrect"
 	case ChannelOpenFail:
@@ -110,13 +155,103 @@ func (c ChanCreationError) String() string {
 	case PaymentStreamError:
 		return "Error on payment request, try again"
 	case ErrorPaymentAmount:
-		return fmt.Sprintf("The amount of invoice exceeds the limit of %d Atoms", maxPaymentAtoms)
+		return "The amount of invoice exceeds the payment limit"
 	case TimeLimitError:
 		return "Action time limited. Please wait."
 	case InternalServerError:
 		return "An internal error has occurred."
+	case CaptchaFailed:
+		return "Human verification failed, please try again."
+	case PoWFailed:
+		return "Proof of work is missing or invalid."
+	case ChannelCapReached:
+		return "The faucet has reached its maximum number of channels, please try again later."
+	case OpenQueueFull:
+		return "The faucet is busy opening other channels, please try again later."
+	case PeerUnreachable:
+		return "Faucet cannot reach this node at the given address"
+	case PeerHandshakeFailed:
+		return "Faucet reached this node but the handshake with it failed"
+	case PeerWrongNetwork:
+		return "This node is running on another network than the faucet"
+	case OpenInProgress:
+		return "Faucet is already opening a channel with this node"
+	case AmountTooSmall:
+		return "Amount is below the minimum allowed"
+	case BudgetExhausted:
+		return "The faucet's budget is exhausted for this amount, please try again once it resets"
+	case WalletLowFunds:
+		return "The faucet is low on funds, please try a smaller channel or come back later"
 
 	default:
 		return fmt.Sprintf("%v", uint8(c))
 	}
 }
+
+// Code returns a stable machine readable identifier for the
+// ChanCreationError. Unlike the value of the enum itself or the string
+// returned by String, the code is part of the API and must not change once
+// released.
+func (c ChanCreationError) Code() string {
+	switch c {
+	case NoError:
+		return ""
+	case InvalidAddress:
+		return "invalid_address"
+	case NotConnected:
+		return "not_connected"
+	case ChanAmountNotNumber:
+		return "amount_not_number"
+	case ChannelTooLarge:
+		return "channel_too_large"
+	case ChannelTooSmall:
+		return "channel_too_small"
+	case PushIncorrect:
+		return "push_incorrect"
+	case ChannelOpenFail:
+		return "channel_open_fail"
+	case HaveChannel:
+		return "have_channel"
+	case HavePendingChannel:
+		return "have_pending_channel"
+	case ErrorGeneratingInvoice:
+		return "error_generating_invoice"
+	case InvoiceAmountTooHigh:
+		return "invoice_amount_too_high"
+	case ErrorDecodingPayReq:
+		return "error_decoding_pay_req"
+	case PaymentStreamError:
+		return "payment_stream_error"
+	case ErrorPaymentAmount:
+		return "payment_amount_too_high"
+	case TimeLimitError:
+		return "time_limit"
+	case InternalServerError:
+		return "internal_server_error"
+	case CaptchaFailed:
+		return "captcha_failed"
+	case PoWFailed:
+		return "pow_failed"
+	case ChannelCapReached:
+		return "channel_cap_reached"
+	case OpenQueueFull:
+		return "open_queue_full"
+	case PeerUnreachable:
+		return "peer_unreachable"
+	case PeerHandshakeFailed:
+		return "peer_handshake_failed"
+	case PeerWrongNetwork:
+		return "peer_wrong_network"
+	case OpenInProgress:
+		return "open_in_progress"
+	case AmountTooSmall:
+		return "amount_too_small"
+	case BudgetExhausted:
+		return "budget_exhausted"
+	case WalletLowFunds:
+		return "wallet_low_funds"
+
+	default:
+		return fmt.Sprintf("error_%d", uint8(c))
+	}
+}
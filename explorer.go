@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// explorerPlaceholder is replaced by the txid, address or block hash in the
+// URL templates of a block explorer.
+const explorerPlaceholder = "%s"
+
+// blockExplorer holds the URL templates of the pages of a block explorer. A
+// template that is empty means the explorer has no such page.
+type blockExplorer struct {
+	TxURL      string `json:"tx_url,omitempty"`
+	AddressURL string `json:"address_url,omitempty"`
+	BlockURL   string `json:"block_url,omitempty"`
+}
+
+// newBlockExplorer returns the block explorer of the active network with the
+// URL templates set in the passed config overriding its defaults. It returns
+// nil when links to an explorer are disabled or the network has none.
+func newBlockExplorer(cfg *config) (*blockExplorer, error) {
+	if cfg.DisableExplorer {
+		return nil, nil
+	}
+
+	explorer := activeNetParams.explorer
+	templates := []struct {
+		name     string
+		value    string
+		template *string
+	}{
+		{"explorer_tx_url", cfg.ExplorerTxURL, &explorer.TxURL},
+		{"explorer_address_url", cfg.ExplorerAddressURL, &explorer.AddressURL},
+		{"explorer_block_url", cfg.ExplorerBlockURL, &explorer.BlockURL},
+	}
+	for _, t := range templates {
+		if t.value == "" {
+			continue
+		}
+		if err := validateExplorerURL(t.value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", t.name, err)
+		}
+		*t.template = t.value
+	}
+
+	if explorer == (blockExplorer{}) {
+		return nil, nil
+	}
+	return &explorer, nil
+}
+
+// validateExplorerURL returns an error if the passed URL template doesn't
+// hold exactly one placeholder or isn't an http or https URL.
+func validateExplorerURL(template string) error {
+	if strings.Count(template, explorerPlaceholder) != 1 {
+		return fmt.Errorf("%q must contain %s exactly once", template,
+			explorerPlaceholder)
+	}
+
+	u, err := url.Parse(strings.Replace(template, explorerPlaceholder,
+		"x", 1))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", template)
+	}
+	return nil
+}
+
+// explorerLink fills the URL template with the passed value, or returns an
+// empty string when the explorer has no such page.
+func explorerLink(template, value string) string {
+	if template == "" || value == "" {
+		return ""
+	}
+	return strings.Replace(template, explorerPlaceholder,
+		url.PathEscape(value), 1)
+}
+
+// TxLink returns the URL of the page of the transaction with the given txid.
+func (e *blockExplorer) TxLink(txid string) string {
+	if e == nil {
+		return ""
+	}
+	return explorerLink(e.TxURL, txid)
+}
+
+// ChanPointLink returns the URL of the page of the funding transaction of the
+// channel with the given channel point.
+func (e *blockExplorer) ChanPointLink(chanPoint string) string {
+	txid := chanPoint
+	if i := strings.LastIndex(chanPoint, ":"); i > -1 {
+		txid = chanPoint[:i]
+	}
+	return e.TxLink(txid)
+}
+
+// AddressLink returns the URL of the page of the given address.
+func (e *blockExplorer) AddressLink(addr string) string {
+	if e == nil {
+		return ""
+	}
+	return explorerLink(e.AddressURL, addr)
+}
+
+// BlockLink returns the URL of the page of the block with the given hash.
+func (e *blockExplorer) BlockLink(hash string) string {
+	if e == nil {
+		return ""
+	}
+	return explorerLink(e.BlockURL, hash)
+}
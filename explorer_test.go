@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestNewBlockExplorer ensures the explorer of the active network can be
+// overridden or disabled by the config.
+func TestNewBlockExplorer(t *testing.T) {
+	defer func(p *params) { activeNetParams = p }(activeNetParams)
+
+	tests := []struct {
+		name    string
+		net     *params
+		cfg     config
+		want    *blockExplorer
+		wantErr bool
+	}{{
+		name: "testnet default",
+		net:  &decredTestNet3Params,
+		want: &decredTestNet3Params.explorer,
+	}, {
+		name: "mainnet default",
+		net:  &decredMainNetParams,
+		want: &decredMainNetParams.explorer,
+	}, {
+		name: "simnet has no explorer",
+		net:  &decredSimNetParams,
+	}, {
+		name: "simnet override",
+		net:  &decredSimNetParams,
+		cfg: config{
+			ExplorerTxURL: "http://localhost:7777/tx/%s",
+		},
+		want: &blockExplorer{TxURL: "http://localhost:7777/tx/%s"},
+	}, {
+		name: "partial override",
+		net:  &decredTestNet3Params,
+		cfg: config{
+			ExplorerBlockURL: "https://example.com/block/%s",
+		},
+		want: &blockExplorer{
+			TxURL:      decredTestNet3Params.explorer.TxURL,
+			AddressURL: decredTestNet3Params.explorer.AddressURL,
+			BlockURL:   "https://example.com/block/%s",
+		},
+	}, {
+		name: "disabled",
+		net:  &decredTestNet3Params,
+		cfg: config{
+			DisableExplorer: true,
+			ExplorerTxURL:   "https://example.com/tx/%s",
+		},
+	}, {
+		name:    "missing placeholder",
+		net:     &decredTestNet3Params,
+		cfg:     config{ExplorerTxURL: "https://example.com/tx/"},
+		wantErr: true,
+	}, {
+		name:    "repeated placeholder",
+		net:     &decredTestNet3Params,
+		cfg:     config{ExplorerTxURL: "https://example.com/%s/%s"},
+		wantErr: true,
+	}, {
+		name:    "not http",
+		net:     &decredTestNet3Params,
+		cfg:     config{ExplorerAddressURL: "javascript:alert(%s)"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		activeNetParams = test.net
+		explorer, err := newBlockExplorer(&test.cfg)
+		if test.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		switch {
+		case test.want == nil && explorer != nil:
+			t.Fatalf("%s: unexpected explorer: %+v", test.name,
+				explorer)
+		case test.want != nil && (explorer == nil || *explorer != *test.want):
+			t.Fatalf("%s: got explorer %+v, want %+v", test.name,
+				explorer, test.want)
+		}
+	}
+}
+
+// TestBlockExplorerLinks ensures the links of an explorer fill its URL
+// templates, and that missing pages or explorers yield no link.
+func TestBlockExplorerLinks(t *testing.T) {
+	explorer := &blockExplorer{
+		TxURL:    "https://example.com/tx/%s",
+		BlockURL: "https://example.com/block/%s?full=1",
+	}
+	txid := "8b7a3e1d9f2c3b4a5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4"
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"tx", explorer.TxLink(txid), "https://example.com/tx/" + txid},
+		{"chan point", explorer.ChanPointLink(txid + ":1"),
+			"https://example.com/tx/" + txid},
+		{"block", explorer.BlockLink("00ff"),
+			"https://example.com/block/00ff?full=1"},
+		{"escaped", explorer.TxLink("a/b"), "https://example.com/tx/a%2Fb"},
+		{"no address page", explorer.AddressLink("TsXXX"), ""},
+		{"no value", explorer.TxLink(""), ""},
+		{"no explorer", (*blockExplorer)(nil).TxLink(txid), ""},
+		{"no explorer chan point",
+			(*blockExplorer)(nil).ChanPointLink(txid + ":0"), ""},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Fatalf("%s: got %q, want %q", test.name, test.got,
+				test.want)
+		}
+	}
+}
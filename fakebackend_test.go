@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// fakeBackend is a deterministic in-memory lightningBackend used to exercise
+// the faucet without a running dcrlnd node. Each field may be modified by the
+// tests before the fake is handed to the faucet.
+type fakeBackend struct {
+	mtx sync.Mutex
+
+	info     *lnrpc.GetInfoResponse
+	nodes    map[string]*lnrpc.NodeInfo
+	channels []*lnrpc.Channel
+	pending  *lnrpc.PendingChannelsResponse
+	peers    []*lnrpc.Peer
+	balance  *lnrpc.WalletBalanceResponse
+
+	// utxos are the outputs returned by ListUnspent. When nil, the
+	// confirmed balance is returned as a single output.
+	utxos []*lnrpc.Utxo
+
+	// payReqs maps the payment requests known by the fake to their
+	// decoded form.
+	payReqs map[string]*lnrpc.PayReq
+
+	// payments holds every payment returned by ListPayments.
+	payments []*lnrpc.Payment
+
+	// invoices holds every invoice added through AddInvoice.
+	invoices []*lnrpc.Invoice
+
+	// closed holds the channel points of every closed channel.
+	closed []*lnrpc.ChannelPoint
+
+	// reachable holds the nodes ConnectPeer is able to connect to.
+	reachable map[string]bool
+
+	// txConfs maps the txids of the funding transactions reported by
+	// GetTransactions to their number of confirmations.
+	txConfs map[string]int32
+
+	// eventSubs holds the functions called with every channel event,
+	// keyed by subscription.
+	eventSubs    map[int]func(*lnrpc.ChannelEventUpdate)
+	numEventSubs int
+
+	// numTxs is used to generate deterministic txids.
+	numTxs byte
+
+	// openChannelGate, when set, holds OpenChannel back until it is
+	// closed.
+	openChannelGate chan struct{}
+
+	// paymentFee is the routing fee in atoms of every payment.
+	paymentFee int64
+
+	// Errors returned by the corresponding calls when set.
+	errGetInfo      error
+	errOpenChannel  error
+	errCloseChannel error
+	errConnectPeer  error
+	errAddInvoice   error
+	errPayment      error
+	errListUnspent  error
+}
+
+// A compile-time assertion to ensure fakeBackend meets the lightningBackend
+// interface.
+var _ lightningBackend = (*fakeBackend)(nil)
+
+// errFakeUnknown is returned when the fake is queried for unknown data.
+var errFakeUnknown = errors.New("unknown to the fake backend")
+
+// newFakeBackend returns a fake backend for a synced testnet node with a
+// funded wallet and no channels or peers.
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		info: &lnrpc.GetInfoResponse{
+			IdentityPubkey: fakePubKey(0xfa),
+			Alias:          "fakenode",
+			Version:        "0.0.0-fake",
+			SyncedToChain:  true,
+			SyncedToGraph:  true,
+			BlockHeight:    100,
+			Chains: []*lnrpc.Chain{{
+				Chain:   "decred",
+				Network: "testnet",
+			}},
+			Uris: []string{fakePubKey(0xfa) + "@127.0.0.1:9735"},
+		},
+		nodes:   make(map[string]*lnrpc.NodeInfo),
+		pending: &lnrpc.PendingChannelsResponse{},
+		balance: &lnrpc.WalletBalanceResponse{
+			TotalBalance:     100e8,
+			ConfirmedBalance: 100e8,
+		},
+		payReqs:   make(map[string]*lnrpc.PayReq),
+		reachable: make(map[string]bool),
+		txConfs:   make(map[string]int32),
+		eventSubs: make(map[int]func(*lnrpc.ChannelEventUpdate)),
+	}
+}
+
+// fakePubKey returns a hex encoded compressed public key filled with b.
+func fakePubKey(b byte) string {
+	return "02" + strings.Repeat(fmt.Sprintf("%02x", b), 32)
+}
+
+// nextTxid returns a new deterministic txid.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (f *fakeBackend) nextTxid() chainhash.Hash {
+	f.numTxs++
+	var txid chainhash.Hash
+	for i := range txid {
+		txid[i] = f.numTxs
+	}
+	return txid
+}
+
+// addPeer registers a connected peer with the given public key.
+func (f *fakeBackend) addPeer(pubKey string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.peers = append(f.peers, &lnrpc.Peer{
+		PubKey:  pubKey,
+		Address: "127.0.0.1:9735",
+	})
+}
+
+// addChannel registers an active channel with the given public key.
+func (f *fakeBackend) addChannel(pubKey string, capacity int64) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	txid := f.nextTxid()
+	f.channels = append(f.channels, &lnrpc.Channel{
+		Active:        true,
+		RemotePubkey:  pubKey,
+		ChannelPoint:  fmt.Sprintf("%v:0", txid),
+		ChanId:        uint64(f.numTxs),
+		Capacity:      capacity,
+		LocalBalance:  capacity,
+		RemoteBalance: 0,
+		Initiator:     true,
+	})
+}
+
+// activateChannel moves the pending channel with the given channel point to
+// the set of open channels, as if its funding transaction confirmed.
+func (f *fakeBackend) activateChannel(chanPoint string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for i, pending := range f.pending.PendingOpenChannels {
+		channel := pending.Channel
+		if channel.ChannelPoint != chanPoint {
+			continue
+		}
+
+		f.pending.PendingOpenChannels = append(
+			f.pending.PendingOpenChannels[:i],
+			f.pending.PendingOpenChannels[i+1:]...)
+		f.numTxs++
+		open := &lnrpc.Channel{
+			Active:        true,
+			RemotePubkey:  channel.RemoteNodePub,
+			ChannelPoint:  channel.ChannelPoint,
+			ChanId:        uint64(f.numTxs),
+			Capacity:      channel.Capacity,
+			LocalBalance:  channel.LocalBalance,
+			RemoteBalance: channel.RemoteBalance,
+			Initiator:     true,
+		}
+		f.channels = append(f.channels, open)
+
+		update := &lnrpc.ChannelEventUpdate{
+			Type: lnrpc.ChannelEventUpdate_OPEN_CHANNEL,
+			Channel: &lnrpc.ChannelEventUpdate_OpenChannel{
+				OpenChannel: open,
+			},
+		}
+		for _, fn := range f.eventSubs {
+			fn(update)
+		}
+		return
+	}
+}
+
+// confirmTx sets the number of confirmations of the transaction reported by
+// GetTransactions with the given txid.
+func (f *fakeBackend) confirmTx(txid string, numConfs int32) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.txConfs[txid] = numConfs
+}
+
+// addPayReq registers a payment request that can be decoded and paid.
+func (f *fakeBackend) addPayReq(payReq, dest string, amt int64) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.payReqs[payReq] = &lnrpc.PayReq{
+		Destination: dest,
+		PaymentHash: fmt.Sprintf("%064x", len(f.payReqs)+1),
+		NumAtoms:    amt,
+		Description: "fake invoice",
+	}
+}
+
+// addPayment registers a payment of a payment request previously registered
+// with addPayReq, in the given state.
+func (f *fakeBackend) addPayment(payReq string,
+	status lnrpc.Payment_PaymentStatus) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	decoded := f.payReqs[payReq]
+	f.payments = append(f.payments, &lnrpc.Payment{
+		PaymentHash:    decoded.PaymentHash,
+		ValueAtoms:     decoded.NumAtoms,
+		Fee:            f.paymentFee,
+		PaymentRequest: payReq,
+		Status:         status,
+	})
+}
+
+// GetInfo returns general information about the node.
+func (f *fakeBackend) GetInfo(ctx context.Context) (*lnrpc.GetInfoResponse, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errGetInfo != nil {
+		return nil, f.errGetInfo
+	}
+
+	info := *f.info
+	info.NumActiveChannels = uint32(len(f.channels))
+	info.NumPendingChannels = uint32(len(f.pending.PendingOpenChannels))
+	info.NumPeers = uint32(len(f.peers))
+	return &info, nil
+}
+
+// NodeInfo returns the announcement information of the node with the given
+// public key.
+func (f *fakeBackend) NodeInfo(ctx context.Context,
+	pubKey string) (*lnrpc.NodeInfo, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	node, ok := f.nodes[pubKey]
+	if !ok {
+		return nil, errFakeUnknown
+	}
+	return node, nil
+}
+
+// ListChannels returns all of the node's open channels.
+func (f *fakeBackend) ListChannels(ctx context.Context) ([]*lnrpc.Channel, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return append([]*lnrpc.Channel(nil), f.channels...), nil
+}
+
+// PendingChannels returns all of the node's pending channels.
+func (f *fakeBackend) PendingChannels(
+	ctx context.Context) (*lnrpc.PendingChannelsResponse, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	pending := *f.pending
+	return &pending, nil
+}
+
+// OpenChannel registers a new pending channel with the target node.
+func (f *fakeBackend) OpenChannel(ctx context.Context,
+	req *lnrpc.OpenChannelRequest) (*wire.OutPoint, error) {
+
+	f.mtx.Lock()
+	gate := f.openChannelGate
+	f.mtx.Unlock()
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errOpenChannel != nil {
+		return nil, f.errOpenChannel
+	}
+
+	txid := f.nextTxid()
+	fundingPoint := &wire.OutPoint{Hash: txid}
+	f.pending.PendingOpenChannels = append(f.pending.PendingOpenChannels,
+		&lnrpc.PendingChannelsResponse_PendingOpenChannel{
+			Channel: &lnrpc.PendingChannelsResponse_PendingChannel{
+				RemoteNodePub: fmt.Sprintf("%x", req.NodePubkey),
+				ChannelPoint:  fundingPoint.String(),
+				Capacity:      req.LocalFundingAmount,
+				LocalBalance:  req.LocalFundingAmount - req.PushAtoms,
+				RemoteBalance: req.PushAtoms,
+			},
+		})
+	f.balance.ConfirmedBalance -= req.LocalFundingAmount
+	f.balance.TotalBalance -= req.LocalFundingAmount
+
+	return fundingPoint, nil
+}
+
+// CloseChannel removes the channel from the set of open channels. Inactive
+// channels can only be force closed.
+func (f *fakeBackend) CloseChannel(ctx context.Context,
+	chanPoint *lnrpc.ChannelPoint, force bool) (*chainhash.Hash, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errCloseChannel != nil {
+		return nil, f.errCloseChannel
+	}
+
+	txid, err := chainhash.NewHash(chanPoint.GetFundingTxidBytes())
+	if err != nil {
+		return nil, err
+	}
+	strPoint := fmt.Sprintf("%v:%d", txid, chanPoint.OutputIndex)
+
+	for i, channel := range f.channels {
+		if channel.ChannelPoint != strPoint {
+			continue
+		}
+
+		// A cooperative close requires the peer to be online.
+		if !force && !channel.Active {
+			return nil, errors.New("peer is offline")
+		}
+
+		f.channels = append(f.channels[:i], f.channels[i+1:]...)
+		f.closed = append(f.closed, chanPoint)
+		f.pending.WaitingCloseChannels = append(
+			f.pending.WaitingCloseChannels,
+			&lnrpc.PendingChannelsResponse_WaitingCloseChannel{
+				Channel: &lnrpc.PendingChannelsResponse_PendingChannel{
+					RemoteNodePub: channel.RemotePubkey,
+					ChannelPoint:  channel.ChannelPoint,
+					Capacity:      channel.Capacity,
+					LocalBalance:  channel.LocalBalance,
+				},
+				LimboBalance: channel.LocalBalance,
+			},
+		)
+		f.pending.TotalLimboBalance += channel.LocalBalance
+		closingTxid := f.nextTxid()
+		return &closingTxid, nil
+	}
+
+	return nil, errFakeUnknown
+}
+
+// AddInvoice adds a new invoice to the node.
+func (f *fakeBackend) AddInvoice(ctx context.Context,
+	invoice *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errAddInvoice != nil {
+		return nil, f.errAddInvoice
+	}
+
+	f.invoices = append(f.invoices, invoice)
+	addIndex := uint64(len(f.invoices))
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          []byte{byte(addIndex)},
+		PaymentRequest: fmt.Sprintf("lntdcr%dfake", addIndex),
+		AddIndex:       addIndex,
+	}, nil
+}
+
+// DecodePayReq decodes the passed payment request.
+func (f *fakeBackend) DecodePayReq(ctx context.Context,
+	payReq string) (*lnrpc.PayReq, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	decoded, ok := f.payReqs[payReq]
+	if !ok {
+		return nil, errFakeUnknown
+	}
+	return decoded, nil
+}
+
+// SendPayment pays a payment request previously registered with addPayReq
+// through a single hop route.
+func (f *fakeBackend) SendPayment(ctx context.Context,
+	req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errPayment != nil {
+		return nil, f.errPayment
+	}
+
+	decoded, ok := f.payReqs[req.PaymentRequest]
+	if !ok {
+		return &lnrpc.SendResponse{
+			PaymentError: "unable to find a path to destination",
+		}, nil
+	}
+
+	f.payments = append(f.payments, &lnrpc.Payment{
+		PaymentHash:     decoded.PaymentHash,
+		ValueAtoms:      decoded.NumAtoms,
+		Fee:             f.paymentFee,
+		PaymentPreimage: "0102",
+		PaymentRequest:  req.PaymentRequest,
+		Status:          lnrpc.Payment_SUCCEEDED,
+	})
+
+	return &lnrpc.SendResponse{
+		PaymentPreimage: []byte{0x01, 0x02},
+		PaymentHash:     []byte{0x03, 0x04},
+		PaymentRoute: &lnrpc.Route{
+			TotalAmt:  req.Amt + f.paymentFee,
+			TotalFees: f.paymentFee,
+			Hops: []*lnrpc.Hop{{
+				ChanId: 1,
+				PubKey: decoded.Destination,
+			}},
+		},
+	}, nil
+}
+
+// ListPayments returns the payments sent through SendPayment or registered
+// with addPayment.
+func (f *fakeBackend) ListPayments(
+	ctx context.Context) ([]*lnrpc.Payment, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return append([]*lnrpc.Payment(nil), f.payments...), nil
+}
+
+// ListPeers returns the peers the node is currently connected to.
+func (f *fakeBackend) ListPeers(ctx context.Context) ([]*lnrpc.Peer, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return append([]*lnrpc.Peer(nil), f.peers...), nil
+}
+
+// WalletBalance returns the balance of the node's on-chain wallet.
+func (f *fakeBackend) WalletBalance(
+	ctx context.Context) (*lnrpc.WalletBalanceResponse, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	balance := *f.balance
+	return &balance, nil
+}
+
+// ListUnspent returns the unspent outputs of the node's on-chain wallet.
+func (f *fakeBackend) ListUnspent(ctx context.Context) ([]*lnrpc.Utxo, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errListUnspent != nil {
+		return nil, f.errListUnspent
+	}
+	if f.utxos != nil {
+		return append([]*lnrpc.Utxo(nil), f.utxos...), nil
+	}
+	return []*lnrpc.Utxo{{
+		AmountAtoms:   f.balance.ConfirmedBalance,
+		Confirmations: 6,
+	}}, nil
+}
+
+// ConnectPeer connects to the node if it is reachable, which also activates
+// its channels.
+func (f *fakeBackend) ConnectPeer(ctx context.Context, pubKey,
+	host string) error {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errConnectPeer != nil {
+		return f.errConnectPeer
+	}
+	if !f.reachable[pubKey] {
+		return fmt.Errorf("dial tcp %v: connect: connection refused",
+			host)
+	}
+
+	f.peers = append(f.peers, &lnrpc.Peer{
+		PubKey:  pubKey,
+		Address: host,
+	})
+	for _, channel := range f.channels {
+		if channel.RemotePubkey == pubKey {
+			channel.Active = true
+		}
+	}
+	return nil
+}
+
+// GetTransactions returns the transactions whose confirmations were set with
+// confirmTx.
+func (f *fakeBackend) GetTransactions(
+	ctx context.Context) ([]*lnrpc.Transaction, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	txs := make([]*lnrpc.Transaction, 0, len(f.txConfs))
+	for txid, numConfs := range f.txConfs {
+		txs = append(txs, &lnrpc.Transaction{
+			TxHash:           txid,
+			NumConfirmations: numConfs,
+		})
+	}
+	return txs, nil
+}
+
+// SubscribeChannelEvents calls fn for every channel activated with
+// activateChannel until the context is canceled.
+func (f *fakeBackend) SubscribeChannelEvents(ctx context.Context,
+	fn func(*lnrpc.ChannelEventUpdate)) error {
+
+	f.mtx.Lock()
+	id := f.numEventSubs
+	f.numEventSubs++
+	f.eventSubs[id] = fn
+	f.mtx.Unlock()
+
+	<-ctx.Done()
+
+	f.mtx.Lock()
+	delete(f.eventSubs, id)
+	f.mtx.Unlock()
+
+	return ctx.Err()
+}
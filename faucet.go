@@ -1,11 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"html/template"
-	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"os/user"
@@ -15,30 +14,12 @@ import (
 	"sync"
 	"time"
 
-	macaroon "gopkg.in/macaroon.v2"
-
 	"github.com/davecgh/go-spew/spew"
 	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/dcrutil/v2"
 	"github.com/decred/dcrd/wire"
 	"github.com/decred/dcrlnd/lnrpc"
-	"github.com/decred/dcrlnd/macaroons"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-)
-
-const (
-	// maxChannelSize is the larget channel that the faucet will create to
-	// another peer.
-	maxChannelSize int64 = (1 << 30)
-
-	// minChannelSize is the smallest channel that the faucet will extend
-	// to a peer.
-	minChannelSize int64 = 50000
-
-	// maxPaymentAtoms is the larget payment amount in atoms that the faucet
-	// will pay to an invoice
-	maxPaymentAtoms int64 = 1000
+	"github.com/gorilla/mux"
 )
 
 var (
@@ -50,11 +31,6 @@ var (
 
 	// OpenChannelAction represents an action to open channel on post forms
 	OpenChannelAction = "openchannel"
-
-	// requestIPs stores the last time an ip did an action,
-	// and is protected by a mutex that must be held for reads/writes.
-	rateLimitMtx sync.RWMutex
-	requestIPs   map[string]time.Time
 )
 
 // lightningFaucet is a Decred Channel Faucet. The faucet itself is a web app
@@ -63,15 +39,104 @@ var (
 // connection to a local lnd node in order to operate properly. The faucet
 // implements the constrains on the channel size, and also will only open a
 // single channel to a particular node. Finally, the faucet will periodically
-// close channels based on their age as the faucet will only open up 100
-// channels total at any given time.
+// close the channels older than the configured maximum channel age, and once
+// it has max_channels channels it recycles the oldest or least used ones to
+// make room for new ones.
 type lightningFaucet struct {
-	lnd lnrpc.LightningClient
+	lnd lightningBackend
 
 	templates *template.Template
 
-	openChannels map[wire.OutPoint]time.Time
-	cfg          *config
+	// db records every grant made by the faucet.
+	db *faucetDB
+
+	// limiter enforces the rate limits of every action.
+	limiter *rateLimiter
+
+	// clients maps client addresses to their rate limit keys.
+	clients *clientIdentifier
+
+	// captcha is the human verification required by the actions in
+	// captchaActions. It is nil when no verification is required.
+	captcha        captchaProvider
+	captchaActions map[string]bool
+
+	// pow issues the proof of work challenges required from API clients.
+	// It is nil when no proof of work is required.
+	pow *powIssuer
+
+	// trustedProxies are the reverse proxies whose forwarded headers are
+	// used to find the address of the clients. It is empty unless
+	// userealip is set.
+	trustedProxies proxyList
+
+	// peerAddrs decides which addresses the nodes of clients may be
+	// connected at.
+	peerAddrs *peerAddrFilter
+
+	// admin authenticates the requests made to the admin area. It is nil
+	// when no admin credentials are configured.
+	admin *adminAuth
+
+	// disabledActions holds the actions that are currently disabled. They
+	// start out as configured and may be toggled at runtime through the
+	// admin API.
+	actionsMtx      sync.RWMutex
+	disabledActions map[string]bool
+
+	// openChannels tracks the time at which each channel opened by the
+	// faucet was created, and is protected by openChannelsMtx.
+	openChannelsMtx sync.Mutex
+	openChannels    map[wire.OutPoint]time.Time
+
+	// sweeper finds and closes the zombie channels of the node.
+	sweeper *zombieSweeper
+
+	// recycleTrigger wakes up the channel recycler.
+	recycleTrigger chan struct{}
+
+	// closer closes channels cooperatively first, escalating to force
+	// closes once their grace period is over.
+	closer *closeManager
+
+	// limbo follows the channels pending close until their funds are
+	// back in the wallet.
+	limbo *limboTracker
+
+	// opener holds the channel opens waiting for a worker along with the
+	// status of the recent ones.
+	opener *openQueue
+
+	// explorer links transactions, addresses and blocks to the pages of a
+	// block explorer. It is nil when those links are disabled.
+	explorer *blockExplorer
+
+	// policy holds the limits of the actions of every class of clients.
+	policy *policyEngine
+
+	// budget limits the funds spent by the faucet within each hour and
+	// day.
+	budget *budgetTracker
+
+	// paymentLookup is the interval between two lookups of the status of
+	// a payment whose outcome is unknown.
+	paymentLookup time.Duration
+
+	// funds keeps the channels opened by the faucet within the confirmed
+	// funds of its wallet.
+	funds *walletFunds
+
+	// alerts notifies the operator of the health of the faucet. It is nil
+	// when no webhook is configured.
+	alerts *alertManager
+
+	// metrics records the activity of the faucet for Prometheus.
+	metrics *faucetMetrics
+
+	cfg *config
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 
 	//Network info
 	network string
@@ -102,179 +167,283 @@ func cleanAndExpandPath(path string) string {
 	return filepath.Clean(os.ExpandEnv(path))
 }
 
-// verifyTimeLimit will return an error if the given IP address
-// has already performed an action within the given time limit.
-func verifyTimeLimit(ip string, actionsTimeLimit time.Duration) error {
-	rateLimitMtx.RLock()
-	lastRequestTime, found := requestIPs[ip]
-	rateLimitMtx.RUnlock()
-	if found {
-		nextAllowedRequest := lastRequestTime.Add(actionsTimeLimit)
-		coolDownTime := time.Until(nextAllowedRequest)
-
-		if coolDownTime >= 0 {
-			err := fmt.Errorf("client(%v) may only do an action every "+
-				"%v. Wait another %v.",
-				ip, actionsTimeLimit, coolDownTime)
-			return err
-		}
+// getChainInfo makes a request to get information about dcrlnd chain.
+func getChainInfo(lnd lightningBackend) (*lnrpc.Chain, error) {
+	info, err := lnd.GetInfo(ctxb)
+	if err != nil {
+		return nil, fmt.Errorf("get info: %v", err)
+	}
+	if len(info.Chains) == 0 {
+		return nil, fmt.Errorf("get info: node reported no chains")
 	}
-	return nil
+
+	return info.Chains[0], nil
 }
 
-// getRealIP returns the clients IP address. If the useRealIP config is set
-// it will use the X-Real-IP or X-Forwarded-For HTTP headers, otherwise it will
-// return the request.RemoteAddr field.
-//
-// You should only use this option if you have correctly configured
-// your reverse proxies. If you do not use a reverse proxy or are configured
-// to pass on the clients headers without any filters, malicious clients
-// may inject fake IPs.
-func getRealIP(r *http.Request, useRealIP bool) (string, error) {
-	if useRealIP {
-		xForwardFor := http.CanonicalHeaderKey("X-Forwarded-For")
-		xRealIP := http.CanonicalHeaderKey("X-Real-IP")
-
-		if xrip := r.Header.Get(xRealIP); xrip != "" {
-			return xrip, nil
-		} else if xff := r.Header.Get(xForwardFor); xff != "" {
-			i := strings.Index(xff, ", ")
-			if i == -1 {
-				i = len(xff)
-			}
-			return xff[:i], nil
-		}
-		log.Warn(`"X-Forwarded-For" and "X-Real-IP" headers invalid, ` +
-			`using RemoteAddr instead`)
-	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+// newLightningFaucet creates a new channel faucet that's bound to the passed
+// lnd backend, and uses the passed templates to render the web page.
+func newLightningFaucet(cfg *config, templates *template.Template,
+	lnd lightningBackend) (*lightningFaucet, error) {
+
+	// Every call to dcrlnd is measured.
+	metrics := newFaucetMetrics()
+	lnd = &instrumentedBackend{backend: lnd, metrics: metrics}
+
+	// Get chain info to stop creation if the dcrlnd and dcrlnfaucet
+	// are set in different networks.
+	chain, err := getChainInfo(lnd)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	netParams := normalizeNetwork(activeNetParams.Name)
+	if chain.Network != netParams {
+		return nil, fmt.Errorf(
+			"dcrlnd and dcrlnfaucet are set in different "+
+				"networks <dcrlnd: %v / dcrlnfaucet: %v>",
+			chain.Network, netParams)
 	}
-	return host, nil
-}
 
-// getChainInfo makes a request to get information about dcrlnd chain.
-func getChainInfo(l lnrpc.LightningClient) (*lnrpc.Chain, error) {
-	infoReq := &lnrpc.GetInfoRequest{}
-	info, err := l.GetInfo(ctxb, infoReq)
+	db, err := openFaucetDB(dbPath(cfg.DataDir))
 	if err != nil {
-		return nil, fmt.Errorf("get info: %v", err)
+		return nil, err
 	}
 
-	return info.Chains[0], nil
-}
+	// Load the channels opened by the faucet in previous runs so that we
+	// can tell them apart from any other channel of the node.
+	openChannels := make(map[wire.OutPoint]time.Time)
+	err = db.ForEachChannelGrant(func(g *channelGrant) bool {
+		op, err := strPointToOutPoint(g.ChannelPoint)
+		if err != nil {
+			log.Warnf("invalid channel point in grant: %v", err)
+			return true
+		}
+		if _, ok := openChannels[*op]; !ok {
+			openChannels[*op] = g.Timestamp
+		}
+		return true
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to load channel grants: %v", err)
+	}
 
-// newLightningFaucet creates a new channel faucet that's bound to a cluster of
-// lnd nodes, and uses the passed templates to render the web page.
-func newLightningFaucet(cfg *config,
-	templates *template.Template) (*lightningFaucet, error) {
+	var trustedProxies proxyList
+	if cfg.UseRealIP {
+		trustedProxies, err = parseTrustedProxies(cfg.TrustedProxies)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 
-	// First attempt to establish a connection to lnd's RPC sever.
-	tlsCertPath := cleanAndExpandPath(cfg.TLSCertPath)
-	creds, err := credentials.NewClientTLSFromFile(tlsCertPath, "")
+	peerAddrs, err := newPeerAddrFilter(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read cert file: %v", err)
+		db.Close()
+		return nil, err
 	}
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
 
-	// Load the specified macaroon file.
-	macPath := cleanAndExpandPath(cfg.MacaroonPath)
-	macBytes, err := ioutil.ReadFile(macPath)
+	captcha, err := newCaptchaProvider(cfg)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	mac := &macaroon.Macaroon{}
-	if err = mac.UnmarshalBinary(macBytes); err != nil {
+	captchaActions := make(map[string]bool)
+	for _, action := range cfg.CaptchaActions {
+		captchaActions[action] = true
+	}
+
+	pow, err := newPoWIssuer(cfg)
+	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	// Now we append the macaroon credentials to the dial options.
-	opts = append(
-		opts,
-		grpc.WithPerRPCCredentials(macaroons.NewMacaroonCredential(mac)),
-	)
+	admin, err := newAdminAuth(cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	conn, err := grpc.Dial(cfg.LndNode, opts...)
+	explorer, err := newBlockExplorer(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("unable to dial to lnd's gRPC server: %v", err)
+		db.Close()
+		return nil, err
 	}
 
-	// If we're able to connect out to the lnd node, then we can start up
-	// the faucet safely.
-	lnd := lnrpc.NewLightningClient(conn)
+	closer, err := newCloseManager(cfg, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	// Get chain info to stop creation if the dcrlnd and dcrlnfaucet
-	// are set in different networks.
-	chain, err := getChainInfo(lnd)
+	policy, err := newPolicyEngine(cfg, netParams)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	netParams := normalizeNetwork(activeNetParams.Name)
-	if chain.Network != netParams {
-		return nil, fmt.Errorf(
-			"dcrlnd and dcrlnfaucet are set in different "+
-				"networks <dcrlnd: %v / dcrlnfaucet: %v>",
-			chain.Network, netParams)
+
+	alerts, err := newAlertManager(cfg, chain.Network)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
+	limiter := newRateLimiter(map[string]time.Duration{
+		OpenChannelAction:     cfg.OpenChannelTimeLimit,
+		GenerateInvoiceAction: cfg.GenerateInvoiceTimeLimit,
+		PayInvoiceAction:      cfg.PayInvoiceTimeLimit,
+	}, cfg.RateLimitBurst, cfg.RateLimitMaxKeys)
+	limiter.setBurst(subnetKey, cfg.SubnetBurst)
+
 	return &lightningFaucet{
-		lnd:       lnd,
-		templates: templates,
-		cfg:       cfg,
-		network:   chain.Network,
+		lnd:            lnd,
+		templates:      templates,
+		db:             db,
+		limiter:        limiter,
+		clients:        newClientIdentifier(cfg),
+		trustedProxies: trustedProxies,
+		peerAddrs:      peerAddrs,
+		captcha:        captcha,
+		captchaActions: captchaActions,
+		pow:            pow,
+		admin:          admin,
+		openChannels:   openChannels,
+		disabledActions: map[string]bool{
+			GenerateInvoiceAction: cfg.DisableGenerateInvoices,
+			PayInvoiceAction:      cfg.DisablePayInvoices,
+		},
+		sweeper:        newZombieSweeper(cfg),
+		recycleTrigger: make(chan struct{}, 1),
+		closer:         closer,
+		limbo:          newLimboTracker(),
+		opener:         newOpenQueue(cfg),
+		explorer:       explorer,
+		policy:         policy,
+		budget:         newBudgetTracker(cfg),
+		paymentLookup:  defaultPaymentLookupInterval,
+		funds:          newWalletFunds(cfg, lnd),
+		alerts:         alerts,
+		metrics:        metrics,
+		cfg:            cfg,
+		quit:           make(chan struct{}),
+		network:        chain.Network,
 	}, nil
 }
 
 // Start launches all the goroutines necessary for routine operation of the
 // lightning faucet.
 func (l *lightningFaucet) Start(cfg *config) {
-	requestIPs = make(map[string]time.Time)
+	// Restore the time of the last action of every client that is still
+	// within the time limit so that restarting the faucet doesn't reset
+	// the limits.
+	if err := l.restoreRequestTimes(); err != nil {
+		log.Errorf("unable to restore client request times: %v", err)
+	}
+	if err := l.restoreBudgets(); err != nil {
+		log.Errorf("unable to restore spent budgets: %v", err)
+	}
+	l.limiter.Start()
+
+	l.wg.Add(1)
+	go l.closeManagerLoop()
+
+	l.wg.Add(1)
+	go l.policyReloader()
+
+	l.wg.Add(1)
+	go l.limboWatcher()
+
+	if err := l.restoreInflightOpens(); err != nil {
+		log.Errorf("unable to restore channel opens in progress: %v",
+			err)
+	}
+	for i := 0; i < l.opener.numWorkers; i++ {
+		l.wg.Add(1)
+		go l.openJobWorker()
+	}
+	l.wg.Add(2)
+	go l.openJobWatcher()
+	go l.channelEventWatcher()
 
 	if !cfg.DisableZombieSweeper {
+		l.wg.Add(1)
 		go l.zombieChanSweeper()
 	}
-}
 
-// zombieChanSweeper is a goroutine that is tasked with cleaning up "zombie"
-// channels. A zombie channel is a channel in which the peer we have the
-// channel open with hasn't been online for greater than 48 hours. We'll
-// periodically perform a sweep every hour to close out any lingering zombie
-// channels.
-//
-// NOTE: This MUST be run as a goroutine.
-func (l *lightningFaucet) zombieChanSweeper() {
-	log.Info("zombie chan sweeper active")
+	if l.recyclerEnabled() {
+		l.wg.Add(1)
+		go l.channelRecycler()
+	}
 
-	// Any channel peer that hasn't been online in more than 48 hours past
-	// from now will have their channels closed out.
-	timeCutOff := time.Now().Add(-time.Hour * 48)
+	if l.alerts != nil {
+		l.wg.Add(1)
+		go l.alertWatcher()
+	}
+}
 
-	// Upon initial boot, we'll do a scan to close out any channels that
-	// are now considered zombies while we were down.
-	l.sweepZombieChans(timeCutOff)
+// Stop releases the resources held by the faucet.
+func (l *lightningFaucet) Stop() {
+	close(l.quit)
+	l.wg.Wait()
 
-	// Every hour we'll consume a new tick and perform a sweep to close out
-	// any zombies channels.
-	zombieTicker := time.NewTicker(time.Hour * 1)
-	for range zombieTicker.C {
-		log.Info("Performing zombie channel sweep!")
+	l.limiter.Stop()
+	if err := l.db.Close(); err != nil {
+		log.Errorf("unable to close database: %v", err)
+	}
+}
 
-		// In order to ensure we close out the proper channels, we also
-		// calculate the 48 hour offset from the point of our next
-		// tick.
-		timeCutOff = time.Now().Add(-time.Hour * 48)
+// restoreRequestTimes seeds the rate limiter with the grants recorded within
+// the time limit of each action, so that restarting the faucet doesn't reset
+// the limits.
+func (l *lightningFaucet) restoreRequestTimes() error {
+	now := time.Now()
+	withinLimit := func(action string, timestamp time.Time) bool {
+		return now.Sub(timestamp) <= l.limiter.window(action)
+	}
 
-		// With the time cut off calculated, we'll force close any
-		// channels that are now considered "zombies".
-		l.sweepZombieChans(timeCutOff)
+	err := l.db.ForEachChannelGrant(func(g *channelGrant) bool {
+		if !withinLimit(OpenChannelAction, g.Timestamp) {
+			return false
+		}
+		keys := append(l.clients.keys(g.ClientIP),
+			rateLimitKey{nodeKey, g.NodePubKey})
+		l.limiter.record(OpenChannelAction, g.Timestamp, keys...)
+		return true
+	})
+	if err != nil {
+		return err
 	}
+
+	err = l.db.ForEachInvoiceGrant(func(g *invoiceGrant) bool {
+		if !withinLimit(GenerateInvoiceAction, g.Timestamp) {
+			return false
+		}
+		l.limiter.record(GenerateInvoiceAction, g.Timestamp,
+			l.clients.keys(g.ClientIP)...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.db.ForEachPaymentGrant(func(g *paymentGrant) bool {
+		if !withinLimit(PayInvoiceAction, g.Timestamp) {
+			return false
+		}
+		keys := append(l.clients.keys(g.ClientIP),
+			rateLimitKey{destinationKey, g.Destination})
+		l.limiter.record(PayInvoiceAction, g.Timestamp, keys...)
+		return true
+	})
 }
 
 // strPointToChanPoint concerts a string outpoint (txid:index) into an lnrpc
 // ChannelPoint object.
 func strPointToChanPoint(stringPoint string) (*lnrpc.ChannelPoint, error) {
 	s := strings.Split(stringPoint, ":")
+	if len(s) != 2 {
+		return nil, fmt.Errorf("invalid channel point %q", stringPoint)
+	}
 
 	txid, err := chainhash.NewHashFromStr(s[0])
 	if err != nil {
@@ -294,90 +463,58 @@ func strPointToChanPoint(stringPoint string) (*lnrpc.ChannelPoint, error) {
 	}, nil
 }
 
-// sweepZombieChans performs a sweep of the set of channels that the faucet has
-// active to close out any channels that are now considered to be a "zombie". A
-// channel is a zombie if the peer with have the channel open is currently
-// offline, and we haven't detected them as being online since timeCutOff.
-//
-// TODO(roasbeef): after removing the node ANN on startup, will need to rely on
-// LinkNode information.
-func (l *lightningFaucet) sweepZombieChans(timeCutOff time.Time) {
-	// Fetch all the facuet's currently open channels.
-	openChanReq := &lnrpc.ListChannelsRequest{}
-	openChannels, err := l.lnd.ListChannels(ctxb, openChanReq)
+// strPointToOutPoint converts a string outpoint (txid:index) into a
+// wire.OutPoint.
+func strPointToOutPoint(stringPoint string) (*wire.OutPoint, error) {
+	chanPoint, err := strPointToChanPoint(stringPoint)
 	if err != nil {
-		log.Errorf("unable to fetch open channels: %v", err)
-		return
+		return nil, err
 	}
 
-	for _, channel := range openChannels.Channels {
-		// For each channel we'll first fetch the announcement
-		// information for the peer that we have the channel open with.
-		nodeInfoResp, err := l.lnd.GetNodeInfo(ctxb,
-			&lnrpc.NodeInfoRequest{
-				PubKey: channel.RemotePubkey,
-			})
-		if err != nil {
-			log.Errorf("unable to get node pubkey: %v", err)
-			continue
-		}
-
-		// Convert the unix time stamp into a time.Time object.
-		lastSeen := time.Unix(int64(nodeInfoResp.Node.LastUpdate), 0)
-
-		// If the last time we saw this peer online was _before_ our
-		// time cutoff, and the peer isn't currently online, then we'll
-		// force close out the channel.
-		if lastSeen.Before(timeCutOff) && !channel.Active {
-			log.Infof("ChannelPoint(%v) is a zombie, last seen: %v",
-				channel.ChannelPoint, lastSeen)
-
-			chanPoint, err := strPointToChanPoint(channel.ChannelPoint)
-			if err != nil {
-				log.Errorf("unable to get chan point: %v", err)
-				continue
-			}
-			txid, err := l.closeChannel(chanPoint, true)
-			if err != nil {
-				log.Errorf("unable to close zombie chan: %v", err)
-				continue
-			}
-
-			log.Infof("closed zombie chan, txid: %v", txid)
-		}
+	txid, err := chainhash.NewHash(chanPoint.GetFundingTxidBytes())
+	if err != nil {
+		return nil, err
 	}
+
+	return &wire.OutPoint{
+		Hash:  *txid,
+		Index: chanPoint.OutputIndex,
+	}, nil
 }
 
-// closeChannel closes out a target channel optionally executing a force close.
-// This function will block until the closing transaction has been broadcast.
+// closeChannel closes out a target channel optionally executing a force close,
+// recording the reason of the close. This function will block until the
+// closing transaction has been broadcast.
 func (l *lightningFaucet) closeChannel(chanPoint *lnrpc.ChannelPoint,
-	force bool) (*chainhash.Hash, error) {
+	force bool, reason closeReason) (*chainhash.Hash, error) {
 
-	closeReq := &lnrpc.CloseChannelRequest{
-		ChannelPoint: chanPoint,
-		Force:        force,
-	}
-	stream, err := l.lnd.CloseChannel(ctxb, closeReq)
+	closingTxid, err := l.lnd.CloseChannel(ctxb, chanPoint, force)
 	if err != nil {
-		log.Errorf("unable to start channel close: %v", err)
+		return nil, err
 	}
 
-	// Consume the first response which'll be sent once the closing
-	// transaction has been broadcast.
-	resp, err := stream.Recv()
+	err = l.db.AddChannelClose(&channelClose{
+		ChannelPoint: chanPointString(chanPoint),
+		Reason:       reason,
+		Force:        force,
+		ClosingTxid:  closingTxid.String(),
+		Timestamp:    time.Now(),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to close chan: %v", err)
+		log.Errorf("unable to record channel close: %v", err)
 	}
 
-	update, ok := resp.Update.(*lnrpc.CloseStatusUpdate_ClosePending)
-	if !ok {
-		return nil, fmt.Errorf("didn't get a pending update")
-	}
+	return closingTxid, nil
+}
 
-	// Convert the raw bytes into a new chainhash so we gain access to its
-	// utility methods.
-	closingHash := update.ClosePending.Txid
-	return chainhash.NewHash(closingHash)
+// chanPointString returns the txid:index form of an lnrpc ChannelPoint.
+func chanPointString(chanPoint *lnrpc.ChannelPoint) string {
+	txid, err := chainhash.NewHash(chanPoint.GetFundingTxidBytes())
+	if err != nil {
+		return fmt.Sprintf("%x:%d", chanPoint.GetFundingTxidBytes(),
+			chanPoint.OutputIndex)
+	}
+	return fmt.Sprintf("%v:%d", txid, chanPoint.OutputIndex)
 }
 
 // homePageContext defines the initial context required for rendering home
@@ -397,6 +534,10 @@ type homePageContext struct {
 	// for channel creation.
 	NumCoins float64
 
+	// ConfirmedBalance is the confirmed balance of the faucet's wallet in
+	// atoms.
+	ConfirmedBalance int64
+
 	// GitCommitHash is the git HEAD's commit hash of
 	// $GOPATH/src/github.com/decred/dcrlnd
 	GitCommitHash string
@@ -409,15 +550,14 @@ type homePageContext struct {
 	// the creation of a channel.
 	SubmissionError ChanCreationError
 
+	// OpenJob is the channel open whose progress is shown, if any.
+	OpenJob *openJob
+
 	// ChannelTxid is the txid of the created funding channel. If this
 	// field is an empty string, then that indicates the channel hasn't yet
 	// been created.
 	ChannelTxid string
 
-	// NumConfs is the number of confirmations required for the channel to
-	// open up.
-	NumConfs uint32
-
 	// FormFields contains the values which were submitted through the form.
 	FormFields map[string]string
 
@@ -442,6 +582,20 @@ type homePageContext struct {
 	// GenerateInvoiceAction indicates the form action to generate a new Invoice
 	GenerateInvoiceAction string
 
+	// Disable open channel form
+	DisableOpenChannels bool
+
+	// MaxChannels is the number of channels the faucet may have at any
+	// given time, or zero if unlimited.
+	MaxChannels int
+
+	// RecentCloses holds the channels most recently closed by the faucet.
+	RecentCloses []*channelClose
+
+	// Limbo holds the channels pending close along with the funds they
+	// hold until they are back in the wallet.
+	Limbo *limboSummary
+
 	// Disable generate invoices form
 	DisableGenerateInvoices bool
 
@@ -456,41 +610,67 @@ type homePageContext struct {
 	PaymentPreimage    string
 	PaymentHops        []*lnrpc.Hop
 
+	// Captchas holds the human verification challenge of every form that
+	// requires one, keyed by action.
+	Captchas map[string]*captchaChallenge
+
 	// Network info
 	Network string
+
+	// Explorer links to the pages of a block explorer, and is nil when
+	// those links are disabled.
+	Explorer *blockExplorer
+
+	// Limits are the limits of the actions of the client viewing the
+	// page.
+	Limits policyLimits
+
+	// ChannelBudgets and PaymentBudgets are the state of the budgets
+	// limiting the funds spent on channels and payments.
+	ChannelBudgets []budgetStatus
+	PaymentBudgets []budgetStatus
+
+	// OpenChannelsPausedUntil and PayInvoicesPausedUntil are the times at
+	// which the actions paused by an exhausted budget resume, and are
+	// zero while the actions aren't paused.
+	OpenChannelsPausedUntil time.Time
+	PayInvoicesPausedUntil  time.Time
+
+	// MaxFundableCoins is the largest channel, in DCR, the wallet is
+	// currently able to fund. It is only set when a channel was refused
+	// because the faucet is low on funds and a smaller one is possible.
+	MaxFundableCoins string
 }
 
 // fetchHomeState is helper functions that populates the homePageContext with
 // the latest state from the local lnd node.
-func (l *lightningFaucet) fetchHomeState() (*homePageContext, error) {
+func (l *lightningFaucet) fetchHomeState(ctx context.Context) (*homePageContext, error) {
 	// First query for the general information from the lnd node, this'll
 	// be used to populate the number of active channel as well as the
 	// identity of the node.
-	infoReq := &lnrpc.GetInfoRequest{}
-	nodeInfo, err := l.lnd.GetInfo(ctxb, infoReq)
+	nodeInfo, err := l.lnd.GetInfo(ctx)
 	if err != nil {
 		log.Errorf("rpc GetInfoRequest failed: %v", err)
 		return nil, err
 	}
 
-	activeChanReq := &lnrpc.ListChannelsRequest{}
-	activeChannels, err := l.lnd.ListChannels(ctxb, activeChanReq)
+	activeChannels, err := l.lnd.ListChannels(ctx)
 	if err != nil {
 		log.Errorf("rpc ListChannels failed: %v", err)
 		return nil, err
 	}
 
-	pendingChanReq := &lnrpc.PendingChannelsRequest{}
-	pendingChannels, err := l.lnd.PendingChannels(ctxb, pendingChanReq)
+	pendingAt := time.Now()
+	pendingChannels, err := l.lnd.PendingChannels(ctx)
 	if err != nil {
 		log.Errorf("rpc PendingChannels failed: %v", err)
 		return nil, err
 	}
+	l.limbo.observe(pendingAt, pendingChannels)
 
 	// Next obtain the wallet's available balance which indicates how much
 	// we can allocate towards channels.
-	balReq := &lnrpc.WalletBalanceRequest{}
-	walletBalance, err := l.lnd.WalletBalance(ctxb, balReq)
+	walletBalance, err := l.lnd.WalletBalance(ctx)
 	if err != nil {
 		log.Errorf("rpc WalletBalance failed: %v", err)
 		return nil, err
@@ -516,21 +696,105 @@ func (l *lightningFaucet) fetchHomeState() (*homePageContext, error) {
 		FaucetVersion:           Version(),
 		FaucetCommit:            SourceCommit(),
 		NumCoins:                dcrutil.Amount(walletBalance.ConfirmedBalance).ToCoin(),
+		ConfirmedBalance:        walletBalance.ConfirmedBalance,
 		GitCommitHash:           strings.Replace(gitHash, "'", "", -1),
 		NodeAddr:                nodeAddr,
-		NumConfs:                6,
 		FormFields:              make(map[string]string),
-		ActiveChannels:          activeChannels.Channels,
+		ActiveChannels:          activeChannels,
 		PendingChannels:         pendingChannels.PendingOpenChannels,
+		Limbo:                   l.limbo.summary(),
 		OpenChannelAction:       OpenChannelAction,
 		GenerateInvoiceAction:   GenerateInvoiceAction,
 		PayInvoiceAction:        PayInvoiceAction,
-		DisableGenerateInvoices: l.cfg.DisableGenerateInvoices,
-		DisablePayInvoices:      l.cfg.DisablePayInvoices,
+		DisableOpenChannels:     l.actionDisabled(OpenChannelAction),
+		MaxChannels:             l.cfg.MaxChannels,
+		DisableGenerateInvoices: l.actionDisabled(GenerateInvoiceAction),
+		DisablePayInvoices:      l.actionDisabled(PayInvoiceAction),
 		Network:                 l.network,
+		Explorer:                l.explorer,
 	}, nil
 }
 
+// actionDisabled returns whether the action is currently disabled.
+func (l *lightningFaucet) actionDisabled(action string) bool {
+	l.actionsMtx.RLock()
+	defer l.actionsMtx.RUnlock()
+
+	return l.disabledActions[action]
+}
+
+// setActionDisabled enables or disables the action.
+func (l *lightningFaucet) setActionDisabled(action string, disabled bool) {
+	l.actionsMtx.Lock()
+	defer l.actionsMtx.Unlock()
+
+	l.disabledActions[action] = disabled
+}
+
+// addCaptchas adds a new challenge to the home state for every passed action
+// that requires human verification.
+func (l *lightningFaucet) addCaptchas(homeState *homePageContext,
+	actions ...string) {
+
+	for _, action := range actions {
+		if !l.captchaRequired(action) {
+			continue
+		}
+
+		challenge, err := l.captcha.newChallenge()
+		if err != nil {
+			log.Errorf("unable to create captcha challenge: %v", err)
+			continue
+		}
+		if homeState.Captchas == nil {
+			homeState.Captchas = make(map[string]*captchaChallenge)
+		}
+		homeState.Captchas[action] = challenge
+	}
+}
+
+// captchaRequired returns whether the action requires human verification.
+func (l *lightningFaucet) captchaRequired(action string) bool {
+	return l.captcha != nil && l.captchaActions[action]
+}
+
+// checkCaptcha verifies the solution to the captcha of the action submitted
+// by the client at clientIP, if the action requires one.
+func (l *lightningFaucet) checkCaptcha(ctx context.Context, action string,
+	sol *captchaSolution, clientIP string) ChanCreationError {
+
+	if !l.captchaRequired(action) {
+		return NoError
+	}
+
+	if err := l.captcha.verify(ctx, sol, clientIP); err != nil {
+		log.Debugf("Captcha of %v from %v rejected: %v", action,
+			clientIP, err)
+		return CaptchaFailed
+	}
+
+	return NoError
+}
+
+// checkFormCaptcha verifies the captcha submitted along with the form of the
+// action, flagging the challenge rendered in its place on failure.
+func (l *lightningFaucet) checkFormCaptcha(r *http.Request,
+	homeState *homePageContext, action, clientIP string) ChanCreationError {
+
+	if !l.captchaRequired(action) {
+		return NoError
+	}
+
+	chanErr := l.checkCaptcha(r.Context(), action,
+		l.captcha.formSolution(r), clientIP)
+	if chanErr != NoError {
+		if challenge := homeState.Captchas[action]; challenge != nil {
+			challenge.Failed = true
+		}
+	}
+	return chanErr
+}
+
 // faucetHome renders the main home page for the faucet. This includes the form
 // to create channels, the network statistics, and the splash page upon channel
 // success.
@@ -544,12 +808,14 @@ func (l *lightningFaucet) faucetHome(w http.ResponseWriter, r *http.Request) {
 	// In order to render the home template we'll need the necessary
 	// context, so we'll grab that from the lnd daemon now in order to get
 	// the most up to date state.
-	homeInfo, err := l.fetchHomeState()
+	homeInfo, err := l.fetchHomeState(r.Context())
 	if err != nil {
 		log.Error("unable to fetch home state")
 		http.Error(w, "unable to render home page", http.StatusInternalServerError)
 		return
 	}
+	l.addCaptchas(homeInfo, OpenChannelAction)
+	l.addLimits(homeInfo, r)
 
 	// If the method is GET, then we'll render the home page with the form
 	// itself.
@@ -584,7 +850,7 @@ func (l *lightningFaucet) infoPage(w http.ResponseWriter, r *http.Request) {
 	// In order to render the info template we'll need the necessary
 	// context, so we'll grab that from the lnd daemon now in order to get
 	// the most up to date state.
-	homeInfo, err := l.fetchHomeState()
+	homeInfo, err := l.fetchHomeState(r.Context())
 	if err != nil {
 		log.Error("unable to fetch info state")
 		http.Error(w, "unable to render info page", http.StatusInternalServerError)
@@ -598,6 +864,11 @@ func (l *lightningFaucet) infoPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	homeInfo.RecentCloses, err = l.recentCloses(numRecentCloses)
+	if err != nil {
+		log.Errorf("unable to fetch channel closes: %v", err)
+	}
+
 	infoTemplate.Execute(w, homeInfo)
 }
 
@@ -612,12 +883,20 @@ func (l *lightningFaucet) toolsPage(w http.ResponseWriter, r *http.Request) {
 	// In order to render the tool template we'll need the necessary
 	// context, so we'll grab that from the lnd daemon now in order to get
 	// the most up to date state.
-	homeInfo, err := l.fetchHomeState()
+	homeInfo, err := l.fetchHomeState(r.Context())
 	if err != nil {
 		log.Error("unable to fetch info state")
 		http.Error(w, "unable to render info page", http.StatusInternalServerError)
 		return
 	}
+	l.addCaptchas(homeInfo, GenerateInvoiceAction, PayInvoiceAction)
+	l.addLimits(homeInfo, r)
+
+	// The tools page doesn't exist while both of its actions are disabled.
+	if homeInfo.DisableGenerateInvoices && homeInfo.DisablePayInvoices {
+		http.NotFound(w, r)
+		return
+	}
 
 	// If the method is GET, then we'll render the tools page with the form
 	// itself.
@@ -646,9 +925,12 @@ func (l *lightningFaucet) toolsPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (l *lightningFaucet) pendingChannelExistsWithNode(nodePub string) bool {
-	pendingChanReq := &lnrpc.PendingChannelsRequest{}
-	resp, err := l.lnd.PendingChannels(ctxb, pendingChanReq)
+// pendingChannelExistsWithNode returns true if the faucet already has a
+// pending channel with the target node, and false otherwise.
+func (l *lightningFaucet) pendingChannelExistsWithNode(ctx context.Context,
+	nodePub string) bool {
+
+	resp, err := l.lnd.PendingChannels(ctx)
 	if err != nil {
 		return false
 	}
@@ -662,16 +944,17 @@ func (l *lightningFaucet) pendingChannelExistsWithNode(nodePub string) bool {
 	return false
 }
 
-// activeCahannelExistsWithNode return true if the faucet already has a channel open
+// channelExistsWithNode return true if the faucet already has a channel open
 // with the target node, and false otherwise.
-func (l *lightningFaucet) channelExistsWithNode(nodePub string) bool {
-	listChanReq := &lnrpc.ListChannelsRequest{}
-	resp, err := l.lnd.ListChannels(ctxb, listChanReq)
+func (l *lightningFaucet) channelExistsWithNode(ctx context.Context,
+	nodePub string) bool {
+
+	channels, err := l.lnd.ListChannels(ctx)
 	if err != nil {
 		return false
 	}
 
-	for _, channel := range resp.Channels {
+	for _, channel := range channels {
 		if channel.RemotePubkey == nodePub {
 			return true
 		}
@@ -682,14 +965,15 @@ func (l *lightningFaucet) channelExistsWithNode(nodePub string) bool {
 
 // connectedToNode returns true if the faucet is connected to the node, and
 // false otherwise.
-func (l *lightningFaucet) connectedToNode(nodePub string) bool {
-	peersReq := &lnrpc.ListPeersRequest{}
-	resp, err := l.lnd.ListPeers(ctxb, peersReq)
+func (l *lightningFaucet) connectedToNode(ctx context.Context,
+	nodePub string) bool {
+
+	peers, err := l.lnd.ListPeers(ctx)
 	if err != nil {
 		return false
 	}
 
-	for _, peer := range resp.Peers {
+	for _, peer := range peers {
 		if peer.PubKey == nodePub {
 			return true
 		}
@@ -703,96 +987,211 @@ func (l *lightningFaucet) connectedToNode(nodePub string) bool {
 // channels if all the parameters check out.
 func (l *lightningFaucet) openChannel(homeTemplate *template.Template,
 	homeState *homePageContext, w http.ResponseWriter, r *http.Request) {
+
+	// Disable open channel if the operator turned it off.
+	if l.actionDisabled(OpenChannelAction) {
+		http.Error(w, "open channels was disabled", 403)
+		return
+	}
+
 	// Before we can obtain the values the user entered in the form, we
-	// need to parse all parameters.  First attempt to establish a
-	// connection with the
+	// need to parse all parameters.
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "unable to parse form", 500)
 		return
 	}
 
-	nodePubStr := r.FormValue("node")
+	node := r.FormValue("node")
 	amt := r.FormValue("amt")
 	bal := r.FormValue("bal")
 
-	homeState.FormFields["Node"] = nodePubStr
+	homeState.FormFields["Node"] = node
 	homeState.FormFields["Amt"] = amt
 	homeState.FormFields["Bal"] = bal
 
-	// With the forms details parsed, extract out the public key of the
-	// target peer.
-	nodePub, err := hex.DecodeString(nodePubStr)
+	// Parse out the amount fields, exiting early if any of them isn't a
+	// number.
+	chanSizeFloat, err := strconv.ParseFloat(amt, 64)
 	if err != nil {
-		homeState.SubmissionError = InvalidAddress
+		homeState.SubmissionError = ChanAmountNotNumber
 		homeTemplate.Execute(w, homeState)
 		return
 	}
-
-	// If we already have a channel with this peer, then we'll fail the
-	// request as we have a policy of only one channel per node.
-	if l.channelExistsWithNode(nodePubStr) {
-		homeState.SubmissionError = HaveChannel
+	pushAmtFloat, err := strconv.ParseFloat(bal, 64)
+	if err != nil {
+		homeState.SubmissionError = PushIncorrect
 		homeTemplate.Execute(w, homeState)
 		return
 	}
 
-	// If we already have a channel with this peer, then we'll fail the
-	// request as we have a policy of only one channel per node.
-	if l.pendingChannelExistsWithNode(nodePubStr) {
-		homeState.SubmissionError = HavePendingChannel
+	// Convert from input (dcr) to api (atoms) units.
+	chanSize := int64(chanSizeFloat * 1e8)
+	pushAmt := int64(pushAmtFloat * 1e8)
+
+	clientIP, err := getRealIP(r, l.trustedProxies)
+	if err != nil {
+		log.Errorf("Can't get client ip: %v", err)
+		homeState.SubmissionError = InternalServerError
 		homeTemplate.Execute(w, homeState)
 		return
 	}
 
-	// If we're not connected to the node, then we won't be able to extend
-	// a channel to them. So we'll exit early with an error here.
-	if !l.connectedToNode(nodePubStr) {
-		homeState.SubmissionError = NotConnected
+	chanErr := l.checkFormCaptcha(r, homeState, OpenChannelAction, clientIP)
+	if chanErr != NoError {
+		homeState.SubmissionError = chanErr
 		homeTemplate.Execute(w, homeState)
 		return
 	}
 
-	// With the connection established (or already present) with the target
-	// peer, we'll now parse out the rest of the fields, performing
-	// validation and exiting early if any field is invalid.
-	chanSizeFloat, err := strconv.ParseFloat(amt, 64)
-	if err != nil {
-		homeState.SubmissionError = ChanAmountNotNumber
+	class := l.policy.classify(r, clientIP)
+	job, chanErr := l.queueChannel(r.Context(), clientIP, class, node,
+		chanSize, pushAmt)
+	if chanErr != NoError {
+		if chanErr == WalletLowFunds {
+			maxSize := l.maxFundableChannel(r.Context(), class)
+			if maxSize > 0 {
+				homeState.MaxFundableCoins = formatCoins(maxSize)
+			}
+		}
+		homeState.SubmissionError = chanErr
 		homeTemplate.Execute(w, homeState)
 		return
 	}
-	pushAmtFloat, err := strconv.ParseFloat(bal, 64)
+
+	// The channel is opened in the background, so redirect the client to
+	// the page following its progress.
+	http.Redirect(w, r, openJobPath(job.ID), http.StatusSeeOther)
+}
+
+// openJobPath returns the path of the page following the progress of the
+// channel open with the given job ID.
+func openJobPath(id string) string {
+	return "/channels/" + id
+}
+
+// openJobPage renders the home page showing the progress of the channel open
+// whose job ID is given in the path.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) openJobPage(w http.ResponseWriter, r *http.Request) {
+	homeTemplate := l.templates.Lookup("index.html")
+
+	homeInfo, err := l.fetchHomeState(r.Context())
 	if err != nil {
-		homeState.SubmissionError = PushIncorrect
-		homeTemplate.Execute(w, homeState)
+		log.Error("unable to fetch home state")
+		http.Error(w, "unable to render home page", http.StatusInternalServerError)
 		return
 	}
 
-	// Convert from input (dcr) to api (atoms) units.
-	chanSize := int64(chanSizeFloat * 1e8)
-	pushAmt := int64(pushAmtFloat * 1e8)
-
-	// With the initial validation complete, we'll now ensure the channel
-	// size and push amt meet our constraints.
-	switch {
-	// The target channel can't be below the constant min channel size.
-	case chanSize < minChannelSize:
-		homeState.SubmissionError = ChannelTooSmall
-		homeTemplate.Execute(w, homeState)
+	job := l.lookupOpenJob(r.Context(), mux.Vars(r)["id"])
+	if job == nil {
+		http.NotFound(w, r)
 		return
+	}
+	homeInfo.OpenJob = job
+	homeInfo.ChannelTxid = job.FundingTxid
 
-	// The target channel can't be above the max channel size.
-	case chanSize > maxChannelSize:
-		homeState.SubmissionError = ChannelTooLarge
-		homeTemplate.Execute(w, homeState)
-		return
+	if err := homeTemplate.Execute(w, homeInfo); err != nil {
+		log.Errorf("unable to render home page: %v", err)
+	}
+}
+
+// checkChannelRequest validates the parameters of a channel creation request
+// made by the client at clientIP, of the given class, against the policy and
+// the state of the faucet. The target node and nodeHost are as parsed by
+// parseNodeAddr. Nodes given without their address must already be connected
+// to the faucet, while the others are only connected to when the channel is
+// opened. The time limits are enforced by the caller.
+func (l *lightningFaucet) checkChannelRequest(ctx context.Context,
+	clientIP string, class clientClass, nodePubStr, nodeHost string,
+	chanSize, pushAmt int64) ChanCreationError {
+
+	// If we already have a channel with this peer, then we'll fail the
+	// request as we have a policy of only one channel per node.
+	if l.channelExistsWithNode(ctx, nodePubStr) {
+		return HaveChannel
+	}
+
+	// If we already have a channel with this peer, then we'll fail the
+	// request as we have a policy of only one channel per node.
+	if l.pendingChannelExistsWithNode(ctx, nodePubStr) {
+		return HavePendingChannel
+	}
+
+	// Refuse new channels once the faucet has as many as it may have. The
+	// recycler is woken up to free a slot for later requests.
+	capReached, err := l.channelCapReached(ctx)
+	if err != nil {
+		log.Errorf("unable to check channel cap: %v", err)
+		return InternalServerError
+	}
+	if capReached {
+		l.triggerRecycle()
+		return ChannelCapReached
+	}
+
+	// Ensure the channel size and push amt meet our constraints before
+	// reaching out to the target peer. The channel size must be within
+	// the limits of the class of the client.
+	if chanErr := l.policy.checkAmount(class, OpenChannelAction,
+		chanSize); chanErr != NoError {
+
+		return chanErr
+	}
 
 	// The amount pushed to the other side as part of the channel creation
 	// MUST be less than the size of the channel itself.
-	case pushAmt >= chanSize:
-		homeState.SubmissionError = PushIncorrect
-		homeTemplate.Execute(w, homeState)
-		return
+	if pushAmt < 0 || pushAmt >= chanSize {
+		return PushIncorrect
+	}
+
+	// The channel must fit in what is left of the budgets. They are only
+	// spent once the open is queued.
+	chanErr := l.budget.check(time.Now(), budgetSpend{
+		Channels: chanSize,
+		Pushed:   pushAmt,
+	})
+	if chanErr != NoError {
+		return chanErr
+	}
+
+	// The wallet must be able to fund the channel while keeping its
+	// reserve.
+	if chanErr := l.funds.check(ctx, chanSize); chanErr != NoError {
+		return chanErr
+	}
+
+	// If we're not connected to the node, then we won't be able to extend
+	// a channel to them. Connecting to them may take up to the connect
+	// timeout, so it is left to the worker opening the channel when they
+	// gave us their address, and we exit early with an error otherwise.
+	if nodeHost == "" && !l.connectedToNode(ctx, nodePubStr) {
+		return NotConnected
+	}
+
+	return NoError
+}
+
+// fundChannel opens a channel of chanSize atoms with the target node on behalf
+// of the client at clientIP, pushing pushAmt atoms to it, and records the
+// grant. The funding outpoint is returned once the funding transaction has
+// been broadcast. The request must have been validated by checkChannelRequest
+// and queued by queueChannel, which reserved its rate limit tokens and
+// budgets.
+func (l *lightningFaucet) fundChannel(ctx context.Context, clientIP,
+	nodePubStr string, chanSize, pushAmt int64) (*wire.OutPoint,
+	ChanCreationError) {
+
+	nodePub, err := hex.DecodeString(nodePubStr)
+	if err != nil {
+		return nil, InvalidAddress
+	}
+
+	// The funds of the wallet may have been spent since the request was
+	// checked, so set the channel aside from them until the funding
+	// transaction is broadcast, after which the balance reflects it.
+	if chanErr := l.funds.reserve(ctx, chanSize); chanErr != NoError {
+		return nil, chanErr
 	}
 
 	// If we were able to connect to the peer successfully, and all the
@@ -806,70 +1205,48 @@ func (l *lightningFaucet) openChannel(homeTemplate *template.Template,
 	log.Infof("attempting to create channel with params: %v",
 		spew.Sdump(openChanReq))
 
-	openChanStream, err := l.lnd.OpenChannel(ctxb, openChanReq)
+	fundingPoint, err := l.lnd.OpenChannel(ctx, openChanReq)
+	l.funds.release(chanSize)
 	if err != nil {
-		log.Errorf("Opening channel stream failed: %v", err)
-		homeState.SubmissionError = ChannelOpenFail
-		homeTemplate.Execute(w, homeState)
-		return
-	}
+		log.Errorf("Opening channel failed: %v", err)
 
-	// Consume the first update from the open channel stream which
-	// indicates that the channel has been broadcast to the network.
-	chanUpdate, err := openChanStream.Recv()
-	if err != nil {
-		log.Errorf("Channel update failed: %v", err)
-		homeState.SubmissionError = ChannelOpenFail
-		homeTemplate.Execute(w, homeState)
-		return
+		// Peers running on another network are only found out once
+		// they refuse the chain of the channel.
+		if wrongNetworkError(strings.ToLower(err.Error())) {
+			return nil, PeerWrongNetwork
+		}
+		return nil, ChannelOpenFail
 	}
 
-	pendingUpdate := chanUpdate.Update.(*lnrpc.OpenStatusUpdate_ChanPending).ChanPending
-	fundingTXID, _ := chainhash.NewHash(pendingUpdate.Txid)
-
-	log.Infof("channel created with txid: %v", fundingTXID)
+	log.Infof("channel created with txid: %v", fundingPoint.Hash)
 
-	homeState.ChannelTxid = fundingTXID.String()
-	if err := homeTemplate.Execute(w, homeState); err != nil {
-		log.Errorf("unable to render home page: %v", err)
+	now := time.Now()
+	if l.pow != nil {
+		l.pow.observe(now)
 	}
-}
 
-// CloseAllChannels attempt unconditionally close ALL of the faucet's currently
-// open channels. In the case that a channel is active a cooperative closure
-// will be executed, in the case that a channel is inactive, a force close will
-// be attempted.
-func (l *lightningFaucet) CloseAllChannels() error {
-	openChanReq := &lnrpc.ListChannelsRequest{}
-	openChannels, err := l.lnd.ListChannels(ctxb, openChanReq)
+	l.openChannelsMtx.Lock()
+	l.openChannels[*fundingPoint] = now
+	l.openChannelsMtx.Unlock()
+
+	err = l.db.AddChannelGrant(&channelGrant{
+		NodePubKey:   nodePubStr,
+		ChannelSize:  chanSize,
+		PushAmount:   pushAmt,
+		ChannelPoint: fundingPoint.String(),
+		ClientIP:     clientIP,
+		Timestamp:    now,
+	})
 	if err != nil {
-		return fmt.Errorf("unable to fetch open channels: %v", err)
+		log.Errorf("unable to record channel grant: %v", err)
 	}
 
-	for _, channel := range openChannels.Channels {
-		log.Infof("Attempting to close channel: %s", channel.ChannelPoint)
-
-		chanPoint, err := strPointToChanPoint(channel.ChannelPoint)
-		if err != nil {
-			log.Errorf("unable to get chan point: %v", err)
-			continue
-		}
-
-		forceClose := !channel.Active
-		if forceClose {
-			log.Info("Attempting force close")
-		}
-
-		closeTxid, err := l.closeChannel(chanPoint, forceClose)
-		if err != nil {
-			log.Errorf("unable to close channel: %v", err)
-			continue
-		}
-
-		log.Infof("closing txid: %v", closeTxid)
+	// Make room for the next channel if this one filled the cap.
+	if l.cfg.MaxChannels > 0 {
+		l.triggerRecycle()
 	}
 
-	return nil
+	return fundingPoint, NoError
 }
 
 // generateInvoice is a hybrid http.Handler that handles: the validation of the
@@ -879,11 +1256,16 @@ func (l *lightningFaucet) generateInvoice(homeTemplate *template.Template,
 	homeState *homePageContext, w http.ResponseWriter, r *http.Request) {
 
 	// Disable generate invoice if user set this parameter
-	if l.cfg.DisableGenerateInvoices {
+	if l.actionDisabled(GenerateInvoiceAction) {
 		http.Error(w, "generate invoices was disabled", 403)
 		return
 	}
 
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "unable to parse form", 500)
+		return
+	}
+
 	// Get the invoice from users form and set the input value again
 	// for the users repeat the action or verify
 	amt := r.FormValue("amt")
@@ -892,7 +1274,7 @@ func (l *lightningFaucet) generateInvoice(homeTemplate *template.Template,
 	homeState.FormFields["Description"] = description
 
 	// Verify IP before continuing
-	clientIP, err := getRealIP(r, l.cfg.UseRealIP)
+	clientIP, err := getRealIP(r, l.trustedProxies)
 	if err != nil {
 		log.Errorf("Can't get client ip: %v", err)
 		homeState.SubmissionError = InternalServerError
@@ -900,59 +1282,115 @@ func (l *lightningFaucet) generateInvoice(homeTemplate *template.Template,
 		return
 	}
 
-	if err = verifyTimeLimit(clientIP, l.cfg.ActionsTimeLimit); err != nil {
-		log.Errorf("%v", err)
-		homeState.SubmissionError = TimeLimitError
+	amtDcr, err := strconv.ParseFloat(amt, 64)
+	if err != nil {
+		homeState.SubmissionError = ChanAmountNotNumber
 		homeTemplate.Execute(w, homeState)
 		return
 	}
+	amtAtoms := int64(amtDcr * 1e8)
 
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "unable to parse form", 500)
+	chanErr := l.checkFormCaptcha(r, homeState, GenerateInvoiceAction,
+		clientIP)
+	if chanErr != NoError {
+		homeState.SubmissionError = chanErr
+		homeTemplate.Execute(w, homeState)
 		return
 	}
 
-	amtDcr, err := strconv.ParseFloat(amt, 64)
-	if err != nil {
-		homeState.SubmissionError = ChanAmountNotNumber
+	invoice, chanErr := l.createInvoice(r.Context(), clientIP,
+		l.policy.classify(r, clientIP), amtAtoms, description)
+	if chanErr != NoError {
+		homeState.SubmissionError = chanErr
 		homeTemplate.Execute(w, homeState)
 		return
 	}
-	if amtDcr > 0.2 {
-		log.Warnf("Attempt to generate high value invoice (%f) from %s",
-			amtDcr, r.RemoteAddr)
-		homeState.SubmissionError = InvoiceAmountTooHigh
-		homeTemplate.Execute(w, homeState)
-		return
+
+	homeState.InvoicePaymentRequest = invoice.PaymentRequest
+
+	if err := homeTemplate.Execute(w, homeState); err != nil {
+		log.Errorf("unable to render home page: %v", err)
+	}
+}
+
+// createInvoice generates a new invoice of amtAtoms on behalf of the client
+// at clientIP, of the given class, enforcing the time limit between actions
+// and the invoice limits of the policy. It is shared by the html form and the
+// API.
+func (l *lightningFaucet) createInvoice(ctx context.Context, clientIP string,
+	class clientClass, amtAtoms int64, description string) (
+	invoice *lnrpc.AddInvoiceResponse, chanErr ChanCreationError) {
+
+	start := time.Now()
+	defer func() {
+		l.metrics.observeAction(GenerateInvoiceAction, start, chanErr)
+	}()
+
+	// The token of the client is taken right away so that concurrent
+	// requests can't all get past the limit, and given back unless the
+	// invoice is generated.
+	limitKeys := l.clients.keys(clientIP)
+	rate := l.policy.rate(class, GenerateInvoiceAction)
+	if err := l.limiter.reserve(GenerateInvoiceAction, rate,
+		limitKeys...); err != nil {
+
+		log.Errorf("%v", err)
+		return nil, TimeLimitError
+	}
+	defer func() {
+		if chanErr != NoError {
+			l.limiter.release(GenerateInvoiceAction, limitKeys...)
+		}
+	}()
+
+	if amtAtoms < 0 {
+		return nil, ChanAmountNotNumber
+	}
+	chanErr = l.policy.checkAmount(class, GenerateInvoiceAction, amtAtoms)
+	if chanErr != NoError {
+		log.Warnf("Attempt to generate invoice of %v from %s (%s): %v",
+			dcrutil.Amount(amtAtoms), clientIP, class, chanErr.Code())
+		return nil, chanErr
 	}
-	amtAtoms := int64(amtDcr * 1e8)
 
 	invoiceReq := &lnrpc.Invoice{
 		CreationDate: time.Now().Unix(),
 		Value:        amtAtoms,
 		Memo:         description,
 	}
-	invoice, err := l.lnd.AddInvoice(ctxb, invoiceReq)
+	invoice, err := l.lnd.AddInvoice(ctx, invoiceReq)
 	if err != nil {
 		log.Errorf("Generate invoice failed: %v", err)
-		homeState.SubmissionError = ErrorGeneratingInvoice
-		homeTemplate.Execute(w, homeState)
-		return
+		return nil, ErrorGeneratingInvoice
 	}
 
 	log.Infof("Generated invoice #%d for %s rhash=%064x", invoice.AddIndex,
 		dcrutil.Amount(amtAtoms), invoice.RHash)
 
-	homeState.InvoicePaymentRequest = invoice.PaymentRequest
-
-	if err := homeTemplate.Execute(w, homeState); err != nil {
-		log.Errorf("unable to render home page: %v", err)
+	now := time.Now()
+	err = l.db.AddInvoiceGrant(&invoiceGrant{
+		PaymentHash:    hex.EncodeToString(invoice.RHash),
+		PaymentRequest: invoice.PaymentRequest,
+		Amount:         amtAtoms,
+		Description:    description,
+		ClientIP:       clientIP,
+		Timestamp:      now,
+	})
+	if err != nil {
+		log.Errorf("unable to record invoice grant: %v", err)
 	}
 
-	// Update time for client request
-	rateLimitMtx.Lock()
-	requestIPs[clientIP] = time.Now()
-	rateLimitMtx.Unlock()
+	return invoice, NoError
+}
+
+// paymentResult describes an invoice successfully paid by the faucet.
+type paymentResult struct {
+	Destination string
+	Description string
+	Amount      dcrutil.Amount
+	PaymentHash string
+	Preimage    string
+	Hops        []*lnrpc.Hop
 }
 
 // payInvoice is a hybrid http.Handler that handles: the validation of the
@@ -962,7 +1400,7 @@ func (l *lightningFaucet) payInvoice(homeTemplate *template.Template,
 	homeState *homePageContext, w http.ResponseWriter, r *http.Request) {
 
 	// Disable pay invoice if user set this parameter
-	if l.cfg.DisablePayInvoices {
+	if l.actionDisabled(PayInvoiceAction) {
 		http.Error(w, "invoices payment was disabled", 403)
 		return
 	}
@@ -972,8 +1410,8 @@ func (l *lightningFaucet) payInvoice(homeTemplate *template.Template,
 	rawPayReq := r.FormValue("payinvoice")
 	homeState.FormFields["Payinvoice"] = rawPayReq
 
-	// Verify IP before open a channel
-	clientIP, err := getRealIP(r, l.cfg.UseRealIP)
+	// Verify IP before paying the invoice.
+	clientIP, err := getRealIP(r, l.trustedProxies)
 	if err != nil {
 		log.Errorf("Can't get client ip: %v", err)
 		homeState.SubmissionError = InternalServerError
@@ -981,32 +1419,101 @@ func (l *lightningFaucet) payInvoice(homeTemplate *template.Template,
 		return
 	}
 
-	if err = verifyTimeLimit(clientIP, l.cfg.ActionsTimeLimit); err != nil {
-		log.Errorf("%v", err)
-		homeState.SubmissionError = TimeLimitError
+	chanErr := l.checkFormCaptcha(r, homeState, PayInvoiceAction, clientIP)
+	if chanErr != NoError {
+		homeState.SubmissionError = chanErr
 		homeTemplate.Execute(w, homeState)
 		return
 	}
 
+	payment, chanErr := l.sendPayment(r.Context(), clientIP,
+		l.policy.classify(r, clientIP), rawPayReq)
+	if chanErr != NoError {
+		homeState.SubmissionError = chanErr
+		homeTemplate.Execute(w, homeState)
+		return
+	}
+
+	homeState.PaymentDestination = payment.Destination
+	homeState.PaymentDescription = payment.Description
+	homeState.PaymentAmount = payment.Amount.String()
+	homeState.PaymentHash = payment.PaymentHash
+	homeState.PaymentPreimage = payment.Preimage
+	homeState.PaymentHops = payment.Hops
+
+	if err := homeTemplate.Execute(w, homeState); err != nil {
+		log.Errorf("unable to render home page: %v", err)
+	}
+}
+
+// sendPayment decodes and pays the passed payment request on behalf of the
+// client at clientIP, of the given class, enforcing the time limit between
+// actions and the payment limits of the policy. It is shared by the html form
+// and the API.
+func (l *lightningFaucet) sendPayment(ctx context.Context, clientIP string,
+	class clientClass, rawPayReq string) (result *paymentResult,
+	chanErr ChanCreationError) {
+
+	start := time.Now()
+	defer func() {
+		l.metrics.observeAction(PayInvoiceAction, start, chanErr)
+	}()
+
+	// The tokens of the client and of the destination are taken right
+	// away so that concurrent requests can't all get past the limits, and
+	// given back unless the invoice is paid. Those of a payment whose
+	// outcome is unknown are settled along with it.
+	var unsettled bool
+	clientKeys := l.clients.keys(clientIP)
+	rate := l.policy.rate(class, PayInvoiceAction)
+	if err := l.limiter.reserve(PayInvoiceAction, rate, clientKeys...); err != nil {
+		log.Errorf("%v", err)
+		return nil, TimeLimitError
+	}
+	defer func() {
+		if chanErr != NoError && !unsettled {
+			l.limiter.release(PayInvoiceAction, clientKeys...)
+		}
+	}()
+
 	// Try to verify and decode the invoice from users form.
 	payReq := strings.TrimSpace(rawPayReq)
-	payReqString := &lnrpc.PayReqString{PayReq: payReq}
-	decodedPayReq, err := l.lnd.DecodePayReq(ctxb, payReqString)
+	decodedPayReq, err := l.lnd.DecodePayReq(ctx, payReq)
 	if err != nil {
 		log.Errorf("Error on decode pay_req: %v", err)
-		homeState.SubmissionError = ErrorDecodingPayReq
-		homeTemplate.Execute(w, homeState)
-		return
+		return nil, ErrorDecodingPayReq
 	}
 
+	// The destination of the payment request is subject to the same time
+	// limit as the client, so that a single node can't be paid repeatedly
+	// by rotating client addresses.
+	destKey := rateLimitKey{destinationKey, decodedPayReq.Destination}
+	if err := l.limiter.reserve(PayInvoiceAction, rate, destKey); err != nil {
+		log.Errorf("%v", err)
+		return nil, TimeLimitError
+	}
+	defer func() {
+		if chanErr != NoError && !unsettled {
+			l.limiter.release(PayInvoiceAction, destKey)
+		}
+	}()
+
 	decodedAmount := decodedPayReq.GetNumAtoms()
 
 	// Verify invoice amount.
-	if decodedAmount > maxPaymentAtoms {
-		log.Errorf("Max payout, pay_amount: %v", decodedAmount)
-		homeState.SubmissionError = ErrorPaymentAmount
-		homeTemplate.Execute(w, homeState)
-		return
+	chanErr = l.policy.checkAmount(class, PayInvoiceAction, decodedAmount)
+	if chanErr != NoError {
+		log.Errorf("Payment amount %v refused for %s clients: %v",
+			decodedAmount, class, chanErr.Code())
+		return nil, chanErr
+	}
+
+	// Spend the payment from the budgets before sending it, and give it
+	// back if it fails.
+	spend := budgetSpend{Payments: decodedAmount}
+	reservedAt := time.Now()
+	if chanErr := l.budget.reserve(reservedAt, spend); chanErr != NoError {
+		return nil, chanErr
 	}
 
 	// Create the payment request.
@@ -1016,55 +1523,44 @@ func (l *lightningFaucet) payInvoice(homeTemplate *template.Template,
 		IgnoreMaxOutboundAmt: false,
 	}
 
-	// Create a payment stream to send your payment request.
-	paymentStream, err := l.lnd.SendPayment(ctxb)
-	if err != nil {
-		log.Errorf("Error on create Payment Stream: %v", err)
-		homeState.SubmissionError = PaymentStreamError
-		homeTemplate.Execute(w, homeState)
-		return
-	}
-
-	// Stream the payment request.
-	if err := paymentStream.Send(req); err != nil {
-		log.Errorf("Error on send pay_req: %v", err)
-		homeState.SubmissionError = PaymentStreamError
-		homeTemplate.Execute(w, homeState)
-		return
-	}
-
-	// Receive response from streamed payment request.
-	resp, err := paymentStream.Recv()
+	resp, err := l.lnd.SendPayment(ctx, req)
 	if err != nil {
-		log.Errorf("Error on receive pay_req response: %v", err)
-		homeState.SubmissionError = PaymentStreamError
-		homeTemplate.Execute(w, homeState)
-		return
+		// The node may still be sending the payment when the call
+		// fails or is canceled, so its funds and tokens remain spent
+		// until its status is looked up.
+		log.Errorf("Error on payment: %v", err)
+		unsettled = true
+		l.wg.Add(1)
+		go l.paymentResolver(&unsettledPayment{
+			clientIP:   clientIP,
+			payReq:     payReq,
+			decoded:    decodedPayReq,
+			spend:      spend,
+			reservedAt: reservedAt,
+			keys:       append(clientKeys, destKey),
+		})
+		return nil, PaymentStreamError
+	}
+
+	// A payment that failed to find a route is reported in the response
+	// itself rather than as a stream error.
+	if resp.PaymentError != "" || resp.PaymentRoute == nil {
+		log.Errorf("Payment failed: %v", resp.PaymentError)
+		l.budget.release(time.Now(), reservedAt, spend)
+		return nil, PaymentStreamError
 	}
 
-	paymentStream.CloseSend()
-
-	// Log response and send to homeState to create the html version.
-	log.Infof("Invoice has been paid destination=%v	description=%v amount=%v pay_hash:%v preimage=%v",
-		decodedPayReq.Destination, decodedPayReq.Description,
-		dcrutil.Amount(resp.PaymentRoute.TotalAmt), hex.EncodeToString(resp.PaymentHash),
-		hex.EncodeToString(resp.PaymentPreimage))
-
 	amount := dcrutil.Amount(resp.PaymentRoute.TotalAmt)
-
-	homeState.PaymentDestination = decodedPayReq.Destination
-	homeState.PaymentDescription = decodedPayReq.Description
-	homeState.PaymentAmount = amount.String()
-	homeState.PaymentHash = hex.EncodeToString(resp.PaymentHash)
-	homeState.PaymentPreimage = hex.EncodeToString(resp.PaymentPreimage)
-	homeState.PaymentHops = resp.PaymentRoute.Hops
-
-	if err := homeTemplate.Execute(w, homeState); err != nil {
-		log.Errorf("unable to render home page: %v", err)
-	}
-
-	// Update time for client request
-	rateLimitMtx.Lock()
-	requestIPs[clientIP] = time.Now()
-	rateLimitMtx.Unlock()
+	l.recordPayment(clientIP, payReq, decodedPayReq, amount,
+		hex.EncodeToString(resp.PaymentHash),
+		hex.EncodeToString(resp.PaymentPreimage), reservedAt)
+
+	return &paymentResult{
+		Destination: decodedPayReq.Destination,
+		Description: decodedPayReq.Description,
+		Amount:      amount,
+		PaymentHash: hex.EncodeToString(resp.PaymentHash),
+		Preimage:    hex.EncodeToString(resp.PaymentPreimage),
+		Hops:        resp.PaymentRoute.Hops,
+	}, NoError
 }
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// The log rotator is never initialized by the tests, so silence all
+	// subsystems to keep them from writing to it.
+	setLogLevels("off")
+}
+
+// testClientIP is the address every test request originates from.
+const testClientIP = "192.0.2.1"
+
+// newTestFaucet creates a faucet backed by the passed fake using the real
+// html templates and a temporary database. The returned function must be
+// called to stop the faucet and remove the database.
+func newTestFaucet(t *testing.T, lnd *fakeBackend) (*lightningFaucet, func()) {
+	t.Helper()
+
+	cfg := newTestConfig(t)
+	faucet := startTestFaucet(t, cfg, lnd)
+
+	cleanUp := func() {
+		faucet.Stop()
+		os.RemoveAll(cfg.DataDir)
+	}
+	return faucet, cleanUp
+}
+
+// newTestConfig returns the configuration of the test faucets, with their data
+// in a new temporary directory that the caller must remove.
+func newTestConfig(t *testing.T) *config {
+	t.Helper()
+
+	dataDir, err := ioutil.TempDir("", "faucet")
+	if err != nil {
+		t.Fatalf("unable to create data dir: %v", err)
+	}
+
+	return &config{
+		DataDir:                  dataDir,
+		ActionsTimeLimit:         defaultActionsTimeLimit,
+		OpenChannelTimeLimit:     defaultActionsTimeLimit,
+		GenerateInvoiceTimeLimit: defaultActionsTimeLimit,
+		PayInvoiceTimeLimit:      defaultActionsTimeLimit,
+		RateLimitBurst:           defaultRateLimitBurst,
+		RateLimitMaxKeys:         defaultRateLimitMaxKeys,
+		IPv4Prefix:               defaultIPv4Prefix,
+		IPv6Prefix:               defaultIPv6Prefix,
+		SubnetBurst:              defaultSubnetBurst,
+		DisableZombieSweeper:     true,
+		CloseGracePeriod:         defaultCloseGracePeriod,
+		CloseRetryInterval:       defaultCloseRetryInterval,
+		OpenWorkers:              defaultOpenWorkers,
+		OpenQueueSize:            defaultOpenQueueSize,
+		OpenTimeout:              defaultOpenTimeout,
+		ConnectTimeout:           defaultConnectTimeout,
+		MaxInflightOpens:         defaultMaxInflightOpens,
+		WalletReserve:            defaultWalletReserve,
+	}
+}
+
+// startTestFaucet creates and starts a faucet with the passed configuration,
+// backed by the passed fake and using the real html templates. The faucet
+// must be stopped by the caller.
+func startTestFaucet(t *testing.T, cfg *config,
+	lnd *fakeBackend) *lightningFaucet {
+
+	t.Helper()
+
+	templates, err := template.New("faucet").
+		Funcs(customFuncs).
+		ParseGlob(templateGlobPattern)
+	if err != nil {
+		t.Fatalf("unable to parse templates: %v", err)
+	}
+
+	faucet, err := newLightningFaucet(cfg, templates, lnd)
+	if err != nil {
+		os.RemoveAll(cfg.DataDir)
+		t.Fatalf("unable to create faucet: %v", err)
+	}
+	faucet.Start(cfg)
+
+	return faucet
+}
+
+// handlerTest describes a single request made against one of the faucet's
+// html or API handlers and the response it is expected to produce.
+type handlerTest struct {
+	name string
+
+	// setup, when set, prepares the fake backend and the faucet before
+	// the request is made.
+	setup func(*fakeBackend, *lightningFaucet)
+
+	method string
+	target string
+	form   url.Values
+
+	// body, when set, is sent as the JSON body of the request.
+	body string
+
+	wantStatus int
+	wantBody   []string
+
+	// check, when set, verifies the state of the fake backend and the
+	// faucet once the response was received.
+	check func(*testing.T, *fakeBackend, *lightningFaucet,
+		*httptest.ResponseRecorder)
+}
+
+// runHandlerTests executes each test against a fresh faucet using the
+// handler returned by handler.
+func runHandlerTests(t *testing.T, tests []handlerTest,
+	handler func(*lightningFaucet) http.HandlerFunc) {
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			lnd := newFakeBackend()
+			faucet, cleanUp := newTestFaucet(t, lnd)
+			defer cleanUp()
+			if test.setup != nil {
+				test.setup(lnd, faucet)
+			}
+
+			body := strings.NewReader(test.body)
+			if test.form != nil {
+				body = strings.NewReader(test.form.Encode())
+			}
+			req := httptest.NewRequest(test.method, test.target, body)
+			req.RemoteAddr = testClientIP + ":12345"
+			switch {
+			case test.form != nil:
+				req.Header.Set("Content-Type",
+					"application/x-www-form-urlencoded")
+			case test.body != "":
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			rec := httptest.NewRecorder()
+			handler(faucet).ServeHTTP(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Fatalf("unexpected status: got %d, want %d",
+					rec.Code, test.wantStatus)
+			}
+			respBody := rec.Body.String()
+			for _, want := range test.wantBody {
+				if !strings.Contains(respBody, want) {
+					t.Fatalf("response body does not contain "+
+						"%q:\n%s", want, respBody)
+				}
+			}
+			if test.check != nil {
+				test.check(t, lnd, faucet, rec)
+			}
+		})
+	}
+}
+
+// redirectedOpenJob waits for the job of the channel open the response
+// redirected to to leave the queued and negotiating states and returns it.
+func redirectedOpenJob(t *testing.T, l *lightningFaucet,
+	rec *httptest.ResponseRecorder) *openJob {
+
+	t.Helper()
+
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, openJobPath("")) {
+		t.Fatalf("unexpected redirect: %q", location)
+	}
+	return waitOpenJob(t, l, strings.TrimPrefix(location, openJobPath("")))
+}
+
+// TestFaucetHome exercises the home page and the open channel form.
+func TestFaucetHome(t *testing.T) {
+	peer := fakePubKey(0x01)
+	openTarget := "/?action=" + OpenChannelAction
+	openForm := func(node, amt, bal string) url.Values {
+		return url.Values{
+			"node": {node},
+			"amt":  {amt},
+			"bal":  {bal},
+		}
+	}
+
+	tests := []handlerTest{{
+		name:       "render form",
+		method:     http.MethodGet,
+		target:     "/",
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"Node Public Key",
+			"Faucet has no pending channels",
+			"Faucet has no active channels",
+			"0.0005&nbsp;DCR",
+			"10.73741823&nbsp;DCR",
+		},
+	}, {
+		name: "render channels",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addChannel(peer, 100000)
+		},
+		method:     http.MethodGet,
+		target:     "/",
+		wantStatus: http.StatusOK,
+		wantBody:   []string{"Active Channels", peer},
+	}, {
+		name: "backend unavailable",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.errGetInfo = errors.New("unavailable")
+		},
+		method:     http.MethodGet,
+		target:     "/",
+		wantStatus: http.StatusInternalServerError,
+		wantBody:   []string{"unable to render home page"},
+	}, {
+		name:       "invalid pubkey",
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm("zz", "0.01", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{InvalidAddress.String()},
+	}, {
+		name:       "amount not a number",
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "lots", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{ChanAmountNotNumber.String()},
+	}, {
+		name:       "not connected",
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.01", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{NotConnected.String()},
+	}, {
+		name: "already have channel",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+			lnd.addChannel(peer, 100000)
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.01", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{HaveChannel.String()},
+	}, {
+		name: "channel too small",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.0001", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{template.HTMLEscapeString(ChannelTooSmall.String())},
+	}, {
+		name: "channel too large",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "100", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{ChannelTooLarge.String()},
+	}, {
+		name: "push too large",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.01", "0.01"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{PushIncorrect.String()},
+	}, {
+		name: "open fails",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+			lnd.errOpenChannel = errors.New("no funds")
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.01", "0"),
+		wantStatus: http.StatusSeeOther,
+		check: func(t *testing.T, _ *fakeBackend, l *lightningFaucet,
+			rec *httptest.ResponseRecorder) {
+
+			job := redirectedOpenJob(t, l, rec)
+			if job.State != openJobFailed ||
+				job.ErrorCode != ChannelOpenFail.Code() {
+
+				t.Fatalf("channel open not failed: %+v", job)
+			}
+		},
+	}, {
+		name: "node rate limited",
+		setup: func(lnd *fakeBackend, l *lightningFaucet) {
+			lnd.addPeer(peer)
+			l.limiter.record(OpenChannelAction, time.Now(),
+				rateLimitKey{nodeKey, peer})
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.01", "0"),
+		wantStatus: http.StatusOK,
+		wantBody:   []string{TimeLimitError.String()},
+	}, {
+		name: "channel opened",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPeer(peer)
+		},
+		method:     http.MethodPost,
+		target:     openTarget,
+		form:       openForm(peer, "0.01", "0.001"),
+		wantStatus: http.StatusSeeOther,
+		check: func(t *testing.T, lnd *fakeBackend, l *lightningFaucet,
+			rec *httptest.ResponseRecorder) {
+
+			job := redirectedOpenJob(t, l, rec)
+			if job.State != openJobPending || job.FundingTxid == "" {
+				t.Fatalf("channel not pending: %+v", job)
+			}
+
+			// The job opened the requested channel with the node.
+			pending, err := lnd.PendingChannels(context.Background())
+			if err != nil {
+				t.Fatalf("unable to get pending channels: %v", err)
+			}
+			if len(pending.PendingOpenChannels) != 1 {
+				t.Fatalf("unexpected number of pending channels: %d",
+					len(pending.PendingOpenChannels))
+			}
+			channel := pending.PendingOpenChannels[0].Channel
+			if channel.RemoteNodePub != peer ||
+				channel.ChannelPoint != job.ChannelPoint ||
+				channel.Capacity != 1e6 ||
+				channel.RemoteBalance != 1e5 {
+
+				t.Fatalf("unexpected channel: %+v", channel)
+			}
+		},
+	}}
+
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return l.faucetHome
+	})
+}
+
+// TestToolsPage exercises the generate and pay invoice forms.
+func TestToolsPage(t *testing.T) {
+	dest := fakePubKey(0x02)
+	genTarget := "/tools?action=" + GenerateInvoiceAction
+	payTarget := "/tools?action=" + PayInvoiceAction
+	rateLimited := func(_ *fakeBackend, l *lightningFaucet) {
+		clientKey := rateLimitKey{clientIPKey, testClientIP}
+		l.limiter.record(GenerateInvoiceAction, time.Now(), clientKey)
+		l.limiter.record(PayInvoiceAction, time.Now(), clientKey)
+	}
+
+	tests := []handlerTest{{
+		name:       "render forms",
+		method:     http.MethodGet,
+		target:     "/tools",
+		wantStatus: http.StatusOK,
+		wantBody:   []string{"Pay Invoice", "Generate Invoice"},
+	}, {
+		name:   "generate invoice",
+		method: http.MethodPost,
+		target: genTarget,
+		form: url.Values{
+			"amt":         {"0.01"},
+			"description": {"test"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"Invoice successfully generated",
+			"lntdcr1fake",
+		},
+	}, {
+		name:   "invoice amount not a number",
+		method: http.MethodPost,
+		target: genTarget,
+		form: url.Values{
+			"amt": {"lots"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{ChanAmountNotNumber.String()},
+	}, {
+		name:   "invoice too large",
+		method: http.MethodPost,
+		target: genTarget,
+		form: url.Values{
+			"amt": {"1"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{InvoiceAmountTooHigh.String()},
+	}, {
+		name: "invoice generation fails",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.errAddInvoice = errors.New("db full")
+		},
+		method: http.MethodPost,
+		target: genTarget,
+		form: url.Values{
+			"amt": {"0.01"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{ErrorGeneratingInvoice.String()},
+	}, {
+		name:   "invoice rate limited",
+		setup:  rateLimited,
+		method: http.MethodPost,
+		target: genTarget,
+		form: url.Values{
+			"amt": {"0.01"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{TimeLimitError.String()},
+	}, {
+		name: "generate disabled",
+		setup: func(_ *fakeBackend, l *lightningFaucet) {
+			l.setActionDisabled(GenerateInvoiceAction, true)
+		},
+		method: http.MethodPost,
+		target: genTarget,
+		form: url.Values{
+			"amt": {"0.01"},
+		},
+		wantStatus: http.StatusForbidden,
+	}, {
+		name: "pay invoice",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {" lntdcr1pay "},
+		},
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"Invoice successfully paid",
+			dest,
+			"0102",
+		},
+	}, {
+		name:   "undecodable payreq",
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"garbage"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{ErrorDecodingPayReq.String()},
+	}, {
+		name: "payment too large",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPayReq("lntdcr1big", dest, defaultMaxPaymentAtoms+1)
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"lntdcr1big"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{ErrorPaymentAmount.String()},
+	}, {
+		name: "payment fails",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+			lnd.errPayment = errors.New("no route")
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"lntdcr1pay"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{PaymentStreamError.String()},
+	}, {
+		name: "payment rate limited",
+		setup: func(lnd *fakeBackend, l *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+			rateLimited(lnd, l)
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"lntdcr1pay"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{TimeLimitError.String()},
+	}, {
+		name: "destination rate limited",
+		setup: func(lnd *fakeBackend, l *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+			l.limiter.record(PayInvoiceAction, time.Now(),
+				rateLimitKey{destinationKey, dest})
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"lntdcr1pay"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody:   []string{TimeLimitError.String()},
+	}, {
+		name: "payment over budget",
+		setup: func(lnd *fakeBackend, l *lightningFaucet) {
+			lnd.addPayReq("lntdcr1pay", dest, 500)
+			l.budget = newBudgetTracker(&config{
+				PaymentBudgetDaily: 400,
+			})
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"lntdcr1pay"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			template.HTMLEscapeString(BudgetExhausted.String()),
+			"payments: 0.000004 of 0.000004 DCR left today",
+		},
+	}, {
+		name: "payments paused",
+		setup: func(_ *fakeBackend, l *lightningFaucet) {
+			l.budget = newBudgetTracker(&config{
+				PaymentBudgetHourly: 400,
+			})
+			l.budget.reserve(time.Now(), budgetSpend{Payments: 400})
+		},
+		method:     http.MethodGet,
+		target:     "/tools",
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"Paying invoices is paused",
+			"Generate Invoice",
+		},
+	}, {
+		name: "pay disabled",
+		setup: func(_ *fakeBackend, l *lightningFaucet) {
+			l.setActionDisabled(PayInvoiceAction, true)
+		},
+		method: http.MethodPost,
+		target: payTarget,
+		form: url.Values{
+			"payinvoice": {"lntdcr1pay"},
+		},
+		wantStatus: http.StatusForbidden,
+	}, {
+		name:       "method not allowed",
+		method:     http.MethodDelete,
+		target:     "/tools",
+		wantStatus: http.StatusMethodNotAllowed,
+	}}
+
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return l.toolsPage
+	})
+}
+
+// TestInfoPage exercises the system information page.
+func TestInfoPage(t *testing.T) {
+	tests := []handlerTest{{
+		name: "render info",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.addChannel(fakePubKey(0x01), 100000)
+		},
+		method:     http.MethodGet,
+		target:     "/info",
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"System Information",
+			"fakenode",
+			"0.0.0-fake",
+			fakePubKey(0xfa),
+		},
+	}, {
+		name: "render channels pending close",
+		setup: func(lnd *fakeBackend, l *lightningFaucet) {
+			lnd.addChannel(fakePubKey(0x01), 100000)
+			chanPoint, _ := strPointToChanPoint(
+				lnd.channels[0].ChannelPoint)
+			lnd.CloseChannel(context.Background(), chanPoint, true)
+		},
+		method:     http.MethodGet,
+		target:     "/info",
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"Channels Pending Close",
+			string(limboWaitingClose),
+			"100000 atoms",
+		},
+	}, {
+		name:       "method not allowed",
+		method:     http.MethodPost,
+		target:     "/info",
+		wantStatus: http.StatusMethodNotAllowed,
+	}, {
+		name: "backend unavailable",
+		setup: func(lnd *fakeBackend, _ *lightningFaucet) {
+			lnd.errGetInfo = errors.New("unavailable")
+		},
+		method:     http.MethodGet,
+		target:     "/info",
+		wantStatus: http.StatusInternalServerError,
+	}}
+
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return l.infoPage
+	})
+}
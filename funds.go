@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	// defaultWalletReserve is the confirmed balance, in atoms, the faucet
+	// keeps in its wallet by default to pay for closing and sweeping its
+	// channels.
+	defaultWalletReserve int64 = 1e7
+
+	// fundingFeeRate is the fee rate, in atoms per kB, used to estimate the
+	// fee of funding transactions. It is the default relay fee of dcrd.
+	fundingFeeRate int64 = 1e4
+
+	// fundingTxBaseSize is the estimated size, in bytes, of a funding
+	// transaction without its inputs, which holds the funding output and a
+	// change output.
+	fundingTxBaseSize int64 = 200
+
+	// fundingTxInputSize is the estimated size, in bytes, of every P2PKH
+	// input of a funding transaction.
+	fundingTxInputSize int64 = 166
+)
+
+// fundingFee returns the estimated fee, in atoms, of a funding transaction
+// spending numInputs outputs.
+func fundingFee(numInputs int) int64 {
+	size := fundingTxBaseSize + int64(numInputs)*fundingTxInputSize
+	return size * fundingFeeRate / 1000
+}
+
+// walletFunds ensures the channels opened by the faucet are covered by the
+// confirmed funds of its wallet, leaving the reserve untouched.
+type walletFunds struct {
+	lnd     lightningBackend
+	reserve int64
+
+	// inFlight are the atoms of the channels whose funding is being
+	// negotiated, which are still part of the balance of the wallet.
+	mtx      sync.Mutex
+	inFlight int64
+}
+
+// newWalletFunds returns a walletFunds keeping the reserve of the passed
+// config in the wallet of the node.
+func newWalletFunds(cfg *config, lnd lightningBackend) *walletFunds {
+	return &walletFunds{
+		lnd:     lnd,
+		reserve: cfg.WalletReserve,
+	}
+}
+
+// fundable returns the largest channel, in atoms, the confirmed funds of the
+// wallet are able to fund while keeping the reserve, without accounting for
+// the channels being funded. The estimated fee assumes every confirmed output
+// is spent, so it never falls short.
+func (f *walletFunds) fundable(ctx context.Context) (int64, error) {
+	balance, err := f.lnd.WalletBalance(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("rpc WalletBalance failed: %v", err)
+	}
+	utxos, err := f.lnd.ListUnspent(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("rpc ListUnspent failed: %v", err)
+	}
+
+	var spendable int64
+	var numInputs int
+	for _, utxo := range utxos {
+		if utxo.Confirmations < 1 {
+			continue
+		}
+		spendable += utxo.AmountAtoms
+		numInputs++
+	}
+
+	// The balance also excludes the outputs the wallet has locked, so the
+	// smallest of both is what can actually be spent.
+	if balance.ConfirmedBalance < spendable {
+		spendable = balance.ConfirmedBalance
+	}
+
+	return spendable - f.reserve - fundingFee(numInputs), nil
+}
+
+// available returns the largest channel, in atoms, the wallet is currently
+// able to fund, or zero when it is low on funds.
+func (f *walletFunds) available(ctx context.Context) (int64, error) {
+	fundable, err := f.fundable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if avail := fundable - f.inFlight; avail > 0 {
+		return avail, nil
+	}
+	return 0, nil
+}
+
+// check returns WalletLowFunds if funding a channel of chanSize atoms would
+// dip the confirmed balance of the wallet below the reserve.
+func (f *walletFunds) check(ctx context.Context,
+	chanSize int64) ChanCreationError {
+
+	avail, err := f.available(ctx)
+	if err != nil {
+		log.Errorf("unable to check wallet funds: %v", err)
+		return InternalServerError
+	}
+	if chanSize > avail {
+		log.Infof("Wallet low on funds: channel of %d atoms requested, "+
+			"%d atoms available", chanSize, avail)
+		return WalletLowFunds
+	}
+	return NoError
+}
+
+// reserve sets chanSize atoms aside for a channel about to be funded if the
+// wallet is able to fund it, and returns WalletLowFunds otherwise. The funds
+// must be given back with release once the funding transaction is broadcast
+// or the funding failed.
+func (f *walletFunds) reserve(ctx context.Context,
+	chanSize int64) ChanCreationError {
+
+	fundable, err := f.fundable(ctx)
+	if err != nil {
+		log.Errorf("unable to check wallet funds: %v", err)
+		return InternalServerError
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if chanSize > fundable-f.inFlight {
+		log.Infof("Wallet low on funds: channel of %d atoms requested, "+
+			"%d atoms available", chanSize, fundable-f.inFlight)
+		return WalletLowFunds
+	}
+	f.inFlight += chanSize
+	return NoError
+}
+
+// release gives back the funds set aside by reserve.
+func (f *walletFunds) release(chanSize int64) {
+	f.mtx.Lock()
+	f.inFlight -= chanSize
+	f.mtx.Unlock()
+}
+
+// maxFundableChannel returns the largest channel, in atoms, a client of the
+// given class may currently open considering the funds of the wallet, or
+// zero when not even the smallest channel allowed can be funded.
+func (l *lightningFaucet) maxFundableChannel(ctx context.Context,
+	class clientClass) int64 {
+
+	avail, err := l.funds.available(ctx)
+	if err != nil {
+		log.Errorf("unable to check wallet funds: %v", err)
+		return 0
+	}
+
+	limits := l.policy.limits(class).OpenChannel
+	switch {
+	case avail < limits.MinAmount:
+		return 0
+	case avail > limits.MaxAmount:
+		return limits.MaxAmount
+	}
+	return avail
+}
+
+// lowFundsMessage returns the message reporting WalletLowFunds to a client of
+// the given class, which suggests the largest channel currently possible.
+func (l *lightningFaucet) lowFundsMessage(ctx context.Context,
+	class clientClass) (string, int64) {
+
+	maxSize := l.maxFundableChannel(ctx, class)
+	if maxSize == 0 {
+		return WalletLowFunds.String(), 0
+	}
+	return fmt.Sprintf("%s. The largest channel currently possible is "+
+		"%s DCR.", WalletLowFunds, formatCoins(maxSize)), maxSize
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// TestWalletFunds ensures channels are only funded from the confirmed outputs
+// of the wallet, keeping the reserve and the funding fee, and that channels
+// being funded are accounted for.
+func TestWalletFunds(t *testing.T) {
+	lnd := newFakeBackend()
+	lnd.balance.ConfirmedBalance = 15e7
+	lnd.utxos = []*lnrpc.Utxo{
+		{AmountAtoms: 1e8, Confirmations: 3},
+		{AmountAtoms: 5e7, Confirmations: 1},
+		{AmountAtoms: 1e8, Confirmations: 0},
+	}
+	funds := newWalletFunds(&config{WalletReserve: 1e7}, lnd)
+	ctx := context.Background()
+
+	// Only the confirmed outputs are spent, so the fee covers two inputs.
+	want := 15e7 - 1e7 - fundingFee(2)
+	avail, err := funds.available(ctx)
+	if err != nil {
+		t.Fatalf("unable to get available funds: %v", err)
+	}
+	if avail != want {
+		t.Fatalf("unexpected available funds: got %d, want %d", avail,
+			want)
+	}
+	if chanErr := funds.check(ctx, want); chanErr != NoError {
+		t.Fatalf("unexpected check result: %v", chanErr.Code())
+	}
+	if chanErr := funds.check(ctx, want+1); chanErr != WalletLowFunds {
+		t.Fatalf("unexpected check result: %v", chanErr.Code())
+	}
+
+	// Channels being funded are set aside until released.
+	if chanErr := funds.reserve(ctx, 1e8); chanErr != NoError {
+		t.Fatalf("unable to reserve: %v", chanErr.Code())
+	}
+	if chanErr := funds.reserve(ctx, 5e7); chanErr != WalletLowFunds {
+		t.Fatalf("unexpected reserve result: %v", chanErr.Code())
+	}
+	if chanErr := funds.check(ctx, want-1e8); chanErr != NoError {
+		t.Fatalf("unexpected check result: %v", chanErr.Code())
+	}
+	funds.release(1e8)
+	if chanErr := funds.reserve(ctx, 5e7); chanErr != NoError {
+		t.Fatalf("unable to reserve: %v", chanErr.Code())
+	}
+	funds.release(5e7)
+
+	// Outputs locked by the wallet aren't part of its balance.
+	lnd.balance.ConfirmedBalance = 1e8
+	avail, err = funds.available(ctx)
+	if err != nil {
+		t.Fatalf("unable to get available funds: %v", err)
+	}
+	if want := 1e8 - 1e7 - fundingFee(2); avail != want {
+		t.Fatalf("unexpected available funds: got %d, want %d", avail,
+			want)
+	}
+
+	// Nothing is available once the reserve can't be kept.
+	lnd.balance.ConfirmedBalance = 1e7
+	avail, err = funds.available(ctx)
+	if err != nil {
+		t.Fatalf("unable to get available funds: %v", err)
+	}
+	if avail != 0 {
+		t.Fatalf("unexpected available funds: %d", avail)
+	}
+
+	lnd.errListUnspent = errors.New("wallet locked")
+	if chanErr := funds.check(ctx, 1); chanErr != InternalServerError {
+		t.Fatalf("unexpected check result: %v", chanErr.Code())
+	}
+}
+
+// TestOpenChannelLowFunds ensures channels the wallet can't fund are refused
+// with the largest channel currently possible.
+func TestOpenChannelLowFunds(t *testing.T) {
+	peer := fakePubKey(0x01)
+
+	// The wallet holds 0.1 DCR above the reserve, minus the fee.
+	lowFunds := func(lnd *fakeBackend, _ *lightningFaucet) {
+		lnd.addPeer(peer)
+		lnd.balance.ConfirmedBalance = 2e7
+	}
+	maxSize := 2e7 - defaultWalletReserve - fundingFee(1)
+
+	tests := []handlerTest{{
+		name:   "smaller channel possible",
+		setup:  lowFunds,
+		method: http.MethodPost,
+		target: "/?action=" + OpenChannelAction,
+		form: url.Values{
+			"node": {peer},
+			"amt":  {"0.2"},
+			"bal":  {"0"},
+		},
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			WalletLowFunds.String(),
+			"The largest channel currently possible is " +
+				formatCoins(maxSize) + "&nbsp;DCR.",
+		},
+	}, {
+		name:   "fits",
+		setup:  lowFunds,
+		method: http.MethodPost,
+		target: "/?action=" + OpenChannelAction,
+		form: url.Values{
+			"node": {peer},
+			"amt":  {"0.09"},
+			"bal":  {"0"},
+		},
+		wantStatus: http.StatusSeeOther,
+	}}
+	runHandlerTests(t, tests, func(l *lightningFaucet) http.HandlerFunc {
+		return l.faucetHome
+	})
+
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+	lowFunds(lnd, faucet)
+
+	for _, test := range []struct {
+		balance int64
+		wantMax int64
+	}{
+		{2e7, maxSize},
+		{defaultWalletReserve, 0},
+	} {
+		lnd.balance.ConfirmedBalance = test.balance
+
+		body := `{"node_pubkey": "` + peer + `", "amount": 20000000}`
+		req := httptest.NewRequest(http.MethodPost,
+			apiPathPrefix+"/channels", strings.NewReader(body))
+		req.RemoteAddr = testClientIP + ":12345"
+		rec := httptest.NewRecorder()
+		faucet.apiOpenChannel(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected status: %d", rec.Code)
+		}
+
+		var resp apiErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("unable to decode error: %v", err)
+		}
+		if resp.Error.Code != WalletLowFunds.Code() ||
+			resp.Error.MaxChannelSize != test.wantMax {
+
+			t.Fatalf("unexpected error: %+v", resp.Error)
+		}
+	}
+}
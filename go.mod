@@ -13,6 +13,7 @@ require (
 	github.com/gorilla/mux v1.7.4
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/jrick/logrotate v1.0.0
+	go.etcd.io/bbolt v1.3.3
 	golang.org/x/crypto v0.0.0-20190829043050-9756ffdc2472
 	google.golang.org/grpc v1.28.0
 	gopkg.in/macaroon.v2 v2.0.0
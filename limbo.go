@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+const (
+	// limboPollInterval is the interval between two updates of the
+	// channels whose funds are in limbo.
+	limboPollInterval = time.Minute
+
+	// numRecentlySwept is the number of channels whose funds recently
+	// returned to the wallet that are kept around.
+	numRecentlySwept = 10
+)
+
+// limboState is the stage of the close of a channel whose funds haven't
+// returned to the wallet yet.
+type limboState string
+
+const (
+	// limboWaitingClose is the state of a channel whose closing
+	// transaction isn't confirmed yet.
+	limboWaitingClose limboState = "waiting_close"
+
+	// limboClosing is the state of a cooperatively closed channel whose
+	// closing transaction is confirmed but not fully resolved yet.
+	limboClosing limboState = "closing"
+
+	// limboForceClosing is the state of a force closed channel whose
+	// funds are timelocked until their maturity height.
+	limboForceClosing limboState = "force_closing"
+
+	// limboSwept is the final state of a channel once the node no longer
+	// reports it as pending, meaning its funds are back in the wallet.
+	limboSwept limboState = "swept"
+)
+
+// limboChannel describes a closed channel whose funds are, or were, in limbo.
+// All amounts are in atoms.
+type limboChannel struct {
+	ChannelPoint      string     `json:"channel_point"`
+	RemotePubKey      string     `json:"remote_pubkey"`
+	Capacity          int64      `json:"capacity"`
+	State             limboState `json:"state"`
+	ClosingTxid       string     `json:"closing_txid,omitempty"`
+	LimboBalance      int64      `json:"limbo_balance"`
+	RecoveredBalance  int64      `json:"recovered_balance"`
+	MaturityHeight    uint32     `json:"maturity_height,omitempty"`
+	BlocksTilMaturity int32      `json:"blocks_til_maturity,omitempty"`
+	NumPendingHTLCs   int        `json:"num_pending_htlcs"`
+	FirstSeen         time.Time  `json:"first_seen"`
+	Swept             *time.Time `json:"swept,omitempty"`
+}
+
+// limboSummary holds the per channel and aggregate figures of the funds in
+// limbo. All amounts are in atoms.
+type limboSummary struct {
+	Updated         time.Time       `json:"updated"`
+	TotalLimbo      int64           `json:"total_limbo_balance"`
+	TotalRecovered  int64           `json:"total_recovered_balance"`
+	NumWaitingClose int             `json:"num_waiting_close"`
+	NumClosing      int             `json:"num_closing"`
+	NumForceClosing int             `json:"num_force_closing"`
+	Channels        []*limboChannel `json:"channels"`
+	RecentlySwept   []*limboChannel `json:"recently_swept"`
+	TotalSwept      int64           `json:"total_swept"`
+	NumSwept        int             `json:"num_swept"`
+}
+
+// limboTracker follows the channels of the node that are being closed until
+// their funds are back in the wallet.
+type limboTracker struct {
+	mtx sync.Mutex
+
+	// updated is when the pending channels were last observed.
+	updated time.Time
+
+	// totalLimbo is the total limbo balance reported by the node.
+	totalLimbo int64
+
+	// channels holds the channels currently pending close keyed by
+	// channel point.
+	channels map[string]*limboChannel
+
+	// swept holds the channels whose funds most recently returned to the
+	// wallet, newest first.
+	swept []*limboChannel
+
+	// totalSwept and numSwept count every channel whose funds returned to
+	// the wallet since the faucet started.
+	totalSwept int64
+	numSwept   int
+}
+
+// newLimboTracker returns a tracker that doesn't know about any channel yet.
+func newLimboTracker() *limboTracker {
+	return &limboTracker{
+		channels: make(map[string]*limboChannel),
+	}
+}
+
+// observe records the channels the node reports as pending close at the given
+// time, which is when they were requested from the node. Channels that are no
+// longer reported have had their funds returned to the wallet. Observations
+// older than the latest one are ignored.
+func (t *limboTracker) observe(now time.Time,
+	pending *lnrpc.PendingChannelsResponse) {
+
+	current := make(map[string]*limboChannel)
+	add := func(channel *lnrpc.PendingChannelsResponse_PendingChannel,
+		state limboState) *limboChannel {
+
+		c := &limboChannel{
+			ChannelPoint: channel.ChannelPoint,
+			RemotePubKey: channel.RemoteNodePub,
+			Capacity:     channel.Capacity,
+			State:        state,
+			LimboBalance: channel.LocalBalance,
+			FirstSeen:    now,
+		}
+		current[c.ChannelPoint] = c
+		return c
+	}
+
+	for _, channel := range pending.WaitingCloseChannels {
+		c := add(channel.Channel, limboWaitingClose)
+		c.LimboBalance = channel.LimboBalance
+	}
+	for _, channel := range pending.PendingClosingChannels {
+		c := add(channel.Channel, limboClosing)
+		c.ClosingTxid = channel.ClosingTxid
+	}
+	for _, channel := range pending.PendingForceClosingChannels {
+		c := add(channel.Channel, limboForceClosing)
+		c.ClosingTxid = channel.ClosingTxid
+		c.LimboBalance = channel.LimboBalance
+		c.RecoveredBalance = channel.RecoveredBalance
+		c.MaturityHeight = channel.MaturityHeight
+		c.BlocksTilMaturity = channel.BlocksTilMaturity
+		c.NumPendingHTLCs = len(channel.PendingHtlcs)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if now.Before(t.updated) {
+		return
+	}
+
+	for chanPoint, c := range current {
+		prev, ok := t.channels[chanPoint]
+		if !ok {
+			log.Infof("ChannelPoint(%v) is pending close, %d atoms in "+
+				"limbo", chanPoint, c.LimboBalance)
+			continue
+		}
+
+		c.FirstSeen = prev.FirstSeen
+		if c.ClosingTxid == "" {
+			c.ClosingTxid = prev.ClosingTxid
+		}
+		if c.State != prev.State {
+			log.Infof("ChannelPoint(%v) moved from %v to %v, %d "+
+				"atoms in limbo", chanPoint, prev.State, c.State,
+				c.LimboBalance)
+		}
+	}
+
+	for chanPoint, prev := range t.channels {
+		if _, ok := current[chanPoint]; ok {
+			continue
+		}
+
+		// The last known limbo balance is what made it back to the
+		// wallet, along with whatever was already recovered.
+		swept := *prev
+		sweptAt := now
+		swept.State = limboSwept
+		swept.Swept = &sweptAt
+		swept.RecoveredBalance += swept.LimboBalance
+		swept.LimboBalance = 0
+		swept.BlocksTilMaturity = 0
+
+		log.Infof("Funds of ChannelPoint(%v) are back in the wallet, %d "+
+			"atoms recovered", chanPoint, swept.RecoveredBalance)
+
+		t.swept = append([]*limboChannel{&swept}, t.swept...)
+		if len(t.swept) > numRecentlySwept {
+			t.swept = t.swept[:numRecentlySwept]
+		}
+		t.totalSwept += swept.RecoveredBalance
+		t.numSwept++
+	}
+
+	t.channels = current
+	t.totalLimbo = pending.TotalLimboBalance
+	t.updated = now
+}
+
+// summary returns a copy of what the tracker currently knows. The pending
+// channels are sorted by the time they were first seen, oldest first.
+func (t *limboTracker) summary() *limboSummary {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s := &limboSummary{
+		Updated:       t.updated,
+		TotalLimbo:    t.totalLimbo,
+		Channels:      make([]*limboChannel, 0, len(t.channels)),
+		RecentlySwept: make([]*limboChannel, 0, len(t.swept)),
+		TotalSwept:    t.totalSwept,
+		NumSwept:      t.numSwept,
+	}
+	for _, channel := range t.channels {
+		c := *channel
+		s.Channels = append(s.Channels, &c)
+		s.TotalRecovered += c.RecoveredBalance
+
+		switch c.State {
+		case limboWaitingClose:
+			s.NumWaitingClose++
+		case limboClosing:
+			s.NumClosing++
+		case limboForceClosing:
+			s.NumForceClosing++
+		}
+	}
+	sort.Slice(s.Channels, func(i, j int) bool {
+		a, b := s.Channels[i], s.Channels[j]
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.Before(b.FirstSeen)
+		}
+		return a.ChannelPoint < b.ChannelPoint
+	})
+	for _, channel := range t.swept {
+		c := *channel
+		s.RecentlySwept = append(s.RecentlySwept, &c)
+	}
+
+	return s
+}
+
+// updateLimbo fetches the channels pending close from the node and records
+// them with the limbo tracker.
+func (l *lightningFaucet) updateLimbo(ctx context.Context) error {
+	now := time.Now()
+	pending, err := l.lnd.PendingChannels(ctx)
+	if err != nil {
+		return err
+	}
+	l.limbo.observe(now, pending)
+	return nil
+}
+
+// limboWatcher is a goroutine that periodically follows the channels pending
+// close until their funds are back in the wallet.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) limboWatcher() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(limboPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.updateLimbo(ctxb); err != nil {
+			log.Errorf("unable to fetch pending channels: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-l.quit:
+			return
+		}
+	}
+}
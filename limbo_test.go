@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// TestLimboTracker ensures the limbo tracker follows a channel from its close
+// until its funds are back in the wallet.
+func TestLimboTracker(t *testing.T) {
+	tracker := newLimboTracker()
+	channel := &lnrpc.PendingChannelsResponse_PendingChannel{
+		RemoteNodePub: fakePubKey(0x01),
+		ChannelPoint:  "a:0",
+		Capacity:      1e6,
+		LocalBalance:  9e5,
+	}
+
+	start := time.Now()
+	tracker.observe(start, &lnrpc.PendingChannelsResponse{
+		TotalLimboBalance: 9e5,
+		WaitingCloseChannels: []*lnrpc.PendingChannelsResponse_WaitingCloseChannel{{
+			Channel:      channel,
+			LimboBalance: 9e5,
+		}},
+	})
+	s := tracker.summary()
+	if s.TotalLimbo != 9e5 || s.NumWaitingClose != 1 ||
+		len(s.Channels) != 1 {
+
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	// Once the closing transaction confirms the funds are timelocked.
+	tracker.observe(start.Add(time.Minute), &lnrpc.PendingChannelsResponse{
+		TotalLimboBalance: 8e5,
+		PendingForceClosingChannels: []*lnrpc.PendingChannelsResponse_ForceClosedChannel{{
+			Channel:           channel,
+			ClosingTxid:       "b",
+			LimboBalance:      8e5,
+			RecoveredBalance:  1e5,
+			MaturityHeight:    1000,
+			BlocksTilMaturity: 144,
+		}},
+	})
+	s = tracker.summary()
+	if s.NumForceClosing != 1 || s.TotalRecovered != 1e5 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	c := s.Channels[0]
+	if c.State != limboForceClosing || c.BlocksTilMaturity != 144 ||
+		!c.FirstSeen.Equal(start) {
+
+		t.Fatalf("unexpected channel: %+v", c)
+	}
+
+	// Stale observations are ignored.
+	tracker.observe(start, &lnrpc.PendingChannelsResponse{})
+	if s := tracker.summary(); len(s.Channels) != 1 {
+		t.Fatalf("stale observation recorded: %+v", s)
+	}
+
+	// The channel is gone once its funds are swept back to the wallet.
+	tracker.observe(start.Add(2*time.Minute),
+		&lnrpc.PendingChannelsResponse{})
+	s = tracker.summary()
+	if len(s.Channels) != 0 || s.TotalLimbo != 0 || s.NumSwept != 1 ||
+		s.TotalSwept != 9e5 || len(s.RecentlySwept) != 1 {
+
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	c = s.RecentlySwept[0]
+	if c.State != limboSwept || c.ClosingTxid != "b" || c.Swept == nil {
+		t.Fatalf("unexpected swept channel: %+v", c)
+	}
+}
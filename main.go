@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"html/template"
 	"net/http"
 	"os"
@@ -34,7 +35,7 @@ var (
 	}
 
 	// ctxb is a global context with no timeouts that's used within the
-	// gRPC requests to lnd.
+	// gRPC requests to lnd made outside of an http request.
 	ctxb = context.Background()
 )
 
@@ -56,21 +57,58 @@ func main() {
 		Funcs(customFuncs).
 		ParseGlob(templateGlobPattern))
 
+	// If requested, print every grant recorded by the faucet and exit
+	// without connecting to dcrlnd.
+	if cfg.DumpGrants {
+		db, err := openFaucetDB(dbPath(cfg.DataDir))
+		if err != nil {
+			log.Criticalf("unable to open database: %v", err)
+			os.Exit(1)
+			return
+		}
+		err = db.dumpGrants(json.NewEncoder(os.Stdout))
+		db.Close()
+		if err != nil {
+			log.Criticalf("unable to dump grants: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Connect to the dcrlnd node that will back the faucet.
+	lnd, err := newLndBackend(cfg)
+	if err != nil {
+		log.Criticalf("unable to connect to dcrlnd: %v", err)
+		os.Exit(1)
+		return
+	}
+
 	// With the templates loaded, create the faucet itself.
-	faucet, err := newLightningFaucet(cfg, faucetTemplates)
+	faucet, err := newLightningFaucet(cfg, faucetTemplates, lnd)
 	if err != nil {
 		log.Criticalf("unable to create faucet: %v", err)
 		os.Exit(1)
 		return
 	}
 
-	// If the wipe channels bool is set, then we'll attempt to close ALL
-	// the faucet's channels by any means and exit in the case of a success
-	// or failure.
+	// If the wipe channels bool is set, then we'll attempt to close the
+	// selected channels, cooperatively first and then by any means, and
+	// exit with a summary. Any failure results in a non-zero exit code.
 	if cfg.WipeChannels {
-		log.Info("Attempting to wipe all faucet channels")
-		if err := faucet.CloseAllChannels(); err != nil {
-			log.Criticalf("unable to close all the faucet's channels: %v", err)
+		log.Info("Attempting to wipe the faucet channels")
+		report, err := faucet.WipeChannels(newWipeFilter(cfg),
+			cfg.WipeConcurrency, cfg.WipeDryRun, cfg.WipeWaitConfs)
+		if report != nil {
+			report.log()
+		}
+		faucet.Stop()
+		if err != nil {
+			log.Criticalf("unable to wipe the faucet's channels: %v", err)
+			os.Exit(1)
+			return
+		}
+		if failed := report.failed(); len(failed) > 0 {
+			log.Criticalf("unable to close %d channels", len(failed))
 			os.Exit(1)
 			return
 		}
@@ -88,9 +126,39 @@ func main() {
 	r.HandleFunc("/", faucet.faucetHome).Methods("POST", "GET")
 	r.HandleFunc("/info", faucet.infoPage).Methods("GET")
 
-	// If users disable all actions, then disable the route
-	if !(cfg.DisableGenerateInvoices && cfg.DisablePayInvoices) {
-		r.HandleFunc("/tools", faucet.toolsPage).Methods("POST", "GET")
+	// Channels are opened in the background, their progress is shown on
+	// a page of their own.
+	r.HandleFunc("/channels/{id}", faucet.openJobPage).Methods("GET")
+
+	// The tools page answers with a 404 while all of its actions are
+	// disabled, which may change at runtime through the admin API.
+	r.HandleFunc("/tools", faucet.toolsPage).Methods("POST", "GET")
+
+	// The versioned JSON API exposes the same actions as the html forms
+	// for scripts and integration tests.
+	faucet.registerAPIRoutes(r)
+
+	// The admin area lets operators inspect and steer the faucet without
+	// restarting it.
+	faucet.registerAdminRoutes(r)
+
+	// Prometheus metrics are either served on an address of their own,
+	// which keeps them private when the faucet is public, or along with
+	// the faucet to the holders of the admin credentials.
+	switch {
+	case cfg.MetricsListen != "":
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("/metrics", faucet.metricsPage)
+		log.Infof("Serving metrics on %s", cfg.MetricsListen)
+		go func() {
+			err := http.ListenAndServe(cfg.MetricsListen, metricsMux)
+			if err != nil {
+				log.Errorf("unable to serve metrics: %v", err)
+			}
+		}()
+
+	case cfg.Metrics:
+		faucet.registerMetricsRoutes(r)
 	}
 
 	// Next create a static file server which will dispatch our static
@@ -155,6 +223,8 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
+
+	faucet.Stop()
 }
 
 func init() {
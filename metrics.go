@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrlnd/lnrpc"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// metricsNamespace prefixes the name of every metric of the faucet.
+	metricsNamespace = "dcrlnfaucet"
+
+	// metricsScrapeTimeout is the time dcrlnd has to answer the queries
+	// made while the metrics are scraped.
+	metricsScrapeTimeout = 10 * time.Second
+
+	// metricsContentType is the content type of the Prometheus text
+	// exposition format.
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the
+// duration histograms. Channel opens and payments may take up to the open
+// timeout.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5,
+	5, 10, 30, 60, 120}
+
+// labelValueEscaper escapes the label values of the exposition format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelKey joins the values of the labels of a sample into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels formats the labels of a sample in the exposition format.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name,
+			labelValueEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatValue formats the value of a sample in the exposition format.
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeHeader writes the help and type lines of a metric.
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// counterVec is a counter partitioned by labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mtx    sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+// newCounterVec returns a counter partitioned by the given labels.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   metricsNamespace + "_" + name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+}
+
+// add increases the counter with the given label values by v.
+func (c *counterVec) add(v float64, values ...string) {
+	key := labelKey(values)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.keys[key]; !ok {
+		c.keys[key] = values
+	}
+	c.values[key] += v
+}
+
+// write writes every sample of the counter in the exposition format.
+func (c *counterVec) write(w io.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name,
+			formatLabels(c.labels, c.keys[key]),
+			formatValue(c.values[key]))
+	}
+}
+
+// histogram holds the observations of a histogram with a given set of label
+// values.
+type histogram struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mtx        sync.Mutex
+	histograms map[string]*histogram
+}
+
+// newHistogramVec returns a histogram with the given buckets partitioned by
+// the given labels.
+func newHistogramVec(name, help string, buckets []float64,
+	labels ...string) *histogramVec {
+
+	return &histogramVec{
+		name:       metricsNamespace + "_" + name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// observe records the value v in the histogram with the given label values.
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := labelKey(values)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{
+			values: values,
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.histograms[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// write writes every sample of the histogram in the exposition format. The
+// buckets are cumulative, as the format requires.
+func (h *histogramVec) write(w io.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.histograms))
+	for key := range h.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range keys {
+		hist := h.histograms[key]
+		bucketValues := append(append([]string(nil), hist.values...), "")
+		for i, bound := range h.buckets {
+			bucketValues[len(bucketValues)-1] = formatValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				formatLabels(bucketLabels, bucketValues),
+				hist.counts[i])
+		}
+		bucketValues[len(bucketValues)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			formatLabels(bucketLabels, bucketValues), hist.count)
+
+		labels := formatLabels(h.labels, hist.values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels,
+			formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, hist.count)
+	}
+}
+
+// gaugeSample is a sample of a gauge collected while the metrics are scraped.
+type gaugeSample struct {
+	values []string
+	value  float64
+}
+
+// writeGauge writes the samples of a gauge in the exposition format.
+func writeGauge(w io.Writer, name, help string, labels []string,
+	samples ...gaugeSample) {
+
+	name = metricsNamespace + "_" + name
+	writeHeader(w, name, help, "gauge")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, s.values),
+			formatValue(s.value))
+	}
+}
+
+// faucetMetrics holds the metrics the faucet records as it runs. The metrics
+// reflecting the state of the node are collected when they are scraped.
+type faucetMetrics struct {
+	// actions counts the actions requested by clients by outcome and
+	// error code, and actionDuration measures how long they took.
+	actions        *counterVec
+	actionDuration *histogramVec
+
+	// rpcDuration measures the latency of the calls to dcrlnd, and
+	// rpcErrors counts those that failed.
+	rpcDuration *histogramVec
+	rpcErrors   *counterVec
+
+	// zombieSweeps counts the zombie channel sweeps by outcome, and
+	// zombieChannels the zombie channels they found by result.
+	zombieSweeps   *counterVec
+	zombieChannels *counterVec
+}
+
+// newFaucetMetrics returns the metrics of a faucet that just started.
+func newFaucetMetrics() *faucetMetrics {
+	return &faucetMetrics{
+		actions: newCounterVec("actions_total",
+			"Actions requested by clients by outcome and error code.",
+			"action", "outcome", "code"),
+		actionDuration: newHistogramVec("action_duration_seconds",
+			"Time taken by the actions requested by clients, from "+
+				"the request until the outcome is known.",
+			durationBuckets, "action", "outcome"),
+		rpcDuration: newHistogramVec("lnd_rpc_duration_seconds",
+			"Latency of the calls to dcrlnd by method.",
+			durationBuckets, "method"),
+		rpcErrors: newCounterVec("lnd_rpc_errors_total",
+			"Failed calls to dcrlnd by method.", "method"),
+		zombieSweeps: newCounterVec("zombie_sweeps_total",
+			"Zombie channel sweeps by outcome.", "outcome"),
+		zombieChannels: newCounterVec("zombie_channels_total",
+			"Zombie channels found by the sweeps by result.",
+			"result"),
+	}
+}
+
+// observeAction records the outcome of an action that was requested at the
+// time start.
+func (m *faucetMetrics) observeAction(action string, start time.Time,
+	chanErr ChanCreationError) {
+
+	outcome := "success"
+	if chanErr != NoError {
+		outcome = "failure"
+	}
+	m.actions.add(1, action, outcome, chanErr.Code())
+	m.actionDuration.observe(time.Since(start).Seconds(), action, outcome)
+}
+
+// observeRPC records the latency of a call to dcrlnd that started at the time
+// start.
+func (m *faucetMetrics) observeRPC(method string, start time.Time, err error) {
+	m.rpcDuration.observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		m.rpcErrors.add(1, method)
+	}
+}
+
+// observeSweep records the outcome of a zombie channel sweep.
+func (m *faucetMetrics) observeSweep(report *sweepReport) {
+	outcome := "success"
+	if report.Error != "" {
+		outcome = "failure"
+	}
+	m.zombieSweeps.add(1, outcome)
+	m.zombieChannels.add(float64(len(report.Candidates)), "found")
+	m.zombieChannels.add(float64(len(report.Closed)), "closed")
+	m.zombieChannels.add(float64(len(report.Pending)), "pending")
+	m.zombieChannels.add(float64(len(report.Failed)), "failed")
+}
+
+// instrumentedBackend is a lightningBackend measuring the latency of the calls
+// to the backend it wraps.
+type instrumentedBackend struct {
+	backend lightningBackend
+	metrics *faucetMetrics
+}
+
+// A compile-time assertion to ensure instrumentedBackend meets the
+// lightningBackend interface.
+var _ lightningBackend = (*instrumentedBackend)(nil)
+
+// GetInfo returns general information about the node.
+func (b *instrumentedBackend) GetInfo(
+	ctx context.Context) (*lnrpc.GetInfoResponse, error) {
+
+	start := time.Now()
+	resp, err := b.backend.GetInfo(ctx)
+	b.metrics.observeRPC("GetInfo", start, err)
+	return resp, err
+}
+
+// NodeInfo returns the announcement information of the node with the given
+// public key.
+func (b *instrumentedBackend) NodeInfo(ctx context.Context,
+	pubKey string) (*lnrpc.NodeInfo, error) {
+
+	start := time.Now()
+	resp, err := b.backend.NodeInfo(ctx, pubKey)
+	b.metrics.observeRPC("NodeInfo", start, err)
+	return resp, err
+}
+
+// ListChannels returns all of the node's open channels.
+func (b *instrumentedBackend) ListChannels(
+	ctx context.Context) ([]*lnrpc.Channel, error) {
+
+	start := time.Now()
+	resp, err := b.backend.ListChannels(ctx)
+	b.metrics.observeRPC("ListChannels", start, err)
+	return resp, err
+}
+
+// PendingChannels returns all of the node's pending channels.
+func (b *instrumentedBackend) PendingChannels(
+	ctx context.Context) (*lnrpc.PendingChannelsResponse, error) {
+
+	start := time.Now()
+	resp, err := b.backend.PendingChannels(ctx)
+	b.metrics.observeRPC("PendingChannels", start, err)
+	return resp, err
+}
+
+// OpenChannel starts the funding workflow described by the request and
+// returns the funding outpoint once the funding transaction has been
+// broadcast.
+func (b *instrumentedBackend) OpenChannel(ctx context.Context,
+	req *lnrpc.OpenChannelRequest) (*wire.OutPoint, error) {
+
+	start := time.Now()
+	resp, err := b.backend.OpenChannel(ctx, req)
+	b.metrics.observeRPC("OpenChannel", start, err)
+	return resp, err
+}
+
+// CloseChannel closes the channel, optionally executing a force close, and
+// returns the closing txid once it has been broadcast.
+func (b *instrumentedBackend) CloseChannel(ctx context.Context,
+	chanPoint *lnrpc.ChannelPoint, force bool) (*chainhash.Hash, error) {
+
+	start := time.Now()
+	resp, err := b.backend.CloseChannel(ctx, chanPoint, force)
+	b.metrics.observeRPC("CloseChannel", start, err)
+	return resp, err
+}
+
+// AddInvoice adds a new invoice to the node.
+func (b *instrumentedBackend) AddInvoice(ctx context.Context,
+	invoice *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error) {
+
+	start := time.Now()
+	resp, err := b.backend.AddInvoice(ctx, invoice)
+	b.metrics.observeRPC("AddInvoice", start, err)
+	return resp, err
+}
+
+// DecodePayReq decodes the passed payment request.
+func (b *instrumentedBackend) DecodePayReq(ctx context.Context,
+	payReq string) (*lnrpc.PayReq, error) {
+
+	start := time.Now()
+	resp, err := b.backend.DecodePayReq(ctx, payReq)
+	b.metrics.observeRPC("DecodePayReq", start, err)
+	return resp, err
+}
+
+// SendPayment attempts to pay the payment request described by req and
+// blocks until the payment either succeeds or fails.
+func (b *instrumentedBackend) SendPayment(ctx context.Context,
+	req *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+
+	start := time.Now()
+	resp, err := b.backend.SendPayment(ctx, req)
+	b.metrics.observeRPC("SendPayment", start, err)
+	return resp, err
+}
+
+// ListPayments returns the payments sent by the node, including the ones that
+// are still in flight.
+func (b *instrumentedBackend) ListPayments(
+	ctx context.Context) ([]*lnrpc.Payment, error) {
+
+	start := time.Now()
+	resp, err := b.backend.ListPayments(ctx)
+	b.metrics.observeRPC("ListPayments", start, err)
+	return resp, err
+}
+
+// ListPeers returns the peers the node is currently connected to.
+func (b *instrumentedBackend) ListPeers(
+	ctx context.Context) ([]*lnrpc.Peer, error) {
+
+	start := time.Now()
+	resp, err := b.backend.ListPeers(ctx)
+	b.metrics.observeRPC("ListPeers", start, err)
+	return resp, err
+}
+
+// ConnectPeer connects to the node with the given public key at the given
+// host:port address.
+func (b *instrumentedBackend) ConnectPeer(ctx context.Context, pubKey,
+	host string) error {
+
+	start := time.Now()
+	err := b.backend.ConnectPeer(ctx, pubKey, host)
+	b.metrics.observeRPC("ConnectPeer", start, err)
+	return err
+}
+
+// WalletBalance returns the balance of the node's on-chain wallet.
+func (b *instrumentedBackend) WalletBalance(
+	ctx context.Context) (*lnrpc.WalletBalanceResponse, error) {
+
+	start := time.Now()
+	resp, err := b.backend.WalletBalance(ctx)
+	b.metrics.observeRPC("WalletBalance", start, err)
+	return resp, err
+}
+
+// ListUnspent returns the unspent outputs of the node's on-chain wallet,
+// including the unconfirmed ones.
+func (b *instrumentedBackend) ListUnspent(
+	ctx context.Context) ([]*lnrpc.Utxo, error) {
+
+	start := time.Now()
+	resp, err := b.backend.ListUnspent(ctx)
+	b.metrics.observeRPC("ListUnspent", start, err)
+	return resp, err
+}
+
+// GetTransactions returns the transactions of the node's on-chain wallet.
+func (b *instrumentedBackend) GetTransactions(
+	ctx context.Context) ([]*lnrpc.Transaction, error) {
+
+	start := time.Now()
+	resp, err := b.backend.GetTransactions(ctx)
+	b.metrics.observeRPC("GetTransactions", start, err)
+	return resp, err
+}
+
+// SubscribeChannelEvents calls fn for every change of the state of the node's
+// channels. The subscription lasts as long as the faucet runs, so only its
+// failures are counted.
+func (b *instrumentedBackend) SubscribeChannelEvents(ctx context.Context,
+	fn func(*lnrpc.ChannelEventUpdate)) error {
+
+	err := b.backend.SubscribeChannelEvents(ctx, fn)
+	if err != nil && ctx.Err() == nil {
+		b.metrics.rpcErrors.add(1, "SubscribeChannelEvents")
+	}
+	return err
+}
+
+// writeMetrics writes every metric of the faucet in the Prometheus text
+// exposition format. The balance of the wallet and the channels of the node
+// are queried from dcrlnd, and lnd_up reports whether it answered.
+func (l *lightningFaucet) writeMetrics(ctx context.Context, w io.Writer) {
+	m := l.metrics
+	m.actions.write(w)
+	m.actionDuration.write(w)
+	m.rpcDuration.write(w)
+	m.rpcErrors.write(w)
+	m.zombieSweeps.write(w)
+	m.zombieChannels.write(w)
+
+	stats := l.limiter.stats()
+	name := metricsNamespace + "_ratelimit_rejections_total"
+	writeHeader(w, name, "Actions refused by the rate limiter by action "+
+		"and the kind of key that was limited.", "counter")
+	actions := make([]string, 0, len(stats.Rejections))
+	for action := range stats.Rejections {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		reasons := make([]string, 0, len(stats.Rejections[action]))
+		for reason := range stats.Rejections[action] {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "%s%s %d\n", name,
+				formatLabels([]string{"action", "reason"},
+					[]string{action, reason}),
+				stats.Rejections[action][reason])
+		}
+	}
+
+	up := 1.0
+	balance, err := l.lnd.WalletBalance(ctx)
+	if err != nil {
+		log.Errorf("rpc WalletBalance failed: %v", err)
+		up = 0
+	} else {
+		writeGauge(w, "wallet_balance_atoms",
+			"Balance of the wallet of the node by state.",
+			[]string{"state"},
+			gaugeSample{[]string{"confirmed"},
+				float64(balance.ConfirmedBalance)},
+			gaugeSample{[]string{"unconfirmed"},
+				float64(balance.UnconfirmedBalance)})
+	}
+
+	channels, err := l.lnd.ListChannels(ctx)
+	if err != nil {
+		log.Errorf("rpc ListChannels failed: %v", err)
+		up = 0
+	}
+	pending, pendingErr := l.lnd.PendingChannels(ctx)
+	if pendingErr != nil {
+		log.Errorf("rpc PendingChannels failed: %v", pendingErr)
+		up = 0
+	}
+	if err == nil && pendingErr == nil {
+		var numActive, numInactive int
+		for _, c := range channels {
+			if c.Active {
+				numActive++
+			} else {
+				numInactive++
+			}
+		}
+		writeGauge(w, "channels", "Channels of the node by state.",
+			[]string{"state"},
+			gaugeSample{[]string{"active"}, float64(numActive)},
+			gaugeSample{[]string{"inactive"}, float64(numInactive)},
+			gaugeSample{[]string{"pending"},
+				float64(len(pending.PendingOpenChannels))})
+	}
+
+	writeGauge(w, "lnd_up", "Whether dcrlnd answered the queries made "+
+		"while the metrics were scraped.", nil, gaugeSample{value: up})
+}
+
+// registerMetricsRoutes registers the metrics page with the router of the
+// faucet. The page is restricted to the admin credentials, as it would
+// otherwise be public along with the faucet, and nothing is registered unless
+// they are configured.
+func (l *lightningFaucet) registerMetricsRoutes(r *mux.Router) {
+	if l.admin == nil {
+		log.Warn("No admin credentials configured, metrics disabled")
+		return
+	}
+
+	r.HandleFunc("/metrics", l.adminOnly(l.metricsPage)).Methods("GET")
+}
+
+// metricsPage serves the metrics of the faucet to Prometheus.
+//
+// NOTE: This method implements the http.Handler interface.
+func (l *lightningFaucet) metricsPage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), metricsScrapeTimeout)
+	defer cancel()
+
+	var buf bytes.Buffer
+	l.writeMetrics(ctx, &buf)
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Errorf("unable to write metrics: %v", err)
+	}
+}
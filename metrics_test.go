@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestMetricsExposition ensures counters and histograms are written in the
+// Prometheus text exposition format.
+func TestMetricsExposition(t *testing.T) {
+	counter := newCounterVec("test_total", "Test counter.", "kind")
+	counter.add(1, "b")
+	counter.add(2, `a"\`)
+	counter.add(1, "b")
+
+	hist := newHistogramVec("test_seconds", "Test histogram.",
+		[]float64{0.1, 1}, "method")
+	hist.observe(0.0625, "GetInfo")
+	hist.observe(0.5, "GetInfo")
+	hist.observe(2, "GetInfo")
+
+	var buf bytes.Buffer
+	counter.write(&buf)
+	hist.write(&buf)
+
+	want := `# HELP dcrlnfaucet_test_total Test counter.
+# TYPE dcrlnfaucet_test_total counter
+dcrlnfaucet_test_total{kind="a\"\\"} 2
+dcrlnfaucet_test_total{kind="b"} 2
+# HELP dcrlnfaucet_test_seconds Test histogram.
+# TYPE dcrlnfaucet_test_seconds histogram
+dcrlnfaucet_test_seconds_bucket{method="GetInfo",le="0.1"} 1
+dcrlnfaucet_test_seconds_bucket{method="GetInfo",le="1"} 2
+dcrlnfaucet_test_seconds_bucket{method="GetInfo",le="+Inf"} 3
+dcrlnfaucet_test_seconds_sum{method="GetInfo"} 2.5625
+dcrlnfaucet_test_seconds_count{method="GetInfo"} 3
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestMetricsPage ensures the metrics page reports the actions of the faucet,
+// the calls to dcrlnd and the state of the node.
+func TestMetricsPage(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	lnd.addChannel(fakePubKey(0x01), 1e6)
+
+	// An invoice above the limit, one that is generated, and one refused
+	// by the rate limiter.
+	ctx := context.Background()
+	amounts := []int64{defaultMaxInvoiceAtoms + 1, 1000, 1000}
+	for _, amt := range amounts {
+		faucet.createInvoice(ctx, testClientIP, anonymousClient, amt, "")
+	}
+	faucet.sweepZombieChans()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	faucet.metricsPage(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`dcrlnfaucet_actions_total{action="generateinvoice",outcome="success",code=""} 1`,
+		`dcrlnfaucet_actions_total{action="generateinvoice",outcome="failure",code="invoice_amount_too_high"} 1`,
+		`dcrlnfaucet_actions_total{action="generateinvoice",outcome="failure",code="time_limit"} 1`,
+		`dcrlnfaucet_action_duration_seconds_count{action="generateinvoice",outcome="failure"} 2`,
+		`dcrlnfaucet_lnd_rpc_duration_seconds_count{method="AddInvoice"} 1`,
+		`dcrlnfaucet_ratelimit_rejections_total{action="generateinvoice",reason="client"} 1`,
+		`dcrlnfaucet_zombie_sweeps_total{outcome="success"} 1`,
+		`dcrlnfaucet_wallet_balance_atoms{state="confirmed"} 1e+10`,
+		`dcrlnfaucet_channels{state="active"} 1`,
+		`dcrlnfaucet_channels{state="pending"} 0`,
+		`dcrlnfaucet_lnd_up 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Fatalf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+// TestMetricsRoutes ensures the metrics served along with the faucet are only
+// reachable with the admin credentials, and not served at all without them.
+func TestMetricsRoutes(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	r := mux.NewRouter()
+	faucet.registerMetricsRoutes(r)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("metrics served without admin credentials: %d",
+			rec.Code)
+	}
+
+	newTestAdmin(t, faucet)
+	r = mux.NewRouter()
+	faucet.registerMetricsRoutes(r)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected unauthenticated status: %d", rec.Code)
+	}
+
+	rec = adminRequest(r, http.MethodGet, "/metrics", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected authenticated status: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "dcrlnfaucet_lnd_up 1\n") {
+		t.Fatalf("unexpected metrics:\n%s", rec.Body)
+	}
+}
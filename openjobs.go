@@ -0,0 +1,915 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+const (
+	// defaultOpenWorkers is the default number of channel opens performed
+	// at the same time.
+	defaultOpenWorkers = 2
+
+	// defaultOpenQueueSize is the default number of channel opens that may
+	// wait for a worker.
+	defaultOpenQueueSize = 50
+
+	// defaultOpenTimeout is the default time allowed to negotiate the
+	// funding of a channel with its peer.
+	defaultOpenTimeout = 2 * time.Minute
+
+	// defaultMaxInflightOpens is the default number of channel opens that
+	// may be in progress at the same time, from the negotiation of their
+	// funding until their funding transaction confirms.
+	defaultMaxInflightOpens = 10
+
+	// inflightPollInterval is the interval at which a worker checks
+	// whether it may start a job once max_inflight_opens was reached.
+	inflightPollInterval = 10 * time.Second
+
+	// openJobRetention is how long the status of a job remains available
+	// once it stopped changing.
+	openJobRetention = 24 * time.Hour
+
+	// openJobIDSize is the number of random bytes of a job ID.
+	openJobIDSize = 16
+
+	// openJobPollInterval is the interval at which the confirmations of
+	// the funding transactions of pending jobs are updated.
+	openJobPollInterval = 15 * time.Second
+
+	// channelEventsRetryInterval is how long to wait before subscribing to
+	// the channel events of the node again after the subscription failed.
+	channelEventsRetryInterval = 30 * time.Second
+
+	// minChanConfs and maxChanConfs bound the number of confirmations
+	// dcrlnd requires before a channel it accepted is opened, which scales
+	// with the amount at stake.
+	minChanConfs = 3
+	maxChanConfs = 6
+
+	// maxFundingAmount is the largest channel dcrlnd accepts, in atoms.
+	maxFundingAmount = 1<<30 - 1
+)
+
+var (
+	// errOpenQueueFull is returned when a channel open is requested while
+	// the queue is full.
+	errOpenQueueFull = errors.New("channel open queue is full")
+
+	// errOpenInProgress is returned when a channel open is requested with
+	// a node another client is already opening a channel with.
+	errOpenInProgress = errors.New("channel open with node in progress")
+
+	// errOpenCoalesced is returned along with the job of an open in
+	// progress when the same client requests the same channel again.
+	errOpenCoalesced = errors.New("channel open already requested")
+)
+
+// openJobState is the progress of a queued channel open.
+type openJobState string
+
+const (
+	// openJobQueued is the state of a job waiting for a worker.
+	openJobQueued openJobState = "queued"
+
+	// openJobNegotiating is the state of a job whose funding is being
+	// negotiated with the peer.
+	openJobNegotiating openJobState = "negotiating"
+
+	// openJobPending is the state of a job whose funding transaction was
+	// broadcast but isn't confirmed yet.
+	openJobPending openJobState = "pending"
+
+	// openJobOpen is the final state of a job whose channel is active.
+	openJobOpen openJobState = "open"
+
+	// openJobFailed is the final state of a job whose channel couldn't be
+	// opened.
+	openJobFailed openJobState = "failed"
+)
+
+// openJob tracks a channel open requested by a client from the moment it is
+// queued until the channel is active. All amounts are in atoms.
+type openJob struct {
+	ID            string       `json:"id"`
+	State         openJobState `json:"state"`
+	NodePubKey    string       `json:"node_pubkey"`
+	Amount        int64        `json:"amount"`
+	PushAmount    int64        `json:"push_amount"`
+	FundingTxid   string       `json:"funding_txid,omitempty"`
+	FundingTxURL  string       `json:"funding_tx_url,omitempty"`
+	ChannelPoint  string       `json:"channel_point,omitempty"`
+	NumConfs      uint32       `json:"num_confs"`
+	RequiredConfs uint32       `json:"required_confs"`
+	ErrorCode     string       `json:"error_code,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	Created       time.Time    `json:"created"`
+	Updated       time.Time    `json:"updated"`
+
+	// clientIP is the address of the client that requested the channel.
+	clientIP string
+
+	// nodeHost is the address of the node given by the client, if any.
+	nodeHost string
+}
+
+// finished returns whether the state of the job may no longer change.
+func (j *openJob) finished() bool {
+	return j.State == openJobOpen || j.State == openJobFailed
+}
+
+// sameRequest returns whether the job was requested by the client at clientIP
+// for a channel of chanSize atoms, pushAmt of which are pushed.
+func (j *openJob) sameRequest(clientIP string, chanSize, pushAmt int64) bool {
+	return j.clientIP == clientIP && j.Amount == chanSize &&
+		j.PushAmount == pushAmt
+}
+
+// requiredConfs returns the number of confirmations the peer of a channel of
+// chanSize atoms, pushAmt of which are pushed to it, requires before the
+// channel is opened. The peer gets to decide, so this is what dcrlnd requires
+// by default unless chanConfs is set.
+func requiredConfs(chanSize, pushAmt int64, chanConfs uint32) uint32 {
+	if chanConfs > 0 {
+		return chanConfs
+	}
+
+	confs := maxChanConfs * (chanSize + pushAmt) / maxFundingAmount
+	switch {
+	case confs < minChanConfs:
+		return minChanConfs
+	case confs > maxChanConfs:
+		return maxChanConfs
+	}
+	return uint32(confs)
+}
+
+// openQueue holds the channel opens requested by clients until a worker
+// performs them, and keeps their status around for a while afterwards.
+type openQueue struct {
+	numWorkers int
+	timeout    time.Duration
+	chanConfs  uint32
+
+	// queue feeds the workers with the jobs to perform.
+	queue chan *openJob
+
+	// mtx protects jobs, which holds every known job keyed by ID,
+	// subscribers, which holds the channels notified of the changes of
+	// each job, and inflight, which holds the opens in progress keyed by
+	// node public key so that a single channel is opened with each node.
+	mtx         sync.Mutex
+	jobs        map[string]*openJob
+	subscribers map[string]map[chan *openJob]struct{}
+	inflight    map[string]*inflightOpen
+}
+
+// newOpenQueue returns an empty queue using the parameters of the passed
+// config.
+func newOpenQueue(cfg *config) *openQueue {
+	return &openQueue{
+		numWorkers:  cfg.OpenWorkers,
+		timeout:     cfg.OpenTimeout,
+		chanConfs:   cfg.ChanConfs,
+		queue:       make(chan *openJob, cfg.OpenQueueSize),
+		jobs:        make(map[string]*openJob),
+		subscribers: make(map[string]map[chan *openJob]struct{}),
+		inflight:    make(map[string]*inflightOpen),
+	}
+}
+
+// newOpenJobID returns a new random job ID.
+func newOpenJobID() (string, error) {
+	id := make([]byte, openJobIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// add queues, at the time now, the open of a channel of chanSize atoms with the
+// target node on behalf of the client at clientIP, pushing pushAmt atoms to
+// it. A copy of the new job is returned. If an open with the node is already
+// in progress, a copy of its job is returned along with errOpenCoalesced when
+// it was requested by the same client with the same parameters, and
+// errOpenInProgress otherwise.
+func (q *openQueue) add(now time.Time, clientIP, nodePubStr, nodeHost string,
+	chanSize, pushAmt int64) (*openJob, error) {
+
+	id, err := newOpenJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &openJob{
+		ID:            id,
+		State:         openJobQueued,
+		NodePubKey:    nodePubStr,
+		Amount:        chanSize,
+		PushAmount:    pushAmt,
+		RequiredConfs: requiredConfs(chanSize, pushAmt, q.chanConfs),
+		Created:       now,
+		Updated:       now,
+		clientIP:      clientIP,
+		nodeHost:      nodeHost,
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.prune(now)
+
+	existing, err := q.inProgressLocked(now, clientIP, nodePubStr, chanSize,
+		pushAmt)
+	if err != nil {
+		return existing, err
+	}
+
+	select {
+	case q.queue <- job:
+	default:
+		return nil, errOpenQueueFull
+	}
+	q.jobs[id] = job
+	q.inflight[nodePubStr] = &inflightOpen{
+		NodePubKey: nodePubStr,
+		JobID:      id,
+		ClientIP:   clientIP,
+		Started:    now,
+	}
+
+	c := *job
+	return &c, nil
+}
+
+// inProgress returns the error add would return because of an open in
+// progress with the node, along with a copy of its job for errOpenCoalesced.
+func (q *openQueue) inProgress(clientIP, nodePubStr string, chanSize,
+	pushAmt int64) (*openJob, error) {
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return q.inProgressLocked(time.Now(), clientIP, nodePubStr, chanSize,
+		pushAmt)
+}
+
+// inProgressLocked returns errOpenCoalesced along with a copy of the job of the
+// open in progress with the node at the time now if it was requested by the
+// client at clientIP with the same parameters, and errOpenInProgress if it was
+// requested otherwise. Opens that are no longer in progress are forgotten.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (q *openQueue) inProgressLocked(now time.Time, clientIP,
+	nodePubStr string, chanSize, pushAmt int64) (*openJob, error) {
+
+	open, ok := q.inflight[nodePubStr]
+	if !ok {
+		return nil, nil
+	}
+
+	existing := q.jobs[open.JobID]
+	switch {
+	// Opens interrupted by a restart are given up once their timeout
+	// elapsed, and the others once they are no longer negotiated.
+	case existing == nil && now.Sub(open.Started) > q.timeout:
+	case existing != nil && existing.State != openJobQueued &&
+		existing.State != openJobNegotiating:
+
+	case existing != nil && existing.sameRequest(clientIP, chanSize,
+		pushAmt):
+
+		c := *existing
+		return &c, errOpenCoalesced
+
+	default:
+		return nil, errOpenInProgress
+	}
+	delete(q.inflight, nodePubStr)
+
+	return nil, nil
+}
+
+// restore records an open in progress with a node that was interrupted by a
+// restart, which prevents new opens with the node until its timeout elapsed.
+func (q *openQueue) restore(open *inflightOpen) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.inflight[open.NodePubKey] = open
+}
+
+// release forgets about the open in progress of the job with the node.
+func (q *openQueue) release(nodePubStr, id string) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if open, ok := q.inflight[nodePubStr]; ok && open.JobID == id {
+		delete(q.inflight, nodePubStr)
+	}
+}
+
+// start moves the queued job with the given ID to the negotiating state unless
+// maxInflight opens are already in progress, counting the numPending opens
+// whose funding transaction isn't confirmed yet along with the jobs being
+// negotiated. A maxInflight of zero means there is no limit. It returns
+// whether the job was started.
+func (q *openQueue) start(id string, numPending, maxInflight int) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if maxInflight > 0 {
+		numInflight := numPending
+		for _, job := range q.jobs {
+			if job.State == openJobNegotiating {
+				numInflight++
+			}
+		}
+		if numInflight >= maxInflight {
+			return false
+		}
+	}
+
+	if job, ok := q.jobs[id]; ok {
+		q.updateLocked(job, func(j *openJob) {
+			j.State = openJobNegotiating
+		})
+	}
+	return true
+}
+
+// prune forgets the jobs that didn't change for longer than the retention
+// period, unless a worker may still update them.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (q *openQueue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		if job.State == openJobQueued || job.State == openJobNegotiating {
+			continue
+		}
+		if now.Sub(job.Updated) > openJobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// job returns a copy of the job with the given ID, or nil if it is unknown.
+func (q *openQueue) job(id string) *openJob {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// update applies fn to the job with the given ID, if it is still known, and
+// notifies its subscribers when fn changed it.
+func (q *openQueue) update(id string, fn func(*openJob)) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if job, ok := q.jobs[id]; ok {
+		q.updateLocked(job, fn)
+	}
+}
+
+// updateLocked applies fn to the job and notifies its subscribers when fn
+// changed it.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (q *openQueue) updateLocked(job *openJob, fn func(*openJob)) {
+	prev := *job
+	fn(job)
+	if *job == prev {
+		return
+	}
+	job.Updated = time.Now()
+
+	for sub := range q.subscribers[job.ID] {
+		// Only the latest state of the job matters, so replace any
+		// state the subscriber didn't receive yet.
+		select {
+		case <-sub:
+		default:
+		}
+		c := *job
+		sub <- &c
+	}
+}
+
+// subscribe returns a copy of the job with the given ID along with a channel
+// receiving a copy of the job after every change, or nil if the job is
+// unknown. The returned function must be called to cancel the subscription.
+func (q *openQueue) subscribe(id string) (*openJob, <-chan *openJob, func()) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	sub := make(chan *openJob, 1)
+	if q.subscribers[id] == nil {
+		q.subscribers[id] = make(map[chan *openJob]struct{})
+	}
+	q.subscribers[id][sub] = struct{}{}
+
+	cancel := func() {
+		q.mtx.Lock()
+		defer q.mtx.Unlock()
+
+		delete(q.subscribers[id], sub)
+		if len(q.subscribers[id]) == 0 {
+			delete(q.subscribers, id)
+		}
+	}
+
+	c := *job
+	return &c, sub, cancel
+}
+
+// pending returns a copy of the jobs whose funding transaction was broadcast
+// but whose channel isn't known to be active yet, keyed by channel point.
+func (q *openQueue) pending() map[string]*openJob {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	pending := make(map[string]*openJob)
+	for _, job := range q.jobs {
+		if job.State == openJobPending {
+			c := *job
+			pending[c.ChannelPoint] = &c
+		}
+	}
+	return pending
+}
+
+// queueChannel validates the parameters of a channel creation request made by
+// the client at clientIP, of the given class, with the node given as its
+// public key or as pubkey@host:port and, if they check out, queues the open of
+// the channel. The returned job reports the progress of the open. It is shared
+// by the html form and the API.
+func (l *lightningFaucet) queueChannel(ctx context.Context, clientIP string,
+	class clientClass, node string, chanSize, pushAmt int64) (job *openJob,
+	chanErr ChanCreationError) {
+
+	// Refused requests end here, while the outcome of the queued ones is
+	// recorded once their funding is known.
+	start := time.Now()
+	defer func() {
+		if chanErr != NoError {
+			l.metrics.observeAction(OpenChannelAction, start,
+				chanErr)
+		}
+	}()
+
+	nodePubStr, nodeHost, err := parseNodeAddr(node)
+	if err != nil {
+		return nil, InvalidAddress
+	}
+
+	// Requests for a node with which an open is in progress are answered
+	// before taking any token. Repeated requests get the job in progress,
+	// which holds the tokens of the client, and the others are refused.
+	job, err = l.opener.inProgress(clientIP, nodePubStr, chanSize, pushAmt)
+	if err != nil {
+		return openQueueResult(clientIP, nodePubStr, job, err)
+	}
+
+	// Neither the client nor the target node may open channels more
+	// often than the time limit allows. Their tokens are taken as the
+	// open is queued, so that a client can't queue more opens than its
+	// limits allow while earlier ones are in progress.
+	limitKeys := append(l.clients.keys(clientIP),
+		rateLimitKey{nodeKey, nodePubStr})
+	rate := l.policy.rate(class, OpenChannelAction)
+	if err := l.limiter.reserve(OpenChannelAction, rate, limitKeys...); err != nil {
+		log.Errorf("%v", err)
+		return nil, TimeLimitError
+	}
+
+	chanErr = l.checkChannelRequest(ctx, clientIP, class, nodePubStr,
+		nodeHost, chanSize, pushAmt)
+	if chanErr != NoError {
+		l.limiter.release(OpenChannelAction, limitKeys...)
+		return nil, chanErr
+	}
+
+	// The channel is spent from the budgets as it is queued too, so that
+	// the queued opens can't overspend them.
+	now := time.Now()
+	spend := budgetSpend{Channels: chanSize, Pushed: pushAmt}
+	if chanErr = l.budget.reserve(now, spend); chanErr != NoError {
+		l.limiter.release(OpenChannelAction, limitKeys...)
+		return nil, chanErr
+	}
+
+	// Requests that aren't queued give back what they reserved, including
+	// the coalesced ones since the job in progress holds its own.
+	job, err = l.opener.add(now, clientIP, nodePubStr, nodeHost, chanSize,
+		pushAmt)
+	if err != nil {
+		l.releaseOpen(clientIP, nodePubStr, now, chanSize, pushAmt)
+	}
+	return openQueueResult(clientIP, nodePubStr, job, err)
+}
+
+// openQueueResult reports the outcome of queueing the open of a channel with
+// the node on behalf of the client at clientIP, given the job and error
+// returned by the open queue.
+func openQueueResult(clientIP, nodePubStr string, job *openJob,
+	err error) (*openJob, ChanCreationError) {
+
+	switch {
+	case err == errOpenQueueFull:
+		log.Warnf("Refusing channel with %v: %v", nodePubStr, err)
+		return nil, OpenQueueFull
+
+	case err == errOpenInProgress:
+		log.Infof("Refusing channel with %v: %v", nodePubStr, err)
+		return nil, OpenInProgress
+
+	case err == errOpenCoalesced:
+		log.Infof("Channel open with %v already requested by %v as %v",
+			nodePubStr, clientIP, job.ID)
+		return job, NoError
+
+	case err != nil:
+		log.Errorf("unable to queue channel open: %v", err)
+		return nil, InternalServerError
+	}
+
+	log.Infof("Queued channel open %v with %v", job.ID, nodePubStr)
+	return job, NoError
+}
+
+// releaseOpen gives back the rate limit tokens and the budgets reserved by
+// queueChannel at the time reservedAt for a channel open that didn't go
+// through.
+func (l *lightningFaucet) releaseOpen(clientIP, nodePubStr string,
+	reservedAt time.Time, chanSize, pushAmt int64) {
+
+	limitKeys := append(l.clients.keys(clientIP),
+		rateLimitKey{nodeKey, nodePubStr})
+	l.limiter.release(OpenChannelAction, limitKeys...)
+	l.budget.release(time.Now(), reservedAt, budgetSpend{
+		Channels: chanSize,
+		Pushed:   pushAmt,
+	})
+}
+
+// clientOpenFailure returns whether the open of a channel failed because of
+// the node the client asked for rather than because of the faucet.
+func clientOpenFailure(chanErr ChanCreationError) bool {
+	switch chanErr {
+	case PeerUnreachable, PeerHandshakeFailed, PeerWrongNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseFailedOpen gives back what the job reserved as it was queued once
+// its channel failed to be funded with chanErr. Failures of the faucet give
+// back everything, while the client and the node keep having their tokens
+// taken when the node is to blame, so that it can't be retried right away.
+// Nothing is given back when the funding transaction may have been
+// broadcast, as the channel may yet be opened. The funds of the wallet set
+// aside by fundChannel are given back by it in every case, as the balance of
+// the wallet accounts for the funding transaction once it is broadcast.
+func (l *lightningFaucet) releaseFailedOpen(job *openJob,
+	chanErr ChanCreationError, uncertain bool) {
+
+	switch {
+	case uncertain:
+		log.Warnf("Channel open %v with %v timed out, keeping its "+
+			"limits and budgets as it may still be funded", job.ID,
+			job.NodePubKey)
+
+	case clientOpenFailure(chanErr):
+		l.budget.release(time.Now(), job.Created, budgetSpend{
+			Channels: job.Amount,
+			Pushed:   job.PushAmount,
+		})
+
+	default:
+		l.releaseOpen(job.clientIP, job.NodePubKey, job.Created,
+			job.Amount, job.PushAmount)
+	}
+}
+
+// restoreInflightOpens prevents new opens with the nodes whose channel was
+// being negotiated when the faucet last stopped, until the timeout of those
+// opens elapsed. The node may still complete the funding of such a channel.
+func (l *lightningFaucet) restoreInflightOpens() error {
+	now := time.Now()
+	var expired []string
+	err := l.db.ForEachInflightOpen(func(open *inflightOpen) {
+		if now.Sub(open.Started) > l.opener.timeout {
+			expired = append(expired, open.NodePubKey)
+			return
+		}
+
+		log.Warnf("Channel open %v with %v was interrupted, refusing "+
+			"new opens with the node until %v", open.JobID,
+			open.NodePubKey, open.Started.Add(l.opener.timeout))
+		l.opener.restore(open)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, nodePubStr := range expired {
+		if err := l.db.DeleteInflightOpen(nodePubStr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openJobWorker is a goroutine that performs the queued channel opens one at
+// a time.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) openJobWorker() {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case job := <-l.opener.queue:
+			if !l.startOpenJob(job) {
+				return
+			}
+			l.runOpenJob(job)
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// startOpenJob waits until fewer than max_inflight_opens channel opens are in
+// progress and moves the job to the negotiating state. The opens in progress
+// are the jobs being negotiated and the pending channels of the node, whose
+// funding transactions lock outputs of the wallet until they confirm. It
+// returns false if the faucet is shutting down.
+func (l *lightningFaucet) startOpenJob(job *openJob) bool {
+	maxInflight := l.cfg.MaxInflightOpens
+	waiting := false
+	for {
+		numPending := 0
+		var err error
+		if maxInflight > 0 {
+			var pending *lnrpc.PendingChannelsResponse
+			pending, err = l.lnd.PendingChannels(ctxb)
+			if err == nil {
+				numPending = len(pending.PendingOpenChannels)
+			}
+		}
+
+		switch {
+		case err != nil:
+			log.Errorf("unable to count channel opens in progress: "+
+				"%v", err)
+
+		case l.opener.start(job.ID, numPending, maxInflight):
+			return true
+
+		case !waiting:
+			log.Infof("Channel open %v waiting for one of %d opens in "+
+				"progress to confirm", job.ID, maxInflight)
+			waiting = true
+		}
+
+		select {
+		case <-time.After(inflightPollInterval):
+		case <-l.quit:
+			return false
+		}
+	}
+}
+
+// runOpenJob negotiates the funding of the channel of the job with its peer,
+// recording the funding transaction once it is broadcast.
+func (l *lightningFaucet) runOpenJob(job *openJob) {
+	// The open with the node is recorded while it is negotiated so that
+	// no other channel is opened with the node if the faucet restarts in
+	// the meantime.
+	err := l.db.PutInflightOpen(&inflightOpen{
+		NodePubKey: job.NodePubKey,
+		JobID:      job.ID,
+		ClientIP:   job.clientIP,
+		Started:    time.Now(),
+	})
+	if err != nil {
+		log.Errorf("unable to record channel open in progress: %v", err)
+	}
+	defer func() {
+		l.opener.release(job.NodePubKey, job.ID)
+		err := l.db.DeleteInflightOpen(job.NodePubKey)
+		if err != nil {
+			log.Errorf("unable to delete channel open in progress: "+
+				"%v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctxb, l.opener.timeout)
+	defer cancel()
+
+	// A channel with the node may have been opened by a job that was
+	// still in progress when this one was requested.
+	chanErr := NoError
+	switch {
+	case l.channelExistsWithNode(ctx, job.NodePubKey):
+		chanErr = HaveChannel
+	case l.pendingChannelExistsWithNode(ctx, job.NodePubKey):
+		chanErr = HavePendingChannel
+	}
+
+	// Nodes given with their address are connected to now rather than
+	// while their request is handled, which also reconnects to them if
+	// they disconnected while the job was queued. Failures to connect
+	// are reported by the job.
+	if chanErr == NoError && job.nodeHost != "" {
+		chanErr = l.connectToNode(ctx, job.NodePubKey, job.nodeHost)
+	}
+
+	var fundingPoint *wire.OutPoint
+	if chanErr == NoError {
+		fundingPoint, chanErr = l.fundChannel(ctx, job.clientIP,
+			job.NodePubKey, job.Amount, job.PushAmount)
+
+		// Only the funding attempts count towards the failure rate,
+		// and nodes running on another network are to blame for
+		// their failures.
+		if l.alerts != nil {
+			l.alerts.recordOpen(time.Now(), chanErr != NoError &&
+				chanErr != PeerWrongNetwork)
+		}
+	}
+
+	l.metrics.observeAction(OpenChannelAction, job.Created, chanErr)
+
+	// The job reserved its limits and budgets as it was queued, which
+	// are given back depending on why the channel wasn't funded. An open
+	// that failed once its context expired may still have broadcast its
+	// funding transaction.
+	if chanErr != NoError {
+		l.releaseFailedOpen(job, chanErr,
+			chanErr == ChannelOpenFail && ctx.Err() != nil)
+	}
+
+	l.opener.update(job.ID, func(j *openJob) {
+		if chanErr != NoError {
+			j.State = openJobFailed
+			j.ErrorCode = chanErr.Code()
+			j.Error = chanErr.String()
+			return
+		}
+
+		j.State = openJobPending
+		j.FundingTxid = fundingPoint.Hash.String()
+		j.FundingTxURL = l.explorer.TxLink(j.FundingTxid)
+		j.ChannelPoint = fundingPoint.String()
+	})
+}
+
+// updateOpenJobs records the confirmations of the funding transactions of the
+// pending jobs, and marks the jobs whose channel became active as open.
+func (l *lightningFaucet) updateOpenJobs(ctx context.Context) error {
+	pending := l.opener.pending()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	txs, err := l.lnd.GetTransactions(ctx)
+	if err != nil {
+		return err
+	}
+	numConfs := make(map[string]uint32, len(txs))
+	for _, tx := range txs {
+		if tx.NumConfirmations > 0 {
+			numConfs[tx.TxHash] = uint32(tx.NumConfirmations)
+		}
+	}
+	for _, job := range pending {
+		confs := numConfs[job.FundingTxid]
+		l.opener.update(job.ID, func(j *openJob) {
+			j.NumConfs = confs
+		})
+	}
+
+	channels, err := l.lnd.ListChannels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		l.markJobOpen(pending, channel.ChannelPoint)
+	}
+
+	return nil
+}
+
+// markJobOpen marks the pending job of the channel with the given channel
+// point as open, if there is one.
+func (l *lightningFaucet) markJobOpen(pending map[string]*openJob,
+	chanPoint string) {
+
+	job, ok := pending[chanPoint]
+	if !ok {
+		return
+	}
+
+	log.Infof("Channel of job %v is open: ChannelPoint(%v)", job.ID,
+		chanPoint)
+	l.opener.update(job.ID, func(j *openJob) {
+		j.State = openJobOpen
+		if j.NumConfs < j.RequiredConfs {
+			j.NumConfs = j.RequiredConfs
+		}
+	})
+}
+
+// openJobWatcher is a goroutine that periodically updates the progress of the
+// pending jobs.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) openJobWatcher() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(openJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.quit:
+			return
+		}
+
+		if err := l.updateOpenJobs(ctxb); err != nil {
+			log.Errorf("unable to update channel open jobs: %v", err)
+		}
+	}
+}
+
+// channelEventWatcher is a goroutine that marks the pending jobs as open as
+// soon as the node reports their channel is open.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) channelEventWatcher() {
+	defer l.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctxb)
+	go func() {
+		<-l.quit
+		cancel()
+	}()
+
+	for {
+		err := l.lnd.SubscribeChannelEvents(ctx,
+			func(update *lnrpc.ChannelEventUpdate) {
+				if update.Type != lnrpc.ChannelEventUpdate_OPEN_CHANNEL {
+					return
+				}
+				l.markJobOpen(l.opener.pending(),
+					update.GetOpenChannel().GetChannelPoint())
+			})
+
+		select {
+		case <-l.quit:
+			return
+		default:
+		}
+		log.Errorf("Channel events subscription failed: %v", err)
+
+		select {
+		case <-time.After(channelEventsRetryInterval):
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// lookupOpenJob returns the job with the given ID with its state brought up
+// to date, or nil if it is unknown.
+func (l *lightningFaucet) lookupOpenJob(ctx context.Context,
+	id string) *openJob {
+
+	if err := l.updateOpenJobs(ctx); err != nil {
+		log.Errorf("unable to update channel open jobs: %v", err)
+	}
+	return l.opener.job(id)
+}
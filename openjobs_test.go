@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// waitOpenJob waits until the job with the given ID leaves the queued and
+// negotiating states and returns it.
+func waitOpenJob(t *testing.T, l *lightningFaucet, id string) *openJob {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job := l.lookupOpenJob(context.Background(), id)
+		if job == nil {
+			t.Fatalf("unknown job %v", id)
+		}
+		if job.State != openJobQueued && job.State != openJobNegotiating {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %v still in progress", id)
+	return nil
+}
+
+// TestOpenJobs ensures queued channel opens are performed in the background
+// and report their progress until the channel is active.
+func TestOpenJobs(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	peer := fakePubKey(0x01)
+	lnd.addPeer(peer)
+
+	// Invalid requests are refused before being queued.
+	_, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, peer, defaultMinChannelSize-1, 0)
+	if chanErr != ChannelTooSmall {
+		t.Fatalf("unexpected error: %v", chanErr)
+	}
+
+	job, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, peer, 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr)
+	}
+	if job.State != openJobQueued {
+		t.Fatalf("unexpected state: %v", job.State)
+	}
+
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobPending || job.FundingTxid == "" {
+		t.Fatalf("channel not pending: %+v", job)
+	}
+
+	lnd.activateChannel(job.ChannelPoint)
+	job = faucet.lookupOpenJob(context.Background(), job.ID)
+	if job.State != openJobOpen {
+		t.Fatalf("channel not open: %+v", job)
+	}
+
+	// Opens failing once queued are reported by their job.
+	other := fakePubKey(0x02)
+	lnd.addPeer(other)
+	lnd.errOpenChannel = errors.New("no funds")
+	job, chanErr = faucet.queueChannel(context.Background(), "192.0.2.99",
+		anonymousClient, other, 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr)
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobFailed ||
+		job.ErrorCode != ChannelOpenFail.Code() {
+
+		t.Fatalf("channel open not failed: %+v", job)
+	}
+}
+
+// TestOpenJobReservations ensures the rate limit tokens and the budgets of a
+// channel open are taken as soon as it is queued, and given back if it fails.
+func TestOpenJobReservations(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	faucet.budget = newBudgetTracker(&config{ChannelBudgetDaily: 3e6})
+	spent := func() int64 {
+		return faucet.budget.status(time.Now())[0].Spent
+	}
+
+	peers := []string{fakePubKey(0x01), fakePubKey(0x02)}
+	for _, peer := range peers {
+		lnd.addPeer(peer)
+	}
+
+	// The opens are held back while they are negotiated.
+	gate := make(chan struct{})
+	lnd.mtx.Lock()
+	lnd.openChannelGate = gate
+	lnd.mtx.Unlock()
+
+	ctx := context.Background()
+	job, chanErr := faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peers[0], 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	if spent() != 1e6 {
+		t.Fatalf("channel not spent from the budget: %d", spent())
+	}
+
+	// The client can't queue another open while the first one is in
+	// progress, but repeating its request returns the job in progress.
+	_, chanErr = faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peers[1], 1e6, 0)
+	if chanErr != TimeLimitError {
+		t.Fatalf("unexpected error: %v", chanErr.Code())
+	}
+	dup, chanErr := faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peers[0], 1e6, 0)
+	if chanErr != NoError || dup.ID != job.ID {
+		t.Fatalf("request not coalesced: %v %+v", chanErr.Code(), dup)
+	}
+
+	// Requests refused once their tokens were taken give them back.
+	const otherIP = "198.51.100.1"
+	_, chanErr = faucet.queueChannel(ctx, otherIP, anonymousClient,
+		peers[1], 25e5, 0)
+	if chanErr != BudgetExhausted {
+		t.Fatalf("unexpected error: %v", chanErr.Code())
+	}
+	other, chanErr := faucet.queueChannel(ctx, otherIP, anonymousClient,
+		peers[1], 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	if spent() != 2e6 {
+		t.Fatalf("channels not spent from the budget: %d", spent())
+	}
+
+	// Failed opens give back their tokens and budgets.
+	lnd.mtx.Lock()
+	lnd.errOpenChannel = errors.New("no funds")
+	lnd.mtx.Unlock()
+	close(gate)
+	for _, id := range []string{job.ID, other.ID} {
+		job := waitOpenJob(t, faucet, id)
+		if job.State != openJobFailed {
+			t.Fatalf("channel open not failed: %+v", job)
+		}
+	}
+	if spent() != 0 {
+		t.Fatalf("budget not given back: %d", spent())
+	}
+
+	lnd.mtx.Lock()
+	lnd.errOpenChannel = nil
+	lnd.mtx.Unlock()
+	job, chanErr = faucet.queueChannel(ctx, testClientIP, anonymousClient,
+		peers[1], 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobPending {
+		t.Fatalf("channel not pending: %+v", job)
+	}
+}
+
+// TestOpenJobTimeout ensures the opens that time out while negotiated keep
+// their tokens and budgets, as their funding transaction may have been
+// broadcast.
+func TestOpenJobTimeout(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	faucet.budget = newBudgetTracker(&config{ChannelBudgetDaily: 3e6})
+	faucet.opener.timeout = 100 * time.Millisecond
+
+	peer := fakePubKey(0x01)
+	lnd.addPeer(peer)
+	gate := make(chan struct{})
+	defer close(gate)
+	lnd.mtx.Lock()
+	lnd.openChannelGate = gate
+	lnd.mtx.Unlock()
+
+	job, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, peer, 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobFailed {
+		t.Fatalf("channel open not failed: %+v", job)
+	}
+
+	if spent := faucet.budget.status(time.Now())[0].Spent; spent != 1e6 {
+		t.Fatalf("budget given back: %d", spent)
+	}
+	keys := []rateLimitKey{
+		{clientIPKey, testClientIP},
+		{nodeKey, peer},
+	}
+	for _, key := range keys {
+		if faucet.limiter.check(OpenChannelAction, key) == nil {
+			t.Fatalf("tokens of %v given back", key)
+		}
+	}
+}
+
+// TestOpenQueueFull ensures new channel opens are refused once the queue is
+// full.
+func TestOpenQueueFull(t *testing.T) {
+	q := newOpenQueue(&config{OpenQueueSize: 1})
+
+	_, err := q.add(time.Now(), testClientIP, fakePubKey(0x01), "", 1e6, 0)
+	if err != nil {
+		t.Fatalf("unable to queue channel: %v", err)
+	}
+	_, err = q.add(time.Now(), testClientIP, fakePubKey(0x02), "", 1e6, 0)
+	if err != errOpenQueueFull {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestOpenQueueInflight ensures a single channel open with each node may be in
+// progress, repeated requests of the same client being coalesced.
+func TestOpenQueueInflight(t *testing.T) {
+	q := newOpenQueue(&config{OpenQueueSize: 10, OpenTimeout: time.Minute})
+	node := fakePubKey(0x01)
+
+	job, err := q.add(time.Now(), testClientIP, node, "", 1e6, 0)
+	if err != nil {
+		t.Fatalf("unable to queue channel: %v", err)
+	}
+
+	// The same request is coalesced into the job in progress.
+	dup, err := q.add(time.Now(), testClientIP, node, "", 1e6, 0)
+	if err != errOpenCoalesced || dup.ID != job.ID {
+		t.Fatalf("request not coalesced: %v %+v", err, dup)
+	}
+
+	// Different requests with the same node are refused.
+	_, err = q.add(time.Now(), testClientIP, node, "", 2e6, 0)
+	if err != errOpenInProgress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = q.add(time.Now(), "192.0.2.99", node, "", 1e6, 0)
+	if err != errOpenInProgress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = q.add(time.Now(), testClientIP, fakePubKey(0x02), "", 1e6, 0)
+	if err != nil {
+		t.Fatalf("unable to queue channel: %v", err)
+	}
+
+	// Once the open is no longer negotiated, new opens are accepted.
+	q.update(job.ID, func(j *openJob) {
+		j.State = openJobFailed
+	})
+	retry, err := q.add(time.Now(), testClientIP, node, "", 1e6, 0)
+	if err != nil || retry.ID == job.ID {
+		t.Fatalf("unable to queue channel: %v", err)
+	}
+	q.release(node, retry.ID)
+	_, err = q.add(time.Now(), "192.0.2.99", node, "", 1e6, 0)
+	if err != nil {
+		t.Fatalf("unable to queue channel: %v", err)
+	}
+
+	// Opens interrupted by a restart prevent new opens until their
+	// timeout elapsed.
+	restored := fakePubKey(0x03)
+	q.restore(&inflightOpen{
+		NodePubKey: restored,
+		JobID:      "interrupted",
+		Started:    time.Now(),
+	})
+	_, err = q.add(time.Now(), testClientIP, restored, "", 1e6, 0)
+	if err != errOpenInProgress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q.restore(&inflightOpen{
+		NodePubKey: restored,
+		JobID:      "interrupted",
+		Started:    time.Now().Add(-2 * time.Minute),
+	})
+	_, err = q.add(time.Now(), testClientIP, restored, "", 1e6, 0)
+	if err != nil {
+		t.Fatalf("unable to queue channel: %v", err)
+	}
+}
+
+// TestConcurrentOpens ensures simultaneous requests for a channel with the
+// same node open a single channel.
+func TestConcurrentOpens(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	peer := fakePubKey(0x01)
+	lnd.addPeer(peer)
+
+	const numRequests = 5
+	var wg sync.WaitGroup
+	jobs := make(chan *openJob, numRequests)
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			clientIP := fmt.Sprintf("198.51.%d.1", i)
+			job, chanErr := faucet.queueChannel(
+				context.Background(), clientIP, anonymousClient,
+				peer, 1e6, 0)
+			if chanErr == NoError {
+				jobs <- job
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(jobs)
+
+	var numJobs int
+	for job := range jobs {
+		numJobs++
+		waitOpenJob(t, faucet, job.ID)
+	}
+	if numJobs != 1 {
+		t.Fatalf("unexpected number of queued opens: %d", numJobs)
+	}
+
+	pending, err := lnd.PendingChannels(context.Background())
+	if err != nil {
+		t.Fatalf("unable to get pending channels: %v", err)
+	}
+	if len(pending.PendingOpenChannels) != 1 {
+		t.Fatalf("unexpected number of pending channels: %d",
+			len(pending.PendingOpenChannels))
+	}
+}
+
+// TestOpenQueueStart ensures jobs are only started while fewer opens than the
+// limit are in progress.
+func TestOpenQueueStart(t *testing.T) {
+	q := newOpenQueue(&config{OpenQueueSize: 10})
+
+	var jobs []*openJob
+	for i := byte(1); i <= 3; i++ {
+		job, err := q.add(time.Now(), testClientIP, fakePubKey(i), "", 1e6, 0)
+		if err != nil {
+			t.Fatalf("unable to queue channel: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if !q.start(jobs[0].ID, 0, 2) {
+		t.Fatalf("first job not started")
+	}
+	if q.job(jobs[0].ID).State != openJobNegotiating {
+		t.Fatalf("first job not negotiating")
+	}
+
+	// A pending channel and the job being negotiated reach the limit.
+	if q.start(jobs[1].ID, 1, 2) {
+		t.Fatalf("job started beyond the limit")
+	}
+	if q.job(jobs[1].ID).State != openJobQueued {
+		t.Fatalf("job no longer queued")
+	}
+	if !q.start(jobs[1].ID, 0, 2) {
+		t.Fatalf("second job not started")
+	}
+
+	// There is no limit without max_inflight_opens.
+	if !q.start(jobs[2].ID, 5, 0) {
+		t.Fatalf("job not started without limit")
+	}
+}
+
+// TestRestoreInflightOpens ensures the opens interrupted by a restart prevent
+// new opens with their node.
+func TestRestoreInflightOpens(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	interrupted := fakePubKey(0x01)
+	expired := fakePubKey(0x02)
+	lnd.addPeer(interrupted)
+	lnd.addPeer(expired)
+
+	for _, open := range []*inflightOpen{{
+		NodePubKey: interrupted,
+		JobID:      "interrupted",
+		Started:    time.Now(),
+	}, {
+		NodePubKey: expired,
+		JobID:      "expired",
+		Started:    time.Now().Add(-2 * defaultOpenTimeout),
+	}} {
+		if err := faucet.db.PutInflightOpen(open); err != nil {
+			t.Fatalf("unable to record open: %v", err)
+		}
+	}
+	if err := faucet.restoreInflightOpens(); err != nil {
+		t.Fatalf("unable to restore opens: %v", err)
+	}
+
+	_, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, interrupted, 1e6, 0)
+	if chanErr != OpenInProgress {
+		t.Fatalf("unexpected error: %v", chanErr.Code())
+	}
+
+	// Expired opens are forgotten.
+	var numOpens int
+	err := faucet.db.ForEachInflightOpen(func(*inflightOpen) {
+		numOpens++
+	})
+	if err != nil || numOpens != 1 {
+		t.Fatalf("unexpected recorded opens: %d (%v)", numOpens, err)
+	}
+	job, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, expired, 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr.Code())
+	}
+
+	// Finished opens are no longer recorded.
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.State != openJobPending {
+		t.Fatalf("channel not pending: %+v", job)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		numOpens = 0
+		err = faucet.db.ForEachInflightOpen(func(open *inflightOpen) {
+			if open.NodePubKey == expired {
+				numOpens++
+			}
+		})
+		if err != nil {
+			t.Fatalf("unable to read recorded opens: %v", err)
+		}
+		if numOpens == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("open still recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestRequiredConfs ensures the confirmations required by the peer of a channel
+// are estimated the way dcrlnd scales them by default.
+func TestRequiredConfs(t *testing.T) {
+	tests := []struct {
+		chanSize  int64
+		pushAmt   int64
+		chanConfs uint32
+		want      uint32
+	}{
+		{chanSize: 1e6, want: minChanConfs},
+		{chanSize: maxFundingAmount / 2, want: 3},
+		{chanSize: maxFundingAmount / 3, pushAmt: maxFundingAmount / 3,
+			want: 4},
+		{chanSize: maxFundingAmount, want: maxChanConfs},
+		{chanSize: maxFundingAmount, pushAmt: 1e8, want: maxChanConfs},
+		{chanSize: 1e6, chanConfs: 1, want: 1},
+		{chanSize: maxFundingAmount, chanConfs: 2, want: 2},
+	}
+
+	for _, test := range tests {
+		got := requiredConfs(test.chanSize, test.pushAmt, test.chanConfs)
+		if got != test.want {
+			t.Fatalf("requiredConfs(%d, %d, %d): got %d, want %d",
+				test.chanSize, test.pushAmt, test.chanConfs, got,
+				test.want)
+		}
+	}
+}
+
+// readJobEvent reads the next progress event from a job event stream.
+func readJobEvent(t *testing.T, events *bufio.Scanner) *openJob {
+	t.Helper()
+
+	var event string
+	for events.Scan() {
+		line := events.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+
+		case strings.HasPrefix(line, "data: "):
+			if event != "progress" {
+				t.Fatalf("unexpected event %q", event)
+			}
+			var job openJob
+			data := strings.TrimPrefix(line, "data: ")
+			if err := json.Unmarshal([]byte(data), &job); err != nil {
+				t.Fatalf("unable to decode event: %v", err)
+			}
+			return &job
+		}
+	}
+	t.Fatalf("event stream ended: %v", events.Err())
+	return nil
+}
+
+// TestOpenJobEvents ensures the event stream of a job reports the
+// confirmations of its funding transaction until its channel is open.
+func TestOpenJobEvents(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	peer := fakePubKey(0x01)
+	lnd.addPeer(peer)
+	job, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, peer, 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr)
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+	if job.RequiredConfs != minChanConfs {
+		t.Fatalf("unexpected required confirmations: %d",
+			job.RequiredConfs)
+	}
+
+	r := mux.NewRouter()
+	faucet.registerAPIRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + apiPathPrefix + "/channels/unknown/events")
+	if err != nil {
+		t.Fatalf("unable to request events: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + apiPathPrefix + "/channels/" +
+		job.ID + "/events")
+	if err != nil {
+		t.Fatalf("unable to request events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+
+	// The current state of the job is sent right away.
+	event := readJobEvent(t, events)
+	if event.State != openJobPending || event.NumConfs != 0 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// Every new confirmation of the funding transaction is reported.
+	lnd.confirmTx(job.FundingTxid, 2)
+	if err := faucet.updateOpenJobs(context.Background()); err != nil {
+		t.Fatalf("unable to update jobs: %v", err)
+	}
+	event = readJobEvent(t, events)
+	if event.State != openJobPending || event.NumConfs != 2 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// The stream ends once the channel is open.
+	lnd.activateChannel(job.ChannelPoint)
+	if err := faucet.updateOpenJobs(context.Background()); err != nil {
+		t.Fatalf("unable to update jobs: %v", err)
+	}
+	event = readJobEvent(t, events)
+	if event.State != openJobOpen || event.NumConfs != job.RequiredConfs {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	for events.Scan() {
+		if events.Text() != "" {
+			t.Fatalf("unexpected data after open: %q", events.Text())
+		}
+	}
+}
+
+// TestOpenJobPage exercises the page and the API following the progress of a
+// channel open.
+func TestOpenJobPage(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	peer := fakePubKey(0x01)
+	lnd.addPeer(peer)
+	job, chanErr := faucet.queueChannel(context.Background(), testClientIP,
+		anonymousClient, peer, 1e6, 0)
+	if chanErr != NoError {
+		t.Fatalf("unable to queue channel: %v", chanErr)
+	}
+	job = waitOpenJob(t, faucet, job.ID)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/channels/{id}", faucet.openJobPage).Methods("GET")
+	faucet.registerAPIRoutes(r)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantBody   []string
+	}{{
+		target:     openJobPath(job.ID),
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			"Channel successfully created",
+			job.ID,
+			"https://testnet.dcrdata.org/tx/" + job.FundingTxid,
+		},
+	}, {
+		target:     openJobPath("unknown"),
+		wantStatus: http.StatusNotFound,
+	}, {
+		target:     apiPathPrefix + "/channels/" + job.ID,
+		wantStatus: http.StatusOK,
+		wantBody: []string{
+			`"state":"pending"`,
+			`"funding_tx_url":"https://testnet.dcrdata.org/tx/` +
+				job.FundingTxid,
+		},
+	}, {
+		target:     apiPathPrefix + "/channels/unknown",
+		wantStatus: http.StatusNotFound,
+		wantBody:   []string{"unknown_job"},
+	}}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != test.wantStatus {
+			t.Fatalf("%s: unexpected status: got %d, want %d",
+				test.target, rec.Code, test.wantStatus)
+		}
+		body := rec.Body.String()
+		for _, want := range test.wantBody {
+			if !strings.Contains(body, want) {
+				t.Fatalf("%s: response body does not contain "+
+					"%q:\n%s", test.target, want, body)
+			}
+		}
+	}
+}
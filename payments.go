@@ -0,0 +1,138 @@
+package main
+
+import (
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+const (
+	// defaultPaymentLookupInterval is the default interval between two
+	// lookups of the status of a payment whose outcome was lost along
+	// with the call that sent it.
+	defaultPaymentLookupInterval = time.Minute
+)
+
+// unsettledPayment is a payment the node may still be sending although the
+// call that sent it failed. The funds and the tokens it took remain spent
+// until its status is known.
+type unsettledPayment struct {
+	clientIP   string
+	payReq     string
+	decoded    *lnrpc.PayReq
+	spend      budgetSpend
+	reservedAt time.Time
+
+	// keys are the rate limit keys whose tokens were taken by the
+	// payment.
+	keys []rateLimitKey
+}
+
+// paymentResolver looks up the status of the unsettled payment until it either
+// succeeded or failed. A payment that succeeded is recorded as if the call that
+// sent it had returned, while the funds and the tokens of one that failed are
+// given back.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) paymentResolver(p *unsettledPayment) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.paymentLookup)
+	defer ticker.Stop()
+
+	for {
+		// The node is given some time to register the payment, so
+		// that one it doesn't know about can be considered failed.
+		select {
+		case <-ticker.C:
+		case <-l.quit:
+			return
+		}
+
+		settled, err := l.settlePayment(p)
+		if err != nil {
+			log.Errorf("unable to look up payment %v: %v",
+				p.decoded.PaymentHash, err)
+		}
+		if settled {
+			return
+		}
+	}
+}
+
+// settlePayment looks up the status of the unsettled payment, settles its
+// reservations once the payment either succeeded or failed, and returns
+// whether it did.
+func (l *lightningFaucet) settlePayment(p *unsettledPayment) (bool, error) {
+	payments, err := l.lnd.ListPayments(ctxb)
+	if err != nil {
+		return false, err
+	}
+
+	var payment *lnrpc.Payment
+	for _, pmt := range payments {
+		if pmt.PaymentHash == p.decoded.PaymentHash {
+			payment = pmt
+			break
+		}
+	}
+
+	switch {
+	// A payment unknown to the node was never sent.
+	case payment == nil || payment.Status == lnrpc.Payment_FAILED:
+		log.Infof("Payment %v failed, giving back its funds",
+			p.decoded.PaymentHash)
+		l.budget.release(time.Now(), p.reservedAt, p.spend)
+		l.limiter.release(PayInvoiceAction, p.keys...)
+		return true, nil
+
+	case payment.Status == lnrpc.Payment_SUCCEEDED:
+		amount := dcrutil.Amount(p.spend.Payments + payment.Fee)
+		l.recordPayment(p.clientIP, p.payReq, p.decoded, amount,
+			payment.PaymentHash, payment.PaymentPreimage,
+			p.reservedAt)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// recordPayment records the payment of the decoded payment request on behalf
+// of the client at clientIP, whose funds were reserved at reservedAt and which
+// went through for the given amount, routing fees included.
+func (l *lightningFaucet) recordPayment(clientIP, payReq string,
+	decoded *lnrpc.PayReq, amount dcrutil.Amount, paymentHash,
+	preimage string, reservedAt time.Time) {
+
+	// The routing fees are only known once the payment went through, and
+	// are spent from the budgets along with the payment, as they are when
+	// the budgets are restored from the grant.
+	if fees := int64(amount) - decoded.GetNumAtoms(); fees > 0 {
+		l.budget.record(time.Now(), reservedAt, budgetSpend{
+			Payments: fees,
+		})
+	}
+
+	// Log the payment so it can be audited later.
+	log.Infof("Invoice has been paid destination=%v	description=%v amount=%v pay_hash:%v preimage=%v",
+		decoded.Destination, decoded.Description, amount, paymentHash,
+		preimage)
+
+	now := time.Now()
+	if l.pow != nil {
+		l.pow.observe(now)
+	}
+
+	err := l.db.AddPaymentGrant(&paymentGrant{
+		PaymentRequest: payReq,
+		Destination:    decoded.Destination,
+		PaymentHash:    paymentHash,
+		Amount:         int64(amount),
+		ClientIP:       clientIP,
+		Timestamp:      now,
+	})
+	if err != nil {
+		log.Errorf("unable to record payment grant: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// TestUnsettledPayment ensures a payment whose call failed keeps its funds and
+// tokens until its status is known, and is then either recorded or given back.
+func TestUnsettledPayment(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// known is whether the node knows about the payment, in the
+		// given status.
+		known  bool
+		status lnrpc.Payment_PaymentStatus
+
+		// wantSpent is the payment budget spent once the status was
+		// looked up, and wantGrant whether the payment was recorded.
+		wantSpent int64
+		wantGrant bool
+	}{{
+		name:      "never sent",
+		wantSpent: 0,
+	}, {
+		name:      "failed",
+		known:     true,
+		status:    lnrpc.Payment_FAILED,
+		wantSpent: 0,
+	}, {
+		name:      "in flight",
+		known:     true,
+		status:    lnrpc.Payment_IN_FLIGHT,
+		wantSpent: 500,
+	}, {
+		name:      "succeeded",
+		known:     true,
+		status:    lnrpc.Payment_SUCCEEDED,
+		wantSpent: 510,
+		wantGrant: true,
+	}}
+
+	for _, test := range tests {
+		lnd := newFakeBackend()
+		faucet, cleanUp := newTestFaucet(t, lnd)
+
+		lnd.addPayReq("lntdcr1pay", fakePubKey(0x01), 500)
+		lnd.mtx.Lock()
+		lnd.paymentFee = 10
+		lnd.errPayment = errors.New("connection reset")
+		lnd.mtx.Unlock()
+		if test.known {
+			lnd.addPayment("lntdcr1pay", test.status)
+		}
+
+		faucet.paymentLookup = 10 * time.Millisecond
+		faucet.budget = newBudgetTracker(&config{PaymentBudgetDaily: 1000})
+		spent := func() int64 {
+			return faucet.budget.status(time.Now())[0].Spent
+		}
+		clientKey := rateLimitKey{clientIPKey, testClientIP}
+		limited := func() bool {
+			return faucet.limiter.check(PayInvoiceAction, clientKey) != nil
+		}
+
+		_, chanErr := faucet.sendPayment(context.Background(),
+			testClientIP, anonymousClient, "lntdcr1pay")
+		if chanErr != PaymentStreamError {
+			t.Fatalf("%v: unexpected error: %v", test.name, chanErr)
+		}
+		if spent() != 500 || !limited() {
+			t.Fatalf("%v: payment not kept reserved", test.name)
+		}
+
+		// Give the resolver enough lookups to settle the payment.
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) && spent() != test.wantSpent {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		if spent() != test.wantSpent {
+			t.Fatalf("%v: unexpected spending: got %d, want %d",
+				test.name, spent(), test.wantSpent)
+		}
+		wantLimited := test.wantSpent != 0
+		if limited() != wantLimited {
+			t.Fatalf("%v: unexpected limit: got %v, want %v",
+				test.name, limited(), wantLimited)
+		}
+
+		var grants []paymentGrant
+		err := faucet.db.ForEachPaymentGrant(func(g *paymentGrant) bool {
+			grants = append(grants, *g)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("%v: unable to read payment grants: %v",
+				test.name, err)
+		}
+		if (len(grants) != 0) != test.wantGrant {
+			t.Fatalf("%v: unexpected grants: %+v", test.name, grants)
+		}
+		if test.wantGrant && grants[0].Amount != 510 {
+			t.Fatalf("%v: unexpected grant amount: %v", test.name,
+				grants[0].Amount)
+		}
+
+		cleanUp()
+	}
+}
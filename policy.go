@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+const (
+	// defaultMinChannelSize is the smallest channel the faucet extends to
+	// a peer unless the policy says otherwise.
+	defaultMinChannelSize int64 = 50000
+
+	// defaultMaxChannelSize is the largest channel the faucet creates
+	// unless the policy says otherwise, which is the largest channel
+	// dcrlnd accepts.
+	defaultMaxChannelSize int64 = maxFundingAmount
+
+	// defaultMaxInvoiceAtoms is the largest amount in atoms the faucet
+	// generates an invoice for unless the policy says otherwise.
+	defaultMaxInvoiceAtoms int64 = 2e7
+
+	// defaultMaxPaymentAtoms is the largest amount in atoms the faucet
+	// pays to an invoice unless the policy says otherwise.
+	defaultMaxPaymentAtoms int64 = 1000
+
+	// apiKeyHeader is the header through which API clients present their
+	// key.
+	apiKeyHeader = "X-API-Key"
+)
+
+// clientClass is the class of a client, which selects the limits that apply
+// to its requests.
+type clientClass string
+
+const (
+	// anonymousClient is the class of every client that isn't in any of
+	// the other classes.
+	anonymousClient clientClass = "anonymous"
+
+	// apiKeyClient is the class of the clients presenting one of the API
+	// keys of the policy.
+	apiKeyClient clientClass = "apikey"
+
+	// allowlistedClient is the class of the clients whose address is in
+	// the allowlist of the policy.
+	allowlistedClient clientClass = "allowlisted"
+)
+
+// clientClasses lists every client class.
+var clientClasses = []clientClass{anonymousClient, apiKeyClient,
+	allowlistedClient}
+
+// actionLimits are the smallest and largest amounts, in atoms, of an action,
+// along with the pace at which it may be performed.
+type actionLimits struct {
+	MinAmount int64 `json:"min_amount"`
+	MaxAmount int64 `json:"max_amount"`
+	rateLimit
+}
+
+// MinCoins returns the smallest amount of the action in DCR, formatted
+// without an exponent for display.
+func (a actionLimits) MinCoins() string {
+	return formatCoins(a.MinAmount)
+}
+
+// MaxCoins returns the largest amount of the action in DCR, formatted
+// without an exponent for display.
+func (a actionLimits) MaxCoins() string {
+	return formatCoins(a.MaxAmount)
+}
+
+// formatCoins formats the passed amount of atoms in DCR with as many decimals
+// as needed.
+func formatCoins(atoms int64) string {
+	return strconv.FormatFloat(dcrutil.Amount(atoms).ToCoin(), 'f', -1, 64)
+}
+
+// policyLimits are the limits of every action for a class of clients.
+type policyLimits struct {
+	Class           clientClass
+	OpenChannel     actionLimits
+	GenerateInvoice actionLimits
+	PayInvoice      actionLimits
+}
+
+// defaultPolicyLimits returns the limits applying to every client on every
+// network unless the policy says otherwise.
+func defaultPolicyLimits(class clientClass) policyLimits {
+	return policyLimits{
+		Class: class,
+		OpenChannel: actionLimits{
+			MinAmount: defaultMinChannelSize,
+			MaxAmount: defaultMaxChannelSize,
+		},
+		GenerateInvoice: actionLimits{MaxAmount: defaultMaxInvoiceAtoms},
+		PayInvoice:      actionLimits{MaxAmount: defaultMaxPaymentAtoms},
+	}
+}
+
+// action returns the limits of the given action, or nil if there is no such
+// action.
+func (p *policyLimits) action(action string) *actionLimits {
+	switch action {
+	case OpenChannelAction:
+		return &p.OpenChannel
+	case GenerateInvoiceAction:
+		return &p.GenerateInvoice
+	case PayInvoiceAction:
+		return &p.PayInvoice
+	}
+	return nil
+}
+
+// jsonDuration is a duration given as a string such as "30s" in a JSON
+// document.
+type jsonDuration time.Duration
+
+// MarshalJSON encodes the duration as a JSON string.
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON parses the duration from a JSON string.
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(duration)
+	return nil
+}
+
+// policyRule overrides the limits of an action. Empty matching fields match
+// anything, and only the amounts and the pace that are set are overridden.
+type policyRule struct {
+	Network   string        `json:"network"`
+	Class     clientClass   `json:"class"`
+	Action    string        `json:"action"`
+	MinAmount *int64        `json:"min_amount"`
+	MaxAmount *int64        `json:"max_amount"`
+	TimeLimit *jsonDuration `json:"time_limit"`
+	Burst     *int          `json:"burst"`
+}
+
+// matches returns whether the rule applies to the action of the given class
+// of clients on the given network.
+func (r *policyRule) matches(network string, class clientClass,
+	action string) bool {
+
+	return (r.Network == "" || r.Network == network) &&
+		(r.Class == "" || r.Class == class) &&
+		(r.Action == "" || r.Action == action)
+}
+
+// policyFile is the content of the policy file.
+type policyFile struct {
+	// APIKeys are the keys whose holders are in the apikey class.
+	APIKeys []string `json:"api_keys"`
+
+	// Allowlist are the networks, in CIDR notation, or addresses of the
+	// clients in the allowlisted class.
+	Allowlist []string `json:"allowlist"`
+
+	// Limits are applied in order over the default limits, so later
+	// rules take precedence over earlier ones.
+	Limits []policyRule `json:"limits"`
+}
+
+// faucetPolicy is a policy evaluated for the network of the faucet.
+type faucetPolicy struct {
+	apiKeys   [][]byte
+	allowlist proxyList
+	limits    map[clientClass]policyLimits
+}
+
+// newFaucetPolicy evaluates the rules of the passed policy file for the given
+// network.
+func newFaucetPolicy(file *policyFile, network string) (*faucetPolicy, error) {
+	allowlist, err := parseTrustedProxies(file.Allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("allowlist: %v", err)
+	}
+
+	p := &faucetPolicy{
+		allowlist: allowlist,
+		limits:    make(map[clientClass]policyLimits),
+	}
+	for _, key := range file.APIKeys {
+		if key == "" {
+			return nil, fmt.Errorf("api_keys: empty key")
+		}
+		p.apiKeys = append(p.apiKeys, []byte(key))
+	}
+
+	for i := range file.Limits {
+		if err := validatePolicyRule(&file.Limits[i]); err != nil {
+			return nil, fmt.Errorf("limits[%d]: %v", i, err)
+		}
+	}
+
+	for _, class := range clientClasses {
+		limits := defaultPolicyLimits(class)
+		for _, action := range []string{OpenChannelAction,
+			GenerateInvoiceAction, PayInvoiceAction} {
+
+			a := limits.action(action)
+			for _, rule := range file.Limits {
+				if !rule.matches(network, class, action) {
+					continue
+				}
+				if rule.MinAmount != nil {
+					a.MinAmount = *rule.MinAmount
+				}
+				if rule.MaxAmount != nil {
+					a.MaxAmount = *rule.MaxAmount
+				}
+				if rule.TimeLimit != nil {
+					a.TimeLimit = time.Duration(*rule.TimeLimit)
+				}
+				if rule.Burst != nil {
+					a.Burst = *rule.Burst
+				}
+			}
+
+			switch {
+			case a.MinAmount > a.MaxAmount:
+				return nil, fmt.Errorf("%s limits of %s clients: "+
+					"min_amount %d is above max_amount %d",
+					action, class, a.MinAmount, a.MaxAmount)
+
+			case action == OpenChannelAction &&
+				a.MaxAmount > maxFundingAmount:
+				return nil, fmt.Errorf("%s limits of %s clients: "+
+					"max_amount %d is above the largest "+
+					"channel of %d", action, class,
+					a.MaxAmount, int64(maxFundingAmount))
+			}
+		}
+		p.limits[class] = limits
+	}
+
+	return p, nil
+}
+
+// validatePolicyRule returns an error if the rule matches an unknown network,
+// class or action, or holds a negative amount, time limit or burst.
+func validatePolicyRule(r *policyRule) error {
+	switch r.Network {
+	case "", "mainnet", "testnet", "simnet":
+	default:
+		return fmt.Errorf("unknown network %q", r.Network)
+	}
+
+	switch r.Class {
+	case "", anonymousClient, apiKeyClient, allowlistedClient:
+	default:
+		return fmt.Errorf("unknown class %q", r.Class)
+	}
+
+	switch r.Action {
+	case "", OpenChannelAction, GenerateInvoiceAction, PayInvoiceAction:
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if (r.MinAmount != nil && *r.MinAmount < 0) ||
+		(r.MaxAmount != nil && *r.MaxAmount < 0) {
+		return fmt.Errorf("amounts cannot be < 0")
+	}
+	if (r.TimeLimit != nil && *r.TimeLimit < 0) ||
+		(r.Burst != nil && *r.Burst < 0) {
+		return fmt.Errorf("time_limit and burst cannot be < 0")
+	}
+	return nil
+}
+
+// loadPolicyFile reads the policy file at the given path. An empty path
+// yields an empty policy, which applies the default limits to everyone.
+func loadPolicyFile(path string) (*policyFile, error) {
+	file := new(policyFile)
+	if path == "" {
+		return file, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, file); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// policyEngine evaluates the limits of the actions of the faucet. Its policy
+// is loaded from the policy file on startup and whenever it is reloaded.
+type policyEngine struct {
+	path    string
+	network string
+
+	mtx    sync.RWMutex
+	policy *faucetPolicy
+}
+
+// newPolicyEngine returns a policy engine enforcing the policy file of the
+// passed config on the given network.
+func newPolicyEngine(cfg *config, network string) (*policyEngine, error) {
+	e := &policyEngine{
+		path:    cleanAndExpandPath(cfg.PolicyFile),
+		network: network,
+	}
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// reload loads the policy file again. The current policy is kept when the
+// file is invalid.
+func (e *policyEngine) reload() error {
+	file, err := loadPolicyFile(e.path)
+	if err != nil {
+		return fmt.Errorf("unable to load policy: %v", err)
+	}
+	policy, err := newFaucetPolicy(file, e.network)
+	if err != nil {
+		return fmt.Errorf("invalid policy: %v", err)
+	}
+
+	e.mtx.Lock()
+	e.policy = policy
+	e.mtx.Unlock()
+	return nil
+}
+
+// classify returns the class of the client at clientIP making the request.
+// Allowlisted clients are in that class whether they present an API key or
+// not.
+func (e *policyEngine) classify(r *http.Request, clientIP string) clientClass {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+
+	if e.policy.allowlist.trusted(clientIP) {
+		return allowlistedClient
+	}
+
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		for _, apiKey := range e.policy.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), apiKey) == 1 {
+				return apiKeyClient
+			}
+		}
+	}
+
+	return anonymousClient
+}
+
+// limits returns the limits of every action for the given class of clients.
+func (e *policyEngine) limits(class clientClass) policyLimits {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+
+	return e.policy.limits[class]
+}
+
+// rate returns the pace at which clients of the given class may perform the
+// action.
+func (e *policyEngine) rate(class clientClass, action string) rateLimit {
+	limits := e.limits(class)
+	return limits.action(action).rateLimit
+}
+
+// checkAmount returns the error reported to a client of the given class
+// requesting the action for amount atoms, or NoError if the policy allows it.
+func (e *policyEngine) checkAmount(class clientClass, action string,
+	amount int64) ChanCreationError {
+
+	limits := e.limits(class)
+	a := limits.action(action)
+
+	switch action {
+	case OpenChannelAction:
+		switch {
+		case amount < a.MinAmount:
+			return ChannelTooSmall
+		case amount > a.MaxAmount:
+			return ChannelTooLarge
+		}
+
+	case GenerateInvoiceAction:
+		switch {
+		case amount < a.MinAmount:
+			return AmountTooSmall
+		case amount > a.MaxAmount:
+			return InvoiceAmountTooHigh
+		}
+
+	case PayInvoiceAction:
+		switch {
+		case amount < a.MinAmount:
+			return AmountTooSmall
+		case amount > a.MaxAmount:
+			return ErrorPaymentAmount
+		}
+	}
+
+	return NoError
+}
+
+// requestLimits returns the limits applying to the client making the request,
+// which are the anonymous ones when its address can't be found.
+func (l *lightningFaucet) requestLimits(r *http.Request) policyLimits {
+	clientIP, _ := getRealIP(r, l.trustedProxies)
+	return l.policy.limits(l.policy.classify(r, clientIP))
+}
+
+// policyReloader reloads the policy whenever the faucet receives a SIGHUP.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) policyReloader() {
+	defer l.wg.Done()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := l.policy.reload(); err != nil {
+				log.Errorf("%v, keeping the current policy", err)
+				continue
+			}
+			log.Infof("Reloaded policy from %v", l.policy.path)
+
+		case <-l.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// int64Ptr returns a pointer to the passed amount.
+func int64Ptr(amt int64) *int64 {
+	return &amt
+}
+
+// TestNewFaucetPolicy ensures the rules of a policy are layered over the
+// default limits in order, and that invalid policies are refused.
+func TestNewFaucetPolicy(t *testing.T) {
+	hour := jsonDuration(time.Hour)
+	negative := jsonDuration(-time.Second)
+	burst := 5
+
+	tests := []struct {
+		name    string
+		file    policyFile
+		class   clientClass
+		want    policyLimits
+		wantErr bool
+	}{{
+		name:  "defaults",
+		class: anonymousClient,
+		want:  defaultPolicyLimits(anonymousClient),
+	}, {
+		name: "every action and class",
+		file: policyFile{Limits: []policyRule{{
+			MaxAmount: int64Ptr(1e6),
+		}}},
+		class: apiKeyClient,
+		want: policyLimits{
+			Class: apiKeyClient,
+			OpenChannel: actionLimits{
+				MinAmount: defaultMinChannelSize,
+				MaxAmount: 1e6,
+			},
+			GenerateInvoice: actionLimits{MaxAmount: 1e6},
+			PayInvoice:      actionLimits{MaxAmount: 1e6},
+		},
+	}, {
+		name: "later rules take precedence",
+		file: policyFile{Limits: []policyRule{{
+			Action:    OpenChannelAction,
+			MinAmount: int64Ptr(1e5),
+			MaxAmount: int64Ptr(1e7),
+		}, {
+			Class:     allowlistedClient,
+			Action:    OpenChannelAction,
+			MaxAmount: int64Ptr(1e8),
+		}}},
+		class: allowlistedClient,
+		want: policyLimits{
+			Class:           allowlistedClient,
+			OpenChannel:     actionLimits{MinAmount: 1e5, MaxAmount: 1e8},
+			GenerateInvoice: actionLimits{MaxAmount: defaultMaxInvoiceAtoms},
+			PayInvoice:      actionLimits{MaxAmount: defaultMaxPaymentAtoms},
+		},
+	}, {
+		name: "other class",
+		file: policyFile{Limits: []policyRule{{
+			Class:     allowlistedClient,
+			MaxAmount: int64Ptr(1e6),
+		}}},
+		class: anonymousClient,
+		want:  defaultPolicyLimits(anonymousClient),
+	}, {
+		name: "other network",
+		file: policyFile{Limits: []policyRule{{
+			Network:   "mainnet",
+			Action:    PayInvoiceAction,
+			MaxAmount: int64Ptr(0),
+		}}},
+		class: anonymousClient,
+		want:  defaultPolicyLimits(anonymousClient),
+	}, {
+		name: "pace of a class",
+		file: policyFile{Limits: []policyRule{{
+			Class:     apiKeyClient,
+			Action:    PayInvoiceAction,
+			TimeLimit: &hour,
+			Burst:     &burst,
+		}}},
+		class: apiKeyClient,
+		want: policyLimits{
+			Class: apiKeyClient,
+			OpenChannel: actionLimits{
+				MinAmount: defaultMinChannelSize,
+				MaxAmount: defaultMaxChannelSize,
+			},
+			GenerateInvoice: actionLimits{MaxAmount: defaultMaxInvoiceAtoms},
+			PayInvoice: actionLimits{
+				MaxAmount: defaultMaxPaymentAtoms,
+				rateLimit: rateLimit{TimeLimit: time.Hour, Burst: 5},
+			},
+		},
+	}, {
+		name: "unknown network",
+		file: policyFile{Limits: []policyRule{{
+			Network: "regnet",
+		}}},
+		wantErr: true,
+	}, {
+		name: "unknown class",
+		file: policyFile{Limits: []policyRule{{
+			Class: "vip",
+		}}},
+		wantErr: true,
+	}, {
+		name: "unknown action",
+		file: policyFile{Limits: []policyRule{{
+			Action: "closechannel",
+		}}},
+		wantErr: true,
+	}, {
+		name: "negative amount",
+		file: policyFile{Limits: []policyRule{{
+			MinAmount: int64Ptr(-1),
+		}}},
+		wantErr: true,
+	}, {
+		name: "negative time limit",
+		file: policyFile{Limits: []policyRule{{
+			TimeLimit: &negative,
+		}}},
+		wantErr: true,
+	}, {
+		name: "min above max",
+		file: policyFile{Limits: []policyRule{{
+			Action:    GenerateInvoiceAction,
+			MinAmount: int64Ptr(1000),
+			MaxAmount: int64Ptr(100),
+		}}},
+		wantErr: true,
+	}, {
+		name: "channel above funding limit",
+		file: policyFile{Limits: []policyRule{{
+			Action:    OpenChannelAction,
+			MaxAmount: int64Ptr(maxFundingAmount + 1),
+		}}},
+		wantErr: true,
+	}, {
+		name: "invalid allowlist",
+		file: policyFile{
+			Allowlist: []string{"10.0.0.0/33"},
+		},
+		wantErr: true,
+	}, {
+		name: "empty api key",
+		file: policyFile{
+			APIKeys: []string{""},
+		},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		policy, err := newFaucetPolicy(&test.file, "testnet")
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got := policy.limits[test.class]; got != test.want {
+			t.Errorf("%s: unexpected limits: got %+v, want %+v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestPolicyEngine ensures clients are classified by their address and API
+// key, and that their requests are checked against the limits of their class.
+func TestPolicyEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.json")
+	writePolicy := func(file *policyFile) {
+		b, err := json.Marshal(file)
+		if err != nil {
+			t.Fatalf("unable to encode policy: %v", err)
+		}
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatalf("unable to write policy: %v", err)
+		}
+	}
+	timeLimit := jsonDuration(10 * time.Second)
+	burst := 3
+	writePolicy(&policyFile{
+		APIKeys:   []string{"secret"},
+		Allowlist: []string{"10.0.0.0/8"},
+		Limits: []policyRule{{
+			Class:     allowlistedClient,
+			TimeLimit: &timeLimit,
+			Burst:     &burst,
+		}, {
+			Class:     anonymousClient,
+			Action:    OpenChannelAction,
+			MaxAmount: int64Ptr(1e6),
+		}, {
+			Class:     apiKeyClient,
+			Action:    OpenChannelAction,
+			MaxAmount: int64Ptr(1e7),
+		}, {
+			Action:    GenerateInvoiceAction,
+			MinAmount: int64Ptr(100),
+		}},
+	})
+
+	engine, err := newPolicyEngine(&config{PolicyFile: path}, "testnet")
+	if err != nil {
+		t.Fatalf("unable to create policy engine: %v", err)
+	}
+
+	classTests := []struct {
+		clientIP string
+		apiKey   string
+		want     clientClass
+	}{
+		{clientIP: "192.0.2.1", want: anonymousClient},
+		{clientIP: "192.0.2.1", apiKey: "wrong", want: anonymousClient},
+		{clientIP: "192.0.2.1", apiKey: "secret", want: apiKeyClient},
+		{clientIP: "10.1.2.3", want: allowlistedClient},
+		{clientIP: "10.1.2.3", apiKey: "secret", want: allowlistedClient},
+	}
+	for _, test := range classTests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.apiKey != "" {
+			r.Header.Set(apiKeyHeader, test.apiKey)
+		}
+		got := engine.classify(r, test.clientIP)
+		if got != test.want {
+			t.Errorf("%s with key %q: got class %s, want %s",
+				test.clientIP, test.apiKey, got, test.want)
+		}
+	}
+
+	checkTests := []struct {
+		class  clientClass
+		action string
+		amount int64
+		want   ChanCreationError
+	}{
+		{anonymousClient, OpenChannelAction, defaultMinChannelSize - 1, ChannelTooSmall},
+		{anonymousClient, OpenChannelAction, 1e6, NoError},
+		{anonymousClient, OpenChannelAction, 1e6 + 1, ChannelTooLarge},
+		{apiKeyClient, OpenChannelAction, 1e7, NoError},
+		{apiKeyClient, OpenChannelAction, 1e7 + 1, ChannelTooLarge},
+		{allowlistedClient, OpenChannelAction, defaultMaxChannelSize, NoError},
+		{anonymousClient, GenerateInvoiceAction, 99, AmountTooSmall},
+		{anonymousClient, GenerateInvoiceAction, defaultMaxInvoiceAtoms + 1, InvoiceAmountTooHigh},
+		{anonymousClient, PayInvoiceAction, defaultMaxPaymentAtoms, NoError},
+		{anonymousClient, PayInvoiceAction, defaultMaxPaymentAtoms + 1, ErrorPaymentAmount},
+	}
+	for _, test := range checkTests {
+		got := engine.checkAmount(test.class, test.action, test.amount)
+		if got != test.want {
+			t.Errorf("%s %s of %d: got %v, want %v", test.class,
+				test.action, test.amount, got.Code(),
+				test.want.Code())
+		}
+	}
+
+	// The pace of the actions comes from the policy file, and is left to
+	// the rate limiter otherwise.
+	rate := engine.rate(allowlistedClient, PayInvoiceAction)
+	if rate != (rateLimit{TimeLimit: 10 * time.Second, Burst: 3}) {
+		t.Fatalf("unexpected pace of allowlisted clients: %+v", rate)
+	}
+	if rate := engine.rate(anonymousClient, PayInvoiceAction); rate != (rateLimit{}) {
+		t.Fatalf("unexpected pace of anonymous clients: %+v", rate)
+	}
+
+	// An invalid policy is refused by a reload while the current one is
+	// kept.
+	writePolicy(&policyFile{Limits: []policyRule{{Class: "vip"}}})
+	if err := engine.reload(); err == nil {
+		t.Fatalf("expected invalid policy to be refused")
+	}
+	if got := engine.limits(apiKeyClient).OpenChannel.MaxAmount; got != 1e7 {
+		t.Fatalf("policy changed by invalid reload: %d", got)
+	}
+
+	writePolicy(&policyFile{})
+	if err := engine.reload(); err != nil {
+		t.Fatalf("unable to reload policy: %v", err)
+	}
+	if got := engine.limits(apiKeyClient); got != defaultPolicyLimits(apiKeyClient) {
+		t.Fatalf("unexpected limits after reload: %+v", got)
+	}
+}
+
+// TestAmountLimitsCoins ensures limits are shown in DCR without an exponent.
+func TestAmountLimitsCoins(t *testing.T) {
+	limits := defaultPolicyLimits(anonymousClient)
+	tests := []struct {
+		got, want string
+	}{
+		{limits.OpenChannel.MinCoins(), "0.0005"},
+		{limits.OpenChannel.MaxCoins(), "10.73741823"},
+		{limits.GenerateInvoice.MaxCoins(), "0.2"},
+		{limits.PayInvoice.MaxCoins(), "0.00001"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("got %s, want %s", test.got, test.want)
+		}
+	}
+}
+
+// TestAPIInfoLimits ensures the limits returned by the API are those of the
+// class of the requesting client.
+func TestAPIInfoLimits(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	faucet.policy.policy, _ = newFaucetPolicy(&policyFile{
+		APIKeys: []string{"secret"},
+		Limits: []policyRule{{
+			Class:     apiKeyClient,
+			Action:    OpenChannelAction,
+			MaxAmount: int64Ptr(1e8),
+		}},
+	}, "testnet")
+
+	for _, test := range []struct {
+		apiKey  string
+		want    clientClass
+		wantMax int64
+	}{
+		{"", anonymousClient, defaultMaxChannelSize},
+		{"secret", apiKeyClient, 1e8},
+	} {
+		req := httptest.NewRequest(http.MethodGet, apiPathPrefix+"/info",
+			nil)
+		req.RemoteAddr = testClientIP + ":12345"
+		if test.apiKey != "" {
+			req.Header.Set(apiKeyHeader, test.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		faucet.apiInfo(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", rec.Code)
+		}
+
+		var info struct {
+			Limits apiLimits `json:"limits"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+			t.Fatalf("unable to decode info: %v", err)
+		}
+		if info.Limits.Class != test.want ||
+			info.Limits.MaxChannelSize != test.wantMax {
+
+			t.Fatalf("unexpected limits: %+v", info.Limits)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPoWMaxDifficulty is the default upper bound, in leading zero
+	// bits, of the difficulty of the proof of work challenges.
+	defaultPoWMaxDifficulty = 28
+
+	// defaultPoWLoadWindow is the default window over which the load of
+	// the faucet is measured.
+	defaultPoWLoadWindow = 10 * time.Minute
+
+	// defaultPoWLoadStep is the default number of actions performed
+	// within the load window that add a bit of difficulty.
+	defaultPoWLoadStep = 10
+
+	// defaultPoWChanSizeStep is the default channel size, in atoms, that
+	// adds a bit of difficulty.
+	defaultPoWChanSizeStep = 2e8
+
+	// powTTL is how long a proof of work challenge may be solved for after
+	// being issued.
+	powTTL = 10 * time.Minute
+)
+
+// powActions are the actions that require a proof of work from API clients.
+var powActions = map[string]bool{
+	OpenChannelAction: true,
+	PayInvoiceAction:  true,
+}
+
+// powChallenge is a signed proof of work challenge. A solution is any string
+// such that the SHA256 hash of "<challenge>:<solution>" starts with at least
+// Difficulty zero bits.
+type powChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty uint   `json:"difficulty"`
+	Expires    int64  `json:"expires"`
+}
+
+// spentTokens remembers the single use tokens that were already redeemed
+// until they expire.
+type spentTokens struct {
+	mtx    sync.Mutex
+	tokens map[string]time.Time
+}
+
+// newSpentTokens returns an empty set of spent tokens.
+func newSpentTokens() *spentTokens {
+	return &spentTokens{
+		tokens: make(map[string]time.Time),
+	}
+}
+
+// spend marks the token as spent until it expires, returning false if it was
+// already spent.
+func (s *spentTokens) spend(token string, expiresAt time.Time) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	for t, exp := range s.tokens {
+		if now.After(exp) {
+			delete(s.tokens, t)
+		}
+	}
+	if _, ok := s.tokens[token]; ok {
+		return false
+	}
+	s.tokens[token] = expiresAt
+	return true
+}
+
+// powIssuer issues and verifies the proof of work challenges required from
+// API clients. The difficulty of a challenge grows with the number of
+// actions recently performed by the faucet and with the size of the
+// requested channel.
+type powIssuer struct {
+	key []byte
+
+	baseDifficulty uint
+	maxDifficulty  uint
+	loadWindow     time.Duration
+	loadStep       int
+	chanSizeStep   int64
+
+	spent *spentTokens
+
+	// recent holds the time of the actions performed within the load
+	// window, oldest first.
+	mtx    sync.Mutex
+	recent []time.Time
+}
+
+// newPoWIssuer returns a proof of work issuer using the parameters of the
+// passed config, or nil if proofs of work are disabled.
+func newPoWIssuer(cfg *config) (*powIssuer, error) {
+	if cfg.PoWDifficulty == 0 {
+		return nil, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &powIssuer{
+		key:            key,
+		baseDifficulty: cfg.PoWDifficulty,
+		maxDifficulty:  cfg.PoWMaxDifficulty,
+		loadWindow:     cfg.PoWLoadWindow,
+		loadStep:       cfg.PoWLoadStep,
+		chanSizeStep:   cfg.PoWChanSizeStep,
+		spent:          newSpentTokens(),
+	}, nil
+}
+
+// observe records that an action was performed, increasing the load.
+func (p *powIssuer) observe(t time.Time) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.recent = append(p.recent, t)
+}
+
+// load returns the number of actions performed within the load window.
+func (p *powIssuer) load() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	cutOff := time.Now().Add(-p.loadWindow)
+	i := 0
+	for i < len(p.recent) && p.recent[i].Before(cutOff) {
+		i++
+	}
+	p.recent = p.recent[i:]
+
+	return len(p.recent)
+}
+
+// difficulty returns the difficulty of a challenge for an action of the given
+// amount.
+func (p *powIssuer) difficulty(amount int64) uint {
+	difficulty := p.baseDifficulty
+	if p.loadStep > 0 {
+		difficulty += uint(p.load() / p.loadStep)
+	}
+	if p.chanSizeStep > 0 && amount > 0 {
+		difficulty += uint(amount / p.chanSizeStep)
+	}
+	if difficulty > p.maxDifficulty {
+		difficulty = p.maxDifficulty
+	}
+	return difficulty
+}
+
+// sign returns the signature of the passed challenge fields.
+func (p *powIssuer) sign(fields string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(fields))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newChallenge issues a challenge for the action of up to amount atoms.
+func (p *powIssuer) newChallenge(action string,
+	amount int64) (*powChallenge, error) {
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	difficulty := p.difficulty(amount)
+	expires := time.Now().Add(powTTL).Unix()
+	fields := fmt.Sprintf("%s.%d.%d.%d.%x", action, amount, difficulty,
+		expires, nonce)
+
+	return &powChallenge{
+		Challenge:  fields + "." + p.sign(fields),
+		Difficulty: difficulty,
+		Expires:    expires,
+	}, nil
+}
+
+// leadingZeroBits returns the number of leading zero bits of b.
+func leadingZeroBits(b []byte) uint {
+	var n uint
+	for _, c := range b {
+		if c != 0 {
+			return n + uint(bits.LeadingZeros8(c))
+		}
+		n += 8
+	}
+	return n
+}
+
+// verify returns an error unless solution solves a challenge issued for the
+// action of at least amount atoms that hasn't expired nor been solved before.
+func (p *powIssuer) verify(challenge, solution, action string,
+	amount int64) error {
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 6 {
+		return fmt.Errorf("malformed challenge")
+	}
+	fields := strings.Join(parts[:5], ".")
+	if !hmac.Equal([]byte(parts[5]), []byte(p.sign(fields))) {
+		return fmt.Errorf("invalid challenge signature")
+	}
+
+	if parts[0] != action {
+		return fmt.Errorf("challenge issued for %v", parts[0])
+	}
+	maxAmount, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed challenge amount: %v", err)
+	}
+	if amount > maxAmount {
+		return fmt.Errorf("challenge issued for up to %d atoms",
+			maxAmount)
+	}
+	difficulty, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return fmt.Errorf("malformed challenge difficulty: %v", err)
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed challenge expiry: %v", err)
+	}
+	expiresAt := time.Unix(expires, 0)
+	if time.Now().After(expiresAt) {
+		return fmt.Errorf("challenge expired")
+	}
+
+	hash := sha256.Sum256([]byte(challenge + ":" + solution))
+	if leadingZeroBits(hash[:]) < uint(difficulty) {
+		return fmt.Errorf("solution does not meet difficulty %d",
+			difficulty)
+	}
+
+	if !p.spent.spend(challenge, expiresAt) {
+		return fmt.Errorf("challenge already solved")
+	}
+
+	return nil
+}
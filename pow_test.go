@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solvePoW brute forces a solution to the passed challenge.
+func solvePoW(challenge *powChallenge) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		hash := sha256.Sum256([]byte(challenge.Challenge + ":" + solution))
+		if leadingZeroBits(hash[:]) >= challenge.Difficulty {
+			return solution
+		}
+	}
+}
+
+// TestPoWDifficulty ensures the difficulty grows with the load and channel
+// size up to the maximum.
+func TestPoWDifficulty(t *testing.T) {
+	p, err := newPoWIssuer(&config{
+		PoWDifficulty:    8,
+		PoWMaxDifficulty: 12,
+		PoWLoadWindow:    time.Minute,
+		PoWLoadStep:      2,
+		PoWChanSizeStep:  1e8,
+	})
+	if err != nil {
+		t.Fatalf("unable to create issuer: %v", err)
+	}
+
+	if d := p.difficulty(0); d != 8 {
+		t.Fatalf("expected base difficulty 8, got %d", d)
+	}
+	if d := p.difficulty(2e8); d != 10 {
+		t.Fatalf("expected difficulty 10 for 2 DCR, got %d", d)
+	}
+
+	now := time.Now()
+	p.observe(now.Add(-2 * time.Minute))
+	for i := 0; i < 4; i++ {
+		p.observe(now)
+	}
+	if d := p.difficulty(0); d != 10 {
+		t.Fatalf("expected difficulty 10 under load, got %d", d)
+	}
+	if d := p.difficulty(10e8); d != 12 {
+		t.Fatalf("expected capped difficulty 12, got %d", d)
+	}
+}
+
+// TestPoWVerify ensures solutions are only accepted once, for the action and
+// amount they were issued for.
+func TestPoWVerify(t *testing.T) {
+	p, err := newPoWIssuer(&config{
+		PoWDifficulty:    8,
+		PoWMaxDifficulty: 8,
+		PoWLoadWindow:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unable to create issuer: %v", err)
+	}
+
+	challenge, err := p.newChallenge(OpenChannelAction, 1e6)
+	if err != nil {
+		t.Fatalf("unable to create challenge: %v", err)
+	}
+	solution := solvePoW(challenge)
+
+	tests := []struct {
+		name     string
+		solution string
+		action   string
+		amount   int64
+		valid    bool
+	}{
+		{"wrong action", solution, PayInvoiceAction, 1e6, false},
+		{"larger amount", solution, OpenChannelAction, 2e6, false},
+		{"valid", solution, OpenChannelAction, 1e6, true},
+		{"replayed", solution, OpenChannelAction, 1e6, false},
+	}
+	for _, test := range tests {
+		err := p.verify(challenge.Challenge, test.solution,
+			test.action, test.amount)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got err %v, want valid %v", test.name,
+				err, test.valid)
+		}
+	}
+
+	// Raising the amount of a challenge invalidates its signature.
+	forged, err := p.newChallenge(OpenChannelAction, 1e6)
+	if err != nil {
+		t.Fatalf("unable to create challenge: %v", err)
+	}
+	forged.Challenge = strings.Replace(forged.Challenge, ".1000000.",
+		".9000000.", 1)
+	err = p.verify(forged.Challenge, solvePoW(forged), OpenChannelAction,
+		9e6)
+	if err == nil {
+		t.Fatal("forged challenge accepted")
+	}
+}
+
+// TestAPIPoWClasses ensures only anonymous API clients must solve a proof of
+// work challenge to open a channel.
+func TestAPIPoWClasses(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	pow, err := newPoWIssuer(&config{
+		PoWDifficulty:    8,
+		PoWMaxDifficulty: 8,
+		PoWLoadWindow:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unable to create issuer: %v", err)
+	}
+	faucet.pow = pow
+
+	policy, err := newFaucetPolicy(&policyFile{
+		APIKeys: []string{"secret"},
+	}, "testnet")
+	if err != nil {
+		t.Fatalf("unable to create policy: %v", err)
+	}
+	faucet.policy.mtx.Lock()
+	faucet.policy.policy = policy
+	faucet.policy.mtx.Unlock()
+
+	tests := []struct {
+		name       string
+		peer       string
+		apiKey     string
+		wantStatus int
+		wantCode   string
+	}{{
+		name:       "anonymous",
+		peer:       fakePubKey(0x01),
+		wantStatus: http.StatusForbidden,
+		wantCode:   PoWFailed.Code(),
+	}, {
+		name:       "api key",
+		peer:       fakePubKey(0x02),
+		apiKey:     "secret",
+		wantStatus: http.StatusAccepted,
+	}}
+	for _, test := range tests {
+		lnd.addPeer(test.peer)
+
+		body := `{"node_pubkey": "` + test.peer + `", "amount": 1000000}`
+		req := httptest.NewRequest(http.MethodPost,
+			apiPathPrefix+"/channels", strings.NewReader(body))
+		req.RemoteAddr = testClientIP + ":12345"
+		if test.apiKey != "" {
+			req.Header.Set(apiKeyHeader, test.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		faucet.apiOpenChannel(rec, req)
+		if rec.Code != test.wantStatus {
+			t.Fatalf("%s: unexpected status: %d", test.name, rec.Code)
+		}
+		if test.wantCode == "" {
+			continue
+		}
+
+		var resp apiErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: unable to decode error: %v", test.name, err)
+		}
+		if resp.Error.Code != test.wantCode {
+			t.Fatalf("%s: unexpected error: %+v", test.name,
+				resp.Error)
+		}
+	}
+}
+
+// TestAPIPoWPayAmount ensures the proof of work required to pay an invoice
+// must have been issued for at least the amount of the invoice.
+func TestAPIPoWPayAmount(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	pow, err := newPoWIssuer(&config{
+		PoWDifficulty:    8,
+		PoWMaxDifficulty: 8,
+		PoWLoadWindow:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unable to create issuer: %v", err)
+	}
+	faucet.pow = pow
+
+	lnd.addPayReq("lntdcr1pay", fakePubKey(0x02), 500)
+
+	tests := []struct {
+		name       string
+		amount     int64
+		wantStatus int
+	}{
+		{"amount too low", 499, http.StatusForbidden},
+		{"no amount", 0, http.StatusForbidden},
+		{"invoice amount", 500, http.StatusOK},
+	}
+	for _, test := range tests {
+		challenge, err := pow.newChallenge(PayInvoiceAction, test.amount)
+		if err != nil {
+			t.Fatalf("unable to create challenge: %v", err)
+		}
+		body, err := json.Marshal(&apiPaymentRequest{
+			PaymentRequest: "lntdcr1pay",
+			apiPoWSolution: apiPoWSolution{
+				PoWChallenge: challenge.Challenge,
+				PoWSolution:  solvePoW(challenge),
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to encode request: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost,
+			apiPathPrefix+"/payments", strings.NewReader(string(body)))
+		req.RemoteAddr = testClientIP + ":12345"
+		rec := httptest.NewRecorder()
+		faucet.apiPayInvoice(rec, req)
+		if rec.Code != test.wantStatus {
+			t.Fatalf("%s: unexpected status: %d: %s", test.name,
+				rec.Code, rec.Body)
+		}
+	}
+}
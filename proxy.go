@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultTrustedProxies are the proxies trusted when userealip is set but no
+// trusted_proxies are configured, which covers a reverse proxy running on
+// the same host as the faucet.
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// proxyList is a list of the networks of the reverse proxies whose forwarded
+// headers are trusted.
+type proxyList []*net.IPNet
+
+// parseTrustedProxies parses a list of CIDR networks. Plain addresses are
+// accepted as single host networks.
+func parseTrustedProxies(proxies []string) (proxyList, error) {
+	nets, err := parseNetworks("trusted proxy", proxies)
+	return proxyList(nets), err
+}
+
+// parseNetworks parses a list of CIDR networks, described as desc in errors.
+// Plain addresses are accepted as single host networks.
+func parseNetworks(desc string, addrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s %q", desc,
+					addr)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", desc, addr,
+				err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// trusted returns whether the passed address belongs to a trusted proxy.
+func (p proxyList) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeForwardedAddr strips the quotes, brackets and port that may
+// surround an address found in a forwarded header.
+func normalizeForwardedAddr(addr string) string {
+	addr = strings.Trim(strings.TrimSpace(addr), `"`)
+	if strings.HasPrefix(addr, "[") {
+		if i := strings.Index(addr, "]"); i != -1 {
+			return addr[1:i]
+		}
+		return addr
+	}
+
+	// Only IPv4 addresses may carry a port without brackets.
+	if strings.Count(addr, ":") == 1 {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+	}
+	return addr
+}
+
+// forwardedFor returns the addresses found in the for parameters of the
+// RFC 7239 Forwarded headers of the request, from the first hop to the last.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h[http.CanonicalHeaderKey("Forwarded")] {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				i := strings.Index(pair, "=")
+				if i == -1 {
+					continue
+				}
+				name := strings.TrimSpace(pair[:i])
+				if !strings.EqualFold(name, "for") {
+					continue
+				}
+				hops = append(hops,
+					normalizeForwardedAddr(pair[i+1:]))
+			}
+		}
+	}
+	return hops
+}
+
+// xForwardedFor returns the addresses found in the X-Forwarded-For headers of
+// the request, from the first hop to the last.
+func xForwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		for _, hop := range strings.Split(value, ",") {
+			hop = normalizeForwardedAddr(hop)
+			if hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// getRealIP returns the clients IP address. Forwarded headers are only
+// honored when the request comes from one of the trusted proxies, in which
+// case the rightmost hop that isn't a trusted proxy is the client. The
+// Forwarded header is preferred, followed by X-Forwarded-For and finally
+// X-Real-IP. When trustedProxies is empty the request.RemoteAddr field is
+// always returned.
+func getRealIP(r *http.Request, trustedProxies proxyList) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	if !trustedProxies.trusted(host) {
+		return host, nil
+	}
+
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header)
+	}
+	if len(hops) == 0 {
+		xRealIP := http.CanonicalHeaderKey("X-Real-IP")
+		if xrip := normalizeForwardedAddr(r.Header.Get(xRealIP)); xrip != "" {
+			hops = []string{xrip}
+		}
+	}
+	if len(hops) == 0 {
+		log.Warnf("Request from trusted proxy %v has no forwarded "+
+			"headers, using RemoteAddr instead", host)
+		return host, nil
+	}
+
+	// Walk the hops from the closest to the farthest one. Every hop added
+	// by a trusted proxy can be relied upon, so the first untrusted one
+	// is the client. If every hop is trusted the farthest one is the
+	// client.
+	for i := len(hops) - 1; i > 0; i-- {
+		if !trustedProxies.trusted(hops[i]) {
+			return hops[i], nil
+		}
+	}
+	return hops[0], nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestGetRealIP ensures forwarded headers are only honored when sent by a
+// trusted proxy and that the rightmost untrusted hop is the client.
+func TestGetRealIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{
+		"10.0.0.0/8", "2001:db8:ffff::/48", "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("unable to parse trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    proxyList
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{{
+		name:       "headers disabled",
+		remoteAddr: "127.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Real-Ip": {"198.51.100.1"},
+		},
+		want: "127.0.0.1",
+	}, {
+		name:       "untrusted remote",
+		proxies:    trusted,
+		remoteAddr: "203.0.113.5:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For": {"198.51.100.1"},
+			"X-Real-Ip":       {"198.51.100.1"},
+		},
+		want: "203.0.113.5",
+	}, {
+		name:       "trusted remote without headers",
+		proxies:    trusted,
+		remoteAddr: "127.0.0.1:1234",
+		want:       "127.0.0.1",
+	}, {
+		name:       "x-real-ip",
+		proxies:    trusted,
+		remoteAddr: "127.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Real-Ip": {"198.51.100.1"},
+		},
+		want: "198.51.100.1",
+	}, {
+		name:       "spoofed x-forwarded-for",
+		proxies:    trusted,
+		remoteAddr: "127.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For": {"192.0.2.66, 198.51.100.1, 10.1.1.1"},
+		},
+		want: "198.51.100.1",
+	}, {
+		name:       "x-forwarded-for over several headers",
+		proxies:    trusted,
+		remoteAddr: "127.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For": {"192.0.2.66", "198.51.100.1"},
+		},
+		want: "198.51.100.1",
+	}, {
+		name:       "every hop trusted",
+		proxies:    trusted,
+		remoteAddr: "127.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"},
+		},
+		want: "10.2.2.2",
+	}, {
+		name:       "forwarded preferred",
+		proxies:    trusted,
+		remoteAddr: "[2001:db8:ffff::1]:1234",
+		headers: map[string][]string{
+			"Forwarded":       {`for=192.0.2.66, for="[2001:db8:1::7]:4711";proto=https, For=10.1.1.1`},
+			"X-Forwarded-For": {"192.0.2.77"},
+		},
+		want: "2001:db8:1::7",
+	}, {
+		name:       "forwarded ipv4 with port",
+		proxies:    trusted,
+		remoteAddr: "127.0.0.1:1234",
+		headers: map[string][]string{
+			"Forwarded": {`for="198.51.100.1:80";by=10.1.1.1`},
+		},
+		want: "198.51.100.1",
+	}}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for name, values := range test.headers {
+			r.Header[name] = values
+		}
+
+		got, err := getRealIP(r, test.proxies)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
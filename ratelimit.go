@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimitBurst is the default number of actions an entity may
+	// perform back to back before having to wait for the time limit. A
+	// burst of one matches the behavior of a plain time limit.
+	defaultRateLimitBurst = 1
+
+	// defaultRateLimitMaxKeys is the default number of entities the rate
+	// limiter keeps track of at any given time.
+	defaultRateLimitMaxKeys = 100000
+
+	// rateLimitEvictInterval is how often the rate limiter forgets about
+	// the entities that have been idle long enough to have a full bucket.
+	rateLimitEvictInterval = time.Minute
+
+	// keyCapRejection is the rejection reason reported when an action was
+	// refused because the rate limiter is tracking too many entities.
+	keyCapRejection = "key_cap"
+)
+
+// rateLimitKeyKind identifies what a rate limit key refers to.
+type rateLimitKeyKind string
+
+const (
+	// clientIPKey limits the actions performed by a single client, as
+	// identified by its address aggregated to the configured prefix.
+	clientIPKey rateLimitKeyKind = "client"
+
+	// subnetKey limits the actions performed by all of the clients of a
+	// larger subnet, so that rotating addresses within it doesn't bypass
+	// the limits.
+	subnetKey rateLimitKeyKind = "subnet"
+
+	// nodeKey limits the channels opened towards a single node,
+	// regardless of who requests them.
+	nodeKey rateLimitKeyKind = "node"
+
+	// destinationKey limits the payments made to a single payment request
+	// destination, regardless of who requests them.
+	destinationKey rateLimitKeyKind = "destination"
+)
+
+// rateLimitKey identifies an entity that is subject to rate limiting.
+type rateLimitKey struct {
+	kind  rateLimitKeyKind
+	value string
+}
+
+// String returns a human readable description of the key.
+func (k rateLimitKey) String() string {
+	return fmt.Sprintf("%s(%s)", k.kind, k.value)
+}
+
+// actionKey identifies an entity performing a specific action.
+type actionKey struct {
+	action string
+	key    rateLimitKey
+}
+
+// rateLimit is the pace at which a class of clients may perform an action: a
+// token is earned every TimeLimit, up to Burst tokens. Zero fields stand for
+// the time limit of the action and the burst of the rate limiter.
+type rateLimit struct {
+	TimeLimit time.Duration
+	Burst     int
+}
+
+// tokenBucket holds the tokens available to a single entity for a single
+// action. Tokens are refilled lazily, whenever the bucket is looked at.
+type tokenBucket struct {
+	tokens     float64
+	lastUpdate time.Time
+
+	// timeLimit and burst are the pace of the bucket, which is the one of
+	// the last action recorded in it.
+	timeLimit time.Duration
+	burst     float64
+}
+
+// refill adds the tokens earned by the bucket since its last update.
+func (b *tokenBucket) refill(now time.Time) {
+	if !now.After(b.lastUpdate) {
+		return
+	}
+
+	elapsed := now.Sub(b.lastUpdate)
+	b.tokens += float64(elapsed) / float64(b.timeLimit)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastUpdate = now
+}
+
+// setPace refills the bucket at its current pace until now, then switches it
+// to the passed one.
+func (b *tokenBucket) setPace(timeLimit time.Duration, burst float64,
+	now time.Time) {
+
+	b.refill(now)
+	b.timeLimit = timeLimit
+	b.burst = burst
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+}
+
+// rateLimiterStats is a snapshot of the state of the rate limiter.
+type rateLimiterStats struct {
+	TrackedKeys int    `json:"tracked_keys"`
+	MaxKeys     int    `json:"max_keys"`
+	Evicted     uint64 `json:"evicted"`
+
+	// Rejections counts the refused actions by action and by the kind of
+	// key that caused the rejection.
+	Rejections map[string]map[string]uint64 `json:"rejections"`
+}
+
+// rateLimitEntry describes an entity that is currently refused an action.
+type rateLimitEntry struct {
+	Action  string    `json:"action"`
+	Kind    string    `json:"kind"`
+	Key     string    `json:"key"`
+	Tokens  float64   `json:"tokens"`
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// rateLimiter is a token bucket rate limiter. Every entity gets a bucket of
+// burst tokens per action, performing the action consumes a token and a
+// token is given back every time limit of the action. Buckets that are full
+// carry no information and are periodically evicted, and the total number of
+// buckets is capped so that the memory used by the limiter is bounded no
+// matter how many distinct clients the faucet sees.
+type rateLimiter struct {
+	timeLimits map[string]time.Duration
+	burst      float64
+	maxKeys    int
+
+	// kindBursts overrides the burst for specific kinds of keys.
+	kindBursts map[rateLimitKeyKind]float64
+
+	mtx        sync.Mutex
+	buckets    map[actionKey]*tokenBucket
+	evicted    uint64
+	rejections map[string]map[string]uint64
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newRateLimiter returns a rate limiter enforcing the passed time limits,
+// keyed by action. Every entity may perform burst actions back to back and
+// at most maxKeys entities are tracked at once.
+func newRateLimiter(timeLimits map[string]time.Duration, burst,
+	maxKeys int) *rateLimiter {
+
+	return &rateLimiter{
+		timeLimits: timeLimits,
+		burst:      float64(burst),
+		maxKeys:    maxKeys,
+		kindBursts: make(map[rateLimitKeyKind]float64),
+		buckets:    make(map[actionKey]*tokenBucket),
+		rejections: make(map[string]map[string]uint64),
+		quit:       make(chan struct{}),
+	}
+}
+
+// setBurst overrides the burst of every bucket of the given kind of key.
+//
+// NOTE: This MUST be called before the rate limiter is used.
+func (r *rateLimiter) setBurst(kind rateLimitKeyKind, burst int) {
+	r.kindBursts[kind] = float64(burst)
+}
+
+// pace returns the time limit and burst of the bucket of the given kind of key
+// for the action performed at the passed rate limit. The bursts set for
+// specific kinds of keys take precedence over the one of the rate limit.
+func (r *rateLimiter) pace(action string, limit rateLimit,
+	kind rateLimitKeyKind) (time.Duration, float64) {
+
+	timeLimit := r.timeLimit(action)
+	if limit.TimeLimit > 0 {
+		timeLimit = limit.TimeLimit
+	}
+	burst := r.burst
+	if limit.Burst > 0 {
+		burst = float64(limit.Burst)
+	}
+	if kindBurst, ok := r.kindBursts[kind]; ok {
+		burst = kindBurst
+	}
+	return timeLimit, burst
+}
+
+// Start launches the goroutine that evicts idle entities.
+func (r *rateLimiter) Start() {
+	r.wg.Add(1)
+	go r.evictIdle()
+}
+
+// Stop stops the eviction goroutine and waits for it to exit.
+func (r *rateLimiter) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+// timeLimit returns the time it takes to refill a single token for the given
+// action.
+func (r *rateLimiter) timeLimit(action string) time.Duration {
+	return r.timeLimits[action]
+}
+
+// window returns the time it takes for the largest empty bucket of the given
+// action to become full again at the default pace. Actions older than that
+// have no effect on the limits recorded at that pace.
+func (r *rateLimiter) window(action string) time.Duration {
+	burst := r.burst
+	for _, kindBurst := range r.kindBursts {
+		if kindBurst > burst {
+			burst = kindBurst
+		}
+	}
+	return time.Duration(burst * float64(r.timeLimit(action)))
+}
+
+// reject counts an action refused because of the passed reason.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (r *rateLimiter) reject(action, reason string) {
+	if r.rejections[action] == nil {
+		r.rejections[action] = make(map[string]uint64)
+	}
+	r.rejections[action][reason]++
+}
+
+// check returns an error if any of the passed keys has run out of tokens for
+// the action at the default pace, or if the action would require tracking a
+// new key while the limiter is already tracking as many keys as it may. No
+// token is consumed, so callers performing the action must use reserve
+// instead.
+func (r *rateLimiter) check(action string, keys ...rateLimitKey) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.checkLocked(action, rateLimit{}, time.Now(), keys)
+}
+
+// reserve consumes a token from the bucket of every passed key if none of them
+// has run out of tokens for the action performed at the passed rate limit,
+// and returns the error of check otherwise. Checking and consuming the tokens
+// at once ensures concurrent requests can't all pass the check before any of
+// them is recorded. Tokens of actions that end up not being performed must be
+// given back with release.
+func (r *rateLimiter) reserve(action string, limit rateLimit,
+	keys ...rateLimitKey) error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := time.Now()
+	if err := r.checkLocked(action, limit, now, keys); err != nil {
+		return err
+	}
+	r.recordLocked(action, limit, now, keys)
+
+	return nil
+}
+
+// release gives back a token reserved for the action to the bucket of every
+// passed key.
+func (r *rateLimiter) release(action string, keys ...rateLimitKey) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		// Buckets that were evicted in the meantime were full, so
+		// there is nothing to give back.
+		b, found := r.buckets[actionKey{action, key}]
+		if !found {
+			continue
+		}
+
+		b.refill(now)
+		b.tokens++
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+}
+
+// checkLocked implements check for the action performed at the passed rate
+// limit at the time now.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (r *rateLimiter) checkLocked(action string, limit rateLimit,
+	now time.Time, keys []rateLimitKey) error {
+
+	newKeys := 0
+	for _, key := range keys {
+		timeLimit, burst := r.pace(action, limit, key.kind)
+		if timeLimit <= 0 {
+			continue
+		}
+
+		b, found := r.buckets[actionKey{action, key}]
+		if !found {
+			newKeys++
+			continue
+		}
+
+		b.refill(now)
+		tokens := b.tokens
+		if tokens > burst {
+			tokens = burst
+		}
+		if tokens < 1 {
+			r.reject(action, string(key.kind))
+			coolDownTime := time.Duration((1 - tokens) *
+				float64(timeLimit))
+			return fmt.Errorf("%v may only %v every %v. Wait "+
+				"another %v.", key, action, timeLimit,
+				coolDownTime)
+		}
+	}
+
+	// Refuse to track any new key once the cap is reached. Evicting keys
+	// that still hold information instead would let anyone reset their
+	// own limits by flooding the faucet with requests from other keys.
+	if newKeys > 0 && len(r.buckets)+newKeys > r.maxKeys {
+		r.reject(action, keyCapRejection)
+		return fmt.Errorf("the faucet is handling too many clients, "+
+			"try to %v again later", action)
+	}
+
+	return nil
+}
+
+// record consumes a token from the bucket of every passed key at the given
+// time, at the default pace. Times earlier than the last update of a bucket,
+// as found when restoring past actions, still consume a token.
+func (r *rateLimiter) record(action string, t time.Time,
+	keys ...rateLimitKey) {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.recordLocked(action, rateLimit{}, t, keys)
+}
+
+// recordLocked consumes a token from the bucket of every passed key for the
+// action performed at the passed rate limit at the time t.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (r *rateLimiter) recordLocked(action string, limit rateLimit,
+	t time.Time, keys []rateLimitKey) {
+
+	for _, key := range keys {
+		timeLimit, burst := r.pace(action, limit, key.kind)
+		if timeLimit <= 0 {
+			continue
+		}
+
+		k := actionKey{action, key}
+		b, found := r.buckets[k]
+		if !found {
+			b = &tokenBucket{
+				tokens:     burst,
+				lastUpdate: t,
+				timeLimit:  timeLimit,
+				burst:      burst,
+			}
+			r.buckets[k] = b
+		}
+
+		b.setPace(timeLimit, burst, t)
+		b.tokens--
+		if b.tokens < 0 {
+			b.tokens = 0
+		}
+	}
+}
+
+// evict removes every bucket that would be full at the given time. Such
+// buckets behave exactly like the ones of entities never seen before.
+func (r *rateLimiter) evict(now time.Time) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var numEvicted int
+	for k, b := range r.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(r.buckets, k)
+			numEvicted++
+		}
+	}
+	r.evicted += uint64(numEvicted)
+
+	return numEvicted
+}
+
+// evictIdle periodically evicts the buckets of idle entities.
+//
+// NOTE: This MUST be run as a goroutine.
+func (r *rateLimiter) evictIdle() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(rateLimitEvictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if n := r.evict(now); n > 0 {
+				log.Debugf("Evicted %d idle rate limit keys", n)
+			}
+
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// limited returns the entities that are currently refused an action, up to
+// max of them, sorted by the time they may retry.
+func (r *rateLimiter) limited(max int) []*rateLimitEntry {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := time.Now()
+	var entries []*rateLimitEntry
+	for k, b := range r.buckets {
+		b.refill(now)
+		if b.tokens >= 1 {
+			continue
+		}
+		coolDownTime := time.Duration((1 - b.tokens) *
+			float64(b.timeLimit))
+		entries = append(entries, &rateLimitEntry{
+			Action:  k.action,
+			Kind:    string(k.key.kind),
+			Key:     k.key.value,
+			Tokens:  b.tokens,
+			RetryAt: now.Add(coolDownTime),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RetryAt.Before(entries[j].RetryAt)
+	})
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	return entries
+}
+
+// clear forgets every bucket of the passed key, lifting its limits for all
+// actions. It returns the number of buckets removed.
+func (r *rateLimiter) clear(key rateLimitKey) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var numCleared int
+	for k := range r.buckets {
+		if k.key == key {
+			delete(r.buckets, k)
+			numCleared++
+		}
+	}
+	return numCleared
+}
+
+// stats returns a snapshot of the state of the rate limiter.
+func (r *rateLimiter) stats() *rateLimiterStats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rejections := make(map[string]map[string]uint64, len(r.rejections))
+	for action, reasons := range r.rejections {
+		rejections[action] = make(map[string]uint64, len(reasons))
+		for reason, n := range reasons {
+			rejections[action][reason] = n
+		}
+	}
+
+	return &rateLimiterStats{
+		TrackedKeys: len(r.buckets),
+		MaxKeys:     r.maxKeys,
+		Evicted:     r.evicted,
+		Rejections:  rejections,
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRateLimiterBurst ensures that an entity may perform burst actions back
+// to back and is then limited until a token is refilled.
+func TestRateLimiterBurst(t *testing.T) {
+	const timeLimit = time.Minute
+	r := newRateLimiter(map[string]time.Duration{
+		OpenChannelAction: timeLimit,
+	}, 2, 10)
+	key := rateLimitKey{clientIPKey, testClientIP}
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := r.check(OpenChannelAction, key); err != nil {
+			t.Fatalf("action %d refused: %v", i, err)
+		}
+		r.record(OpenChannelAction, now, key)
+	}
+	if err := r.check(OpenChannelAction, key); err == nil {
+		t.Fatal("action allowed past the burst")
+	}
+
+	// Other actions and other keys are limited independently.
+	if err := r.check(GenerateInvoiceAction, key); err != nil {
+		t.Fatalf("unlimited action refused: %v", err)
+	}
+	other := rateLimitKey{clientIPKey, "192.0.2.2"}
+	if err := r.check(OpenChannelAction, other); err != nil {
+		t.Fatalf("other key refused: %v", err)
+	}
+
+	// Once a time limit has passed a single token is available again.
+	r.buckets[actionKey{OpenChannelAction, key}].lastUpdate =
+		now.Add(-timeLimit)
+	if err := r.check(OpenChannelAction, key); err != nil {
+		t.Fatalf("refilled action refused: %v", err)
+	}
+
+	stats := r.stats()
+	if got := stats.Rejections[OpenChannelAction][string(clientIPKey)]; got != 1 {
+		t.Fatalf("expected 1 rejection, got %d", got)
+	}
+}
+
+// TestRateLimiterReserve ensures that concurrent reservations can't get past
+// the burst and that released tokens may be reserved again.
+func TestRateLimiterReserve(t *testing.T) {
+	const timeLimit = time.Hour
+	r := newRateLimiter(map[string]time.Duration{
+		PayInvoiceAction: timeLimit,
+	}, 2, 10)
+	client := rateLimitKey{clientIPKey, testClientIP}
+	dest := rateLimitKey{destinationKey, fakePubKey(0x01)}
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		reserved int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.reserve(PayInvoiceAction, rateLimit{}, client, dest) == nil {
+				mtx.Lock()
+				reserved++
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 2 {
+		t.Fatalf("expected 2 reservations, got %d", reserved)
+	}
+
+	// A released token is available again, but never past the burst.
+	r.release(PayInvoiceAction, client, dest)
+	if err := r.reserve(PayInvoiceAction, rateLimit{}, client, dest); err != nil {
+		t.Fatalf("released token refused: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		r.release(PayInvoiceAction, client)
+	}
+	b := r.buckets[actionKey{PayInvoiceAction, client}]
+	if b.tokens > 2 {
+		t.Fatalf("bucket refilled past the burst: %v", b.tokens)
+	}
+
+	// The destination still has no token left.
+	if err := r.reserve(PayInvoiceAction, rateLimit{}, client, dest); err == nil {
+		t.Fatal("reservation allowed past the burst")
+	}
+	if b.tokens < 2 {
+		t.Fatal("tokens consumed by a refused reservation")
+	}
+
+	// Releasing tokens of unknown keys is a no-op.
+	r.release(PayInvoiceAction, rateLimitKey{clientIPKey, "192.0.2.2"})
+	if n := r.stats().TrackedKeys; n != 2 {
+		t.Fatalf("expected 2 tracked keys, got %d", n)
+	}
+}
+
+// TestRateLimiterPace ensures the actions reserved at the pace of a class of
+// clients use its burst and time limit, while the others keep the defaults.
+func TestRateLimiterPace(t *testing.T) {
+	const timeLimit = time.Minute
+	r := newRateLimiter(map[string]time.Duration{
+		OpenChannelAction: timeLimit,
+	}, 1, 10)
+	r.setBurst(subnetKey, 1)
+	client := rateLimitKey{clientIPKey, testClientIP}
+	other := rateLimitKey{clientIPKey, "192.0.2.2"}
+
+	pace := rateLimit{TimeLimit: time.Hour, Burst: 3}
+	for i := 0; i < 3; i++ {
+		if err := r.reserve(OpenChannelAction, pace, client); err != nil {
+			t.Fatalf("action %d refused: %v", i, err)
+		}
+	}
+	if err := r.reserve(OpenChannelAction, pace, client); err == nil {
+		t.Fatal("action allowed past the burst of the class")
+	}
+	b := r.buckets[actionKey{OpenChannelAction, client}]
+	if b.timeLimit != time.Hour || b.burst != 3 {
+		t.Fatalf("unexpected pace of the bucket: %v %v", b.timeLimit,
+			b.burst)
+	}
+
+	// Released tokens are capped at the burst of the class.
+	for i := 0; i < 5; i++ {
+		r.release(OpenChannelAction, client)
+	}
+	if b.tokens != 3 {
+		t.Fatalf("unexpected tokens after release: %v", b.tokens)
+	}
+
+	// Other clients keep the default pace, and the bursts of specific
+	// kinds of keys take precedence over the one of the class.
+	if err := r.reserve(OpenChannelAction, rateLimit{}, other); err != nil {
+		t.Fatalf("action refused: %v", err)
+	}
+	if err := r.reserve(OpenChannelAction, rateLimit{}, other); err == nil {
+		t.Fatal("action allowed past the default burst")
+	}
+	subnet := rateLimitKey{subnetKey, "192.0.2.0/24"}
+	if err := r.reserve(OpenChannelAction, pace, subnet); err != nil {
+		t.Fatalf("action refused: %v", err)
+	}
+	if err := r.reserve(OpenChannelAction, pace, subnet); err == nil {
+		t.Fatal("action allowed past the burst of the subnet")
+	}
+
+	// Buckets are evicted once full at their own pace, so only the one
+	// refilled at the default pace is.
+	if err := r.reserve(OpenChannelAction, pace, client); err != nil {
+		t.Fatalf("action refused: %v", err)
+	}
+	if n := r.evict(time.Now().Add(2 * timeLimit)); n != 1 {
+		t.Fatalf("expected 1 evicted key, got %d", n)
+	}
+	if _, ok := r.buckets[actionKey{OpenChannelAction, other}]; ok {
+		t.Fatal("bucket refilled at the default pace not evicted")
+	}
+}
+
+// TestRateLimiterEviction ensures that full buckets are evicted and that no
+// new keys are tracked once the cap is reached.
+func TestRateLimiterEviction(t *testing.T) {
+	const timeLimit = time.Minute
+	r := newRateLimiter(map[string]time.Duration{
+		PayInvoiceAction: timeLimit,
+	}, 1, 2)
+	client := rateLimitKey{clientIPKey, testClientIP}
+	dest := rateLimitKey{destinationKey, fakePubKey(0x01)}
+	other := rateLimitKey{clientIPKey, "192.0.2.2"}
+
+	now := time.Now()
+	r.record(PayInvoiceAction, now.Add(-timeLimit/2), client)
+	r.record(PayInvoiceAction, now.Add(-2*timeLimit), dest)
+
+	err := r.check(PayInvoiceAction, other)
+	if err == nil {
+		t.Fatal("new key tracked past the cap")
+	}
+	stats := r.stats()
+	if got := stats.Rejections[PayInvoiceAction][keyCapRejection]; got != 1 {
+		t.Fatalf("expected 1 key cap rejection, got %d", got)
+	}
+
+	// Only the destination has a full bucket by now.
+	if n := r.evict(now); n != 1 {
+		t.Fatalf("expected 1 evicted key, got %d", n)
+	}
+	if err := r.check(PayInvoiceAction, client); err == nil {
+		t.Fatal("evicted a key that is still limited")
+	}
+	if err := r.check(PayInvoiceAction, other); err != nil {
+		t.Fatalf("new key refused after eviction: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+const (
+	// defaultMaxChannels is the default number of channels the faucet may
+	// have open or pending at any given time.
+	defaultMaxChannels = 100
+
+	// defaultRecycleInterval is the default interval between two runs of
+	// the channel recycler.
+	defaultRecycleInterval = time.Hour
+
+	// recycleOldest recycles the oldest faucet channels first.
+	recycleOldest = "oldest"
+
+	// recycleLeastUsed recycles the faucet channels with the fewest
+	// commitment updates first.
+	recycleLeastUsed = "leastused"
+
+	// numRecentCloses is the number of recent channel closes shown on the
+	// info page.
+	numRecentCloses = 10
+)
+
+// closeReason describes why the faucet closed a channel.
+type closeReason string
+
+const (
+	// closeReasonMaxAge is used for channels older than the maximum
+	// channel age.
+	closeReasonMaxAge closeReason = "max_age"
+
+	// closeReasonChannelCap is used for channels recycled to make room for
+	// new ones once the channel cap is reached.
+	closeReasonChannelCap closeReason = "channel_cap"
+
+	// closeReasonZombie is used for channels closed by the zombie
+	// channel sweeper.
+	closeReasonZombie closeReason = "zombie"
+
+	// closeReasonAdmin is used for channels closed through the admin API.
+	closeReasonAdmin closeReason = "admin"
+
+	// closeReasonWipe is used for channels closed by wipe_chans.
+	closeReasonWipe closeReason = "wipe"
+)
+
+// faucetChannel is an open channel that was opened by the faucet.
+type faucetChannel struct {
+	channel  *lnrpc.Channel
+	openedAt time.Time
+}
+
+// faucetChannels returns the open channels that were opened by the faucet and
+// aren't being closed, along with the number of channels opened by the faucet
+// that are still pending.
+func (l *lightningFaucet) faucetChannels(
+	ctx context.Context) ([]*faucetChannel, int, error) {
+
+	channels, err := l.lnd.ListChannels(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to list channels: %v", err)
+	}
+	pending, err := l.lnd.PendingChannels(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to list pending channels: %v",
+			err)
+	}
+
+	l.openChannelsMtx.Lock()
+	defer l.openChannelsMtx.Unlock()
+
+	var open []*faucetChannel
+	for _, channel := range channels {
+		op, err := strPointToOutPoint(channel.ChannelPoint)
+		if err != nil {
+			continue
+		}
+		if l.closer.closing(channel.ChannelPoint) {
+			continue
+		}
+		if openedAt, ok := l.openChannels[*op]; ok {
+			open = append(open, &faucetChannel{
+				channel:  channel,
+				openedAt: openedAt,
+			})
+		}
+	}
+
+	var numPending int
+	for _, channel := range pending.PendingOpenChannels {
+		op, err := strPointToOutPoint(channel.Channel.ChannelPoint)
+		if err != nil {
+			continue
+		}
+		if _, ok := l.openChannels[*op]; ok {
+			numPending++
+		}
+	}
+
+	return open, numPending, nil
+}
+
+// sortRecycleCandidates orders the channels in the order they are recycled
+// by the passed policy.
+func sortRecycleCandidates(channels []*faucetChannel, policy string) {
+	sort.SliceStable(channels, func(i, j int) bool {
+		a, b := channels[i], channels[j]
+		if policy == recycleLeastUsed &&
+			a.channel.NumUpdates != b.channel.NumUpdates {
+
+			return a.channel.NumUpdates < b.channel.NumUpdates
+		}
+		return a.openedAt.Before(b.openedAt)
+	})
+}
+
+// channelCapReached returns whether the faucet already has as many channels
+// as it may have. Channels still pending count towards the cap.
+func (l *lightningFaucet) channelCapReached(ctx context.Context) (bool, error) {
+	if l.cfg.MaxChannels <= 0 {
+		return false, nil
+	}
+
+	open, numPending, err := l.faucetChannels(ctx)
+	if err != nil {
+		return false, err
+	}
+	return len(open)+numPending >= l.cfg.MaxChannels, nil
+}
+
+// recyclerEnabled returns whether the channel recycler is running.
+func (l *lightningFaucet) recyclerEnabled() bool {
+	return l.cfg.MaxChannels > 0 || l.cfg.ChannelMaxAge > 0
+}
+
+// triggerRecycle asks the channel recycler to run as soon as possible without
+// waiting for it.
+func (l *lightningFaucet) triggerRecycle() {
+	select {
+	case l.recycleTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// channelRecycler is a goroutine that cooperatively closes the channels
+// opened by the faucet once they reach the maximum channel age, and recycles
+// channels to free a slot once the channel cap is reached. It runs every
+// recycle interval and whenever triggered.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) channelRecycler() {
+	defer l.wg.Done()
+
+	log.Infof("Channel recycler active, max channels: %d, max age: %v, "+
+		"policy: %v", l.cfg.MaxChannels, l.cfg.ChannelMaxAge,
+		l.cfg.RecyclePolicy)
+
+	ticker := time.NewTicker(l.cfg.RecycleInterval)
+	defer ticker.Stop()
+
+	l.recycleChannels()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.recycleTrigger:
+		case <-l.quit:
+			return
+		}
+
+		l.recycleChannels()
+	}
+}
+
+// recycleChannels cooperatively closes every faucet channel older than the
+// maximum channel age, then keeps closing channels in the order of the
+// recycle policy until there is room for a new one under the channel cap.
+func (l *lightningFaucet) recycleChannels() {
+	channels, numPending, err := l.faucetChannels(ctxb)
+	if err != nil {
+		log.Errorf("unable to fetch faucet channels: %v", err)
+		return
+	}
+	sortRecycleCandidates(channels, l.cfg.RecyclePolicy)
+
+	now := time.Now()
+	numChannels := len(channels) + numPending
+	for _, c := range channels {
+		var reason closeReason
+		switch {
+		case l.cfg.ChannelMaxAge > 0 &&
+			now.Sub(c.openedAt) > l.cfg.ChannelMaxAge:
+			reason = closeReasonMaxAge
+
+		case l.cfg.MaxChannels > 0 && numChannels >= l.cfg.MaxChannels:
+			reason = closeReasonChannelCap
+
+		default:
+			continue
+		}
+
+		log.Infof("Recycling ChannelPoint(%v) opened at %v: %v",
+			c.channel.ChannelPoint, c.openedAt, reason)
+
+		// The close manager keeps attempting to close the channel
+		// cooperatively if it can't be closed right away, so the
+		// channel no longer counts towards the cap either way.
+		req, err := l.requestClose(c.channel.ChannelPoint,
+			c.channel.RemotePubkey, reason, false)
+		if err != nil {
+			log.Errorf("unable to recycle ChannelPoint(%v): %v",
+				c.channel.ChannelPoint, err)
+			continue
+		}
+		numChannels--
+
+		if req.State == closeStateClosed {
+			log.Infof("Recycled ChannelPoint(%v), closing txid: %v",
+				c.channel.ChannelPoint, req.ClosingTxid)
+		}
+	}
+}
+
+// recentCloses returns up to limit of the most recent channel closes.
+func (l *lightningFaucet) recentCloses(limit int) ([]*channelClose, error) {
+	closes := []*channelClose{}
+	err := l.db.ForEachChannelClose(func(c *channelClose) bool {
+		closes = append(closes, c)
+		return len(closes) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return closes, nil
+}
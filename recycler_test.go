@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// addFaucetChannels adds a channel opened by the faucet for each of the
+// passed ages and returns their channel points, in the same order.
+func addFaucetChannels(t *testing.T, lnd *fakeBackend, l *lightningFaucet,
+	ages ...time.Duration) []string {
+
+	t.Helper()
+
+	now := time.Now()
+	chanPoints := make([]string, 0, len(ages))
+	for i, age := range ages {
+		lnd.addChannel(fakePubKey(byte(i+1)), 1e6)
+		chanPoint := lnd.channels[len(lnd.channels)-1].ChannelPoint
+		op, err := strPointToOutPoint(chanPoint)
+		if err != nil {
+			t.Fatalf("invalid channel point: %v", err)
+		}
+		l.openChannels[*op] = now.Add(-age)
+		chanPoints = append(chanPoints, chanPoint)
+	}
+	return chanPoints
+}
+
+// TestRecycleChannels ensures the recycler closes channels past the maximum
+// age and frees a slot once the channel cap is reached, recording why.
+func TestRecycleChannels(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	faucet.cfg.MaxChannels = 3
+	faucet.cfg.ChannelMaxAge = 30 * 24 * time.Hour
+	faucet.cfg.RecyclePolicy = recycleOldest
+
+	chanPoints := addFaucetChannels(t, lnd, faucet, time.Hour,
+		40*24*time.Hour, 2*time.Hour, 3*time.Hour)
+
+	// Channels not opened by the faucet are never recycled.
+	lnd.addChannel(fakePubKey(0x10), 1e6)
+
+	faucet.recycleChannels()
+
+	// The channel past the maximum age is closed first, then the oldest
+	// remaining one to get back below the cap.
+	closes, err := faucet.recentCloses(10)
+	if err != nil {
+		t.Fatalf("unable to fetch closes: %v", err)
+	}
+	if len(closes) != 2 {
+		t.Fatalf("expected 2 closes, got %d", len(closes))
+	}
+	want := map[string]closeReason{
+		chanPoints[1]: closeReasonMaxAge,
+		chanPoints[3]: closeReasonChannelCap,
+	}
+	for _, c := range closes {
+		if want[c.ChannelPoint] != c.Reason {
+			t.Fatalf("unexpected close of %v: %v", c.ChannelPoint,
+				c.Reason)
+		}
+		if c.Force {
+			t.Fatalf("channel %v force closed", c.ChannelPoint)
+		}
+	}
+	if len(lnd.channels) != 3 {
+		t.Fatalf("expected 3 remaining channels, got %d",
+			len(lnd.channels))
+	}
+}
+
+// TestRecycleLeastUsed ensures the least used channels are recycled first
+// when requested.
+func TestRecycleLeastUsed(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	faucet.cfg.MaxChannels = 2
+	faucet.cfg.RecyclePolicy = recycleLeastUsed
+
+	chanPoints := addFaucetChannels(t, lnd, faucet, 3*time.Hour, time.Hour)
+	lnd.channels[0].NumUpdates = 10
+
+	faucet.recycleChannels()
+
+	closes, err := faucet.recentCloses(10)
+	if err != nil {
+		t.Fatalf("unable to fetch closes: %v", err)
+	}
+	if len(closes) != 1 || closes[0].ChannelPoint != chanPoints[1] {
+		t.Fatalf("unexpected closes: %v", closes)
+	}
+}
+
+// TestChannelCapReached ensures new channels are refused once the faucet has
+// as many channels as it may have.
+func TestChannelCapReached(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	faucet.cfg.MaxChannels = 1
+	addFaucetChannels(t, lnd, faucet, time.Hour)
+
+	peer := fakePubKey(0x20)
+	lnd.addPeer(peer)
+	chanErr := faucet.checkChannelRequest(context.Background(),
+		testClientIP, anonymousClient, peer, "", 1e6, 0)
+	if chanErr != ChannelCapReached {
+		t.Fatalf("unexpected error: %v", chanErr)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+const (
+	// defaultZombieSweepInterval is the default interval between two
+	// zombie channel sweeps.
+	defaultZombieSweepInterval = time.Hour
+
+	// defaultZombieOfflineThreshold is the default time the peer of a
+	// channel must have been offline for the channel to be a zombie.
+	defaultZombieOfflineThreshold = 48 * time.Hour
+
+	// defaultZombieIdleThreshold is the default time a channel must have
+	// gone without any update for it to be a zombie.
+	defaultZombieIdleThreshold = 48 * time.Hour
+
+	// maxSweepReports is the number of sweep reports kept in memory.
+	maxSweepReports = 24
+)
+
+// zombieCandidate describes a channel found to be a zombie by a sweep. The
+// durations are in seconds.
+type zombieCandidate struct {
+	ChannelPoint string  `json:"channel_point"`
+	RemotePubKey string  `json:"remote_pubkey"`
+	Capacity     int64   `json:"capacity"`
+	LocalBalance int64   `json:"local_balance"`
+	OfflineFor   int64   `json:"offline_for"`
+	IdleFor      int64   `json:"idle_for"`
+	UptimeRatio  float64 `json:"uptime_ratio"`
+}
+
+// sweepFailure describes a zombie channel that couldn't be closed.
+type sweepFailure struct {
+	ChannelPoint string `json:"channel_point"`
+	Error        string `json:"error"`
+}
+
+// sweepReport describes the outcome of a single zombie channel sweep.
+type sweepReport struct {
+	Started        time.Time          `json:"started"`
+	Finished       time.Time          `json:"finished"`
+	DryRun         bool               `json:"dry_run"`
+	Error          string             `json:"error,omitempty"`
+	NumChannels    int                `json:"num_channels"`
+	Candidates     []*zombieCandidate `json:"candidates"`
+	Closed         []string           `json:"closed"`
+	Pending        []string           `json:"pending"`
+	Failed         []*sweepFailure    `json:"failed"`
+	AtomsReclaimed int64              `json:"atoms_reclaimed"`
+}
+
+// chanActivity tracks when the state of a channel last changed.
+type chanActivity struct {
+	numUpdates uint64
+	changedAt  time.Time
+}
+
+// zombieSweeper holds what the zombie channel sweeper learned about the
+// channels and peers of the node across sweeps, along with the reports of
+// the most recent sweeps.
+type zombieSweeper struct {
+	offlineThreshold time.Duration
+	idleThreshold    time.Duration
+	minUptimeRatio   float64
+	dryRun           bool
+
+	mtx sync.Mutex
+
+	// started is when the sweeper started watching the node. Peers it
+	// hasn't seen connected yet are assumed to have been offline since
+	// then.
+	started time.Time
+
+	// lastSeen is the last time each peer was seen connected.
+	lastSeen map[string]time.Time
+
+	// activity tracks the updates of each channel.
+	activity map[string]*chanActivity
+
+	// reports holds the most recent sweep reports, oldest first.
+	reports []*sweepReport
+}
+
+// newZombieSweeper returns a zombie sweeper using the thresholds of the
+// passed config.
+func newZombieSweeper(cfg *config) *zombieSweeper {
+	return &zombieSweeper{
+		offlineThreshold: cfg.ZombieOfflineThreshold,
+		idleThreshold:    cfg.ZombieIdleThreshold,
+		minUptimeRatio:   cfg.ZombieMinUptimeRatio,
+		dryRun:           cfg.ZombieDryRun,
+		started:          time.Now(),
+		lastSeen:         make(map[string]time.Time),
+		activity:         make(map[string]*chanActivity),
+	}
+}
+
+// observe records the connected peers and the state of the channels at the
+// given time, forgetting about the channels that are gone.
+func (s *zombieSweeper) observe(now time.Time, channels []*lnrpc.Channel,
+	peers []*lnrpc.Peer) {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, peer := range peers {
+		s.lastSeen[peer.PubKey] = now
+	}
+
+	current := make(map[string]struct{}, len(channels))
+	for _, channel := range channels {
+		current[channel.ChannelPoint] = struct{}{}
+		if channel.Active {
+			s.lastSeen[channel.RemotePubkey] = now
+		}
+
+		a, ok := s.activity[channel.ChannelPoint]
+		switch {
+		case !ok:
+			s.activity[channel.ChannelPoint] = &chanActivity{
+				numUpdates: channel.NumUpdates,
+				changedAt:  now,
+			}
+
+		case a.numUpdates != channel.NumUpdates ||
+			len(channel.PendingHtlcs) > 0:
+			a.numUpdates = channel.NumUpdates
+			a.changedAt = now
+		}
+	}
+	for chanPoint := range s.activity {
+		if _, ok := current[chanPoint]; !ok {
+			delete(s.activity, chanPoint)
+		}
+	}
+}
+
+// candidate returns the description of the channel if it is a zombie at the
+// given time, or nil otherwise. A zombie channel is inactive, has no HTLCs in
+// flight, its peer has been offline for at least the offline threshold and
+// it has gone without updates for at least the idle threshold. When a minimum
+// uptime ratio is configured, the peer must also have been online for less
+// than that fraction of the lifetime of the channel.
+func (s *zombieSweeper) candidate(now time.Time, channel *lnrpc.Channel,
+	connected bool) *zombieCandidate {
+
+	if channel.Active || connected || len(channel.PendingHtlcs) > 0 {
+		return nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// Peers never seen connected by the sweeper have been offline at
+	// least since it started, or for the downtime of the channel as
+	// tracked by the node if that is longer.
+	offlineFor := now.Sub(s.started)
+	if lastSeen, ok := s.lastSeen[channel.RemotePubkey]; ok {
+		offlineFor = now.Sub(lastSeen)
+	} else if channel.Lifetime > channel.Uptime {
+		downtime := time.Duration(channel.Lifetime-channel.Uptime) *
+			time.Second
+		if downtime > offlineFor {
+			offlineFor = downtime
+		}
+	}
+
+	idleFor := now.Sub(s.started)
+	if a, ok := s.activity[channel.ChannelPoint]; ok {
+		idleFor = now.Sub(a.changedAt)
+	}
+
+	uptimeRatio := 1.0
+	if channel.Lifetime > 0 {
+		uptimeRatio = float64(channel.Uptime) / float64(channel.Lifetime)
+	}
+
+	switch {
+	case offlineFor < s.offlineThreshold:
+		return nil
+	case idleFor < s.idleThreshold:
+		return nil
+	case s.minUptimeRatio > 0 && uptimeRatio >= s.minUptimeRatio:
+		return nil
+	}
+
+	return &zombieCandidate{
+		ChannelPoint: channel.ChannelPoint,
+		RemotePubKey: channel.RemotePubkey,
+		Capacity:     channel.Capacity,
+		LocalBalance: channel.LocalBalance,
+		OfflineFor:   int64(offlineFor / time.Second),
+		IdleFor:      int64(idleFor / time.Second),
+		UptimeRatio:  uptimeRatio,
+	}
+}
+
+// addReport stores the report of a sweep, dropping the oldest one once
+// maxSweepReports are stored.
+func (s *zombieSweeper) addReport(report *sweepReport) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.reports = append(s.reports, report)
+	if len(s.reports) > maxSweepReports {
+		s.reports = s.reports[len(s.reports)-maxSweepReports:]
+	}
+}
+
+// recentReports returns the stored sweep reports, newest first.
+func (s *zombieSweeper) recentReports() []*sweepReport {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	reports := make([]*sweepReport, 0, len(s.reports))
+	for i := len(s.reports) - 1; i >= 0; i-- {
+		reports = append(reports, s.reports[i])
+	}
+	return reports
+}
+
+// zombieChanSweeper is a goroutine that is tasked with cleaning up "zombie"
+// channels. We'll periodically perform a sweep to close out, or only report
+// in dry-run mode, any lingering zombie channels.
+//
+// NOTE: This MUST be run as a goroutine.
+func (l *lightningFaucet) zombieChanSweeper() {
+	defer l.wg.Done()
+
+	log.Infof("Zombie chan sweeper active, offline threshold: %v, idle "+
+		"threshold: %v, dry run: %v", l.sweeper.offlineThreshold,
+		l.sweeper.idleThreshold, l.sweeper.dryRun)
+
+	// Upon initial boot, we'll do a scan to learn about the current state
+	// of the channels and peers.
+	l.sweepZombieChans()
+
+	ticker := time.NewTicker(l.cfg.ZombieSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			log.Info("Performing zombie channel sweep!")
+			l.sweepZombieChans()
+
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// sweepZombieChans performs a sweep of the set of channels that the faucet has
+// active to close out any channels that are now considered to be a "zombie",
+// and returns the report of the sweep. In dry-run mode the zombies are only
+// reported.
+func (l *lightningFaucet) sweepZombieChans() *sweepReport {
+	s := l.sweeper
+	report := &sweepReport{
+		Started:    time.Now(),
+		DryRun:     s.dryRun,
+		Candidates: []*zombieCandidate{},
+		Closed:     []string{},
+		Pending:    []string{},
+		Failed:     []*sweepFailure{},
+	}
+	defer func() {
+		report.Finished = time.Now()
+		s.addReport(report)
+		l.metrics.observeSweep(report)
+		log.Infof("Zombie sweep done: %d channels, %d candidates, "+
+			"%d closed, %d pending, %d failed, %d atoms reclaimed, "+
+			"dry run: %v", report.NumChannels, len(report.Candidates),
+			len(report.Closed), len(report.Pending), len(report.Failed),
+			report.AtomsReclaimed, report.DryRun)
+	}()
+
+	channels, err := l.lnd.ListChannels(ctxb)
+	if err != nil {
+		log.Errorf("unable to fetch open channels: %v", err)
+		report.Error = err.Error()
+		return report
+	}
+	peers, err := l.lnd.ListPeers(ctxb)
+	if err != nil {
+		log.Errorf("unable to fetch peers: %v", err)
+		report.Error = err.Error()
+		return report
+	}
+	report.NumChannels = len(channels)
+
+	now := time.Now()
+	s.observe(now, channels, peers)
+	connected := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		connected[peer.PubKey] = true
+	}
+
+	for _, channel := range channels {
+		// Channels already being closed are left to the close
+		// manager.
+		if l.closer.closing(channel.ChannelPoint) {
+			continue
+		}
+
+		c := s.candidate(now, channel, connected[channel.RemotePubkey])
+		if c == nil {
+			continue
+		}
+		report.Candidates = append(report.Candidates, c)
+
+		log.Infof("ChannelPoint(%v) is a zombie, offline for %v, idle "+
+			"for %v, uptime ratio %.2f", c.ChannelPoint,
+			time.Duration(c.OfflineFor)*time.Second,
+			time.Duration(c.IdleFor)*time.Second, c.UptimeRatio)
+
+		if s.dryRun {
+			continue
+		}
+
+		// Zombies are closed cooperatively first in case their peer
+		// comes back, the close manager escalates to a force close
+		// once the grace period is over.
+		req, err := l.requestClose(channel.ChannelPoint,
+			channel.RemotePubkey, closeReasonZombie, false)
+		if err != nil {
+			log.Errorf("unable to close zombie chan: %v", err)
+			report.Failed = append(report.Failed, &sweepFailure{
+				ChannelPoint: channel.ChannelPoint,
+				Error:        err.Error(),
+			})
+			continue
+		}
+
+		if req.State != closeStateClosed {
+			report.Pending = append(report.Pending, channel.ChannelPoint)
+			continue
+		}
+
+		log.Infof("closed zombie chan, txid: %v", req.ClosingTxid)
+		report.Closed = append(report.Closed, channel.ChannelPoint)
+		report.AtomsReclaimed += channel.LocalBalance
+	}
+
+	return report
+}
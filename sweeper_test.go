@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// TestZombieCandidate ensures a channel is only a zombie once it is inactive,
+// idle and its peer has been offline for long enough.
+func TestZombieCandidate(t *testing.T) {
+	const threshold = 48 * time.Hour
+	peer := fakePubKey(0x01)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		channel    *lnrpc.Channel
+		connected  bool
+		lastSeen   time.Duration
+		idleFor    time.Duration
+		minUptime  float64
+		wantZombie bool
+	}{{
+		name:       "offline and idle",
+		channel:    &lnrpc.Channel{},
+		lastSeen:   72 * time.Hour,
+		idleFor:    72 * time.Hour,
+		wantZombie: true,
+	}, {
+		name:     "active",
+		channel:  &lnrpc.Channel{Active: true},
+		lastSeen: 72 * time.Hour,
+		idleFor:  72 * time.Hour,
+	}, {
+		name:      "connected",
+		channel:   &lnrpc.Channel{},
+		connected: true,
+		lastSeen:  72 * time.Hour,
+		idleFor:   72 * time.Hour,
+	}, {
+		name:     "recently seen",
+		channel:  &lnrpc.Channel{},
+		lastSeen: time.Hour,
+		idleFor:  72 * time.Hour,
+	}, {
+		name:     "recently updated",
+		channel:  &lnrpc.Channel{},
+		lastSeen: 72 * time.Hour,
+		idleFor:  time.Hour,
+	}, {
+		name: "htlcs in flight",
+		channel: &lnrpc.Channel{
+			PendingHtlcs: []*lnrpc.HTLC{{}},
+		},
+		lastSeen: 72 * time.Hour,
+		idleFor:  72 * time.Hour,
+	}, {
+		name: "good uptime",
+		channel: &lnrpc.Channel{
+			Uptime:   90,
+			Lifetime: 100,
+		},
+		lastSeen:  72 * time.Hour,
+		idleFor:   72 * time.Hour,
+		minUptime: 0.5,
+	}, {
+		name: "poor uptime",
+		channel: &lnrpc.Channel{
+			Uptime:   10,
+			Lifetime: 100,
+		},
+		lastSeen:   72 * time.Hour,
+		idleFor:    72 * time.Hour,
+		minUptime:  0.5,
+		wantZombie: true,
+	}}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			s := newZombieSweeper(&config{
+				ZombieOfflineThreshold: threshold,
+				ZombieIdleThreshold:    threshold,
+				ZombieMinUptimeRatio:   test.minUptime,
+			})
+			test.channel.RemotePubkey = peer
+			test.channel.ChannelPoint = fakePubKey(0x02) + ":0"
+			s.lastSeen[peer] = now.Add(-test.lastSeen)
+			s.activity[test.channel.ChannelPoint] = &chanActivity{
+				changedAt: now.Add(-test.idleFor),
+			}
+
+			c := s.candidate(now, test.channel, test.connected)
+			if (c != nil) != test.wantZombie {
+				t.Fatalf("unexpected candidate: %v", c)
+			}
+		})
+	}
+}
+
+// TestSweepZombieChans ensures zombies are only reported in dry-run mode and
+// closed otherwise, with the outcome of each sweep reported.
+func TestSweepZombieChans(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	zombie := fakePubKey(0x01)
+	lnd.addChannel(zombie, 1e6)
+	lnd.channels[0].Active = false
+	online := fakePubKey(0x02)
+	lnd.addChannel(online, 1e6)
+	lnd.addPeer(online)
+
+	faucet.sweeper.idleThreshold = 0
+	faucet.sweeper.started = time.Now().Add(-72 * time.Hour)
+	faucet.sweeper.dryRun = true
+
+	report := faucet.sweepZombieChans()
+	if len(report.Candidates) != 1 || len(report.Closed) != 0 ||
+		len(lnd.closed) != 0 {
+
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if report.Candidates[0].RemotePubKey != zombie {
+		t.Fatalf("unexpected candidate: %v", report.Candidates[0])
+	}
+
+	// The peer of the zombie is offline, so it is left to the close
+	// manager until its grace period is over.
+	faucet.sweeper.dryRun = false
+	report = faucet.sweepZombieChans()
+	if len(report.Pending) != 1 || len(lnd.closed) != 0 {
+		t.Fatalf("zombie close not requested: %+v", report)
+	}
+	if !faucet.closer.closing(lnd.channels[0].ChannelPoint) {
+		t.Fatalf("zombie not handed to the close manager")
+	}
+
+	// Channels already being closed are skipped.
+	report = faucet.sweepZombieChans()
+	if len(report.Candidates) != 0 {
+		t.Fatalf("unexpected candidates: %+v", report)
+	}
+
+	// Zombies are force closed right away without a grace period.
+	lnd.addChannel(fakePubKey(0x03), 1e6)
+	lnd.channels[2].Active = false
+	faucet.closer.gracePeriod = 0
+	report = faucet.sweepZombieChans()
+	if len(report.Closed) != 1 || len(lnd.closed) != 1 {
+		t.Fatalf("zombie not closed: %+v", report)
+	}
+	if report.AtomsReclaimed != 1e6 {
+		t.Fatalf("unexpected atoms reclaimed: %d",
+			report.AtomsReclaimed)
+	}
+
+	reports := faucet.sweeper.recentReports()
+	if len(reports) != 4 || reports[0] != report {
+		t.Fatalf("unexpected reports: %v", reports)
+	}
+}
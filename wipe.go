@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+const (
+	// defaultWipeConcurrency is the default number of channels closed at
+	// the same time by wipe_chans.
+	defaultWipeConcurrency = 4
+
+	// wipeConfirmationPollInterval is the interval at which wipe_chans
+	// checks whether the closing transactions are confirmed.
+	wipeConfirmationPollInterval = 30 * time.Second
+)
+
+// wipeFilter selects the channels closed by wipe_chans. The zero value selects
+// every channel of the node.
+type wipeFilter struct {
+	// faucetOnly only selects the channels opened by the faucet.
+	faucetOnly bool
+
+	// minAge only selects channels opened by the faucet at least this long
+	// ago. The age of the other channels is unknown, so they aren't
+	// selected when it is set.
+	minAge time.Duration
+
+	// peers only selects the channels with any of these peers when not
+	// empty.
+	peers map[string]bool
+
+	// minCapacity and maxCapacity only select the channels whose capacity
+	// is within these bounds when not zero.
+	minCapacity int64
+	maxCapacity int64
+}
+
+// newWipeFilter returns the wipe filter described by the passed config.
+func newWipeFilter(cfg *config) *wipeFilter {
+	f := &wipeFilter{
+		faucetOnly:  cfg.WipeFaucetOnly,
+		minAge:      cfg.WipeMinAge,
+		minCapacity: cfg.WipeMinCapacity,
+		maxCapacity: cfg.WipeMaxCapacity,
+	}
+	if len(cfg.WipePeers) > 0 {
+		f.peers = make(map[string]bool, len(cfg.WipePeers))
+		for _, peer := range cfg.WipePeers {
+			f.peers[peer] = true
+		}
+	}
+	return f
+}
+
+// validateWipePeer returns an error if the passed string isn't a node pubkey.
+func validateWipePeer(peer string) error {
+	b, err := hex.DecodeString(peer)
+	if err != nil || len(b) != 33 {
+		return fmt.Errorf("invalid node pubkey %q", peer)
+	}
+	return nil
+}
+
+// selects returns whether the channel is selected by the filter at the given
+// time. faucetOpened tells whether the channel was opened by the faucet at
+// openedAt.
+func (f *wipeFilter) selects(now time.Time, channel *lnrpc.Channel,
+	faucetOpened bool, openedAt time.Time) bool {
+
+	switch {
+	case f.faucetOnly && !faucetOpened:
+		return false
+	case f.minAge > 0 && (!faucetOpened || now.Sub(openedAt) < f.minAge):
+		return false
+	case len(f.peers) > 0 && !f.peers[channel.RemotePubkey]:
+		return false
+	case f.minCapacity > 0 && channel.Capacity < f.minCapacity:
+		return false
+	case f.maxCapacity > 0 && channel.Capacity > f.maxCapacity:
+		return false
+	}
+	return true
+}
+
+// wipeChannel describes the outcome of wiping a single channel.
+type wipeChannel struct {
+	ChannelPoint string
+	RemotePubKey string
+	Capacity     int64
+	FaucetOpened bool
+	OpenedAt     time.Time
+	ClosingTxid  string
+	Confirmed    bool
+	Error        string
+}
+
+// wipeReport describes the outcome of wipe_chans.
+type wipeReport struct {
+	DryRun     bool
+	NumSkipped int
+	Channels   []*wipeChannel
+}
+
+// failed returns the channels that couldn't be closed.
+func (r *wipeReport) failed() []*wipeChannel {
+	var failed []*wipeChannel
+	for _, c := range r.Channels {
+		if c.Error != "" {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// log writes a summary of the wipe, along with every failure, to the log.
+func (r *wipeReport) log() {
+	var numClosed, numConfirmed int
+	for _, c := range r.Channels {
+		if c.Error != "" {
+			continue
+		}
+		numClosed++
+		if c.Confirmed {
+			numConfirmed++
+		}
+	}
+
+	if r.DryRun {
+		log.Infof("Wipe dry run: %d channels would be closed, %d "+
+			"skipped", len(r.Channels), r.NumSkipped)
+		return
+	}
+
+	failed := r.failed()
+	log.Infof("Wipe done: %d channels selected, %d skipped, %d closed, "+
+		"%d confirmed, %d failed", len(r.Channels), r.NumSkipped,
+		numClosed, numConfirmed, len(failed))
+	for _, c := range failed {
+		log.Errorf("Unable to close ChannelPoint(%v): %v",
+			c.ChannelPoint, c.Error)
+	}
+}
+
+// forEachConcurrently calls fn for every channel, running at most n calls at
+// the same time, and waits for all of them to return.
+func forEachConcurrently(n int, channels []*wipeChannel,
+	fn func(*wipeChannel)) {
+
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, c := range channels {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(c *wipeChannel) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// WipeChannels closes the channels of the node selected by the filter,
+// closing at most concurrency channels at the same time. Every channel is
+// closed cooperatively first and force closed once the close grace period is
+// over. When waitConfs is set, it only returns once every closing transaction
+// is confirmed. In dry-run mode the selected channels are only listed.
+func (l *lightningFaucet) WipeChannels(filter *wipeFilter, concurrency int,
+	dryRun, waitConfs bool) (*wipeReport, error) {
+
+	openChannels, err := l.lnd.ListChannels(ctxb)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch open channels: %v", err)
+	}
+
+	report := &wipeReport{DryRun: dryRun}
+	now := time.Now()
+	l.openChannelsMtx.Lock()
+	for _, channel := range openChannels {
+		var (
+			openedAt     time.Time
+			faucetOpened bool
+		)
+		op, err := strPointToOutPoint(channel.ChannelPoint)
+		if err == nil {
+			openedAt, faucetOpened = l.openChannels[*op]
+		}
+
+		if !filter.selects(now, channel, faucetOpened, openedAt) {
+			report.NumSkipped++
+			continue
+		}
+		report.Channels = append(report.Channels, &wipeChannel{
+			ChannelPoint: channel.ChannelPoint,
+			RemotePubKey: channel.RemotePubkey,
+			Capacity:     channel.Capacity,
+			FaucetOpened: faucetOpened,
+			OpenedAt:     openedAt,
+		})
+	}
+	l.openChannelsMtx.Unlock()
+
+	if dryRun {
+		for _, c := range report.Channels {
+			opened := "unknown"
+			if c.FaucetOpened {
+				opened = c.OpenedAt.Format(time.RFC3339)
+			}
+			log.Infof("Would close ChannelPoint(%v) with %v, capacity: "+
+				"%d, opened by the faucet: %v", c.ChannelPoint,
+				c.RemotePubKey, c.Capacity, opened)
+		}
+		return report, nil
+	}
+
+	// Make a first attempt to close every channel, then keep moving the
+	// remaining closes forward until they were all broadcast or their
+	// force close failed.
+	var mtx sync.Mutex
+	pending := make([]*wipeChannel, 0, len(report.Channels))
+	record := func(c *wipeChannel, req *closeRequest) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		switch req.State {
+		case closeStateClosed:
+			c.ClosingTxid = req.ClosingTxid
+			log.Infof("Closed ChannelPoint(%v), closing txid: %v",
+				c.ChannelPoint, c.ClosingTxid)
+
+		// The close manager keeps attempting to force close the
+		// channel once the faucet runs again.
+		case closeStateForce:
+			c.Error = req.LastError
+
+		default:
+			pending = append(pending, c)
+		}
+	}
+
+	forEachConcurrently(concurrency, report.Channels, func(c *wipeChannel) {
+		log.Infof("Attempting to close channel: %s", c.ChannelPoint)
+
+		req, err := l.requestClose(c.ChannelPoint, c.RemotePubKey,
+			closeReasonWipe, false)
+		if err != nil {
+			c.Error = err.Error()
+			return
+		}
+		record(c, req)
+	})
+
+	for len(pending) > 0 {
+		log.Infof("Waiting %v to retry closing %d channels",
+			l.closer.retryInterval, len(pending))
+		time.Sleep(l.closer.retryInterval)
+
+		openChannels, err := l.lnd.ListChannels(ctxb)
+		if err != nil {
+			return report, fmt.Errorf("unable to fetch open "+
+				"channels: %v", err)
+		}
+		open := make(map[string]bool, len(openChannels))
+		for _, channel := range openChannels {
+			open[channel.ChannelPoint] = true
+		}
+
+		retry := pending
+		pending = nil
+		forEachConcurrently(concurrency, retry, func(c *wipeChannel) {
+			if !open[c.ChannelPoint] {
+				log.Infof("ChannelPoint(%v) is no longer open",
+					c.ChannelPoint)
+				l.forgetClose(c.ChannelPoint)
+				return
+			}
+
+			req := l.stepClose(c.ChannelPoint)
+			if req == nil {
+				return
+			}
+			record(c, req)
+		})
+	}
+
+	if waitConfs {
+		if err := l.waitCloseConfirmations(report.Channels); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// waitCloseConfirmations waits until the closing transaction of every closed
+// channel is confirmed, which is once the node no longer reports them as
+// waiting for their close.
+func (l *lightningFaucet) waitCloseConfirmations(channels []*wipeChannel) error {
+	for {
+		pending, err := l.lnd.PendingChannels(ctxb)
+		if err != nil {
+			return fmt.Errorf("unable to fetch pending channels: %v",
+				err)
+		}
+		waiting := make(map[string]bool)
+		for _, channel := range pending.WaitingCloseChannels {
+			waiting[channel.Channel.ChannelPoint] = true
+		}
+
+		var numWaiting int
+		for _, c := range channels {
+			if c.Error != "" || c.Confirmed {
+				continue
+			}
+			if waiting[c.ChannelPoint] {
+				numWaiting++
+				continue
+			}
+
+			c.Confirmed = true
+			log.Infof("Closing transaction of ChannelPoint(%v) "+
+				"confirmed", c.ChannelPoint)
+		}
+		if numWaiting == 0 {
+			return nil
+		}
+
+		log.Infof("Waiting for %d closing transactions to confirm",
+			numWaiting)
+		time.Sleep(wipeConfirmationPollInterval)
+	}
+}
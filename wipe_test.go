@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrlnd/lnrpc"
+)
+
+// TestWipeFilter ensures wipe_chans only selects the channels matching all of
+// its filters.
+func TestWipeFilter(t *testing.T) {
+	now := time.Now()
+	channel := &lnrpc.Channel{
+		RemotePubkey: fakePubKey(0x01),
+		Capacity:     1e6,
+	}
+
+	tests := []struct {
+		name         string
+		filter       wipeFilter
+		faucetOpened bool
+		age          time.Duration
+		want         bool
+	}{{
+		name: "no filter",
+		want: true,
+	}, {
+		name:   "faucet only",
+		filter: wipeFilter{faucetOnly: true},
+		want:   false,
+	}, {
+		name:         "faucet only opened by faucet",
+		filter:       wipeFilter{faucetOnly: true},
+		faucetOpened: true,
+		want:         true,
+	}, {
+		name:   "min age unknown age",
+		filter: wipeFilter{minAge: time.Hour},
+		want:   false,
+	}, {
+		name:         "min age too young",
+		filter:       wipeFilter{minAge: time.Hour},
+		faucetOpened: true,
+		age:          time.Minute,
+		want:         false,
+	}, {
+		name:         "min age old enough",
+		filter:       wipeFilter{minAge: time.Hour},
+		faucetOpened: true,
+		age:          2 * time.Hour,
+		want:         true,
+	}, {
+		name: "other peer",
+		filter: wipeFilter{peers: map[string]bool{
+			fakePubKey(0x02): true,
+		}},
+		want: false,
+	}, {
+		name: "matching peer",
+		filter: wipeFilter{peers: map[string]bool{
+			fakePubKey(0x01): true,
+		}},
+		want: true,
+	}, {
+		name:   "too small",
+		filter: wipeFilter{minCapacity: 2e6},
+		want:   false,
+	}, {
+		name:   "too large",
+		filter: wipeFilter{maxCapacity: 5e5},
+		want:   false,
+	}, {
+		name:   "capacity within bounds",
+		filter: wipeFilter{minCapacity: 1e6, maxCapacity: 1e6},
+		want:   true,
+	}}
+
+	for _, test := range tests {
+		got := test.filter.selects(now, channel, test.faucetOpened,
+			now.Add(-test.age))
+		if got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want,
+				got)
+		}
+	}
+}
+
+// TestWipeChannels ensures wipe_chans only closes the selected channels,
+// force closing the ones that can't be closed cooperatively, and reports the
+// channels it couldn't close.
+func TestWipeChannels(t *testing.T) {
+	lnd := newFakeBackend()
+	faucet, cleanUp := newTestFaucet(t, lnd)
+	defer cleanUp()
+
+	chanPoints := addFaucetChannels(t, lnd, faucet, time.Hour, time.Hour)
+	lnd.channels[1].Active = false
+	lnd.addChannel(fakePubKey(0x10), 1e6)
+
+	filter := &wipeFilter{faucetOnly: true}
+	report, err := faucet.WipeChannels(filter, 2, true, false)
+	if err != nil {
+		t.Fatalf("unable to wipe channels: %v", err)
+	}
+	if len(report.Channels) != 2 || report.NumSkipped != 1 ||
+		len(lnd.closed) != 0 {
+
+		t.Fatalf("unexpected dry run: %+v", report)
+	}
+
+	// Without a grace period the inactive channel is force closed right
+	// away.
+	faucet.closer.gracePeriod = 0
+	report, err = faucet.WipeChannels(filter, 2, false, false)
+	if err != nil {
+		t.Fatalf("unable to wipe channels: %v", err)
+	}
+	if len(report.failed()) != 0 || len(lnd.closed) != 2 {
+		t.Fatalf("channels not closed: %+v", report)
+	}
+	for _, c := range report.Channels {
+		if c.ClosingTxid == "" {
+			t.Fatalf("no closing txid for %v", c.ChannelPoint)
+		}
+	}
+	closes, err := faucet.recentCloses(10)
+	if err != nil {
+		t.Fatalf("unable to fetch closes: %v", err)
+	}
+	force := make(map[string]bool)
+	for _, c := range closes {
+		force[c.ChannelPoint] = c.Force
+	}
+	if force[chanPoints[0]] || !force[chanPoints[1]] {
+		t.Fatalf("unexpected closes: %v", closes)
+	}
+
+	// Closes are confirmed once the node no longer waits for them.
+	lnd.pending.WaitingCloseChannels = nil
+	if err := faucet.waitCloseConfirmations(report.Channels); err != nil {
+		t.Fatalf("unable to wait for confirmations: %v", err)
+	}
+	for _, c := range report.Channels {
+		if !c.Confirmed {
+			t.Fatalf("close of %v not confirmed", c.ChannelPoint)
+		}
+	}
+
+	// Failed closes are reported.
+	lnd.errCloseChannel = errors.New("unable to close")
+	report, err = faucet.WipeChannels(&wipeFilter{}, 2, false, false)
+	if err != nil {
+		t.Fatalf("unable to wipe channels: %v", err)
+	}
+	if len(report.Channels) != 1 || len(report.failed()) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}